@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/net/publicsuffix"
+)
+
+// tlds that show up disproportionately in spam/phishing reports
+var suspiciousTLDs = map[string]bool{
+	"zip": true, "mov": true, "xyz": true, "top": true, "tk": true, "ml": true,
+	"ga": true, "cf": true, "gq": true, "click": true, "country": true, "work": true,
+	"icu": true, "buzz": true, "rest": true, "cam": true, "loan": true,
+}
+
+// SubmissionRisk is the abuse score for a url someone wants to shorten
+type SubmissionRisk struct {
+	Score   float64  // 0 = clean, 1 = almost certainly abuse
+	Reasons []string // human readable, shown to admins in the review queue
+}
+
+func (sr *SubmissionRisk) add(score float64, reason string) {
+	sr.Score += score
+	sr.Reasons = append(sr.Reasons, reason)
+	if sr.Score > 1 {
+		sr.Score = 1
+	}
+}
+
+// scoreSubmission runs the cheap local heuristics, then (if network is set) the
+// slower ones - redirect chain and domain age via rdap
+func (app *App) scoreSubmission(ctx context.Context, rawURL string, network bool) SubmissionRisk {
+	var risk SubmissionRisk
+
+	switch {
+	case len(rawURL) > 2000:
+		risk.add(0.3, "very long url")
+	case len(rawURL) > 500:
+		risk.add(0.1, "long url")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		risk.add(1, "unparseable url")
+		return risk
+	}
+	host := strings.ToLower(u.Hostname())
+
+	if u.User != nil {
+		risk.add(0.4, "credentials in url")
+	}
+	if net.ParseIP(host) != nil {
+		risk.add(0.3, "ip address host")
+	}
+	if i := strings.LastIndex(host, "."); i >= 0 && suspiciousTLDs[host[i+1:]] {
+		risk.add(0.3, "suspicious tld ."+host[i+1:])
+	}
+	if strings.Contains(host, "xn--") {
+		risk.add(0.2, "punycode host")
+	}
+
+	if !network || !webURL(rawURL) {
+		return risk
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+
+	chain, err := app.redirectChain(ctx, rawURL, 5)
+	if err != nil {
+		risk.add(0.1, "destination unreachable")
+	} else if len(chain) > 3 {
+		risk.add(0.2, fmt.Sprintf("%d redirect hops", len(chain)-1))
+	}
+
+	if registered, err := app.domainRegistered(ctx, host); err == nil && time.Since(registered) < app.Config.AbuseNewDomainAge {
+		risk.add(0.4, "domain registered "+registered.Format("2006-01-02"))
+	}
+
+	return risk
+}
+
+// redirectChain follows a destination's redirects by hand (HEAD only) and
+// returns every url visited, starting with the original
+func (app *App) redirectChain(ctx context.Context, rawURL string, maxHops int) ([]string, error) {
+	client := *app.Outbound
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	chain := []string{rawURL}
+	current := rawURL
+	for i := 0; i < maxHops; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, current, nil)
+		if err != nil {
+			return chain, err
+		}
+		req.Header.Set("User-Agent", outboundUserAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return chain, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+			return chain, nil
+		}
+		next, err := resp.Location()
+		if err != nil {
+			return chain, nil // redirect without a location, treat as the end
+		}
+		current = next.String()
+		chain = append(chain, current)
+	}
+	return chain, nil
+}
+
+// rdap response - we only care about the registration event
+type rdapDomain struct {
+	Events []struct {
+		Action string    `json:"eventAction"`
+		Date   time.Time `json:"eventDate"`
+	} `json:"events"`
+}
+
+// domainRegistered looks up when the registrable part of host was registered.
+// answers are cached for a day since registration dates don't exactly change
+func (app *App) domainRegistered(ctx context.Context, host string) (time.Time, error) {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cacheKey := "rdap:" + domain
+	if v, found := app.Lookups.Get(cacheKey); found {
+		return v.(time.Time), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, app.Config.RDAPEndpoint+url.PathEscape(domain), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	req.Header.Set("User-Agent", outboundUserAgent)
+
+	resp, err := app.Outbound.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("rdap returned %s", resp.Status)
+	}
+
+	var info rdapDomain
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return time.Time{}, err
+	}
+	for _, ev := range info.Events {
+		if ev.Action == "registration" {
+			app.Lookups.Set(cacheKey, ev.Date, 24*time.Hour)
+			return ev.Date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no registration date for %s", domain)
+}
+
+// handles GET /api/admin/quarantine - links waiting for a human to look at them
+func (app *App) quarantineListHandler(w http.ResponseWriter, r *http.Request) {
+	links, next, err := app.listURLs(r.URL.Query().Get("after"), 100, func(u *URL) bool {
+		return u.Quarantined
+	})
+	if err != nil {
+		log.Printf("quarantine list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.linkList(links, next))
+}
+
+// handles POST /api/admin/quarantine/{shortCode}/{action} - approve lets the link
+// start redirecting, reject disables it for good
+func (app *App) quarantineActionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
+	action := vars["action"]
+
+	found := false
+	_, err := app.auditedUpdate(requestActor(r), shortCode, func(u *URL) (string, error) {
+		if !u.Quarantined {
+			return "", nil
+		}
+		found = true
+		u.Quarantined = false
+		if action == "reject" {
+			u.Disabled = true
+			u.DisabledReason = "rejected as abuse"
+		}
+		return action, nil
+	})
+	if err != nil {
+		log.Printf("quarantine %s error: %v", action, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errorNotFound, "no quarantined link with that code")
+		return
+	}
+
+	app.Cache.Delete(shortCode)
+	log.Printf("quarantined link %s: %sd", shortCode, action)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookups cache entries default to an hour, cleaned up every 10 minutes
+func newLookupCache() *cache.Cache {
+	return cache.New(time.Hour, 10*time.Minute)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACCESS_LOG writes every request in apache's combined log format, so
+// goaccess, awstats and friends can read redirect traffic as is. the file
+// rotates by size (ACCESS_LOG_MAX_MB) and/or age (ACCESS_LOG_ROTATE) into
+// access.log.20240301-120000 style names, keeping the newest ACCESS_LOG_KEEP
+
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// rotatingFile is an append-only file that moves itself aside when it gets
+// too big or too old. safe for concurrent writes
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64         // 0 = no size limit
+	maxAge   time.Duration // 0 = no time limit
+	keep     int           // rotated files to keep, 0 = all
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxBytes int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxAge: maxAge, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	// a file left over from before a restart counts from its last write
+	if info.Size() > 0 {
+		f.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && ((f.maxBytes > 0 && f.size+int64(len(p)) > f.maxBytes) || (f.maxAge > 0 && time.Since(f.opened) >= f.maxAge)) {
+		if err := f.rotate(); err != nil {
+			// keep writing to the old file rather than lose lines
+			log.Printf("access log rotation failed: %v", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// rotate moves the current file aside, starts a new one and prunes old ones
+func (f *rotatingFile) rotate() error {
+	rotated := f.path + "." + time.Now().Format("20060102-150405")
+	if _, err := os.Stat(rotated); err == nil {
+		rotated += fmt.Sprintf(".%d", time.Now().UnixNano())
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		// put it back so we still have somewhere to write
+		os.Rename(rotated, f.path)
+		return err
+	}
+	old.Close()
+	f.prune()
+	return nil
+}
+
+func (f *rotatingFile) prune() {
+	if f.keep <= 0 {
+		return
+	}
+	rotated, err := filepath.Glob(f.path + ".*")
+	if err != nil || len(rotated) <= f.keep {
+		return
+	}
+	// the timestamps sort in order
+	sort.Strings(rotated)
+	for _, name := range rotated[:len(rotated)-f.keep] {
+		if err := os.Remove(name); err != nil {
+			log.Printf("access log prune: %v", err)
+		}
+	}
+}
+
+// statusRecorder catches the status and size for the log line
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach flush and friends
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessLogMiddleware wraps the whole router, so requests nothing matched
+// get logged too
+func (app *App) accessLogMiddleware(next http.Handler) http.Handler {
+	if app.AccessLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := app.now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		size := "-"
+		if rec.bytes > 0 {
+			size = fmt.Sprint(rec.bytes)
+		}
+		fmt.Fprintf(app.AccessLog, "%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+			clientIP(r), start.Format(accessLogTimeFormat),
+			r.Method, logQuote(r.RequestURI), r.Proto, rec.status, size,
+			logQuote(r.Referer()), logQuote(r.UserAgent()))
+	})
+}
+
+// logQuote keeps a value from breaking out of its quotes, the way apache escapes it
+func logQuote(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
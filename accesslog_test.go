@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	ta := newTestApp(t, map[string]string{"ACCESS_LOG": path})
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/logged"}, http.StatusOK).ShortCode
+
+	ta.do(http.MethodGet, "/"+code+"?utm=x", nil, "Referer", "https://ref.example/", "User-Agent", `Agent "quoted"`)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := regexp.MustCompile(`^192\.0\.2\.1 - - \[01/Mar/2024:12:00:00 \+0000\] "GET /` + code + `\?utm=x HTTP/1\.1" 301 \d+ "https://ref\.example/" "Agent \\"quoted\\""$`)
+	if len(lines) != 2 || !want.MatchString(lines[1]) {
+		t.Fatalf("log:\n%s", data)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := openRotatingFile(path, 100, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 5; i++ {
+		f.Write([]byte(line))
+		// rotated names have second resolution, same second ones get a suffix
+		time.Sleep(time.Millisecond)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != line {
+		t.Errorf("current file has %d bytes, want one line", len(current))
+	}
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Errorf("kept %d rotated files, want 2: %v", len(rotated), rotated)
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// account emails: confirming a user's address and resetting a forgotten
+// password. both send a link with a signed token - user id, expiry and an
+// hmac (JWT_SECRET) over those plus a stamp of the account, so nothing is
+// stored. the stamp is the email for verification and the password hash for
+// resets, which makes a reset link die once it's been used. sending is rate
+// limited with token buckets per address and per client ip, so the
+// endpoints can't be used to flood someone's inbox
+
+const (
+	verifyTokenTTL = 48 * time.Hour
+	resetTokenTTL  = time.Hour
+
+	accountMailBurst = 3                // emails in a row per address or ip
+	accountMailEvery = 20 * time.Minute // and then one more per
+)
+
+// accountToken signs a token for one purpose ("verify" or "reset")
+func (app *App) accountToken(purpose string, user *User, ttl time.Duration) string {
+	payload := user.ID + "." + strconv.FormatInt(app.now().Add(ttl).Unix(), 36)
+	return payload + "." + app.accountMAC(purpose, payload, user)
+}
+
+func (app *App) accountMAC(purpose, payload string, user *User) string {
+	stamp := user.Email
+	if purpose == "reset" {
+		stamp = hashToken(string(user.PasswordHash))
+	}
+	mac := hmac.New(sha256.New, app.Config.JWTSecret)
+	mac.Write([]byte("account:" + purpose + "\x00" + payload + "\x00" + stamp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkAccountToken returns the user a token is for, nil when it's forged,
+// expired or used up
+func (app *App) checkAccountToken(purpose, token string) (*User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil
+	}
+	expires, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil || app.now().Unix() > expires {
+		return nil, nil
+	}
+	user, err := app.getUser(parts[0])
+	if err != nil || user == nil || !app.accountTokenFits(purpose, token, user) {
+		return nil, err
+	}
+	return user, nil
+}
+
+// accountTokenFits checks a token's mac against user as it is now. a reset
+// checks again inside its write, see resetPassword
+func (app *App) accountTokenFits(purpose, token string, user *User) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	want := app.accountMAC(purpose, parts[0]+"."+parts[1], user)
+	return hmac.Equal([]byte(parts[2]), []byte(want))
+}
+
+// mailLimits are the token buckets for account emails, by "email:" or "ip:" key
+type mailLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*mailBucket
+}
+
+type mailBucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+func newMailLimits() *mailLimits {
+	return &mailLimits{buckets: map[string]*mailBucket{}}
+}
+
+// allow takes a token for key if there's one left
+func (l *mailLimits) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[key]
+	if b == nil {
+		// full buckets are the same as none, drop them now and then
+		if len(l.buckets) > 10000 {
+			for k, old := range l.buckets {
+				if now.Sub(old.refilled) > accountMailBurst*accountMailEvery {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		b = &mailBucket{tokens: accountMailBurst, refilled: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(accountMailBurst, b.tokens+now.Sub(b.refilled).Seconds()/accountMailEvery.Seconds())
+	b.refilled = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sendAccountMail renders one of the account emails and sends it in the
+// background, so an address that exists doesn't answer any slower
+func (app *App) sendAccountMail(user *User, template, subject, link string, ttl time.Duration) {
+	var body bytes.Buffer
+	err := app.MailTemplates.ExecuteTemplate(&body, template, map[string]any{
+		"Brand": app.Brand.Name,
+		"Email": user.Email,
+		"Link":  link,
+		"Hours": int(ttl.Hours()),
+	})
+	if err != nil {
+		log.Printf("%s render error: %v", template, err)
+		return
+	}
+	app.Mails.Add(1)
+	go func() {
+		defer app.Mails.Done()
+		if err := app.sendMail(user.Email, subject, body.String()); err != nil {
+			log.Printf("%s to %s failed: %v", template, user.Email, err)
+		}
+	}()
+}
+
+// sendVerification mails a user the link that confirms their address
+func (app *App) sendVerification(user *User) {
+	if !app.mailConfigured() {
+		return
+	}
+	link := app.Config.BaseURL + "/verify-email?token=" + url.QueryEscape(app.accountToken("verify", user, verifyTokenTTL))
+	app.sendAccountMail(user, "verify.txt", "Confirm your email address", link, verifyTokenTTL)
+}
+
+// mailAllowed checks the buckets for an account email request
+func (app *App) mailAllowed(r *http.Request, email string) (ipOK, emailOK bool) {
+	now := app.now()
+	return app.MailLimits.allow("ip:"+clientIP(r), now), app.MailLimits.allow("email:"+email, now)
+}
+
+// accountMailRefused answers a request whose ip is out of tokens
+func accountMailRefused(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(accountMailEvery.Seconds())))
+	writeError(w, http.StatusTooManyRequests, errorRateLimited, "too many emails requested, try again later")
+}
+
+// handles POST /api/auth/verify-email/send - another verification email
+// for the logged-in user
+func (app *App) sendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user := identityFromContext(r.Context()).User
+	if user == nil {
+		writeError(w, http.StatusForbidden, errorForbidden, "verifying an email needs a user login")
+		return
+	}
+	if !app.mailConfigured() {
+		writeError(w, http.StatusServiceUnavailable, errorDisabled, "email is not configured on this server")
+		return
+	}
+	if user.EmailVerified {
+		writeError(w, http.StatusConflict, errorConflict, "email is already verified")
+		return
+	}
+	if ipOK, emailOK := app.mailAllowed(r, user.Email); !ipOK || !emailOK {
+		accountMailRefused(w)
+		return
+	}
+	app.sendVerification(user)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyEmail marks the token's user as verified. false for a bad token
+func (app *App) verifyEmail(token string) (bool, error) {
+	user, err := app.checkAccountToken("verify", token)
+	if err != nil || user == nil {
+		return false, err
+	}
+	return true, app.updateUser(user.ID, func(u *User) error {
+		u.EmailVerified = true
+		return nil
+	})
+}
+
+// handles POST /api/auth/verify-email
+func (app *App) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	ok, err := app.verifyEmail(req.Token)
+	if err != nil {
+		log.Printf("email verify error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !ok {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "token", "invalid or expired link")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles GET /verify-email?token= - the link in the email
+func (app *App) verifyEmailPageHandler(w http.ResponseWriter, r *http.Request) {
+	ok, err := app.verifyEmail(r.URL.Query().Get("token"))
+	if err != nil {
+		log.Printf("email verify error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		app.renderLogin(w, r, http.StatusBadRequest, map[string]any{"Next": "/", "CSRF": app.setLoginCSRF(w, r), "Error": "This link is invalid or has expired"})
+		return
+	}
+	app.renderLogin(w, r, http.StatusOK, map[string]any{"Next": "/", "CSRF": app.setLoginCSRF(w, r), "Message": "Your email address is confirmed"})
+}
+
+// handles POST /api/auth/password-reset - mails a reset link. the answer is
+// the same whether or not there's an account, only a flood gets a 429
+func (app *App) requestResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "email", "email is required")
+		return
+	}
+	if !app.mailConfigured() {
+		writeError(w, http.StatusServiceUnavailable, errorDisabled, "email is not configured on this server")
+		return
+	}
+	sent, err := app.requestReset(r, email)
+	if err != nil {
+		log.Printf("password reset lookup error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !sent {
+		accountMailRefused(w)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requestReset mails a reset link if there's an account for email. false
+// when the client has asked for too many already. an address that's had
+// enough is skipped without saying so
+func (app *App) requestReset(r *http.Request, email string) (bool, error) {
+	ipOK, emailOK := app.mailAllowed(r, email)
+	if !ipOK {
+		return false, nil
+	}
+	user, err := app.getUserByEmail(email)
+	if err != nil || user == nil || !emailOK {
+		return true, err
+	}
+	link := app.Config.BaseURL + "/reset-password?token=" + url.QueryEscape(app.accountToken("reset", user, resetTokenTTL))
+	app.sendAccountMail(user, "reset.txt", "Reset your password", link, resetTokenTTL)
+	return true, nil
+}
+
+// resetPassword sets a new password for the token's user and logs them out
+// everywhere. field and problem say what was wrong with the request
+func (app *App) resetPassword(token, password string) (field, problem string, err error) {
+	user, err := app.checkAccountToken("reset", token)
+	if err != nil {
+		return "", "", err
+	}
+	if user == nil {
+		return "token", "invalid or expired link", nil
+	}
+	if len(password) < minPasswordLen {
+		return "password", "password must be at least 10 characters", nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		// bcrypt only fails on passwords over 72 bytes
+		return "password", "password too long", nil
+	}
+
+	// the token is stamped with the old password hash, so checking it again
+	// against the stored user inside the write means two confirms racing with
+	// the same link can't both get through
+	used := false
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		fresh, err := getUserTx(tx, user.ID)
+		if err != nil || fresh == nil {
+			return err
+		}
+		if !app.accountTokenFits("reset", token, fresh) {
+			used = true
+			return nil
+		}
+		fresh.PasswordHash = hash
+		fresh.EmailVerified = true // the link came to their inbox
+		data, err := json.Marshal(fresh)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("users")).Put([]byte(fresh.ID), data); err != nil {
+			return err
+		}
+		return app.endLogins(tx, fresh.ID)
+	})
+	if err == nil && used {
+		return "token", "invalid or expired link", nil
+	}
+	return "", "", err
+}
+
+// endLogins revokes every login token and browser session a user has
+func (app *App) endLogins(tx *bolt.Tx, userID string) error {
+	if bucket := tx.Bucket([]byte("refresh_tokens")); bucket != nil {
+		var families []string
+		err := bucket.ForEach(func(k, v []byte) error {
+			var stored RefreshToken
+			if json.Unmarshal(v, &stored) == nil && stored.UserID == userID {
+				families = append(families, stored.Family)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, family := range families {
+			if err := app.revokeFamily(tx, family); err != nil {
+				return err
+			}
+		}
+	}
+	if bucket := tx.Bucket([]byte("sessions")); bucket != nil {
+		var ended [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var session Session
+			if json.Unmarshal(v, &session) == nil && session.UserID == userID {
+				ended = append(ended, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range ended {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handles POST /api/auth/password-reset/confirm
+func (app *App) confirmResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	field, problem, err := app.resetPassword(req.Token, req.Password)
+	if err != nil {
+		log.Printf("password reset error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if problem != "" {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, field, problem)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles GET /reset-password?token= - the link in the email
+// without a token it asks for the email address to send one to
+func (app *App) resetPageHandler(w http.ResponseWriter, r *http.Request) {
+	app.renderReset(w, r, http.StatusOK, map[string]any{"Token": r.URL.Query().Get("token")})
+}
+
+func (app *App) renderReset(w http.ResponseWriter, r *http.Request, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer") // the token is in the url
+	w.WriteHeader(status)
+	if err := app.page(r).ExecuteTemplate(w, "reset.html", data); err != nil {
+		log.Printf("reset render error: %v", err)
+	}
+}
+
+// handles POST /reset-password - either form on that page
+func (app *App) resetFormHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		email := strings.ToLower(strings.TrimSpace(r.PostForm.Get("email")))
+		if !app.mailConfigured() {
+			app.renderReset(w, r, http.StatusServiceUnavailable, map[string]any{"Email": email, "Error": "Email is not configured on this server"})
+			return
+		}
+		sent, err := app.requestReset(r, email)
+		if err != nil {
+			log.Printf("password reset lookup error: %v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !sent {
+			app.renderReset(w, r, http.StatusTooManyRequests, map[string]any{"Email": email, "Error": "Too many emails requested, try again later"})
+			return
+		}
+		app.renderReset(w, r, http.StatusAccepted, map[string]any{"Email": email, "Message": "If there's an account for that address, a reset link is on its way"})
+		return
+	}
+
+	_, problem, err := app.resetPassword(token, r.PostForm.Get("password"))
+	if err != nil {
+		log.Printf("password reset error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if problem != "" {
+		app.renderReset(w, r, http.StatusBadRequest, map[string]any{"Token": token, "Error": problem})
+		return
+	}
+	app.renderLogin(w, r, http.StatusOK, map[string]any{"Next": "/", "CSRF": app.setLoginCSRF(w, r), "Message": "Your password is changed, log in with the new one"})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP takes mail on a local port and hands each message body over
+func fakeSMTP(t *testing.T) (map[string]string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	mails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				in := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+				reply("220 fake")
+				for {
+					line, err := in.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+					case "DATA":
+						reply("354 go on")
+						var body strings.Builder
+						for {
+							l, err := in.ReadString('\n')
+							if err != nil || l == ".\r\n" {
+								break
+							}
+							body.WriteString(l)
+						}
+						mails <- body.String()
+						reply("250 queued")
+					case "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return map[string]string{"SMTP_HOST": host, "SMTP_PORT": port, "SMTP_FROM": "links@example.com"}, mails
+}
+
+var mailLink = regexp.MustCompile(`http://sho\.rt/[a-z-]+\?token=\S+`)
+
+// nextToken waits for an email and pulls the token out of its link
+func nextToken(t *testing.T, mails <-chan string) string {
+	t.Helper()
+	select {
+	case mail := <-mails:
+		link, err := url.Parse(mailLink.FindString(mail))
+		if err != nil || link.Query().Get("token") == "" {
+			t.Fatalf("no link in mail:\n%s", mail)
+		}
+		return link.Query().Get("token")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail sent")
+	}
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	env, mails := fakeSMTP(t)
+	ta := newTestApp(t, env)
+	alice := loginAs(ta, "alice@example.com", nil)
+	token := nextToken(t, mails) // sent when the account was made
+
+	if rec := ta.do(http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token + "x"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("forged token: status %d, want 400", rec.Code)
+	}
+	if rec := ta.do(http.MethodGet, "/verify-email?token="+url.QueryEscape(token), nil); rec.Code != http.StatusOK {
+		t.Fatalf("verify link: status %d", rec.Code)
+	}
+	if user, _ := ta.getUserByEmail("alice@example.com"); !user.EmailVerified {
+		t.Fatal("email not verified")
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/auth/verify-email/send", nil, alice...); rec.Code != http.StatusConflict {
+		t.Fatalf("resend once verified: status %d, want 409", rec.Code)
+	}
+
+	// links run out
+	user, _ := ta.getUserByEmail("alice@example.com")
+	token = ta.accountToken("verify", user, verifyTokenTTL)
+	ta.clock.Advance(verifyTokenTTL + time.Minute)
+	if rec := ta.do(http.MethodPost, "/api/v1/auth/verify-email", map[string]string{"token": token}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expired token: status %d, want 400", rec.Code)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	env, mails := fakeSMTP(t)
+	ta := newTestApp(t, env)
+	alice := loginAs(ta, "alice@example.com", nil)
+	nextToken(t, mails) // verification
+
+	// unknown addresses get the same answer and no mail
+	if rec := ta.do(http.MethodPost, "/api/v1/auth/password-reset", map[string]string{"email": "nobody@example.com"}); rec.Code != http.StatusAccepted {
+		t.Fatalf("unknown email: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/auth/password-reset", map[string]string{"email": "Alice@example.com"}); rec.Code != http.StatusAccepted {
+		t.Fatalf("reset request: status %d", rec.Code)
+	}
+	token := nextToken(t, mails)
+
+	confirm := func(password string) int {
+		return ta.do(http.MethodPost, "/api/v1/auth/password-reset/confirm", map[string]string{"token": token, "password": password}).Code
+	}
+	if code := confirm("short"); code != http.StatusBadRequest {
+		t.Fatalf("short password: status %d, want 400", code)
+	}
+	if code := confirm("a brand new password"); code != http.StatusNoContent {
+		t.Fatalf("confirm: status %d", code)
+	}
+	if code := confirm("another new password"); code != http.StatusBadRequest {
+		t.Fatalf("reusing the link: status %d, want 400", code)
+	}
+
+	// old logins are over, the new password works
+	if rec := ta.do(http.MethodGet, "/api/v1/me/settings", nil, alice...); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("old login token: status %d, want 401", rec.Code)
+	}
+	rec := ta.do(http.MethodPost, "/api/v1/auth/login", map[string]string{"email": "alice@example.com", "password": "a brand new password"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login with the new password: status %d", rec.Code)
+	}
+	var users []UserResponse
+	json.NewDecoder(ta.do(http.MethodGet, "/api/v1/admin/users", nil, "Authorization", "Bearer "+testAdminToken).Body).Decode(&users)
+	if len(users) != 1 || !users[0].Verified {
+		t.Errorf("users = %+v, want alice verified by the reset", users)
+	}
+}
+
+// a reset link is good for one password, however many confirms race with it
+func TestPasswordResetRace(t *testing.T) {
+	env, mails := fakeSMTP(t)
+	ta := newTestApp(t, env)
+	loginAs(ta, "alice@example.com", nil)
+	nextToken(t, mails) // verification
+	if rec := ta.do(http.MethodPost, "/api/v1/auth/password-reset", map[string]string{"email": "alice@example.com"}); rec.Code != http.StatusAccepted {
+		t.Fatalf("reset request: status %d", rec.Code)
+	}
+	token := nextToken(t, mails)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 4)
+	for i := range codes {
+		wg.Go(func() {
+			codes[i] = ta.do(http.MethodPost, "/api/v1/auth/password-reset/confirm", map[string]string{"token": token, "password": fmt.Sprintf("new password number %d", i)}).Code
+		})
+	}
+	wg.Wait()
+	if n := slices.Index(codes, http.StatusNoContent); n < 0 || slices.Index(codes[n+1:], http.StatusNoContent) >= 0 {
+		t.Errorf("confirm statuses %v, want exactly one 204", codes)
+	}
+}
+
+func TestAccountMailRateLimit(t *testing.T) {
+	env, _ := fakeSMTP(t)
+	ta := newTestApp(t, env)
+	ask := func() *http.Response {
+		return ta.do(http.MethodPost, "/api/v1/auth/password-reset", map[string]string{"email": "nobody@example.com"}).Result()
+	}
+	for i := range accountMailBurst {
+		if resp := ask(); resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("request %d: status %d", i+1, resp.StatusCode)
+		}
+	}
+	resp := ask()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("over the burst: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	ta.clock.Advance(accountMailEvery)
+	if resp := ask(); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("after refill: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// bearerToken pulls the token out of an "Authorization: Bearer xyz" header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// owns reports whether an identity may see a link's details - admins see
+// everything, api keys and users only the links they created
+func (id *Identity) owns(u *URL) bool {
+	return id.Admin ||
+		(id.Key != nil && id.Key.ID == u.APIKeyID) ||
+		(id.User != nil && id.User.ID == u.UserID)
+}
+
+// authorizeLink loads a link for an authIdentified endpoint if the caller owns
+// it. writes the 404 itself so other people's codes look the same as missing ones
+func (app *App) authorizeLink(w http.ResponseWriter, r *http.Request, shortCode string) (*URL, bool) {
+	urlData, err := app.getURL(shortCode)
+	if err == nil && urlData != nil {
+		if identityFromContext(r.Context()).owns(urlData) {
+			return urlData, true
+		}
+	}
+
+	writeError(w, http.StatusNotFound, errorNotFound, "link not found")
+	return nil, false
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forEachStore runs a test once on each STORE
+func forEachStore(t *testing.T, fn func(t *testing.T, ta *testApp)) {
+	for _, store := range []string{storeBolt, storeMemory} {
+		t.Run(store, func(t *testing.T) {
+			fn(t, newTestApp(t, map[string]string{"STORE": store}))
+		})
+	}
+}
+
+func TestShortenAndRedirect(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ta *testApp) {
+		resp := ta.shorten(ShortenRequest{URL: "https://example.com/page"}, http.StatusOK)
+		if !resp.Created || len(resp.ShortCode) != 8 {
+			t.Fatalf("got %+v, want a new 8 character code", resp)
+		}
+		if resp.ShortURL != "http://sho.rt/"+resp.ShortCode {
+			t.Errorf("short url = %q", resp.ShortURL)
+		}
+
+		// the first hit comes from the database, the second from the cache
+		for i := 0; i < 2; i++ {
+			rec := ta.do(http.MethodGet, "/"+resp.ShortCode, nil)
+			if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://example.com/page" {
+				t.Fatalf("redirect %d: status %d, location %q", i, rec.Code, rec.Header().Get("Location"))
+			}
+		}
+	})
+}
+
+func TestShortenAddsScheme(t *testing.T) {
+	ta := newTestApp(t, nil)
+	resp := ta.shorten(ShortenRequest{URL: "example.com/no-scheme"}, http.StatusOK)
+	if resp.OriginalURL != "https://example.com/no-scheme" {
+		t.Errorf("original url = %q", resp.OriginalURL)
+	}
+}
+
+func TestShortenPlainText(t *testing.T) {
+	ta := newTestApp(t, nil)
+
+	rec := ta.do(http.MethodGet, "/api/v1/shorten?url=https://example.com/plain", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "http://sho.rt/") {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+
+	rec = ta.do(http.MethodGet, "/api/v1/shorten?url=ftp://example.com/x", nil)
+	if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("plain error: status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestShortenDedupe(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ta *testApp) {
+		first := ta.shorten(ShortenRequest{URL: "https://example.com/same"}, http.StatusOK)
+		second := ta.shorten(ShortenRequest{URL: "https://example.com/same"}, http.StatusOK)
+		if second.Created || second.ShortCode != first.ShortCode {
+			t.Fatalf("second shorten got %+v, want the existing code %s", second, first.ShortCode)
+		}
+
+		other := ta.shorten(ShortenRequest{URL: "https://example.com/other"}, http.StatusOK)
+		if !other.Created || other.ShortCode == first.ShortCode {
+			t.Fatalf("different url got %+v", other)
+		}
+	})
+}
+
+func TestShortenNoReuse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ta *testApp) {
+		no := false
+		shared := ta.shorten(ShortenRequest{URL: "https://example.com/campaigns"}, http.StatusOK)
+		var codes []string
+		for _, campaign := range []string{"spring", "fall"} {
+			req := ShortenRequest{URL: "https://example.com/campaigns"}
+			req.Campaign, req.Reuse = campaign, &no
+			resp := ta.shorten(req, http.StatusOK)
+			if !resp.Created || resp.ShortCode == shared.ShortCode {
+				t.Fatalf("%s: got %+v", campaign, resp)
+			}
+			codes = append(codes, resp.ShortCode)
+		}
+		if codes[0] == codes[1] {
+			t.Fatalf("both campaigns got %s", codes[0])
+		}
+
+		// and they don't take over from the shared one
+		again := ta.shorten(ShortenRequest{URL: "https://example.com/campaigns"}, http.StatusOK)
+		if again.Created || again.ShortCode != shared.ShortCode {
+			t.Fatalf("reuse got %+v, want %s", again, shared.ShortCode)
+		}
+	})
+}
+
+// each key dedupes on its own links, getting another key's code back would
+// leave it with a link someone else can still edit
+func TestShortenDedupePerKey(t *testing.T) {
+	ta := newTestApp(t, nil)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	keys := make([]string, 2)
+	for i := range keys {
+		rec := ta.do(http.MethodPost, "/api/v1/admin/keys", map[string]string{"name": fmt.Sprintf("key%d", i)}, admin...)
+		var created CreateAPIKeyResponse
+		json.NewDecoder(rec.Body).Decode(&created)
+		keys[i] = created.Key
+	}
+	shorten := func(key string) ShortenResponse {
+		rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/shared"}, "X-API-Key", key)
+		var resp ShortenResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("shorten: status %d", rec.Code)
+		}
+		return resp
+	}
+
+	a, b := shorten(keys[0]), shorten(keys[1])
+	if !b.Created || b.ShortCode == a.ShortCode {
+		t.Fatalf("second key got %+v, want its own link", b)
+	}
+	if again := shorten(keys[1]); again.Created || again.ShortCode != b.ShortCode {
+		t.Errorf("same key again got %+v, want %s", again, b.ShortCode)
+	}
+	if anon := ta.shorten(ShortenRequest{URL: "https://example.com/shared"}, http.StatusOK); !anon.Created || anon.ShortCode == a.ShortCode || anon.ShortCode == b.ShortCode {
+		t.Errorf("anonymous got %+v, want a link of its own", anon)
+	}
+	if rec := ta.do(http.MethodPatch, "/api/v1/links/"+b.ShortCode, map[string]string{"title": "mine"}, "X-API-Key", keys[1]); rec.Code != http.StatusOK {
+		t.Errorf("patch own link: status %d", rec.Code)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	forEachStore(t, func(t *testing.T, ta *testApp) {
+		resp := ta.shorten(ShortenRequest{URL: "https://example.com/soon", ShortenOptions: ShortenOptions{ExpiresIn: "1h"}}, http.StatusOK)
+		if resp.ExpiresAt == nil || !resp.ExpiresAt.Equal(ta.clock.Now().Add(time.Hour)) {
+			t.Fatalf("expires_at = %v, want an hour from the app clock", resp.ExpiresAt)
+		}
+		if rec := ta.do(http.MethodGet, "/"+resp.ShortCode, nil); rec.Code != http.StatusMovedPermanently {
+			t.Fatalf("before expiry: status %d", rec.Code)
+		}
+
+		// the link is in the cache by now, which must not keep it alive
+		ta.clock.Advance(time.Hour)
+		if rec := ta.do(http.MethodGet, "/"+resp.ShortCode, nil); rec.Code != http.StatusGone {
+			t.Fatalf("after expiry: status %d", rec.Code)
+		}
+
+		// an expired link doesn't count for dedupe
+		again := ta.shorten(ShortenRequest{URL: "https://example.com/soon"}, http.StatusOK)
+		if !again.Created || again.ShortCode == resp.ShortCode {
+			t.Fatalf("shortening after expiry got %+v", again)
+		}
+	})
+}
+
+func TestDeterministicCodes(t *testing.T) {
+	a := newTestApp(t, nil).shorten(ShortenRequest{URL: "https://example.com/same-seed"}, http.StatusOK)
+	b := newTestApp(t, nil).shorten(ShortenRequest{URL: "https://example.com/same-seed"}, http.StatusOK)
+	if a.ShortCode != b.ShortCode {
+		t.Fatalf("same clock and seed gave %s and %s", a.ShortCode, b.ShortCode)
+	}
+}
+
+func TestRedirectUnknownCode(t *testing.T) {
+	ta := newTestApp(t, nil)
+	if rec := ta.do(http.MethodGet, "/abcd1234", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", rec.Code)
+	}
+}
+
+func TestShortenErrors(t *testing.T) {
+	ta := newTestApp(t, map[string]string{
+		"MAX_BODY_BYTES": "4096",
+		"MAX_URL_LENGTH": "200",
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		err    string
+	}{
+		{"malformed json", `{"url":`, http.StatusBadRequest, "invalid json"},
+		{"unknown field", `{"url":"https://example.com","colour":"red"}`, http.StatusBadRequest, `invalid json: unknown field "colour"`},
+		{"trailing data", `{"url":"https://example.com"} {}`, http.StatusBadRequest, "invalid json: unexpected data after the json value"},
+		{"body too large", `{"url":"https://example.com/` + strings.Repeat("a", 5000) + `"}`, http.StatusRequestEntityTooLarge, "request body is too large"},
+		{"missing url", `{}`, http.StatusBadRequest, "url is required"},
+		{"url too long", `{"url":"https://example.com/` + strings.Repeat("a", 200) + `"}`, http.StatusBadRequest, "url is longer than 200 characters"},
+		{"scheme not allowed", `{"url":"javascript:alert(1)"}`, http.StatusBadRequest, "javascript: links are not allowed"},
+		{"self link", `{"url":"http://sho.rt/abcd1234"}`, http.StatusBadRequest, "url points back at this shortener"},
+		{"private address", `{"url":"http://10.0.0.1/admin"}`, http.StatusBadRequest, "url points at an internal address"},
+		{"loopback address", `{"url":"http://127.0.0.1:8080/"}`, http.StatusBadRequest, "url points at an internal address"},
+		{"bad expiry", `{"url":"https://example.com","expires_in":"soon"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ta.do(http.MethodPost, "/api/v1/shorten", tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := errorOf(t, rec); tt.err != "" && got != tt.err {
+				t.Errorf("error %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestErrorsAreTranslated(t *testing.T) {
+	ta := newTestApp(t, nil)
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", `{"url":`, "Accept-Language", "de")
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Language") != "de" {
+		t.Fatalf("status %d, language %q", rec.Code, rec.Header().Get("Content-Language"))
+	}
+	if got := errorOf(t, rec); got == "invalid json" {
+		t.Errorf("error stayed english: %q", got)
+	}
+}
+
+func TestAdminEndpointsNeedToken(t *testing.T) {
+	ta := newTestApp(t, nil)
+	if rec := ta.do(http.MethodGet, "/api/v1/admin/audit", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodGet, "/api/v1/admin/audit", nil, "Authorization", "Bearer "+testAdminToken); rec.Code != http.StatusOK {
+		t.Fatalf("with token: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPublicRoutesOnlyServeLinks(t *testing.T) {
+	ta := newTestApp(t, nil)
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/public"}, http.StatusOK).ShortCode
+	public := ta.publicRoutes()
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/" + code, http.StatusMovedPermanently},
+		{http.MethodGet, "/" + code + "/stats", http.StatusOK},
+		{http.MethodGet, "/", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/shorten", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/admin/audit", http.StatusNotFound},
+		{http.MethodGet, "/debug/vars", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		public.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// APIKey is a machine credential for scripts and integrations. only the
+// sha256 of the key is stored, the plaintext is shown once at creation
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"` // first few chars so people can tell keys apart
+	OrgID     string    `json:"org_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Defaults *ShortenOptions `json:"defaults,omitempty"` // saved recipe applied to this key's shorten calls
+
+	hash string // bucket key, filled in on lookup
+}
+
+// response for POST /api/admin/keys - the only time the key itself is returned
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromRequest reads the key from X-API-Key, or a bearer token that looks like one of ours
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token := bearerToken(r); strings.HasPrefix(token, "lf_") {
+		return token
+	}
+	return ""
+}
+
+// lookupAPIKey finds the record for a plaintext key, nil if unknown
+func (app *App) lookupAPIKey(key string) (*APIKey, error) {
+	var apiKey *APIKey
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("apikeys"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(hashAPIKey(key)))
+		if v == nil {
+			return nil
+		}
+		apiKey = &APIKey{hash: hashAPIKey(key)}
+		return json.Unmarshal(v, apiKey)
+	})
+	return apiKey, err
+}
+
+// apiKeyFromContext returns the key the request authenticated with, if any
+func apiKeyFromContext(ctx context.Context) *APIKey {
+	return identityFromContext(ctx).Key
+}
+
+// handles POST /api/admin/keys
+func (app *App) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string `json:"name"`
+		OrgID string `json:"org_id"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "name", "name is required")
+		return
+	}
+	if req.OrgID != "" {
+		if org, _ := app.getOrg(req.OrgID); org == nil {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "org_id", "org not found")
+			return
+		}
+	}
+
+	secret := make([]byte, 24)
+	id := make([]byte, 6)
+	rand.Read(secret)
+	rand.Read(id)
+
+	key := "lf_" + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey := APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    key[:7],
+		OrgID:     req.OrgID,
+		CreatedAt: time.Now(),
+	}
+
+	apiKey.hash = hashAPIKey(key)
+	if err := app.saveAPIKey(&apiKey); err != nil {
+		log.Printf("api key save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save api key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// handles GET /api/admin/keys
+func (app *App) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := []APIKey{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("apikeys"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var apiKey APIKey
+			if json.Unmarshal(v, &apiKey) == nil {
+				keys = append(keys, apiKey)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("api key list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handles DELETE /api/admin/keys/{id} - revocation is just deleting the record
+func (app *App) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	found := false
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("apikeys"))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var apiKey APIKey
+			if json.Unmarshal(v, &apiKey) == nil && apiKey.ID == id {
+				found = true
+				return c.Delete()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("api key revoke error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errorNotFound, "api key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// destination snapshots. with ARCHIVE=true every new live link asks the
+// wayback machine to save its destination, in the background, and keeps the
+// snapshot's url as archive_url - so when the site goes away there's still
+// something to send people to. it shows up on the link and in resolve.
+// ARCHIVE_SAVE_URL is the save endpoint with the destination appended, the
+// wayback's by default. archive.today has no api (it's captchas all the way
+// down), so it isn't an option
+
+const (
+	archiveConcurrency = 2               // the save api rate limits hard
+	archiveTimeout     = 2 * time.Minute // saving a page takes its time
+)
+
+// archiveQueue keeps snapshot requests to a few at a time. a reload hands it
+// over, so a new app doesn't get its own allowance
+type archiveQueue struct {
+	slots   chan struct{}
+	running sync.WaitGroup // requests queued or running
+}
+
+func newArchiveQueue() *archiveQueue {
+	return &archiveQueue{slots: make(chan struct{}, archiveConcurrency)}
+}
+
+// Wait blocks until every queued snapshot is done
+func (q *archiveQueue) Wait() {
+	q.running.Wait()
+}
+
+// archiveLink snapshots a new link's destination in the background
+func (app *App) archiveLink(u *URL) {
+	if !app.Config.Archive || !webURL(u.OriginalURL) {
+		return
+	}
+	shortCode, dest := u.ShortCode, u.OriginalURL
+	q := app.Archives
+	q.running.Add(1)
+	go func() {
+		defer q.running.Done()
+		q.slots <- struct{}{}
+		defer func() { <-q.slots }()
+
+		snapshot, err := app.snapshot(context.Background(), dest)
+		if err != nil {
+			log.Printf("archiving %s for %s failed: %v", dest, shortCode, err)
+			return
+		}
+		err = app.DB.Update(func(tx *bolt.Tx) error {
+			return updateURLTx(tx, shortCode, func(u *URL) error {
+				// purged and reused in the meantime - not ours any more
+				if u.OriginalURL == dest {
+					u.ArchiveURL = snapshot
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			log.Printf("archive url save error for %s: %v", shortCode, err)
+		}
+	}()
+}
+
+// snapshot asks the archive to save dest and returns where the copy lives.
+// the wayback says so in Content-Location or by redirecting to it; failing
+// both, its /web/{url} always leads to the newest copy
+func (app *App) snapshot(ctx context.Context, dest string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, archiveTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, app.Config.ArchiveSaveURL+dest, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", outboundUserAgent)
+	client := *app.Outbound
+	client.Timeout = archiveTimeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", errors.New("archive returned " + resp.Status)
+	}
+
+	final := resp.Request.URL
+	if loc := resp.Header.Get("Content-Location"); loc != "" {
+		if u, err := final.Parse(loc); err == nil {
+			return u.String(), nil
+		}
+	}
+	if strings.HasPrefix(final.Path, "/web/") {
+		return final.String(), nil
+	}
+	return final.Scheme + "://" + final.Host + "/web/" + dest, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	var saves atomic.Int32
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dest := strings.TrimPrefix(r.URL.Path, "/save/")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/web/"):
+			w.Write([]byte("the snapshot"))
+		case strings.HasSuffix(dest, "/located"):
+			saves.Add(1)
+			w.Header().Set("Content-Location", "/web/20261015120000/"+dest)
+		case strings.HasSuffix(dest, "/redirected"):
+			saves.Add(1)
+			http.Redirect(w, r, "http://"+r.Host+"/web/20261015120001/"+dest, http.StatusFound)
+		case strings.HasSuffix(dest, "/failing"):
+			saves.Add(1)
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			saves.Add(1)
+		}
+	}))
+	defer archive.Close()
+
+	ta := newTestApp(t, map[string]string{
+		"ALLOW_PRIVATE_DESTINATIONS": "true",
+		"ARCHIVE":                    "true",
+		"ARCHIVE_SAVE_URL":           archive.URL + "/save/",
+		"ALLOWED_SCHEMES":            "http,https,mailto",
+	})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	codes := map[string]string{}
+	for _, path := range []string{"located", "redirected", "plain", "failing"} {
+		codes[path] = ta.shorten(ShortenRequest{URL: "https://example.com/" + path}, http.StatusOK).ShortCode
+	}
+	rec := ta.do(http.MethodPost, "/api/v1/shorten/batch", "https://example.com/batched/located", append(admin, "Content-Type", "text/plain")...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("batch: status %d: %s", rec.Code, rec.Body)
+	}
+	// mailto: has nothing to archive
+	ta.shorten(ShortenRequest{URL: "mailto:someone@example.com"}, http.StatusOK)
+	ta.Archives.Wait()
+
+	if n := saves.Load(); n != 5 {
+		t.Errorf("%d saves, want 5", n)
+	}
+	want := map[string]string{
+		"located":    archive.URL + "/web/20261015120000/https://example.com/located",
+		"redirected": archive.URL + "/web/20261015120001/https://example.com/redirected",
+		"plain":      archive.URL + "/web/https://example.com/plain",
+		"failing":    "",
+	}
+	for path, code := range codes {
+		rec := ta.do(http.MethodGet, "/api/v1/links/"+code, nil, admin...)
+		var link URL
+		json.NewDecoder(rec.Body).Decode(&link)
+		if link.ArchiveURL != want[path] {
+			t.Errorf("%s: archive_url %q, want %q", path, link.ArchiveURL, want[path])
+		}
+	}
+
+	rec = ta.do(http.MethodPost, "/api/v1/resolve", ResolveRequest{Codes: []string{codes["located"]}}, admin...)
+	var resp ResolveResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Links) != 1 || resp.Links[0].ArchiveURL != want["located"] {
+		t.Errorf("resolve = %+v", resp)
+	}
+}
+
+func TestArchiveOff(t *testing.T) {
+	var saves atomic.Int32
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { saves.Add(1) }))
+	defer archive.Close()
+
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true", "ARCHIVE_SAVE_URL": archive.URL + "/save/"})
+	ta.shorten(ShortenRequest{URL: "https://example.com/unarchived"}, http.StatusOK)
+	ta.Archives.Wait()
+	if n := saves.Load(); n != 0 {
+		t.Errorf("%d saves with ARCHIVE off", n)
+	}
+}
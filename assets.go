@@ -0,0 +1,118 @@
+package main
+
+import (
+	"embed"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	texttemplate "text/template"
+)
+
+// pages and emails are in templates/, css and js for them in static/, and
+// translations in locales/ - all built into the binary. ASSETS_DIR points at a directory with the same layout
+// whose files win over the built-in ones, so a deployment can rebrand by
+// overriding just the files it cares about
+//
+//go:embed templates static locales
+var embeddedAssets embed.FS
+
+var (
+	// partials only define blocks the pages pull in
+	partialTemplates = []string{"theme.html", "i18n.html"}
+	pageTemplates    = []string{"index.html", "login.html", "bookmarklet.html", "dashboard.html", "analytics.html", "preview.html", "stats.html", "cloak.html", "card.html", "reset.html", "interstitial.html"}
+	mailTemplates    = []string{"report.txt", "verify.txt", "reset.txt"}
+	// other plain text, parsed in with the emails
+	textTemplates = []string{"robots.txt"}
+)
+
+// overlayFS looks in the override directory first, then the embedded files
+type overlayFS struct {
+	override fs.FS // nil when ASSETS_DIR isn't set
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.override != nil {
+		if f, err := o.override.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return o.base.Open(name)
+}
+
+func newAssets(dir string) fs.FS {
+	assets := overlayFS{base: embeddedAssets}
+	if dir != "" {
+		assets.override = os.DirFS(dir)
+	}
+	return assets
+}
+
+// loadTemplates parses every page and email template, failing on the first
+// broken one so a bad override shows up at startup rather than on a request.
+// pages are parsed once and then cloned per language with its own t func
+func (app *App) loadTemplates() error {
+	brand, err := newBrand(app.Config)
+	if err != nil {
+		return err
+	}
+	catalogs, err := loadCatalogs(app.Assets)
+	if err != nil {
+		return err
+	}
+	pages := htmltemplate.New("").Funcs(htmltemplate.FuncMap{
+		"brand":    func() *Brand { return brand },
+		"langs":    func() []string { return supportedLangs },
+		"t":        catalog{}.t,
+		"lang":     func() string { return "en" },
+		"messages": func() catalog { return catalog{} },
+	})
+	for _, name := range append(partialTemplates, pageTemplates...) {
+		data, err := fs.ReadFile(app.Assets, "templates/"+name)
+		if err != nil {
+			return err
+		}
+		if _, err := pages.New(name).Parse(string(data)); err != nil {
+			return err
+		}
+	}
+
+	mail := texttemplate.New("")
+	for _, name := range append(mailTemplates, textTemplates...) {
+		data, err := fs.ReadFile(app.Assets, "templates/"+name)
+		if err != nil {
+			return err
+		}
+		if _, err := mail.New(name).Parse(string(data)); err != nil {
+			return err
+		}
+	}
+
+	localized := map[string]*htmltemplate.Template{}
+	for lang, messages := range catalogs {
+		clone, err := pages.Clone()
+		if err != nil {
+			return err
+		}
+		localized[lang] = clone.Funcs(htmltemplate.FuncMap{
+			"t":        messages.t,
+			"lang":     func() string { return lang },
+			"messages": func() catalog { return messages },
+		})
+	}
+
+	app.Brand, app.Pages, app.Catalogs, app.MailTemplates = brand, localized, catalogs, mail
+	return nil
+}
+
+// page returns the page templates in the request's language
+func (app *App) page(r *http.Request) *htmltemplate.Template {
+	return app.Pages[langFromContext(r.Context())]
+}
+
+// staticHandler serves static/ under /static/
+func (app *App) staticHandler() http.Handler {
+	static, _ := fs.Sub(app.Assets, "static")
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
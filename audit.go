@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// AuditEntry is one change to a link. entries live in the "audit" bucket under
+// an increasing sequence number and are never rewritten or deleted
+type AuditEntry struct {
+	ID        uint64         `json:"id"`
+	Time      time.Time      `json:"time"`
+	Actor     string         `json:"actor"` // method:subject, anonymous, or system:<job>
+	IP        string         `json:"ip,omitempty"`
+	Action    string         `json:"action"`
+	ShortCode string         `json:"short_code"`
+	Old       map[string]any `json:"old,omitempty"` // fields as they were - only the ones that changed on edits
+	New       map[string]any `json:"new,omitempty"`
+}
+
+// actor names an identity for the audit log
+func (id *Identity) actor() string {
+	if id.Anonymous() {
+		return "anonymous"
+	}
+	return id.Method + ":" + id.Subject
+}
+
+// requestActor fills in who is behind a request
+func requestActor(r *http.Request) AuditEntry {
+	return AuditEntry{Actor: identityFromContext(r.Context()).actor(), IP: clientIP(r)}
+}
+
+// linkFields turns a link into a field map for the log
+func linkFields(u *URL) map[string]any {
+	if u == nil {
+		return nil
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil
+	}
+	var out map[string]any
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// linkDiff keeps just the fields that differ between two versions of a link
+func linkDiff(before, after *URL) (map[string]any, map[string]any) {
+	oldFields, newFields := linkFields(before), linkFields(after)
+	for k, v := range oldFields {
+		if nv, ok := newFields[k]; ok && reflect.DeepEqual(v, nv) {
+			delete(oldFields, k)
+			delete(newFields, k)
+		}
+	}
+	return oldFields, newFields
+}
+
+// auditTx appends an entry inside the caller's transaction, so the change and
+// its record are written together or not at all
+func auditTx(tx *bolt.Tx, entry AuditEntry) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("audit"))
+	if err != nil {
+		return err
+	}
+	entry.ID, err = bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	data, err := sealJSON(tx, entry)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, entry.ID)
+	return bucket.Put(key, data)
+}
+
+// auditedUpdate is updateURL that logs the change. fn returns the action to
+// record, or "" when it turned out there was nothing worth logging
+func (app *App) auditedUpdate(who AuditEntry, shortCode string, fn func(u *URL) (string, error)) (*URL, error) {
+	var updated *URL
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		action := ""
+		var before URL
+		err := updateURLTx(tx, shortCode, func(u *URL) error {
+			before = *u
+			var err error
+			action, err = fn(u)
+			updated = u
+			return err
+		})
+		if err != nil || action == "" {
+			return err
+		}
+		entry := who
+		entry.Action, entry.ShortCode = action, shortCode
+		entry.Old, entry.New = linkDiff(&before, updated)
+		return auditTx(tx, entry)
+	})
+	return updated, err
+}
+
+// response for GET /api/admin/audit
+type AuditListResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Next    string       `json:"next,omitempty"` // pass back as ?before= for older entries
+}
+
+// handles GET /api/admin/audit - newest first, filterable by code, actor and action
+func (app *App) auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	before, _ := strconv.ParseUint(q.Get("before"), 10, 64)
+	code, actor, action := q.Get("code"), q.Get("actor"), q.Get("action")
+
+	resp := AuditListResponse{Entries: []AuditEntry{}}
+	err = app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("audit"))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.Last()
+		if before > 0 {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, before)
+			// seek lands on before itself (or past the end), step back from there
+			if k, v = c.Seek(key); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil; k, v = c.Prev() {
+			var entry AuditEntry
+			if err := openJSON(tx, v, &entry); err != nil {
+				continue
+			}
+			if (code != "" && entry.ShortCode != code) || (actor != "" && entry.Actor != actor) || (action != "" && entry.Action != action) {
+				continue
+			}
+			if len(resp.Entries) == limit {
+				resp.Next = strconv.FormatUint(resp.Entries[limit-1].ID, 10)
+				break
+			}
+			resp.Entries = append(resp.Entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("audit list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// Identity is who a request is acting as, worked out once per request by the
+// auth chain and stashed in the context for handlers
+type Identity struct {
+	Method  string  // which authenticator matched - admin, apikey, jwt, session, mtls or anonymous
+	Subject string  // key id, user id, certificate name... empty for anonymous
+	Admin   bool    // may use operator endpoints
+	Key     *APIKey // set when Method is apikey
+	User    *User   // set when Method is jwt or session
+}
+
+func (id *Identity) Anonymous() bool { return id == nil || id.Method == "anonymous" }
+
+// Authenticator is one way of proving who you are. each one looks for its own
+// kind of credential: no credential means (nil, nil) so the chain moves on,
+// a credential that doesn't check out is an error and the request is refused
+type Authenticator interface {
+	Name() string
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// errBadCredentials is what authenticators return for credentials that were
+// presented but are wrong - the message goes back to the client as-is
+type errBadCredentials string
+
+func (e errBadCredentials) Error() string { return string(e) }
+
+// adminTokenAuth accepts "Authorization: Bearer <ADMIN_TOKEN>". other bearer
+// tokens aren't ours to reject since api keys travel the same way
+type adminTokenAuth struct {
+	token string
+}
+
+func (a *adminTokenAuth) Name() string { return "admin" }
+
+func (a *adminTokenAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if a.token == "" || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return nil, nil
+	}
+	return &Identity{Method: "admin", Subject: "admin", Admin: true}, nil
+}
+
+// apiKeyAuth accepts X-API-Key or a bearer token that looks like one of our keys
+type apiKeyAuth struct {
+	app *App
+}
+
+func (a *apiKeyAuth) Name() string { return "apikey" }
+
+func (a *apiKeyAuth) Authenticate(r *http.Request) (*Identity, error) {
+	key := apiKeyFromRequest(r)
+	if key == "" {
+		return nil, nil
+	}
+
+	apiKey, err := a.app.lookupAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, errBadCredentials("invalid api key")
+	}
+	return &Identity{Method: "apikey", Subject: apiKey.ID, Key: apiKey}, nil
+}
+
+// mtlsAuth trusts a verified client certificate. this only sees anything when
+// the server itself terminates tls with client auth on - the common name is
+// the subject, and names listed in MTLS_ADMIN_SUBJECTS get admin rights
+type mtlsAuth struct {
+	adminSubjects []string
+}
+
+func (a *mtlsAuth) Name() string { return "mtls" }
+
+func (a *mtlsAuth) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if subject == "" {
+		return nil, errBadCredentials("client certificate has no common name")
+	}
+	return &Identity{Method: "mtls", Subject: subject, Admin: slices.Contains(a.adminSubjects, subject)}, nil
+}
+
+// authChain is the ordered list of authenticators tried on every request -
+// first one to recognise a credential wins, nobody matching means anonymous.
+// new auth methods slot in here without touching any handler
+func (app *App) authChain() []Authenticator {
+	return []Authenticator{
+		&adminTokenAuth{token: app.Config.AdminToken},
+		&apiKeyAuth{app: app},
+		&jwtAuth{app: app},
+		&sessionAuth{app: app},
+		&mtlsAuth{adminSubjects: app.Config.MTLSAdminSubjects},
+	}
+}
+
+var anonymous = &Identity{Method: "anonymous"}
+
+type identityContextKey struct{}
+
+// authMiddleware runs the chain and puts the resulting identity in the context.
+// it never blocks anonymous requests, that's up to each route's requirement
+func (app *App) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := anonymous
+		for _, auth := range app.Auth {
+			id, err := auth.Authenticate(r)
+			if err != nil {
+				var bad errBadCredentials
+				if errors.As(err, &bad) {
+					writeError(w, http.StatusUnauthorized, errorUnauthorized, bad.Error())
+					return
+				}
+				log.Printf("%s auth error: %v", auth.Name(), err)
+				writeError(w, http.StatusInternalServerError, errorServer, "server error")
+				return
+			}
+			if id != nil {
+				identity = id
+				break
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)))
+	})
+}
+
+// identityFromContext returns who the request is acting as, anonymous if the
+// auth middleware didn't run
+func identityFromContext(ctx context.Context) *Identity {
+	if id, ok := ctx.Value(identityContextKey{}).(*Identity); ok {
+		return id
+	}
+	return anonymous
+}
+
+// AuthRequirement is what a route demands of the caller, declared next to the
+// route in main
+type AuthRequirement int
+
+const (
+	authAnyone     AuthRequirement = iota // anonymous is fine
+	authIdentified                        // any authenticated identity - api key, user, admin, mtls...
+	authAdmin                             // operators only
+)
+
+// require wraps a handler with a route's auth requirement
+func (app *App) require(req AuthRequirement, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := identityFromContext(r.Context())
+
+		switch {
+		case req == authAdmin && identity.Admin,
+			req == authIdentified && !identity.Anonymous(),
+			req == authAnyone:
+			next(w, r)
+			return
+		}
+
+		switch {
+		case !identity.Anonymous():
+			writeError(w, http.StatusForbidden, errorForbidden, "forbidden")
+		case req == authAdmin && app.Config.AdminToken == "" && len(app.Config.MTLSAdminSubjects) == 0:
+			// nothing could ever satisfy this - the admin api is simply switched off
+			writeError(w, http.StatusForbidden, errorDisabled, "admin api disabled")
+		default:
+			writeError(w, http.StatusUnauthorized, errorUnauthorized, "unauthorized")
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// caps for POST /api/shorten/batch - one paste, not a bulk import tool
+const (
+	maxBatchURLs  = 500
+	maxBatchBytes = 1 << 20
+)
+
+// batch result statuses
+const (
+	batchCreated  = "created"
+	batchExisting = "existing"  // already shortened before this request
+	batchRepeated = "duplicate" // appeared earlier in the same paste
+	batchPending  = "pending_review"
+	batchInvalid  = "invalid"
+)
+
+// BatchResult is one row of the results table
+type BatchResult struct {
+	Line        int    `json:"line"`
+	Input       string `json:"input"`
+	Status      string `json:"status"`
+	ShortURL    string `json:"short_url,omitempty"`
+	ShortCode   string `json:"short_code,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	ClaimToken  string `json:"claim_token,omitempty"` // anonymous batches only
+	Error       string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results  []BatchResult `json:"results"`
+	Created  int           `json:"created"`
+	Existing int           `json:"existing"`
+	Invalid  int           `json:"invalid"`
+}
+
+// looksLikeURL is deliberately picky - spreadsheet pastes are full of cells like
+// "Acme Inc." that would otherwise pass as hostnames
+func looksLikeURL(token string) bool {
+	if strings.HasPrefix(token, "http://") || strings.HasPrefix(token, "https://") {
+		return true
+	}
+	host, _, _ := strings.Cut(token, "/")
+	host, _, _ = strings.Cut(host, "?")
+	dot := strings.LastIndex(host, ".")
+	if dot <= 0 {
+		return false
+	}
+	tld := host[dot+1:]
+	if len(tld) < 2 {
+		return false
+	}
+	for _, c := range tld {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// pastedCells splits one pasted line into the words that might be urls.
+// spreadsheets paste tab separated; a line with quotes is read as csv, so a
+// quoted cell stays whole whatever it holds
+func pastedCells(line string) []string {
+	fields := []string{line}
+	switch {
+	case strings.Contains(line, "\t"):
+		fields = strings.Split(line, "\t")
+	case strings.Contains(line, `"`):
+		r := csv.NewReader(strings.NewReader(line))
+		r.LazyQuotes, r.FieldsPerRecord = true, -1
+		if strings.Count(line, ";") > strings.Count(line, ",") {
+			r.Comma = ';'
+		}
+		if record, err := r.Read(); err == nil {
+			fields = record
+		}
+	}
+	var cells []string
+	for _, field := range fields {
+		for _, word := range strings.Fields(field) {
+			cells = append(cells, splitWord(word)...)
+		}
+	}
+	return cells
+}
+
+// splitWord splits a word on commas and semicolons, which urls can hold
+// themselves - a url is only split when every piece is one too, so
+// "a.com,b.com" is two links but a maps link's "@40.7,-74.0,15z" stays whole
+func splitWord(word string) []string {
+	word = strings.Trim(word, `",;`)
+	parts := strings.FieldsFunc(word, func(r rune) bool { return r == ',' || r == ';' })
+	if !looksLikeURL(word) {
+		return parts
+	}
+	for _, part := range parts {
+		if !looksLikeURL(part) {
+			return []string{word}
+		}
+	}
+	return parts
+}
+
+// parsePastedURLs splits a pasted blob into candidate rows. each line can hold
+// several cells (see pastedCells); lines with nothing url-like become invalid
+// rows so users can see what got skipped
+func parsePastedURLs(blob string) []BatchResult {
+	var results []BatchResult
+	for i, line := range strings.Split(blob, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		cells := pastedCells(line)
+		found := false
+		for _, cell := range cells {
+			if !looksLikeURL(cell) {
+				continue
+			}
+			found = true
+			res := BatchResult{Line: i + 1, Input: cell, OriginalURL: normalizeURL(cell)}
+			if !isValidURL(res.OriginalURL) {
+				res.Status = batchInvalid
+				res.Error = "invalid url format"
+				res.OriginalURL = ""
+			}
+			results = append(results, res)
+		}
+		if !found {
+			results = append(results, BatchResult{Line: i + 1, Input: line, Status: batchInvalid, Error: "no url found"})
+		}
+	}
+	return results
+}
+
+// handles POST /api/shorten/batch - takes a newline separated blob (text/plain,
+// or json {"text": "..."}) and shortens everything in one transaction
+func (app *App) batchShortenHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBytes+1))
+	if err != nil || len(body) > maxBatchBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, errorTooLarge, "paste is too large")
+		return
+	}
+
+	blob := string(body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req struct {
+			Text string `json:"text"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errorInvalidJSON, bodyErrorMessage(err))
+			return
+		}
+		blob = req.Text
+	}
+
+	// options come from the query string, with the api key's saved defaults and
+	// then the admin's behind them
+	q := r.URL.Query()
+	opts := queryOptions(q)
+	apiKey := apiKeyFromContext(r.Context())
+	source, apiKeyID := sourceWeb, ""
+	if apiKey != nil {
+		opts.fillFrom(apiKey.Defaults)
+		source, apiKeyID = sourceAPI, apiKey.ID
+	}
+	opts.fillFrom(app.linkDefaults())
+	identity := identityFromContext(r.Context())
+	userID := ""
+	if identity.User != nil {
+		userID = identity.User.ID
+	}
+	if err := app.validateOptions(&opts); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := app.checkDomain(identity, opts.Domain); err != nil {
+		writeFieldError(w, http.StatusForbidden, errorForbidden, "domain", err.Error())
+		return
+	}
+	if err := app.checkNamespace(identity, opts.Namespace); err != nil {
+		writeFieldError(w, http.StatusForbidden, errorForbidden, "namespace", err.Error())
+		return
+	}
+
+	// custom fields come in as field.<name>=value and apply to every row
+	fields, err := app.checkFields(identity.OrgID(), queryFields(q))
+	if err != nil {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "fields", err.Error())
+		return
+	}
+
+	results := parsePastedURLs(blob)
+	if len(results) == 0 {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "text", "no urls found")
+		return
+	}
+	if len(results) > maxBatchURLs {
+		writeError(w, http.StatusRequestEntityTooLarge, errorTooLarge, fmt.Sprintf("at most %d urls per batch", maxBatchURLs))
+		return
+	}
+
+	for i := range results {
+		if results[i].Status != batchInvalid {
+			results[i].OriginalURL = opts.applyUTM(results[i].OriginalURL)
+			// no dns lookups here, hundreds of them would take forever
+			err := app.validateDestination(results[i].OriginalURL)
+			if err == nil {
+				err = app.checkURLLength(results[i].OriginalURL)
+			}
+			if err == nil {
+				err = app.checkDestination(r.Context(), results[i].OriginalURL, false)
+			}
+			if err != nil {
+				results[i].Status = batchInvalid
+				results[i].Error = err.Error()
+				results[i].OriginalURL = ""
+			}
+		}
+	}
+
+	// only the cheap local heuristics here - hundreds of rdap lookups would take forever
+	risks := map[string]SubmissionRisk{}
+	if app.Config.AbuseQuarantineScore > 0 && app.flagOn(flagAbuseScoring, clientIP(r)) {
+		for _, res := range results {
+			if res.Status != batchInvalid {
+				risks[res.OriginalURL] = app.scoreSubmission(r.Context(), res.OriginalURL, false)
+			}
+		}
+	}
+
+	now := app.now()
+	domains := map[string]string{} // short code -> domain, for building short urls afterwards
+	var created []URL              // live new links, for integrations
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("urls"))
+		if err != nil {
+			return err
+		}
+		reverseBucket, err := tx.CreateBucketIfNotExists([]byte("reverse"))
+		if err != nil {
+			return err
+		}
+
+		seen := map[string]string{}
+		for i := range results {
+			res := &results[i]
+			if res.Status == batchInvalid {
+				continue
+			}
+
+			// with reuse: false every line gets its own code, repeats included
+			reuse := opts.reuses()
+			if reuse {
+				if code, ok := seen[res.OriginalURL]; ok {
+					res.Status = batchRepeated
+					res.ShortCode = code
+					continue
+				}
+				if existing := liveLinkTx(tx, linkOwner(userID, apiKeyID), opts.Domain, opts.Namespace, res.OriginalURL, now); existing != nil {
+					res.Status = batchExisting
+					res.ShortCode = existing.ShortCode
+					domains[res.ShortCode] = existing.Domain
+					seen[res.OriginalURL] = res.ShortCode
+					continue
+				}
+			}
+
+			// collision check happens inside this transaction so nothing can sneak in between
+			shortCode := app.newShortCode(bucket, res.OriginalURL)
+
+			risk := risks[res.OriginalURL]
+			urlData := URL{
+				OriginalURL:  res.OriginalURL,
+				ShortCode:    shortCode,
+				CreatedAt:    now,
+				Campaign:     opts.Campaign,
+				Tags:         opts.Tags,
+				ExpiresAt:    opts.expiresAt(now),
+				Domain:       opts.Domain,
+				Namespace:    opts.Namespace,
+				Redirect:     opts.Redirect,
+				CreatedVia:   source,
+				ClickWebhook: opts.ClickWebhook,
+				Private:      opts.private(),
+				Interstitial: opts.interstitial(),
+				APIKeyID:     apiKeyID,
+				UserID:       userID,
+				OrgID:        identity.OrgID(),
+				Fields:       fields,
+				Quarantined:  app.Config.AbuseQuarantineScore > 0 && risk.Score >= app.Config.AbuseQuarantineScore,
+				RiskScore:    risk.Score,
+				RiskReasons:  risk.Reasons,
+			}
+			urlJSON, err := sealJSON(tx, urlData)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(shortCode), urlJSON); err != nil {
+				return err
+			}
+			if reuse {
+				if err := reverseBucket.Put(reverseKey(tx, linkOwner(userID, apiKeyID), opts.Domain, opts.Namespace, res.OriginalURL), []byte(shortCode)); err != nil {
+					return err
+				}
+			}
+			if err := appendChange(tx, syncEntry(&urlData)); err != nil {
+				return err
+			}
+			entry := requestActor(r)
+			entry.Action, entry.ShortCode, entry.New = "create", shortCode, linkFields(&urlData)
+			if err := auditTx(tx, entry); err != nil {
+				return err
+			}
+
+			if identity.Anonymous() {
+				if res.ClaimToken, err = newClaimToken(tx, shortCode); err != nil {
+					return err
+				}
+			}
+
+			res.Status = batchCreated
+			if urlData.Quarantined {
+				res.Status = batchPending
+			} else {
+				created = append(created, urlData)
+			}
+			res.ShortCode = shortCode
+			domains[shortCode] = opts.Domain
+			seen[res.OriginalURL] = shortCode
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("batch insert error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save urls")
+		return
+	}
+
+	for i := range created {
+		app.cacheLink(&created[i])
+		app.archiveLink(&created[i])
+	}
+	go func() {
+		for i := range created {
+			app.notify(created[i].OrgID, app.linkCreatedEvent(&created[i]))
+		}
+	}()
+
+	resp := BatchResponse{Results: results}
+	for i := range results {
+		res := &results[i]
+		switch res.Status {
+		case batchInvalid:
+			resp.Invalid++
+			continue
+		case batchCreated:
+			resp.Created++
+			app.Metrics.incr("shorten.created")
+		case batchExisting:
+			resp.Existing++
+			app.Metrics.incr("shorten.existing")
+		}
+		res.ShortURL = app.linkShortURL(&URL{ShortCode: res.ShortCode, Domain: domains[res.ShortCode], Namespace: opts.Namespace})
+	}
+
+	// spreadsheets users can paste the result straight back in
+	if q.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"line", "input", "status", "short_url", "original_url", "error"})
+		for _, res := range results {
+			cw.Write([]string{strconv.Itoa(res.Line), res.Input, res.Status, res.ShortURL, res.OriginalURL, res.Error})
+		}
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePastedURLs(t *testing.T) {
+	tests := []struct {
+		name, blob string
+		want       []string // OriginalURL per row, "" for an invalid one
+	}{
+		{"one per line", "https://a.com\nb.com", []string{"https://a.com", "https://b.com"}},
+		{"commas in a url", "https://www.google.com/maps/@40.7128,-74.0060,15z", []string{"https://www.google.com/maps/@40.7128,-74.0060,15z"}},
+		{"semicolon in a query", "https://example.com/search?q=a;b", []string{"https://example.com/search?q=a;b"}},
+		{"comma separated urls", "https://a.com,https://b.com; c.com", []string{"https://a.com", "https://b.com", "https://c.com"}},
+		{"spreadsheet row", "Acme\thttps://example.com/x?q=a,b\tnotes", []string{"https://example.com/x?q=a,b"}},
+		{"csv row", `Maps,"https://www.google.com/maps/@40.7128,-74.0060,15z",12`, []string{"https://www.google.com/maps/@40.7128,-74.0060,15z"}},
+		{"csv with semicolons", `Search;"https://example.com/search?q=a;b"`, []string{"https://example.com/search?q=a;b"}},
+		{"unquoted cells", "Acme,https://a.com", []string{"https://a.com"}},
+		{"nothing", "just some words", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, res := range parsePastedURLs(tt.blob) {
+				got = append(got, res.OriginalURL)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parsePastedURLs(%q) = %q, want %q", tt.blob, got, tt.want)
+			}
+		})
+	}
+}
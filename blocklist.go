@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BlockEntry is one blocked ip range, persisted in the blocklist bucket keyed by cidr
+type BlockEntry struct {
+	CIDR      string    `json:"cidr"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// prefixSet is a concurrency safe list of ip ranges checked on every request.
+// the blocklist copy is read from the bucket at startup and reloaded on changes
+type prefixSet struct {
+	mu       sync.RWMutex
+	prefixes []netip.Prefix
+}
+
+func (b *prefixSet) contains(ip netip.Addr) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, p := range b.prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *prefixSet) set(prefixes []netip.Prefix) {
+	b.mu.Lock()
+	b.prefixes = prefixes
+	b.mu.Unlock()
+}
+
+// parseBlockCIDR accepts "1.2.3.0/24" or a bare ip, which becomes a single-host range
+func parseBlockCIDR(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+// loadBlocklist reads every entry from the store into memory
+func (app *App) loadBlocklist() error {
+	var prefixes []netip.Prefix
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("blocklist"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			p, err := netip.ParsePrefix(string(k))
+			if err != nil {
+				log.Printf("skipping bad blocklist entry %q: %v", k, err)
+				return nil
+			}
+			prefixes = append(prefixes, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	app.Blocklist.set(prefixes)
+	return nil
+}
+
+// blocklistMiddleware refuses requests from blocked ranges - both shortening and
+// redirects. the admin api stays reachable so an operator can't lock themselves out
+func (app *App) blocklistMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/"+apiVersion+"/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, err := netip.ParseAddr(clientIP(r))
+		if err == nil && app.Blocklist.contains(ip.Unmap()) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// realIPMiddleware swaps RemoteAddr for the X-Forwarded-For client when the
+// request came through one of our own proxies, so blocking and rate limits see
+// the real caller. walks right to left and stops at the first untrusted hop
+func (app *App) realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(app.Config.TrustedProxies) == 0 || !app.trustedProxy(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			r.RemoteAddr = net.JoinHostPort(hop, "0")
+			if !app.trustedProxy(hop) {
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *App) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, p := range app.Config.TrustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// handles GET /api/admin/blocklist
+func (app *App) listBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	entries := []BlockEntry{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("blocklist"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var entry BlockEntry
+			if json.Unmarshal(v, &entry) == nil {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("blocklist list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handles POST /api/admin/blocklist - takes effect immediately
+func (app *App) addBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CIDR   string `json:"cidr"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+
+	prefix, err := parseBlockCIDR(req.CIDR)
+	if err != nil {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "cidr", fmt.Sprintf("invalid cidr %q", req.CIDR))
+		return
+	}
+
+	entry := BlockEntry{CIDR: prefix.String(), Reason: strings.TrimSpace(req.Reason), CreatedAt: time.Now()}
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("blocklist"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(entry.CIDR), data)
+	})
+	if err == nil {
+		err = app.loadBlocklist()
+	}
+	if err != nil {
+		log.Printf("blocklist add error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save blocklist entry")
+		return
+	}
+
+	log.Printf("blocked %s (%s)", entry.CIDR, entry.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handles DELETE /api/admin/blocklist?cidr=1.2.3.0/24
+func (app *App) removeBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	prefix, err := parseBlockCIDR(r.URL.Query().Get("cidr"))
+	if err != nil {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "cidr", "invalid cidr")
+		return
+	}
+
+	found := false
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("blocklist"))
+		if bucket == nil || bucket.Get([]byte(prefix.String())) == nil {
+			return nil
+		}
+		found = true
+		return bucket.Delete([]byte(prefix.String()))
+	})
+	if err == nil {
+		err = app.loadBlocklist()
+	}
+	if err != nil {
+		log.Printf("blocklist remove error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errorNotFound, "cidr not in blocklist")
+		return
+	}
+
+	log.Printf("unblocked %s", prefix)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// bookmarklet builds the javascript: link that sends the current tab here
+func (app *App) bookmarklet() template.URL {
+	return template.URL("javascript:location.href=" + strconv.Quote(app.Config.BaseURL+"/shorten?url=") +
+		"+encodeURIComponent(location.href)")
+}
+
+// handles GET /shorten?url= - what the bookmarklet opens, and the installed
+// app's share target (which may only send ?text=). it's a plain page
+// navigation, so it relies on the login cookie and sends people to /login first.
+// with nothing to shorten it shows the bookmarklet to install
+func (app *App) bookmarkletHandler(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	if identity.User == nil {
+		http.Redirect(w, r, app.path("/login?next="+url.QueryEscape(r.URL.RequestURI())), http.StatusSeeOther)
+		return
+	}
+
+	data := map[string]any{
+		"Email":       identity.User.Email,
+		"Bookmarklet": app.bookmarklet(),
+		"CSRF":        csrfFor(r),
+	}
+	status := http.StatusOK
+	if input := sharedURL(r.URL.Query()); input != "" {
+		data["Input"] = input
+		resp, code, err := app.createLink(r, ShortenRequest{URL: input})
+		if err != nil {
+			var failed *shortenErr
+			if !errors.As(err, &failed) {
+				failed = &shortenErr{status: http.StatusInternalServerError, code: errorServer, msg: "server error"}
+			}
+			status = failed.status
+			data["Error"] = failed.msg
+		} else {
+			status = code
+			data["Result"] = resp
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := app.page(r).ExecuteTemplate(w, "bookmarklet.html", data); err != nil {
+		log.Printf("bookmarklet render error: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// what to do with clicks from crawlers and tools
+const (
+	botClicksSeparate = "separate" // counted in BotClickCount, not ClickCount
+	botClicksExclude  = "exclude"  // dropped entirely
+	botClicksOff      = "off"      // no filtering, everything is a click
+)
+
+// substrings (lowercase) of user agents that are never a person clicking a link
+var botUserAgents = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "embedly", "preview",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "okhttp",
+	"java/", "libwww", "httpclient", "headless", "lighthouse", "pingdom", "uptime",
+	"monitor", "scanner", "validator", "linkcheck",
+}
+
+// isBot decides whether a request is a crawler/tool rather than a human click:
+// HEAD requests, missing or known-automated user agents, and known bot ip ranges
+func (app *App) isBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		return true
+	}
+	for _, pattern := range botUserAgents {
+		if strings.Contains(ua, pattern) {
+			return true
+		}
+	}
+	if unfurling(r) {
+		return true
+	}
+
+	if ip, err := netip.ParseAddr(clientIP(r)); err == nil && app.BotNets.contains(ip.Unmap()) {
+		return true
+	}
+	return false
+}
+
+// trackClick records a click in the background. Clicks tracks the pending
+// writes so anything closing the database can wait for them first
+func (app *App) trackClick(shortCode string, click Click) {
+	if !app.flagOn(flagAnalytics, shortCode) || app.Config.AnalyticsLevel == analyticsOff {
+		return
+	}
+	alert := app.judgeClick(shortCode, &click)
+	app.Clicks.Add(1)
+	go func() {
+		defer app.Clicks.Done()
+		app.recordClick(shortCode, click)
+		if len(alert) > 0 {
+			app.clickAnomaly(shortCode, alert)
+		}
+	}()
+}
+
+// recordClick bumps the right counters for a redirect - runs in the background
+// so the user never waits on a db write. bot clicks stay out of the breakdowns
+// unless filtering is off, suspect ones always do
+func (app *App) recordClick(shortCode string, click Click) {
+	if click.Bot && app.Config.BotClicks == botClicksExclude {
+		return
+	}
+	human := (!click.Bot || app.Config.BotClicks == botClicksOff) && !click.Suspect
+
+	var counted *URL
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		err := updateURLTx(tx, shortCode, func(u *URL) error {
+			switch {
+			case human:
+				u.ClickCount++
+			case click.Suspect:
+				u.SuspectClickCount++
+			default:
+				u.BotClickCount++
+			}
+			counted = u
+			return nil
+		})
+		if err != nil || counted == nil || !human {
+			return err
+		}
+		return recordStats(tx, shortCode, click)
+	})
+	if err != nil {
+		log.Printf("click count error for %s: %v", shortCode, err)
+		return
+	}
+	if counted != nil && human {
+		app.clickMilestone(counted)
+	}
+}
+
+// loadBotNets reads known crawler ranges from a file, one ip or cidr per line,
+// # comments allowed - the format most published bot lists already use
+func loadBotNets(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		p, err := parseBlockCIDR(line)
+		if err != nil {
+			log.Printf("skipping bad bot ip entry %q: %v", line, err)
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, scanner.Err()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// per-link redirect modes
+const (
+	redirectPermanent = "permanent" // 301, browsers and cdns may cache it for REDIRECT_MAX_AGE
+	redirectTemporary = "temporary" // 302 + no-store, every click reaches us and gets counted
+	redirectFrame     = "frame"     // the destination in an iframe under the short url, see cloak.go
+	redirectMeta      = "meta"      // a page with a meta refresh instead of a 3xx, see cloak.go
+)
+
+// cachedLink is what the redirect cache holds per short code
+type cachedLink struct {
+	URL          string
+	Domain       string // short domain the link belongs to, for DOMAIN_SCOPED_CODES
+	Namespace    string // path prefix it answers under, see namespaces.go
+	Temporary    bool
+	Mode         string // the link's redirect, frame and meta are served by cloak.go
+	ExpiresAt    *time.Time
+	Webhook      string            // click_webhook, see clickhook.go
+	Rule         string            // picks the destination per click, see rules.go
+	Rewrite      *Rewrite          // answers /{code}/{rest} too, see rewrite.go
+	Countries    *GeoRule          // who gets the redirect, see geoblock.go
+	Card         *LinkCard         // served to unfurlers instead, see card.go
+	Headers      map[string]string // sent with the redirect, see headers.go
+	Private      bool              // needs a login or an access url, see private.go
+	Interstitial bool              // a warning page first, see interstitial.go
+}
+
+func (link cachedLink) expired(now time.Time) bool {
+	return link.ExpiresAt != nil && !link.ExpiresAt.After(now)
+}
+
+// cacheLink puts a link in the redirect cache - never past the link's expiry
+func (app *App) cacheLink(u *URL) {
+	app.Cache.Set(u.ShortCode, toCachedLink(u), app.cacheTTL(u.ExpiresAt))
+}
+
+// toCachedLink is the part of a link the redirect needs
+func toCachedLink(u *URL) cachedLink {
+	return cachedLink{
+		URL:          u.OriginalURL,
+		Domain:       u.Domain,
+		Namespace:    u.Namespace,
+		Temporary:    u.Redirect == redirectTemporary,
+		Mode:         u.Redirect,
+		ExpiresAt:    u.ExpiresAt,
+		Webhook:      u.ClickWebhook,
+		Rule:         u.Rule,
+		Rewrite:      u.Rewrite,
+		Countries:    u.Countries,
+		Card:         u.Card,
+		Headers:      u.Headers,
+		Private:      u.Private,
+		Interstitial: u.Interstitial,
+	}
+}
+
+// redirect answers with the link's redirect and says explicitly how long
+// downstream caches may keep it, rather than leaving a bare 301 to heuristics
+func (app *App) redirect(w http.ResponseWriter, r *http.Request, link cachedLink) {
+	app.noindex(w)
+	if card := app.unfurlCard(link); card != nil {
+		// unfurlers get the card page from the same url, caches have to tell them apart
+		w.Header().Add("Vary", "User-Agent")
+		if unfurling(r) {
+			app.serveCard(w, r, link, card)
+			return
+		}
+	}
+	app.Metrics.incr("redirects")
+	setLinkHeaders(w, link)
+	if link.cloaked() {
+		app.cloak(w, r, link)
+		return
+	}
+	if link.Interstitial {
+		app.interstitial(w, r, link)
+		return
+	}
+	// a private link's answer depends on who's asking, nothing may keep it
+	if link.Temporary || link.Private {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, link.URL, http.StatusFound)
+		return
+	}
+
+	maxAge := app.Config.RedirectMaxAge
+	if link.ExpiresAt != nil {
+		maxAge = min(maxAge, link.ExpiresAt.Sub(app.now()))
+	}
+	maxAge = max(maxAge, 0).Truncate(time.Second)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	w.Header().Set("Expires", app.now().Add(maxAge).UTC().Format(http.TimeFormat))
+	http.Redirect(w, r, link.URL, http.StatusMovedPermanently)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// days of history in a campaign report
+const campaignDays = 30
+
+// CampaignSummary is one row of GET /api/campaigns. campaigns aren't stored on
+// their own - they're the links sharing a campaign name, added up
+type CampaignSummary struct {
+	Name          string    `json:"name"`
+	Links         int       `json:"links"`
+	Clicks        int       `json:"clicks"`
+	BotClicks     int       `json:"bot_clicks,omitempty"`
+	SuspectClicks int       `json:"suspect_clicks,omitempty"`
+	FirstCreated  time.Time `json:"first_created"`
+	LastCreated   time.Time `json:"last_created"`
+}
+
+func (s *CampaignSummary) add(u *URL) {
+	if s.Links == 0 || u.CreatedAt.Before(s.FirstCreated) {
+		s.FirstCreated = u.CreatedAt
+	}
+	if u.CreatedAt.After(s.LastCreated) {
+		s.LastCreated = u.CreatedAt
+	}
+	s.Links++
+	s.Clicks += u.ClickCount
+	s.BotClicks += u.BotClickCount
+	s.SuspectClicks += u.SuspectClickCount
+}
+
+// CampaignLink is a link's share of its campaign
+type CampaignLink struct {
+	ShortCode   string  `json:"short_code"`
+	ShortURL    string  `json:"short_url"`
+	OriginalURL string  `json:"original_url"`
+	Clicks      int     `json:"clicks"`
+	Share       float64 `json:"share"` // fraction of the campaign's clicks
+}
+
+// response for GET /api/campaigns/{name}
+type CampaignReport struct {
+	CampaignSummary
+	Daily     []DayCount     `json:"daily"`     // last 30 days across all links, oldest first
+	Breakdown []CampaignLink `json:"breakdown"` // busiest first
+}
+
+// campaignLinks collects the caller's links, grouped by campaign. admins see everyone's
+func (app *App) campaignLinks(id *Identity, only string) (map[string][]URL, error) {
+	links, _, err := app.listURLs("", -1, func(u *URL) bool {
+		return u.Campaign != "" && u.TrashedAt == nil && (only == "" || u.Campaign == only) && id.owns(u)
+	})
+	groups := map[string][]URL{}
+	for _, u := range links {
+		groups[u.Campaign] = append(groups[u.Campaign], u)
+	}
+	return groups, err
+}
+
+// handles GET /api/campaigns
+func (app *App) listCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := app.campaignLinks(identityFromContext(r.Context()), "")
+	if err != nil {
+		log.Printf("campaign list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	out := []CampaignSummary{}
+	for name, links := range groups {
+		summary := CampaignSummary{Name: name}
+		for i := range links {
+			summary.add(&links[i])
+		}
+		out = append(out, summary)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastCreated.After(out[j].LastCreated) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handles GET /api/campaigns/{name} - totals, daily clicks and the per-link split
+func (app *App) campaignHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	groups, err := app.campaignLinks(identityFromContext(r.Context()), name)
+	if err != nil {
+		log.Printf("campaign report error for %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if len(groups[name]) == 0 {
+		writeError(w, http.StatusNotFound, errorNotFound, "campaign not found")
+		return
+	}
+
+	report := CampaignReport{CampaignSummary: CampaignSummary{Name: name}, Breakdown: []CampaignLink{}}
+	for i := range groups[name] {
+		u := &groups[name][i]
+		report.add(u)
+		report.Breakdown = append(report.Breakdown, CampaignLink{
+			ShortCode:   u.ShortCode,
+			ShortURL:    app.linkShortURL(u),
+			OriginalURL: u.OriginalURL,
+			Clicks:      u.ClickCount,
+		})
+
+		days, err := app.dailyClicks(u.ShortCode, campaignDays)
+		if err != nil {
+			log.Printf("campaign report error for %s: %v", name, err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+		if report.Daily == nil {
+			report.Daily = days
+			continue
+		}
+		for d := range days {
+			report.Daily[d].Count += days[d].Count
+		}
+	}
+
+	for i := range report.Breakdown {
+		if report.Clicks > 0 {
+			report.Breakdown[i].Share = float64(report.Breakdown[i].Clicks) / float64(report.Clicks)
+		}
+	}
+	sort.SliceStable(report.Breakdown, func(i, j int) bool { return report.Breakdown[i].Clicks > report.Breakdown[j].Clicks })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
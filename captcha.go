@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
+)
+
+// RiskProvider decides if a request looks human enough to let through.
+// captcha vendors and local heuristics all sit behind this so a deployment
+// can swap turnstile for hcaptcha (or neither) without touching handlers
+type RiskProvider interface {
+	Name() string
+	Assess(r *http.Request) (RiskResult, error)
+}
+
+// RiskResult is what a provider thinks about a single request
+type RiskResult struct {
+	Allowed bool
+	Score   float64 // 0 = looks clean, 1 = almost certainly abuse
+	Reason  string
+}
+
+// clients send the widget token in this header since the api body is json
+const captchaTokenHeader = "X-Captcha-Token"
+
+// siteverify response - turnstile and hcaptcha share the same basic shape
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score,omitempty"` // hcaptcha enterprise and recaptcha v3 only
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// siteVerify posts a token to a vendor's verification endpoint
+func siteVerify(client *http.Client, endpoint string, form url.Values) (*siteVerifyResponse, error) {
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("siteverify returned %s", resp.Status)
+	}
+
+	var out siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func verifyResult(out *siteVerifyResponse) RiskResult {
+	res := RiskResult{Allowed: out.Success}
+	if out.Score != nil {
+		res.Score = *out.Score
+	} else if !out.Success {
+		res.Score = 1
+	}
+	if !out.Success {
+		res.Reason = strings.Join(out.ErrorCodes, ",")
+	}
+	return res
+}
+
+// cloudflare turnstile
+type turnstileProvider struct {
+	secret string
+	client *http.Client
+}
+
+func (p *turnstileProvider) Name() string { return "turnstile" }
+
+func (p *turnstileProvider) Assess(r *http.Request) (RiskResult, error) {
+	token := r.Header.Get(captchaTokenHeader)
+	if token == "" {
+		return RiskResult{Score: 1, Reason: "missing captcha token"}, nil
+	}
+
+	out, err := siteVerify(p.client, "https://challenges.cloudflare.com/turnstile/v0/siteverify", url.Values{
+		"secret":   {p.secret},
+		"response": {token},
+		"remoteip": {clientIP(r)},
+	})
+	if err != nil {
+		return RiskResult{}, err
+	}
+	return verifyResult(out), nil
+}
+
+// hcaptcha
+type hcaptchaProvider struct {
+	secret  string
+	siteKey string
+	client  *http.Client
+}
+
+func (p *hcaptchaProvider) Name() string { return "hcaptcha" }
+
+func (p *hcaptchaProvider) Assess(r *http.Request) (RiskResult, error) {
+	token := r.Header.Get(captchaTokenHeader)
+	if token == "" {
+		return RiskResult{Score: 1, Reason: "missing captcha token"}, nil
+	}
+
+	form := url.Values{
+		"secret":   {p.secret},
+		"response": {token},
+		"remoteip": {clientIP(r)},
+	}
+	if p.siteKey != "" {
+		form.Set("sitekey", p.siteKey)
+	}
+
+	out, err := siteVerify(p.client, "https://api.hcaptcha.com/siteverify", form)
+	if err != nil {
+		return RiskResult{}, err
+	}
+	return verifyResult(out), nil
+}
+
+// google recaptcha - v2 checkbox just says success, v3 adds a score where
+// 1.0 is very likely human (the opposite of our Score)
+type recaptchaProvider struct {
+	secret   string
+	minScore float64
+	client   *http.Client
+}
+
+func (p *recaptchaProvider) Name() string { return "recaptcha" }
+
+func (p *recaptchaProvider) Assess(r *http.Request) (RiskResult, error) {
+	token := r.Header.Get(captchaTokenHeader)
+	if token == "" {
+		return RiskResult{Score: 1, Reason: "missing captcha token"}, nil
+	}
+
+	out, err := siteVerify(p.client, "https://www.google.com/recaptcha/api/siteverify", url.Values{
+		"secret":   {p.secret},
+		"response": {token},
+		"remoteip": {clientIP(r)},
+	})
+	if err != nil {
+		return RiskResult{}, err
+	}
+
+	res := verifyResult(out)
+	if out.Success && out.Score != nil {
+		res.Score = 1 - *out.Score
+		if *out.Score < p.minScore {
+			res.Allowed = false
+			res.Reason = "low recaptcha score"
+		}
+	}
+	return res, nil
+}
+
+// heuristicProvider scores requests locally without calling anyone -
+// good enough for small deployments that don't want a vendor at all
+type heuristicProvider struct {
+	maxPerMinute int
+	hits         *cache.Cache // ip -> requests in the current window
+}
+
+func newHeuristicProvider(maxPerMinute int) *heuristicProvider {
+	return &heuristicProvider{
+		maxPerMinute: maxPerMinute,
+		hits:         cache.New(time.Minute, 2*time.Minute),
+	}
+}
+
+func (p *heuristicProvider) Name() string { return "heuristic" }
+
+func (p *heuristicProvider) Assess(r *http.Request) (RiskResult, error) {
+	var score float64
+	var reasons []string
+
+	ua := strings.ToLower(r.UserAgent())
+	switch {
+	case ua == "":
+		score += 0.5
+		reasons = append(reasons, "no user agent")
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "spider") || strings.Contains(ua, "headless"):
+		score += 0.4
+		reasons = append(reasons, "automated user agent")
+	}
+
+	if r.Header.Get("Accept-Language") == "" {
+		score += 0.2
+		reasons = append(reasons, "no accept-language")
+	}
+
+	// first hit in the window creates the counter, the rest bump it
+	ip := clientIP(r)
+	count := 1
+	if err := p.hits.Add(ip, 1, cache.DefaultExpiration); err != nil {
+		count, _ = p.hits.IncrementInt(ip, 1)
+	}
+	if p.maxPerMinute > 0 && count > p.maxPerMinute {
+		score += 0.6
+		reasons = append(reasons, "too many requests")
+	}
+
+	if score > 1 {
+		score = 1
+	}
+	return RiskResult{
+		Allowed: score < 0.7,
+		Score:   score,
+		Reason:  strings.Join(reasons, ", "),
+	}, nil
+}
+
+// newRiskProviders wires up the providers that are configured and maps
+// them onto route names, so each route can pick its own (or none)
+func newRiskProviders(cfg *Config) (map[string]RiskProvider, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	available := map[string]RiskProvider{
+		"heuristic": newHeuristicProvider(cfg.RiskMaxPerMin),
+	}
+	if cfg.TurnstileSecret != "" {
+		available["turnstile"] = &turnstileProvider{secret: cfg.TurnstileSecret, client: client}
+	}
+	if cfg.HCaptchaSecret != "" {
+		available["hcaptcha"] = &hcaptchaProvider{secret: cfg.HCaptchaSecret, siteKey: cfg.HCaptchaSiteKey, client: client}
+	}
+	if cfg.RecaptchaSecret != "" {
+		available["recaptcha"] = &recaptchaProvider{secret: cfg.RecaptchaSecret, minScore: cfg.RecaptchaMinScore, client: client}
+	}
+
+	routes := map[string]RiskProvider{}
+	for route, name := range cfg.CaptchaRoutes {
+		if name == "" || name == "none" {
+			continue
+		}
+		provider, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("route %q wants risk provider %q which is not configured", route, name)
+		}
+		routes[route] = provider
+	}
+	return routes, nil
+}
+
+// CaptchaWidget tells the web ui which vendor widget (if any) to render for a route
+type CaptchaWidget struct {
+	Provider string // turnstile, hcaptcha or recaptcha
+	SiteKey  string
+}
+
+func (app *App) captchaWidget(route string) *CaptchaWidget {
+	provider, ok := app.RiskProviders[route]
+	if !ok {
+		return nil
+	}
+
+	var siteKey string
+	switch provider.Name() {
+	case "turnstile":
+		siteKey = app.Config.TurnstileSiteKey
+	case "hcaptcha":
+		siteKey = app.Config.HCaptchaSiteKey
+	case "recaptcha":
+		siteKey = app.Config.RecaptchaSiteKey
+	}
+	if siteKey == "" {
+		return nil // heuristic, or a vendor without a site key - nothing to render
+	}
+	return &CaptchaWidget{Provider: provider.Name(), SiteKey: siteKey}
+}
+
+// riskMiddleware runs the provider configured for the matched route (if any)
+func (app *App) riskMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		provider, ok := app.RiskProviders[route.GetName()]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// authenticated clients are already accountable, captchas are for anonymous browsers
+		if !identityFromContext(r.Context()).Anonymous() || !app.flagOn(flagCaptcha, clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res, err := provider.Assess(r)
+		if err != nil {
+			// fail closed - if the vendor is down we'd rather refuse than let spam in
+			log.Printf("%s verification error: %v", provider.Name(), err)
+			writeError(w, http.StatusServiceUnavailable, errorUnavailable, "verification unavailable, try again")
+			return
+		}
+		if !res.Allowed {
+			log.Printf("%s blocked %s on %s: %s (score %.2f)", provider.Name(), clientIP(r), route.GetName(), res.Reason, res.Score)
+			writeError(w, http.StatusForbidden, errorVerification, "verification failed")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// social cards. a link can carry its own title, description and image for
+// when it's shared: chat apps and social sites fetch the short url to build a
+// preview, and instead of the redirect (and whatever the destination says
+// about itself) they get a small page with og: and twitter: tags from the
+// card. people still get the plain redirect, see unfurl.go for who's who. the
+// page has no og:url on purpose - facebook would go and fetch that instead
+
+const maxCardImageLen = 2048
+
+// LinkCard is what a shared link unfurls as
+type LinkCard struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"` // absolute http(s) url
+}
+
+// validateCard trims a card before it's saved. nil or nothing set means none
+func validateCard(c *LinkCard) (*LinkCard, error) {
+	if c == nil {
+		return nil, nil
+	}
+	card := LinkCard{
+		Title:       strings.TrimSpace(c.Title),
+		Description: strings.TrimSpace(c.Description),
+		Image:       strings.TrimSpace(c.Image),
+	}
+	if card == (LinkCard{}) {
+		return nil, nil
+	}
+	var problems fieldErrors
+	if utf8.RuneCountInString(card.Title) > maxTitleLen {
+		problems.add("card.title", fmt.Errorf("card title must be at most %d characters", maxTitleLen))
+	}
+	if utf8.RuneCountInString(card.Description) > maxDescriptionLen {
+		problems.add("card.description", fmt.Errorf("card description must be at most %d characters", maxDescriptionLen))
+	}
+	if card.Image != "" {
+		u, err := url.Parse(card.Image)
+		if err != nil || !webURL(card.Image) || u.Host == "" || len(card.Image) > maxCardImageLen {
+			problems.add("card.image", errors.New("card image must be an absolute http(s) url"))
+		}
+	}
+	return &card, problems.err()
+}
+
+// serveCard answers a preview fetcher with a card for the link. it moves on
+// to the destination like a meta link, in case a person ends up on it after all
+func (app *App) serveCard(w http.ResponseWriter, r *http.Request, link cachedLink, card *LinkCard) {
+	host := link.URL
+	if u, err := url.Parse(link.URL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := app.page(r).ExecuteTemplate(w, "card.html", map[string]any{
+		"URL":  link.URL,
+		"Host": host,
+		"Card": card,
+	})
+	if err != nil {
+		log.Printf("card render error: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCardServedToCrawlers(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	req := ShortenRequest{URL: "https://example.com/launch"}
+	req.Card = &LinkCard{Title: " Our launch ", Description: "Everything <new>", Image: "https://cdn.example.com/card.png"}
+	code := ta.shorten(req, http.StatusOK).ShortCode
+
+	rec := ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("crawler: status %d: %s", rec.Code, body)
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="Our launch">`,
+		`<meta property="og:description" content="Everything &lt;new&gt;">`,
+		`<meta property="og:image" content="https://cdn.example.com/card.png">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+		`url=https://example.com/launch`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("card page is missing %s:\n%s", want, body)
+		}
+	}
+
+	// people still get the redirect, and caches know it depends on who asks
+	rec = ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Vary") != "User-Agent" {
+		t.Errorf("person: status %d, vary %q", rec.Code, rec.Header().Get("Vary"))
+	}
+
+	// a link without a card redirects crawlers as before
+	plain := ta.shorten(ShortenRequest{URL: "https://example.com/plain"}, http.StatusOK).ShortCode
+	if rec := ta.do(http.MethodGet, "/"+plain, nil, "User-Agent", "Twitterbot/1.0"); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("no card: status %d", rec.Code)
+	}
+}
+
+func TestCardUpdate(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/later"}, http.StatusOK).ShortCode
+	crawler := []string{"User-Agent", "facebookexternalhit/1.1"}
+
+	// the redirect is cached by now, the edit has to reach it
+	ta.do(http.MethodGet, "/"+code, nil, crawler...)
+	rec := ta.do(http.MethodPatch, "/api/v1/links/"+code, map[string]any{"card": LinkCard{Title: "Later"}}, admin...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set: status %d: %s", rec.Code, rec.Body)
+	}
+	rec = ta.do(http.MethodGet, "/"+code, nil, crawler...)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<meta name="twitter:card" content="summary">`) {
+		t.Errorf("after set: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// an empty card clears it
+	ta.do(http.MethodPatch, "/api/v1/links/"+code, map[string]any{"card": LinkCard{}}, admin...)
+	if rec := ta.do(http.MethodGet, "/"+code, nil, crawler...); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("after clear: status %d", rec.Code)
+	}
+
+	rec = ta.do(http.MethodPatch, "/api/v1/links/"+code, map[string]any{"card": LinkCard{Title: strings.Repeat("x", maxTitleLen+1), Image: "/card.png"}}, admin...)
+	var resp ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if errs := resp.Errors; rec.Code != http.StatusBadRequest || len(errs) != 2 || errs[0].Field != "card.title" || errs[1].Field != "card.image" {
+		t.Errorf("invalid: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// links made without any credentials come back with a one-time claim token.
+// whoever holds it can later pull the link into their account, stats and all
+// (stats are keyed by short code so there's nothing to move). only the hash
+// is kept, in the claims bucket: short code -> sha256 of the token
+
+// newClaimToken stores a claim for a fresh anonymous link inside the caller's
+// transaction and returns the plaintext token for the response
+func newClaimToken(tx *bolt.Tx, shortCode string) (string, error) {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("claims"))
+	if err != nil {
+		return "", err
+	}
+	token := randomToken("ct_", 24)
+	return token, bucket.Put([]byte(shortCode), []byte(hashToken(token)))
+}
+
+// handles POST /api/links/{shortCode}/claim - moves an anonymous link into
+// the logged-in user's account
+func (app *App) claimLinkHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	user := identityFromContext(r.Context()).User
+	if user == nil {
+		writeError(w, http.StatusForbidden, errorForbidden, "claiming a link needs a user login")
+		return
+	}
+
+	var req struct {
+		ClaimToken string `json:"claim_token"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if req.ClaimToken == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "claim_token", "claim_token is required")
+		return
+	}
+
+	var claimed *URL
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		claims := tx.Bucket([]byte("claims"))
+		if claims == nil {
+			return nil
+		}
+		stored := claims.Get([]byte(shortCode))
+		if stored == nil || subtle.ConstantTimeCompare(stored, []byte(hashToken(req.ClaimToken))) != 1 {
+			return nil
+		}
+
+		err := updateURLTx(tx, shortCode, func(u *URL) error {
+			// someone may have taken it over another way in the meantime
+			if u.UserID != "" || u.APIKeyID != "" {
+				return nil
+			}
+			u.UserID = user.ID
+			u.OrgID = user.OrgID
+			claimed = u
+			return nil
+		})
+		if err != nil || claimed == nil {
+			return err
+		}
+		entry := requestActor(r)
+		entry.Action, entry.ShortCode = "claim", shortCode
+		entry.New = map[string]any{"user_id": claimed.UserID, "org_id": claimed.OrgID}
+		if err := auditTx(tx, entry); err != nil {
+			return err
+		}
+		return claims.Delete([]byte(shortCode))
+	})
+	if err != nil {
+		log.Printf("claim error for %s: %v", shortCode, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if claimed == nil {
+		// wrong token, already claimed and missing link all look the same
+		writeError(w, http.StatusNotFound, errorNotFound, "nothing to claim")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claimed)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a link can have a click_webhook - a url that gets a POST with the click's
+// details on every redirect, for people feeding clicks into their own
+// tracking. posts go out in the background through the same locked down
+// client as org integrations, so the visitor never waits on them.
+//
+// endpoints are protected from us and we from them: each one gets a token
+// bucket (CLICK_WEBHOOK_RATE, CLICK_WEBHOOK_BURST) and clicks over it are
+// dropped, and CLICK_WEBHOOK_FAILURES failures in a row open a breaker that
+// skips the endpoint for CLICK_WEBHOOK_COOLDOWN. after that one post is let
+// through to see if it's back
+
+const (
+	maxClickWebhookLen = 2048
+	eventClick         = "link.click"
+)
+
+// ClickEvent is the body of a click webhook post
+type ClickEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	ShortCode   string    `json:"short_code"`
+	ShortURL    string    `json:"short_url"`
+	Destination string    `json:"destination"`
+	Referrer    string    `json:"referrer,omitempty"`
+	Browser     string    `json:"browser,omitempty"`
+	OS          string    `json:"os,omitempty"`
+	Country     string    `json:"country,omitempty"`
+	Bot         bool      `json:"bot"`
+}
+
+// normalizeClickWebhook checks a click_webhook value, empty means none
+func normalizeClickWebhook(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", errors.New("click_webhook must be an absolute http(s) url")
+	}
+	if len(u.String()) > maxClickWebhookLen {
+		return "", fmt.Errorf("click_webhook must be at most %d characters", maxClickWebhookLen)
+	}
+	return u.String(), nil
+}
+
+// clickHooks is the rate limit and breaker state for every endpoint, keyed
+// by url. it outlives reloads, the limits come from whichever config is current
+type clickHooks struct {
+	mu        sync.Mutex
+	endpoints map[string]*hookState
+}
+
+type hookState struct {
+	tokens    float64
+	refilled  time.Time
+	failures  int       // in a row
+	openUntil time.Time // breaker open until then
+	probing   bool      // the post after a cooldown is out, hold the rest until it's back
+}
+
+func newClickHooks() *clickHooks {
+	return &clickHooks{endpoints: map[string]*hookState{}}
+}
+
+// allow reports whether a post to endpoint can go out now, taking a token if so
+func (h *clickHooks) allow(cfg *Config, endpoint string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.endpoints[endpoint]
+	if s == nil {
+		s = &hookState{tokens: float64(cfg.ClickWebhookBurst), refilled: now}
+		h.endpoints[endpoint] = s
+	}
+	if now.Before(s.openUntil) || s.probing {
+		return false
+	}
+
+	if cfg.ClickWebhookRate > 0 {
+		s.tokens = min(float64(cfg.ClickWebhookBurst), s.tokens+now.Sub(s.refilled).Seconds()*cfg.ClickWebhookRate)
+		s.refilled = now
+		if s.tokens < 1 {
+			return false
+		}
+		s.tokens--
+	}
+	// first one after the breaker opened - see how it goes before sending more
+	if !s.openUntil.IsZero() {
+		s.probing = true
+	}
+	return true
+}
+
+// done records how a post went, opening the breaker after too many failures
+func (h *clickHooks) done(cfg *Config, endpoint string, err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.endpoints[endpoint]
+	if s == nil {
+		return
+	}
+	s.probing = false
+	if err == nil {
+		s.failures, s.openUntil = 0, time.Time{}
+		return
+	}
+	s.failures++
+	if !s.openUntil.IsZero() || (cfg.ClickWebhookFailures > 0 && s.failures >= cfg.ClickWebhookFailures) {
+		if s.openUntil.IsZero() || !now.Before(s.openUntil) {
+			log.Printf("click webhook %s failed %d times in a row, pausing it for %s", endpoint, s.failures, cfg.ClickWebhookCooldown)
+		}
+		s.openUntil = now.Add(cfg.ClickWebhookCooldown)
+	}
+}
+
+// clickWebhook posts a click to the link's webhook, if it has one
+func (app *App) clickWebhook(shortCode string, link cachedLink, click Click) {
+	if link.Webhook == "" {
+		return
+	}
+	if !app.ClickHooks.allow(app.Config, link.Webhook, time.Now()) {
+		app.Metrics.incr("click_webhook.dropped")
+		return
+	}
+
+	ev := ClickEvent{
+		Type:        eventClick,
+		Time:        click.Time,
+		ShortCode:   shortCode,
+		ShortURL:    app.linkShortURL(&URL{ShortCode: shortCode, Domain: link.Domain, Namespace: link.Namespace}),
+		Destination: link.URL,
+		Referrer:    click.Referrer,
+		Browser:     click.Browser,
+		OS:          click.OS,
+		Country:     click.Country,
+		Bot:         click.Bot,
+	}
+	// nothing about a visitor who opted out, see privacy.go
+	if click.Private {
+		ev.Referrer, ev.Browser, ev.OS, ev.Country = "", "", "", ""
+	}
+	go func() {
+		err := app.postClick(link.Webhook, ev)
+		if err != nil {
+			log.Printf("click webhook for %s failed: %v", shortCode, err)
+			app.Metrics.incr("click_webhook.failed")
+		} else {
+			app.Metrics.incr("click_webhook.sent")
+		}
+		app.ClickHooks.done(app.Config, link.Webhook, err, time.Now())
+	}()
+}
+
+func (app *App) postClick(endpoint string, ev ClickEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", outboundUserAgent)
+	req.Header.Set(eventTypeHeader, eventClick)
+
+	resp, err := app.Webhooks.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClickWebhook(t *testing.T) {
+	events := make(chan ClickEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev ClickEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		events <- ev
+	}))
+	defer hook.Close()
+
+	ta := newTestApp(t, map[string]string{"INTEGRATIONS_ALLOW_PRIVATE": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	bad := ShortenRequest{URL: "https://example.com/hooked"}
+	bad.ClickWebhook = "ftp://example.com/"
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", bad); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad webhook: status %d", rec.Code)
+	}
+
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/hooked"}, http.StatusOK).ShortCode
+	if rec := ta.do(http.MethodPatch, "/api/v1/links/"+code, map[string]string{"click_webhook": hook.URL}, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("patch: status %d: %s", rec.Code, rec.Body)
+	}
+
+	ta.do(http.MethodGet, "/"+code, nil, "Referer", "https://ref.example/page")
+	select {
+	case ev := <-events:
+		if ev.Type != eventClick || ev.ShortCode != code || ev.ShortURL != "http://sho.rt/"+code || ev.Destination != "https://example.com/hooked" || ev.Referrer != "ref.example" {
+			t.Errorf("event = %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook never called")
+	}
+}
+
+func TestClickHooksBreaker(t *testing.T) {
+	cfg := &Config{ClickWebhookRate: 1, ClickWebhookBurst: 2, ClickWebhookFailures: 2, ClickWebhookCooldown: time.Minute}
+	h := newClickHooks()
+	now := time.Now()
+	const endpoint = "https://hooks.example/click"
+
+	if !h.allow(cfg, endpoint, now) || !h.allow(cfg, endpoint, now) {
+		t.Fatal("burst should be allowed")
+	}
+	if h.allow(cfg, endpoint, now) {
+		t.Fatal("third post in the same instant should be rate limited")
+	}
+
+	failed := errors.New("boom")
+	h.done(cfg, endpoint, failed, now)
+	h.done(cfg, endpoint, failed, now)
+	if h.allow(cfg, endpoint, now.Add(30*time.Second)) {
+		t.Fatal("breaker should be open")
+	}
+
+	// after the cooldown one probe goes out, and nothing else until it's back
+	later := now.Add(2 * time.Minute)
+	if !h.allow(cfg, endpoint, later) {
+		t.Fatal("probe should be allowed after the cooldown")
+	}
+	if h.allow(cfg, endpoint, later) {
+		t.Fatal("only one probe at a time")
+	}
+	h.done(cfg, endpoint, nil, later)
+	if !h.allow(cfg, endpoint, later) {
+		t.Fatal("breaker should close after a successful probe")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// raw click events live in the "clicks" bucket, a sub-bucket per link keyed by
+// unix nanos + sequence so a cursor walks them oldest first. once they're older
+// than CLICK_RETENTION the compactor folds them into per-day counters in the
+// link's stats bucket ("daily:<yyyy-mm-dd>:ref:<host>" and so on) and drops them.
+// compaction works in whole utc days, so any day is either all raw or all rolled up
+type clickEvent struct {
+	Referrer string `json:"r"`
+	Browser  string `json:"b"`
+	OS       string `json:"o"`
+	Country  string `json:"c,omitempty"`
+}
+
+// how many events one compactor transaction handles, keeps write locks short
+const compactBatch = 5000
+
+func clickKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func clickTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+// recordEvent stores a raw click inside the caller's transaction
+func recordEvent(tx *bolt.Tx, shortCode string, click Click) error {
+	clicks, err := tx.CreateBucketIfNotExists([]byte("clicks"))
+	if err != nil {
+		return err
+	}
+	bucket, err := clicks.CreateBucketIfNotExists([]byte(shortCode))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := sealJSON(tx, clickEvent{Referrer: click.Referrer, Browser: click.Browser, OS: click.OS, Country: click.Country})
+	if err != nil {
+		return err
+	}
+	return bucket.Put(clickKey(click.Time, seq), data)
+}
+
+// field of an event that a breakdown prefix counts
+func (e *clickEvent) value(prefix string) string {
+	switch prefix {
+	case "ref:":
+		return e.Referrer
+	case "browser:":
+		return e.Browser
+	case "os:":
+		return e.OS
+	case "country:":
+		return e.Country
+	}
+	return ""
+}
+
+// compactionCutoff is the start of the oldest utc day that's still kept raw
+func (app *App) compactionCutoff() time.Time {
+	return time.Now().Add(-app.Config.ClickRetention).UTC().Truncate(24 * time.Hour)
+}
+
+// rangeBreakdown is statBreakdown limited to clicks since a day - raw events for
+// recent days, the daily rollups for anything older
+func (app *App) rangeBreakdown(shortCode, prefix string, since time.Time) ([]StatCount, uint64, error) {
+	counts := map[string]uint64{}
+	var total uint64
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		sealer := storeCipherOf(tx)
+		if stats := tx.Bucket([]byte("stats")); stats != nil {
+			if bucket := stats.Bucket([]byte(shortCode)); bucket != nil {
+				c := bucket.Cursor()
+				start := "daily:" + since.Format(time.DateOnly)
+				for k, v := c.Seek([]byte(start)); k != nil && strings.HasPrefix(string(k), "daily:"); k, v = c.Next() {
+					// daily:<yyyy-mm-dd>:<prefix><value>
+					rest := string(k[len("daily:yyyy-mm-dd:"):])
+					if len(v) != 8 || !strings.HasPrefix(rest, prefix) {
+						continue
+					}
+					n := binary.BigEndian.Uint64(v)
+					counts[sealer.openWord(strings.TrimPrefix(rest, prefix))] += n
+					total += n
+				}
+			}
+		}
+
+		clicks := tx.Bucket([]byte("clicks"))
+		if clicks == nil {
+			return nil
+		}
+		bucket := clicks.Bucket([]byte(shortCode))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(clickKey(since, 0)); k != nil; k, v = c.Next() {
+			var event clickEvent
+			// events from before a dimension was recorded have nothing for it
+			if openJSON(tx, v, &event) != nil || event.value(prefix) == "" {
+				continue
+			}
+			counts[event.value(prefix)]++
+			total++
+		}
+		return nil
+	})
+
+	rows := []StatCount{}
+	for key, n := range counts {
+		rows = append(rows, StatCount{Key: key, Count: n})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows, total, err
+}
+
+// compactOldClicks rolls raw events past CLICK_RETENTION up into daily counters
+func (app *App) compactOldClicks() {
+	compacted, err := app.compactClicks(app.compactionCutoff())
+	if err != nil {
+		log.Printf("click compaction error: %v", err)
+	}
+	if compacted > 0 {
+		log.Printf("click compaction: rolled up %d events", compacted)
+	}
+}
+
+func (app *App) compactClicks(cutoff time.Time) (int, error) {
+	var codes []string
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		clicks := tx.Bucket([]byte("clicks"))
+		if clicks == nil {
+			return nil
+		}
+		return clicks.ForEachBucket(func(k []byte) error {
+			codes = append(codes, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	compacted := 0
+	for _, code := range codes {
+		for {
+			n, err := app.compactLink(code, cutoff)
+			if err != nil {
+				return compacted, err
+			}
+			compacted += n
+			if n < compactBatch {
+				break
+			}
+		}
+	}
+	return compacted, nil
+}
+
+// compactLink rolls up one batch of a link's old events, returning how many it did
+func (app *App) compactLink(shortCode string, cutoff time.Time) (int, error) {
+	n := 0
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		clicks := tx.Bucket([]byte("clicks"))
+		if clicks == nil {
+			return nil
+		}
+		bucket := clicks.Bucket([]byte(shortCode))
+		if bucket == nil {
+			return nil
+		}
+		stats, err := tx.CreateBucketIfNotExists([]byte("stats"))
+		if err != nil {
+			return err
+		}
+		rollup, err := stats.CreateBucketIfNotExists([]byte(shortCode))
+		if err != nil {
+			return err
+		}
+		sealer := storeCipherOf(tx)
+
+		// collect first - deleting under a live cursor skips keys
+		var old [][]byte
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil && clickTime(k).Before(cutoff) && len(old) < compactBatch; k, v = c.Next() {
+			old = append(old, k)
+			var event clickEvent
+			if openJSON(tx, v, &event) != nil {
+				continue
+			}
+			day := "daily:" + clickTime(k).UTC().Format(time.DateOnly) + ":"
+			for _, prefix := range statDimensions {
+				if event.value(prefix) == "" {
+					continue
+				}
+				if err := bumpCounter(rollup, sealer.counterKey(day+prefix+event.value(prefix))); err != nil {
+					return err
+				}
+			}
+		}
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(old)
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// cloaked redirects. a frame link answers with a page that shows the
+// destination in a full-window iframe, so the short url stays in the address
+// bar; a meta link answers with a page that moves on via meta refresh instead
+// of a 3xx. both are only for http(s) destinations, and sites that refuse to
+// be framed (X-Frame-Options, frame-ancestors) show up blank in frame mode
+
+// checkCloak refuses frame and meta links to anything but a web page
+func checkCloak(redirect, dest string) error {
+	if (redirect == redirectFrame || redirect == redirectMeta) && !webURL(dest) {
+		return errors.New("frame and meta redirects need an http(s) destination")
+	}
+	return nil
+}
+
+// cloaked says whether the link is served through a page instead of a redirect
+func (link cachedLink) cloaked() bool {
+	return link.Mode == redirectFrame || link.Mode == redirectMeta
+}
+
+// cloak serves the frame or meta refresh page. like temporary redirects it's
+// never cached, every view is a click
+func (app *App) cloak(w http.ResponseWriter, r *http.Request, link cachedLink) {
+	u, err := url.Parse(link.URL)
+	if err != nil || !webURL(link.URL) {
+		// a rule picked something that can't be framed - plain redirect then
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, link.URL, http.StatusFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if w.Header().Get("Referrer-Policy") == "" {
+		w.Header().Set("Referrer-Policy", "no-referrer-when-downgrade")
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	err = app.page(r).ExecuteTemplate(w, "cloak.html", map[string]any{
+		"URL":   link.URL,
+		"Host":  u.Hostname(),
+		"Frame": link.Mode == redirectFrame,
+	})
+	if err != nil {
+		log.Printf("cloak render error: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCloakedLinks(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true", "ALLOWED_SCHEMES": "http,https,mailto"})
+
+	req := ShortenRequest{URL: "https://example.com/landing?a=1&b=2"}
+	req.Redirect = redirectFrame
+	frame := ta.shorten(req, http.StatusOK).ShortCode
+	req.URL, req.Redirect = "https://example.com/refresh", redirectMeta
+	meta := ta.shorten(req, http.StatusOK).ShortCode
+
+	for range 2 { // the second round comes from the redirect cache
+		rec := ta.do(http.MethodGet, "/"+frame, nil)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" || !strings.Contains(body, `<iframe src="https://example.com/landing?a=1&amp;b=2"`) {
+			t.Errorf("frame: %d %s", rec.Code, body)
+		}
+		rec = ta.do(http.MethodGet, "/"+meta, nil)
+		if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, `content="0; url=https://example.com/refresh"`) || strings.Contains(body, "<iframe") {
+			t.Errorf("meta: %d %s", rec.Code, body)
+		}
+	}
+
+	req.URL = "mailto:team@example.com"
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", req)
+	if msg := errorOf(t, rec); rec.Code != http.StatusBadRequest || !strings.Contains(msg, "http(s)") {
+		t.Errorf("meta mailto link: status %d %q, want 400", rec.Code, msg)
+	}
+}
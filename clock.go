@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Clock is where short code generation and link expiry get the time from.
+// the real one is time.Now, tests hand in one they can move by hand
+type Clock interface {
+	Now() time.Time
+}
+
+// RandomSource feeds short code generation. codes are picked inside a write
+// transaction, so it's never called from two goroutines at once - a seeded
+// *rand.Rand from math/rand/v2 works as is
+type RandomSource interface {
+	Uint64() uint64
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// systemRandom is math/rand/v2's global generator, seeded by the runtime
+type systemRandom struct{}
+
+func (systemRandom) Uint64() uint64 { return rand.Uint64() }
+
+// AppOption swaps out one of the app's sources of time or randomness, or
+// carries state over from the app a reload replaces (see reload.go)
+type AppOption func(*App)
+
+func WithClock(c Clock) AppOption {
+	return func(app *App) { app.Clock = c }
+}
+
+func WithRandom(r RandomSource) AppOption {
+	return func(app *App) { app.Random = r }
+}
+
+func (app *App) apply(opts ...AppOption) {
+	for _, opt := range opts {
+		opt(app)
+	}
+}
+
+// now is the app clock's time, the wall clock when none was set
+func (app *App) now() time.Time {
+	if app.Clock == nil {
+		return time.Now()
+	}
+	return app.Clock.Now()
+}
+
+func (app *App) random() RandomSource {
+	if app.Random == nil {
+		return systemRandom{}
+	}
+	return app.Random
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// short code alphabets. generated codes are base62 unless CODE_ALPHABET says
+// otherwise - safe leaves out characters that are easy to mix up when a code
+// is printed or read aloud, and with emoji they're made from CODE_EMOJI,
+// /🍕🚀🌈🦄🎉🐙. codes are stored and looked up by their utf-8 bytes: browsers
+// send them percent-encoded, go decodes the path and the router matches that
+
+const (
+	alphabetBase62 = "base62"
+	alphabetSafe   = "safe"
+	alphabetEmoji  = "emoji"
+)
+
+// base62 without 0/O/o and 1/l/I
+const safeChars = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// what a custom code gets warned about in safe mode
+const confusableChars = "0Oo1lI"
+
+const (
+	emojiCodeLength   = 6 // 32 emoji -> a billion codes
+	minEmojiSymbols   = 16
+	maxEmojiCustomLen = 16 // in emoji, for custom codes
+)
+
+// single code points with emoji presentation, no variation selectors - what
+// people paste back is then byte for byte what we minted
+var defaultCodeEmoji = []string{
+	"😀", "😂", "😍", "😎", "🤖", "👻", "👽", "🐶", "🐱", "🦊", "🐼", "🐸", "🐙", "🦄", "🐝", "🌈",
+	"🌵", "🍕", "🍩", "🍉", "🍓", "🎉", "🎈", "🎸", "🚀", "🔥", "💎", "🍀", "🌙", "🎯", "🎲", "🐳",
+}
+
+// codeAlphabet is what generated codes are made of and what the link routes accept
+type codeAlphabet struct {
+	name    string
+	symbols []string
+	length  int            // of generated codes, in symbols
+	route   string         // regexp for the code in link routes
+	custom  *regexp.Regexp // custom codes allowed on top of codePattern, nil = none
+}
+
+// newCodeAlphabet checks CODE_ALPHABET and CODE_EMOJI
+func newCodeAlphabet(config *Config) (*codeAlphabet, error) {
+	switch config.CodeAlphabet {
+	case "", alphabetBase62:
+		return &codeAlphabet{name: alphabetBase62, route: codePattern}, nil
+	case alphabetSafe:
+		chars := safeChars
+		if config.CaseInsensitiveCodes {
+			// lowercasing would turn L back into l
+			chars = strings.Map(func(r rune) rune {
+				if r >= 'A' && r <= 'Z' {
+					return -1
+				}
+				return r
+			}, safeChars)
+		}
+		return &codeAlphabet{name: alphabetSafe, symbols: strings.Split(chars, ""), length: 8, route: codePattern}, nil
+	case alphabetEmoji:
+	default:
+		return nil, fmt.Errorf("unknown alphabet %q, want base62, safe or emoji", config.CodeAlphabet)
+	}
+
+	symbols := config.CodeEmoji
+	if len(symbols) == 0 {
+		symbols = defaultCodeEmoji
+	}
+	if len(symbols) < minEmojiSymbols {
+		return nil, fmt.Errorf("CODE_EMOJI needs at least %d emoji, has %d", minEmojiSymbols, len(symbols))
+	}
+	quoted := make([]string, 0, len(symbols))
+	for i, s := range symbols {
+		if !utf8.ValidString(s) || strings.ContainsFunc(s, func(r rune) bool { return r < utf8.RuneSelf }) {
+			return nil, fmt.Errorf("CODE_EMOJI entry %q isn't an emoji", s)
+		}
+		if slices.Contains(symbols[:i], s) {
+			return nil, fmt.Errorf("CODE_EMOJI has %q twice", s)
+		}
+		quoted = append(quoted, regexp.QuoteMeta(s))
+	}
+	emoji := "(?:" + strings.Join(quoted, "|") + ")"
+	return &codeAlphabet{
+		name:    alphabetEmoji,
+		symbols: symbols,
+		length:  emojiCodeLength,
+		route:   fmt.Sprintf("%s|%s{1,%d}", codePattern, emoji, maxEmojiCustomLen),
+		custom:  regexp.MustCompile(fmt.Sprintf("^%s{3,%d}$", emoji, maxEmojiCustomLen)),
+	}, nil
+}
+
+// encode turns hash bytes into a code from the alphabet's symbols
+func (a *codeAlphabet) encode(sum []byte) string {
+	var b strings.Builder
+	for i := 0; i < a.length && i < len(sum); i++ {
+		b.WriteString(a.symbols[int(sum[i])%len(a.symbols)])
+	}
+	return b.String()
+}
+
+// allowsCustom says whether a requested code is made of the alphabet's symbols
+func (a *codeAlphabet) allowsCustom(code string) bool {
+	return a.custom != nil && a.custom.MatchString(code)
+}
+
+// codeWarnings points out confusable characters in a custom code when the
+// safe alphabet is on - the code still works, it's just easy to misread
+func (app *App) codeWarnings(code string) []string {
+	if app.Codes.name != alphabetSafe {
+		return nil
+	}
+	var found []string
+	for _, c := range confusableChars {
+		if strings.ContainsRune(code, c) {
+			found = append(found, string(c))
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("code %q has characters that are easy to mix up when printed or read aloud: %s", code, strings.Join(found, " "))}
+}
+
+// with CASE_INSENSITIVE_CODES codes are lowercased when they're made and when
+// they're looked up, so AB3XY7ZQ copied off a poster still works. codes from
+// before the switch keep resolving when typed as they were minted
+
+// foldCode is the code a request's {shortCode} refers to
+func (app *App) foldCode(code string) string {
+	lower := strings.ToLower(code)
+	if !app.Config.CaseInsensitiveCodes || lower == code {
+		return code
+	}
+	exact := false
+	app.DB.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte("urls")); bucket != nil {
+			exact = bucket.Get([]byte(code)) != nil
+		}
+		return nil
+	})
+	if exact {
+		return code
+	}
+	return lower
+}
+
+// codeCaseMiddleware folds the {shortCode} route variable for every handler
+// that takes one
+func (app *App) codeCaseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if code, ok := vars["shortCode"]; ok && app.Config.CaseInsensitiveCodes {
+			vars["shortCode"] = app.foldCode(code)
+			r = mux.SetURLVars(r, vars)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
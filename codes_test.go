@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEmojiCodes(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"CODE_ALPHABET": "emoji", "ALLOW_PRIVATE_DESTINATIONS": "true"})
+
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/party"}, http.StatusOK).ShortCode
+	if n := utf8.RuneCountInString(code); n != emojiCodeLength || !ta.Codes.allowsCustom(code) {
+		t.Fatalf("generated code %q", code)
+	}
+	// browsers send the emoji percent-encoded
+	for _, path := range []string{"/" + url.PathEscape(code), "/" + url.PathEscape(code) + "/stats"} {
+		if rec := ta.do(http.MethodGet, path, nil); rec.Code >= 400 {
+			t.Errorf("%s: status %d", path, rec.Code)
+		}
+	}
+
+	req := ShortenRequest{URL: "https://example.com/pizza", Code: "🍕🍕🍕"}
+	ta.shorten(req, http.StatusOK)
+	if rec := ta.do(http.MethodGet, "/%F0%9F%8D%95%F0%9F%8D%95%F0%9F%8D%95", nil); rec.Header().Get("Location") != "https://example.com/pizza" {
+		t.Errorf("custom emoji code: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	// emoji outside the alphabet aren't codes
+	req.Code = "🍔🍔🍔"
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", req); rec.Code != http.StatusBadRequest {
+		t.Errorf("foreign emoji: status %d, want 400", rec.Code)
+	}
+}
+
+func TestCodeAlphabetConfig(t *testing.T) {
+	for _, config := range []*Config{
+		{CodeAlphabet: "klingon"},
+		{CodeAlphabet: alphabetEmoji, CodeEmoji: []string{"🍕", "🚀"}},
+		{CodeAlphabet: alphabetEmoji, CodeEmoji: append([]string{"ab"}, defaultCodeEmoji...)},
+		{CodeAlphabet: alphabetEmoji, CodeEmoji: append([]string{"🍕"}, defaultCodeEmoji...)},
+	} {
+		if _, err := newCodeAlphabet(config); err == nil {
+			t.Errorf("%q %q: no error", config.CodeAlphabet, config.CodeEmoji)
+		}
+	}
+}
+
+func TestCaseInsensitiveCodes(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	legacy := ta.shorten(ShortenRequest{URL: "https://example.com/legacy", Code: "MixedCase"}, http.StatusOK).ShortCode
+
+	ta.Config.CaseInsensitiveCodes = true
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/poster"}, http.StatusOK).ShortCode
+	if code != strings.ToLower(code) {
+		t.Errorf("generated code %q isn't lowercase", code)
+	}
+	if custom := ta.shorten(ShortenRequest{URL: "https://example.com/flyer", Code: "Spring-Sale"}, http.StatusOK).ShortCode; custom != "spring-sale" {
+		t.Errorf("custom code = %q", custom)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/" + strings.ToUpper(code), "https://example.com/poster"},
+		{"/SPRING-SALE", "https://example.com/flyer"},
+		{"/MixedCase", "https://example.com/legacy"}, // made before the switch
+	}
+	for _, tt := range tests {
+		if rec := ta.do(http.MethodGet, tt.path, nil); rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s: %d %q, want %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+	if rec := ta.do(http.MethodGet, "/"+legacy+"/stats", nil); rec.Code != http.StatusOK {
+		t.Errorf("legacy stats page: status %d", rec.Code)
+	}
+}
+
+func TestSafeCodes(t *testing.T) {
+	for _, insensitive := range []string{"false", "true"} {
+		ta := newTestApp(t, map[string]string{"CODE_ALPHABET": "safe", "CASE_INSENSITIVE_CODES": insensitive, "ALLOW_PRIVATE_DESTINATIONS": "true"})
+		for i := range 20 {
+			code := ta.shorten(ShortenRequest{URL: fmt.Sprintf("https://example.com/read-aloud/%d", i)}, http.StatusOK).ShortCode
+			if len(code) != 8 || strings.ContainsAny(code, confusableChars) {
+				t.Errorf("case insensitive %s: generated code %q", insensitive, code)
+			}
+		}
+	}
+
+	ta := newTestApp(t, map[string]string{"CODE_ALPHABET": "safe", "CASE_INSENSITIVE_CODES": "false", "ALLOW_PRIVATE_DESTINATIONS": "true"})
+	resp := ta.shorten(ShortenRequest{URL: "https://example.com/billboard", Code: "Go1den"}, http.StatusOK)
+	if len(resp.Warnings) != 1 || !strings.HasSuffix(resp.Warnings[0], ": o 1") {
+		t.Errorf("warnings = %q", resp.Warnings)
+	}
+	if resp := ta.shorten(ShortenRequest{URL: "https://example.com/bus", Code: "transit"}, http.StatusOK); resp.Warnings != nil {
+		t.Errorf("clean code got warnings %q", resp.Warnings)
+	}
+}
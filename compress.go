@@ -0,0 +1,237 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// responses are compressed with br or gzip, whichever the client's
+// Accept-Encoding likes best (br on a tie). only text is worth it - json,
+// ndjson, html, css, js - and only bodies of COMPRESSION_MIN_BYTES or more,
+// below that the framing costs more than it saves. the first bytes are held
+// back until there are enough of them, a flush (the ndjson streams) starts
+// compressing right away
+
+// content types worth compressing, by media type
+var compressibleTypes = []string{
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/manifest+json",
+	"image/svg+xml",
+	"text/css",
+	"text/csv",
+	"text/html",
+	"text/javascript",
+	"text/plain",
+}
+
+// brotli's own default (11) is meant for files compressed once, 5 is about
+// gzip's speed and still smaller
+const brotliLevel = 5
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	brotliWriters = sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	}}
+)
+
+// compressMiddleware sits inside the metrics and access log, so those see
+// the status as the handler wrote it
+func (app *App) compressMiddleware(next http.Handler) http.Handler {
+	if !app.Config.Compress {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minBytes: app.Config.CompressMinBytes}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, "" for neither
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			weight = f
+		}
+		q[name] = weight
+	}
+	for _, name := range []string{"br", "gzip"} {
+		weight, ok := q[name]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = name, weight
+		}
+	}
+	return best
+}
+
+// compressWriter holds the status and the first minBytes of the body until
+// it knows whether the response gets compressed
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+
+	status  int
+	buf     []byte
+	decided bool           // the real header has been written
+	enc     io.WriteCloser // set when compressing
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 || code < 200 {
+		// informational (103 early hints), or a second call net/http complains about
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if !cw.compressible() {
+		cw.decided = true
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.Header().Add("Vary", "Accept-Encoding")
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		if cw.Header().Get("Content-Type") == "" {
+			// sniff now, net/http would only get to see the compressed bytes
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minBytes {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// compressible is whether the response as the handler set it up can be compressed
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusPartialContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || strings.Contains(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && slices.Contains(compressibleTypes, mediaType)
+}
+
+// start writes the held back header and body, compressed or not
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// the bytes differ from the uncompressed ones, so the etag can only be weak
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "br":
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.enc = bw
+		default:
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush starts compressing whatever is held back - a streamed response wants
+// its bytes out now, and will likely go past minBytes anyway
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.status != 0 {
+		cw.start(true)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close sends what's still held back (uncompressed, it was too small) or
+// finishes the compressed stream
+func (cw *compressWriter) Close() error {
+	if !cw.decided && cw.status != 0 {
+		return cw.start(false)
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	// pooled without a hold on the response
+	switch enc := cw.enc.(type) {
+	case *brotli.Writer:
+		enc.Reset(io.Discard)
+		brotliWriters.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(io.Discard)
+		gzipWriters.Put(enc)
+	}
+	cw.enc = nil
+	return err
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
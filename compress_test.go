@@ -0,0 +1,102 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip, deflate":         "gzip",
+		"gzip, deflate, br":     "br",
+		"br;q=0.5, gzip":        "gzip",
+		"br;q=0, gzip;q=0":      "",
+		"*":                     "br",
+		"GZIP;q=0.8, *;q=0.1":   "gzip",
+		"br;q=nope, gzip;q=0.1": "gzip",
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true", "COMPRESSION_MIN_BYTES": "512"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	for _, path := range []string{"one", "two", "three", "four", "five", "six", "seven", "eight"} {
+		ta.shorten(ShortenRequest{URL: "https://example.com/a/longer/path/" + path}, http.StatusOK)
+	}
+
+	plain := ta.do(http.MethodGet, "/api/v1/links", nil, admin...)
+	if plain.Header().Get("Content-Encoding") != "" || plain.Body.Len() < 512 {
+		t.Fatalf("no Accept-Encoding: encoding %q, %d bytes", plain.Header().Get("Content-Encoding"), plain.Body.Len())
+	}
+
+	for encoding, reader := range map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	} {
+		rec := ta.do(http.MethodGet, "/api/v1/links", nil, append(admin, "Accept-Encoding", encoding)...)
+		if rec.Header().Get("Content-Encoding") != encoding || rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: headers %v", encoding, rec.Header())
+		}
+		r, err := reader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil || string(body) != plain.Body.String() {
+			t.Errorf("%s: got %d bytes back (%v), want %d", encoding, len(body), err, plain.Body.Len())
+		}
+
+		// the etag turns weak, and still matches
+		etag := rec.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `W/"`) {
+			t.Errorf("%s: etag %q", encoding, etag)
+		}
+		if rec := ta.do(http.MethodGet, "/api/v1/links", nil, append(admin, "Accept-Encoding", encoding, "If-None-Match", etag)...); rec.Code != http.StatusNotModified {
+			t.Errorf("%s: If-None-Match: status %d", encoding, rec.Code)
+		}
+	}
+
+	// streams are compressed from the first flush
+	rec := ta.do(http.MethodPost, "/api/v1/resolve/stream", "nope-nope\n", append(admin, "Accept-Encoding", "gzip")...)
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("stream: %v, headers %v", err, rec.Header())
+	}
+	if body, _ := io.ReadAll(gr); !strings.Contains(string(body), `"status":"not_found"`) {
+		t.Errorf("stream = %s", body)
+	}
+
+	// small bodies and redirects go out as they are
+	rec = ta.do(http.MethodGet, "/api/v1/links?limit=1", nil, append(admin, "Accept-Encoding", "gzip")...)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("small: headers %v", rec.Header())
+	}
+	var list LinkListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Links) != 1 {
+		t.Errorf("small: %v, %+v", err, list)
+	}
+	rec = ta.do(http.MethodGet, "/"+list.Links[0].ShortCode, nil, "Accept-Encoding", "gzip")
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("redirect: status %d, headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCompressionOff(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"COMPRESSION": "false", "COMPRESSION_MIN_BYTES": "0"})
+	rec := ta.do(http.MethodGet, "/", nil, "Accept-Encoding", "gzip")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("status %d, headers %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// dashboards poll the link and stats reads, so those answer conditional
+// requests: every response has an ETag (a hash of the body, so it changes
+// exactly when the json does) and, where there's a time to give, a
+// Last-Modified. If-None-Match and If-Modified-Since get a bare 304 when
+// nothing changed. browsers do this on their own for fetch() since the
+// responses are marked no-cache rather than no-store
+
+// lastModified is the last write to a link of any kind, clicks included
+func (u *URL) lastModified() time.Time {
+	if u.UpdatedAt != nil {
+		return *u.UpdatedAt
+	}
+	return u.CreatedAt
+}
+
+// serveConditional writes v as json, or a 304 when the client's copy matches.
+// a zero modified leaves out Last-Modified (and If-Modified-Since with it)
+func serveConditional(w http.ResponseWriter, r *http.Request, modified time.Time, v any) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		log.Printf("encode %s: %v", r.URL.Path, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	h := w.Header()
+	h.Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(sum[:16])+`"`)
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("Content-Type", "application/json")
+	// ServeContent does the If-* matching (If-None-Match wins over
+	// If-Modified-Since) and the 304
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body.Bytes()))
+}
+
+// handles GET /api/links/{shortCode} - one link, as it appears in GET /api/links
+func (app *App) getLinkHandler(w http.ResponseWriter, r *http.Request) {
+	urlData, ok := app.authorizeLink(w, r, mux.Vars(r)["shortCode"])
+	if !ok {
+		return
+	}
+	serveConditional(w, r, urlData.lastModified(), ListedLink{URL: *urlData, ShortURL: app.linkShortURL(urlData)})
+}
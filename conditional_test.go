@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConditionalReads(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/polled"}, http.StatusOK).ShortCode
+
+	for _, path := range []string{"/api/v1/links/" + code, "/api/v1/links/" + code + "/stats", "/api/v1/links"} {
+		rec := ta.do(http.MethodGet, path, nil, admin...)
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: status %d, etag %q", path, rec.Code, etag)
+		}
+		if rec := ta.do(http.MethodGet, path, nil, append(admin, "If-None-Match", etag)...); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: same etag: status %d", path, rec.Code)
+		}
+		if rec := ta.do(http.MethodGet, path, nil, append(admin, "If-None-Match", `"stale"`)...); rec.Code != http.StatusOK {
+			t.Errorf("%s: other etag: status %d", path, rec.Code)
+		}
+	}
+
+	rec := ta.do(http.MethodGet, "/api/v1/links/"+code, nil, admin...)
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if modified == "" {
+		t.Fatal("no Last-Modified")
+	}
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+code, nil, append(admin, "If-Modified-Since", modified)...); rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d", rec.Code)
+	}
+
+	// a click changes the link, the old etag no longer matches
+	ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	ta.Clicks.Wait()
+	rec = ta.do(http.MethodGet, "/api/v1/links/"+code, nil, append(admin, "If-None-Match", etag)...)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("after a click: status %d, etag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	since := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+code+"/stats", nil, append(admin, "If-Modified-Since", since)...); rec.Code != http.StatusOK {
+		t.Errorf("stats since an hour ago: status %d", rec.Code)
+	}
+
+	// someone else's link is still a 404, not a 304
+	rec = ta.do(http.MethodPost, "/api/v1/admin/keys", map[string]string{"name": "other"}, admin...)
+	var created CreateAPIKeyResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+code, nil, "X-API-Key", created.Key, "If-None-Match", etag); rec.Code != http.StatusNotFound {
+		t.Errorf("other key: status %d", rec.Code)
+	}
+}
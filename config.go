@@ -0,0 +1,470 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds runtime settings - everything comes from env vars so docker deploys stay simple
+type Config struct {
+	Port       string
+	AdminToken string // bearer token for operator endpoints, admin api is off when empty
+	BaseURL    string // public url used when building short urls (origin + PathPrefix), no trailing slash
+	PathPrefix string // sub-path everything is served under, e.g. "/s", empty for the root
+	AssetsDir  string // optional templates/ and static/ overrides, see assets.go
+	Store      string // bolt or memory, see memstore.go
+	DBPath     string // bolt file for STORE=bolt
+	StoreKey   []byte // encrypts links and analytics at rest, see storecrypt.go
+
+	AdminAddr string // listener for the api, ui and admin endpoints, PORT then only serves links
+	DebugAddr string // separate listener for pprof and /debug/vars, see debug.go
+
+	// the server's own log, see logging.go
+	LogOutputs []string // stderr, stdout, file, syslog and/or journald
+	LogFile    string   // for the file output
+	SyslogAddr string   // udp://host:514 or tcp://host:601, empty = local daemon
+	LogTag     string   // program name syslog and journald file lines under
+
+	// statsd / datadog counters, see metrics.go
+	StatsDAddr   string        // host:port, empty = off
+	StatsDPrefix string        // put in front of every metric name
+	StatsDTags   []string      // datadog style key:value tags sent with every metric
+	StatsDFlush  time.Duration // how often counts are sent
+
+	// combined format request log, see accesslog.go
+	AccessLog       string        // file path, empty = off
+	AccessLogMaxMB  int           // rotate past this size, 0 = no limit
+	AccessLogRotate time.Duration // rotate when the file is this old, 0 = never
+	AccessLogKeep   int           // rotated files to keep, 0 = all
+
+	// mirroring traffic to another deployment, see shadow.go
+	ShadowURL         string        // base url of the deployment under test, empty = off
+	ShadowSample      float64       // percent of requests mirrored
+	ShadowTimeout     time.Duration // how long a mirrored request may take
+	ShadowMaxInFlight int           // mirrored requests out at once, the rest are dropped
+
+	// the unversioned /api/... aliases of /api/v1, see versions.go
+	LegacyAPI       bool
+	LegacyAPISunset time.Time // announced in the Sunset header, zero = no date yet
+
+	// gzip/br for text responses, see compress.go
+	Compress         bool
+	CompressMinBytes int // smaller bodies go out as they are
+
+	MaxBodyBytes int64 // request bodies past this get a 413, see jsonbody.go
+	MaxURLLength int   // longest destination url accepted, 0 = no limit
+
+	AllowedSchemes []string // destination url schemes, see schemes.go
+	LinkHeaders    []string // response headers links may set on their redirect, see headers.go
+
+	// look of the served pages, see theme.go
+	Theme      string // auto, light or dark
+	BrandName  string
+	BrandLogo  string
+	BrandColor string
+
+	ShortDomains      []string // extra hostnames links can be minted on, each serves only redirects
+	DomainScopedCodes bool     // a code only resolves on the domain it was created on
+
+	CodeAlphabet string   // base62 or emoji, see codes.go
+	CodeEmoji    []string // the emoji for CODE_ALPHABET=emoji, empty = the built-in set
+
+	CaseInsensitiveCodes bool // codes are lowercased when made and looked up, see codes.go
+
+	// stateless signed links, see ephemeral.go
+	EphemeralSecret []byte        // empty = off
+	EphemeralMaxTTL time.Duration // longest expires_in accepted
+
+	// automatic lets encrypt certificates for the main host, SHORT_DOMAINS and verified custom domains
+	Autocert      bool
+	AutocertDir   string // where issued certificates are kept between restarts
+	AutocertEmail string // contact address for the ca, optional
+	HTTPSPort     string // PORT then only answers acme challenges and redirects to https
+
+	// http versions per listener, see protocols.go
+	H2C   bool // cleartext http/2 on PORT, for a proxy in front that speaks it
+	HTTP2 bool // h2 on HTTPS_PORT
+	HTTP3 bool // QUIC on HTTPS_PORT over udp
+
+	TrustedProxies []netip.Prefix // X-Forwarded-For is only believed from these
+
+	MTLSAdminSubjects []string // client certificate common names with admin rights
+
+	IntegrationsAllowPrivate bool // let org integrations post to internal addresses
+
+	// destination checks, see destinations.go
+	AllowSelfLinks           bool // links may point at this shortener's own hosts
+	AllowPrivateDestinations bool // links may point at internal addresses, and outbound fetches may reach them
+	FollowRedirectsMaxHops   int  // redirects followed for follow_redirects, see unwrap.go
+
+	// server-wide chat notifications, on top of per-org integrations
+	NotifySlackWebhook   string
+	NotifyDiscordWebhook string
+	NotifyEvents         []string // empty = all
+	NotifyClickThreshold int      // 0 = no click milestone messages
+
+	// click fraud checks, see fraud.go
+	FraudDetection      bool
+	FraudIPClicks       int           // clicks per minute on one link from one ip before they're suspect, 0 = no limit
+	FraudNetworkClicks  int           // same from one /24 or /48
+	FraudSpikeFactor    float64       // a minute this many times the usual rate is a spike, 0 = off
+	FraudSpikeMinClicks int           // minutes with fewer clicks are never a spike
+	FraudAlertEmail     []string      // addresses alerts are mailed to
+	FraudAlertCooldown  time.Duration // least time between alerts for one link
+
+	LinkRules bool // links may carry a CEL rule that picks the destination per click, see rules.go
+
+	// per-link click webhooks, see clickhook.go
+	ClickWebhookRate     float64       // posts per second to one endpoint, 0 = no limit
+	ClickWebhookBurst    int           // posts an endpoint can take at once before the rate kicks in
+	ClickWebhookFailures int           // consecutive failures that open the breaker
+	ClickWebhookCooldown time.Duration // how long an open breaker stays open
+
+	AnalyticsLevel string // off, aggregate or full, see privacy.go
+	RespectDNT     bool   // DNT and Sec-GPC clicks are only counted in aggregate
+
+	BotClicks         string // separate, exclude or off
+	BotIPFile         string // optional list of known crawler ip ranges
+	CountHeadRequests bool   // HEAD on a short link runs through click counting (as a bot click)
+	UnfurlMode        string // card, preview or redirect, see unfurl.go
+	CountUnfurls      bool   // link preview fetches run through click counting (as bot clicks)
+	RobotsTxt         string // disallow, allow or off, see robots.go
+	RobotsNoindex     bool   // X-Robots-Tag: noindex on redirects and link pages
+
+	PreviewSecret []byte // hmac key for signed preview urls
+
+	StatsPage string // public, owner or off - who can see /{code}/stats
+
+	CountryHeader string // request header a proxy puts the visitor's country code in, empty = no country stats
+
+	// outgoing email, off unless SMTP_HOST and SMTP_FROM are set
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPFrom       string
+	ReportInterval time.Duration // how often opted-in users get a summary
+
+	// first-party login tokens
+	JWTSecret       []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	SessionTTL      time.Duration // browser login cookie, see sessions.go
+	TOTPKey         []byte        // seals two-factor secrets, nil turns setup off - see twofactor.go
+	Admin2FA        bool          // admin users need two-factor on for admin rights
+
+	// captcha / risk scoring
+	TurnstileSecret   string
+	TurnstileSiteKey  string
+	HCaptchaSecret    string
+	HCaptchaSiteKey   string
+	RecaptchaSecret   string
+	RecaptchaSiteKey  string
+	RecaptchaMinScore float64           // v3 only - lowest score we still treat as human
+	CaptchaRoutes     map[string]string // route name -> provider name
+	RiskMaxPerMin     int               // heuristic provider: requests per ip per minute before flagging
+
+	// dead link checker
+	HealthCheckInterval time.Duration // 0 disables the checker
+	HealthDisableBroken bool          // switch off links that keep failing
+	HealthFailThreshold int           // consecutive failures before disabling
+
+	// wayback snapshots of new destinations, see archive.go
+	Archive        bool
+	ArchiveSaveURL string // save endpoint, the destination is appended
+
+	// submission abuse scoring
+	AbuseQuarantineScore float64       // links scoring at or above this wait for approval, 0 disables scoring
+	AbuseNetworkChecks   bool          // follow redirects and do rdap lookups while scoring, opt in since it's slow
+	AbuseNewDomainAge    time.Duration // domains younger than this count as suspicious
+	RDAPEndpoint         string
+
+	// never-clicked link report
+	GCInterval time.Duration // 0 disables the scheduled report
+	GCMinAge   time.Duration // links younger than this are left alone
+	GCReclaim  bool          // delete what the scheduled report finds
+
+	TrashRetention time.Duration // deleted links are purged for good after this, 0 = never
+	ClickRetention time.Duration // raw click events older than this are rolled up into daily counts, 0 = keep them
+
+	// redirect cache
+	CacheTTL             time.Duration // 0 = entries never expire by age
+	CacheCleanupInterval time.Duration // how often expired entries are swept out
+	CacheMaxEntries      int           // above 0 the cache is an lru of this size
+	RedirectMaxAge       time.Duration // how long browsers/cdns may cache a permanent redirect
+}
+
+// loadConfig reads settings from the environment, falling back to sane
+// defaults. secrets can come from elsewhere, see secrets.go. it never exits -
+// a reload with a typo has to leave the running server alone - so settings
+// that can't be used come back as one error listing all of them
+func loadConfig() (*Config, error) {
+	secrets, err := loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+	port := envString("PORT", "8080")
+
+	// "s", "/s/" and "/s" all mean /s
+	prefix := strings.Trim(envString("PATH_PREFIX", ""), "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	baseURL := strings.TrimRight(envString("BASE_URL", "http://localhost:"+port), "/")
+	if !strings.HasSuffix(baseURL, prefix) {
+		baseURL += prefix
+	}
+
+	var errs []error
+	config := &Config{
+		Port:              port,
+		AdminToken:        secrets["ADMIN_TOKEN"],
+		BaseURL:           baseURL,
+		PathPrefix:        prefix,
+		AssetsDir:         envString("ASSETS_DIR", ""),
+		Store:             envString("STORE", storeBolt),
+		DBPath:            envString("DB_PATH", "urls.db"),
+		StoreKey:          secrets.key("STORE_KEY"),
+		AdminAddr:         envString("ADMIN_ADDR", ""),
+		DebugAddr:         envString("DEBUG_ADDR", ""),
+		LogOutputs:        envListDefault("LOG_OUTPUTS", []string{logStderr}),
+		LogFile:           envString("LOG_FILE", ""),
+		SyslogAddr:        envString("SYSLOG_ADDR", ""),
+		LogTag:            envString("LOG_TAG", "urlshortener"),
+		StatsDAddr:        envString("STATSD_ADDR", ""),
+		StatsDPrefix:      envString("STATSD_PREFIX", "urlshortener."),
+		StatsDTags:        envList("STATSD_TAGS"),
+		StatsDFlush:       envDuration("STATSD_FLUSH", 10*time.Second),
+		AccessLog:         envString("ACCESS_LOG", ""),
+		AccessLogMaxMB:    envInt("ACCESS_LOG_MAX_MB", 100),
+		AccessLogRotate:   envDuration("ACCESS_LOG_ROTATE", 24*time.Hour),
+		AccessLogKeep:     envInt("ACCESS_LOG_KEEP", 7),
+		ShadowURL:         strings.TrimRight(envString("SHADOW_URL", ""), "/"),
+		ShadowSample:      envFloat("SHADOW_SAMPLE", 100),
+		ShadowTimeout:     envDuration("SHADOW_TIMEOUT", 10*time.Second),
+		ShadowMaxInFlight: envInt("SHADOW_MAX_IN_FLIGHT", 100),
+		LegacyAPI:         envBool("LEGACY_API", true),
+		LegacyAPISunset:   envDate("LEGACY_API_SUNSET"),
+		Compress:          envBool("COMPRESSION", true),
+		CompressMinBytes:  envInt("COMPRESSION_MIN_BYTES", 1024),
+		MaxBodyBytes:      int64(envInt("MAX_BODY_BYTES", 1<<20)),
+		MaxURLLength:      envInt("MAX_URL_LENGTH", 4096),
+		AllowedSchemes:    envSchemes("ALLOWED_SCHEMES", []string{"http", "https"}, &errs),
+		LinkHeaders:       envLinkHeaders("LINK_HEADERS", defaultLinkHeaders, &errs),
+		Theme:             envString("THEME", themeAuto),
+		BrandName:         envString("BRAND_NAME", "LinkFast"),
+		BrandLogo:         envString("BRAND_LOGO", ""),
+		BrandColor:        envString("BRAND_COLOR", "#007bff"),
+		PreviewSecret:     secrets.signingKey("PREVIEW_SECRET", "signed preview links"),
+		StatsPage:         envString("STATS_PAGE", statsPagePublic),
+		CountryHeader:     envString("COUNTRY_HEADER", ""),
+		JWTSecret:         secrets.signingKey("JWT_SECRET", "login tokens"),
+		SMTPHost:          envString("SMTP_HOST", ""),
+		SMTPPort:          envInt("SMTP_PORT", 587),
+		SMTPUsername:      secrets["SMTP_USERNAME"],
+		SMTPPassword:      secrets["SMTP_PASSWORD"],
+		SMTPFrom:          envString("SMTP_FROM", ""),
+		ReportInterval:    envDuration("REPORT_INTERVAL", 7*24*time.Hour),
+		AccessTokenTTL:    envDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:   envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		SessionTTL:        envDuration("SESSION_TTL", 7*24*time.Hour),
+		TOTPKey:           secrets.key("TOTP_KEY"),
+		Admin2FA:          envBool("ADMIN_2FA", false),
+		ShortDomains:      envList("SHORT_DOMAINS"),
+		DomainScopedCodes: envBool("DOMAIN_SCOPED_CODES", false),
+		CodeAlphabet:      envString("CODE_ALPHABET", alphabetBase62),
+		CodeEmoji:         envList("CODE_EMOJI"),
+
+		CaseInsensitiveCodes: envBool("CASE_INSENSITIVE_CODES", false),
+
+		EphemeralSecret:   []byte(secrets["EPHEMERAL_SECRET"]),
+		EphemeralMaxTTL:   envDuration("EPHEMERAL_MAX_TTL", 30*24*time.Hour),
+		Autocert:          envBool("AUTOCERT", false),
+		AutocertDir:       envString("AUTOCERT_DIR", "certs"),
+		AutocertEmail:     envString("AUTOCERT_EMAIL", ""),
+		HTTPSPort:         envString("HTTPS_PORT", "443"),
+		H2C:               envBool("H2C", false),
+		HTTP2:             envBool("HTTP2", true),
+		HTTP3:             envBool("HTTP3", false),
+		TrustedProxies:    envPrefixes("TRUSTED_PROXIES", &errs),
+		MTLSAdminSubjects: envList("MTLS_ADMIN_SUBJECTS"),
+
+		IntegrationsAllowPrivate: envBool("INTEGRATIONS_ALLOW_PRIVATE", false),
+		AllowSelfLinks:           envBool("ALLOW_SELF_LINKS", false),
+		AllowPrivateDestinations: envBool("ALLOW_PRIVATE_DESTINATIONS", false),
+		FollowRedirectsMaxHops:   envInt("FOLLOW_REDIRECTS_MAX_HOPS", 5),
+		NotifySlackWebhook:       secrets["NOTIFY_SLACK_WEBHOOK"],
+		NotifyDiscordWebhook:     secrets["NOTIFY_DISCORD_WEBHOOK"],
+		NotifyEvents:             envList("NOTIFY_EVENTS"),
+		NotifyClickThreshold:     envInt("NOTIFY_CLICK_THRESHOLD", 0),
+		FraudDetection:           envBool("FRAUD_DETECTION", false),
+		FraudIPClicks:            envInt("FRAUD_IP_CLICKS", 20),
+		FraudNetworkClicks:       envInt("FRAUD_NETWORK_CLICKS", 100),
+		FraudSpikeFactor:         envFloat("FRAUD_SPIKE_FACTOR", 10),
+		FraudSpikeMinClicks:      envInt("FRAUD_SPIKE_MIN_CLICKS", 60),
+		FraudAlertEmail:          envList("FRAUD_ALERT_EMAIL"),
+		FraudAlertCooldown:       envDuration("FRAUD_ALERT_COOLDOWN", time.Hour),
+		LinkRules:                envBool("LINK_RULES", false),
+		ClickWebhookRate:         envFloat("CLICK_WEBHOOK_RATE", 10),
+		ClickWebhookBurst:        envInt("CLICK_WEBHOOK_BURST", 20),
+		ClickWebhookFailures:     envInt("CLICK_WEBHOOK_FAILURES", 5),
+		ClickWebhookCooldown:     envDuration("CLICK_WEBHOOK_COOLDOWN", time.Minute),
+		AnalyticsLevel:           envString("ANALYTICS_LEVEL", analyticsFull),
+		RespectDNT:               envBool("RESPECT_DNT", true),
+		BotClicks:                envString("BOT_CLICKS", botClicksSeparate),
+		BotIPFile:                envString("BOT_IP_FILE", ""),
+		CountHeadRequests:        envBool("COUNT_HEAD_REQUESTS", false),
+		UnfurlMode:               envString("UNFURL_MODE", unfurlCard),
+		CountUnfurls:             envBool("COUNT_UNFURLS", false),
+		RobotsTxt:                envString("ROBOTS_TXT", robotsDisallow),
+		RobotsNoindex:            envBool("ROBOTS_NOINDEX", false),
+		TurnstileSecret:          secrets["TURNSTILE_SECRET"],
+		TurnstileSiteKey:         envString("TURNSTILE_SITEKEY", ""),
+		HCaptchaSecret:           secrets["HCAPTCHA_SECRET"],
+		HCaptchaSiteKey:          envString("HCAPTCHA_SITEKEY", ""),
+		RecaptchaSecret:          secrets["RECAPTCHA_SECRET"],
+		RecaptchaSiteKey:         envString("RECAPTCHA_SITEKEY", ""),
+		RecaptchaMinScore:        envFloat("RECAPTCHA_MIN_SCORE", 0.5),
+		CaptchaRoutes:            envMap("CAPTCHA_ROUTES"),
+		RiskMaxPerMin:            envInt("RISK_MAX_PER_MINUTE", 30),
+
+		HealthCheckInterval: envDuration("HEALTH_CHECK_INTERVAL", 6*time.Hour),
+		HealthDisableBroken: envBool("HEALTH_DISABLE_BROKEN", false),
+		HealthFailThreshold: envInt("HEALTH_FAIL_THRESHOLD", 3),
+
+		Archive:        envBool("ARCHIVE", false),
+		ArchiveSaveURL: envString("ARCHIVE_SAVE_URL", "https://web.archive.org/save/"),
+
+		AbuseQuarantineScore: envFloat("ABUSE_QUARANTINE_SCORE", 0.7),
+		AbuseNetworkChecks:   envBool("ABUSE_NETWORK_CHECKS", false),
+		AbuseNewDomainAge:    envDuration("ABUSE_NEW_DOMAIN_AGE", 30*24*time.Hour),
+		RDAPEndpoint:         envString("RDAP_ENDPOINT", "https://rdap.org/domain/"),
+
+		GCInterval: envDuration("GC_INTERVAL", 24*time.Hour),
+		GCMinAge:   envDuration("GC_MIN_AGE", 90*24*time.Hour),
+		GCReclaim:  envBool("GC_RECLAIM", false),
+
+		TrashRetention: envDuration("TRASH_RETENTION", 30*24*time.Hour),
+		ClickRetention: envDuration("CLICK_RETENTION", 90*24*time.Hour),
+
+		CacheTTL:             envDuration("CACHE_TTL", 5*time.Minute),
+		CacheCleanupInterval: envDuration("CACHE_CLEANUP_INTERVAL", 10*time.Minute),
+		CacheMaxEntries:      envInt("CACHE_MAX_ENTRIES", 0),
+		RedirectMaxAge:       envDuration("REDIRECT_MAX_AGE", time.Hour),
+	}
+	if _, err := globalIntegrations(config); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// envDate reads a yyyy-mm-dd date, zero when unset or unreadable
+func envDate(key string) time.Time {
+	v := os.Getenv(key)
+	if v == "" {
+		return time.Time{}
+	}
+	d, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		log.Printf("ignoring %s=%q, dates are yyyy-mm-dd", key, v)
+	}
+	return d
+}
+
+// envList splits a comma separated value, dropping empty entries
+func envList(key string) []string {
+	return splitList(os.Getenv(key))
+}
+
+// envListDefault is envList with a fallback for when nothing is set
+func envListDefault(key string, fallback []string) []string {
+	if list := envList(key); len(list) > 0 {
+		return list
+	}
+	return fallback
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// envPrefixes parses a comma separated list of cidrs (bare ips allowed). typos
+// go on errs, since a silently ignored proxy range would make every client
+// look like the proxy
+func envPrefixes(key string, errs *[]error) []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range envList(key) {
+		p, err := parseBlockCIDR(s)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("invalid %s entry %q: %v", key, s, err))
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// envMap parses "a=x,b=y" style values
+func envMap(key string) map[string]string {
+	out := map[string]string{}
+	for _, pair := range envList(key) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSessionCSRF(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	var user UserResponse
+	rec := ta.do(http.MethodPost, "/api/v1/admin/users", map[string]string{"email": "alice@example.com", "password": "correct horse battery"},
+		"Authorization", "Bearer "+testAdminToken)
+	json.NewDecoder(rec.Body).Decode(&user)
+
+	// the login form comes with its double-submit cookie
+	rec = ta.do(http.MethodGet, "/login", nil)
+	var loginToken string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == loginCSRFCookie {
+			loginToken = c.Value
+		}
+	}
+	if loginToken == "" || !strings.Contains(rec.Body.String(), loginToken) {
+		t.Fatal("login page has no csrf token")
+	}
+	login := func(token string) *http.Response {
+		form := url.Values{"email": {"alice@example.com"}, "password": {"correct horse battery"}, "csrf_token": {token}}
+		return ta.do(http.MethodPost, "/login", form.Encode(),
+			"Content-Type", "application/x-www-form-urlencoded", "Cookie", loginCSRFCookie+"="+loginToken).Result()
+	}
+	if resp := login("forged"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("login with a wrong token: status %d, want 403", resp.StatusCode)
+	}
+	var cookie string
+	for _, c := range login(loginToken).Cookies() {
+		if c.Name == sessionCookie {
+			cookie = sessionCookie + "=" + c.Value
+			if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+				t.Errorf("session cookie %+v isn't HttpOnly and Lax", c)
+			}
+		}
+	}
+	if cookie == "" {
+		t.Fatal("login set no session cookie")
+	}
+
+	// pages for the session carry its token
+	page := ta.do(http.MethodGet, "/", nil, "Cookie", cookie).Body.String()
+	token := csrfToken(strings.TrimPrefix(cookie, sessionCookie+"="))
+	if !strings.Contains(page, `<meta name="csrf-token" content="`+token+`">`) {
+		t.Fatal("home page has no csrf meta tag")
+	}
+
+	// the cookie alone doesn't act as the user
+	n := 0
+	ownerOf := func(header ...string) string {
+		n++
+		rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: fmt.Sprintf("https://example.com/%d", n)}, header...)
+		var resp ShortenResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		u, _ := ta.getURL(resp.ShortCode)
+		if u == nil {
+			t.Fatalf("shorten: status %d", rec.Code)
+		}
+		return u.UserID
+	}
+	if owner := ownerOf("Cookie", cookie); owner != "" {
+		t.Errorf("cookie without token made a link for %q", owner)
+	}
+	if owner := ownerOf("Cookie", cookie, csrfHeader, "wrong"); owner != "" {
+		t.Errorf("cookie with a wrong token made a link for %q", owner)
+	}
+	if owner := ownerOf("Cookie", cookie, csrfHeader, token); owner != user.ID {
+		t.Errorf("link owner %q, want %q", owner, user.ID)
+	}
+
+	// nor does it on the GET shorten a third-party page could navigate to
+	getOwner := func(header ...string) string {
+		n++
+		rec := ta.do(http.MethodGet, "/api/v1/shorten?url="+url.QueryEscape(fmt.Sprintf("https://example.com/%d", n)), nil, header...)
+		shortURL := strings.TrimSpace(rec.Body.String())
+		u, _ := ta.getURL(shortURL[strings.LastIndex(shortURL, "/")+1:])
+		if u == nil {
+			t.Fatalf("GET shorten: status %d %s", rec.Code, shortURL)
+		}
+		return u.UserID
+	}
+	if owner := getOwner("Cookie", cookie); owner != "" {
+		t.Errorf("GET shorten with the cookie alone made a link for %q", owner)
+	}
+	if owner := getOwner("Cookie", cookie, csrfHeader, token); owner != user.ID {
+		t.Errorf("GET shorten link owner %q, want %q", owner, user.ID)
+	}
+
+	if rec := ta.do(http.MethodPost, "/logout", "", "Content-Type", "application/x-www-form-urlencoded", "Cookie", cookie); rec.Code != http.StatusForbidden {
+		t.Fatalf("logout without token: status %d, want 403", rec.Code)
+	}
+	rec = ta.do(http.MethodPost, "/logout", url.Values{"csrf_token": {token}}.Encode(), "Content-Type", "application/x-www-form-urlencoded", "Cookie", cookie)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("logout: status %d", rec.Code)
+	}
+	if owner := ownerOf("Cookie", cookie, csrfHeader, token); owner != "" {
+		t.Errorf("logged out session still made a link for %q", owner)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// the dns record that proves someone controls a domain they register
+const (
+	domainVerifyPrefix = "_linkfast."
+	domainVerifyValue  = "linkfast-verification="
+)
+
+var hostnamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// CustomDomain is a short domain a user or api key brought along. it only
+// starts serving links once the TXT record is found, and then it's usable by
+// whoever registered it and the rest of their org
+type CustomDomain struct {
+	Domain     string     `json:"domain"`
+	OwnerID    string     `json:"owner_id"` // identity subject - user or api key id
+	OrgID      string     `json:"org_id,omitempty"`
+	Token      string     `json:"token"`
+	Verified   bool       `json:"verified"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// what to put in dns, filled in for responses
+	RecordName  string `json:"record_name,omitempty"`
+	RecordValue string `json:"record_value,omitempty"`
+}
+
+func (d *CustomDomain) withRecord() CustomDomain {
+	out := *d
+	out.RecordName = domainVerifyPrefix + d.Domain
+	out.RecordValue = domainVerifyValue + d.Token
+	return out
+}
+
+// usableBy says whether an identity may mint links on (or manage) the domain
+func (d *CustomDomain) usableBy(id *Identity) bool {
+	if id.Admin {
+		return true
+	}
+	if id.Anonymous() {
+		return false
+	}
+	return id.Subject == d.OwnerID || (d.OrgID != "" && id.OrgID() == d.OrgID)
+}
+
+// domainSet is the in-memory copy of verified custom domains, consulted on
+// every request for routing - reloaded from the bucket whenever it changes
+type domainSet struct {
+	mu      sync.RWMutex
+	domains map[string]*CustomDomain
+}
+
+func (s *domainSet) get(host string) *CustomDomain {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.domains[strings.ToLower(host)]
+}
+
+func (s *domainSet) set(domains map[string]*CustomDomain) {
+	s.mu.Lock()
+	s.domains = domains
+	s.mu.Unlock()
+}
+
+// loadCustomDomains reads the verified domains into memory
+func (app *App) loadCustomDomains() error {
+	domains := map[string]*CustomDomain{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("domains"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var d CustomDomain
+			if err := json.Unmarshal(v, &d); err != nil {
+				log.Printf("skipping bad domain entry %q: %v", k, err)
+				return nil
+			}
+			if d.Verified {
+				domains[d.Domain] = &d
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	app.CustomDomains.set(domains)
+	return nil
+}
+
+// checkDomain makes sure a caller may use the domain they asked for - server
+// wide SHORT_DOMAINS are open to everyone, custom ones only to their owners
+func (app *App) checkDomain(id *Identity, domain string) error {
+	if domain == "" {
+		return nil
+	}
+	if custom := app.CustomDomains.get(domain); custom != nil && !custom.usableBy(id) {
+		return fmt.Errorf("domain %q belongs to someone else", domain)
+	}
+	return nil
+}
+
+// certHostPolicy is autocert's HostPolicy - only ask for certificates for
+// names we actually serve, so random Host headers can't burn through the
+// ca's rate limits
+func (app *App) certHostPolicy(_ context.Context, host string) error {
+	host = hostOnly(host)
+	if host == app.primaryHost() || app.allowedDomain(host) {
+		return nil
+	}
+	return fmt.Errorf("acme/autocert: host %q is not configured", host)
+}
+
+// lookupVerification checks the domain's TXT records for its token
+func lookupVerification(d *CustomDomain) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupTXT(ctx, domainVerifyPrefix+d.Domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == domainVerifyValue+d.Token {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getCustomDomain loads a registered domain, verified or not
+func (app *App) getCustomDomain(name string) (*CustomDomain, error) {
+	var d *CustomDomain
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("domains"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		d = &CustomDomain{}
+		return json.Unmarshal(v, d)
+	})
+	return d, err
+}
+
+// authorizeDomain is authorizeLink for domains - someone else's domain 404s
+func (app *App) authorizeDomain(w http.ResponseWriter, r *http.Request) (*CustomDomain, bool) {
+	d, err := app.getCustomDomain(strings.ToLower(mux.Vars(r)["domain"]))
+	if err == nil && d != nil && d.usableBy(identityFromContext(r.Context())) {
+		return d, true
+	}
+	if err != nil {
+		log.Printf("domain load error: %v", err)
+	}
+	writeError(w, http.StatusNotFound, errorNotFound, "domain not found")
+	return nil, false
+}
+
+// handles POST /api/domains - registers a domain and returns the TXT record to add
+func (app *App) createDomainHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+
+	name := hostOnly(strings.TrimSpace(req.Domain))
+	if !hostnamePattern.MatchString(name) || len(name) > 253 {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "domain", "domain must be a hostname like links.example.com")
+		return
+	}
+	if name == app.primaryHost() || app.allowedDomain(name) {
+		writeFieldError(w, http.StatusConflict, errorConflict, "domain", "domain is already in use")
+		return
+	}
+
+	identity := identityFromContext(r.Context())
+	d := CustomDomain{
+		Domain:    name,
+		OwnerID:   identity.Subject,
+		OrgID:     identity.OrgID(),
+		Token:     randomToken("", 16),
+		CreatedAt: time.Now(),
+	}
+
+	taken := false
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("domains"))
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(name)) != nil {
+			taken = true
+			return nil
+		}
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(name), data)
+	})
+	if err != nil {
+		log.Printf("domain save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if taken {
+		writeFieldError(w, http.StatusConflict, errorConflict, "domain", "domain is already registered")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(d.withRecord())
+}
+
+// handles GET /api/domains - the caller's domains (and their org's), all of them for admins
+func (app *App) listDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	out := []CustomDomain{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("domains"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var d CustomDomain
+			if json.Unmarshal(v, &d) == nil && d.usableBy(identity) {
+				out = append(out, d.withRecord())
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("domain list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handles POST /api/domains/{domain}/verify - looks for the TXT record and
+// switches the domain on when it's there
+func (app *App) verifyDomainHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := app.authorizeDomain(w, r)
+	if !ok {
+		return
+	}
+
+	if !d.Verified {
+		found, err := lookupVerification(d)
+		if err != nil {
+			log.Printf("dns lookup for %s failed: %v", d.Domain, err)
+			writeError(w, http.StatusBadGateway, errorUpstream, "dns lookup failed, try again shortly")
+			return
+		}
+		if !found {
+			writeError(w, http.StatusUnprocessableEntity, errorNotVerified, "TXT record "+domainVerifyPrefix+d.Domain+" not found yet")
+			return
+		}
+
+		now := time.Now()
+		d.Verified, d.VerifiedAt = true, &now
+		err = app.DB.Update(func(tx *bolt.Tx) error {
+			data, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte("domains")).Put([]byte(d.Domain), data)
+		})
+		if err == nil {
+			err = app.loadCustomDomains()
+		}
+		if err != nil {
+			log.Printf("domain save error: %v", err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+		log.Printf("custom domain %s verified", d.Domain)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.withRecord())
+}
+
+// handles DELETE /api/domains/{domain} - links already on it stop resolving there
+func (app *App) deleteDomainHandler(w http.ResponseWriter, r *http.Request) {
+	d, ok := app.authorizeDomain(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("domains")).Delete([]byte(d.Domain))
+	})
+	if err == nil {
+		err = app.loadCustomDomains()
+	}
+	if err != nil {
+		log.Printf("domain delete error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// handles GET /admin - a static page that asks for the admin token once, keeps
+// it in sessionStorage and talks to the normal json api with it, so there's no
+// separate login machinery. the data behind it is still guarded by the token
+func (app *App) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := app.page(r).ExecuteTemplate(w, "dashboard.html", nil); err != nil {
+		log.Printf("dashboard render error: %v", err)
+	}
+}
+
+// handles GET /analytics - charts for one of the caller's links, drawn in the
+// browser from /api/links/{shortCode}/stats. it logs in with the same tab
+// tokens as the home page
+func (app *App) analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := app.page(r).ExecuteTemplate(w, "analytics.html", nil); err != nil {
+		log.Printf("analytics render error: %v", err)
+	}
+}
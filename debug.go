@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// runtime debugging for tuning under load: net/http/pprof plus /debug/vars
+// with what's going on inside. by default both sit on the main router behind
+// the admin token. DEBUG_ADDR (say localhost:6060) moves them to their own
+// listener without auth instead, so keep that one off the public network
+
+var processStart = time.Now()
+
+// mountDebug adds the debug endpoints to r, each wrapped in guard
+func (app *App) mountDebug(r *mux.Router, guard func(http.HandlerFunc) http.HandlerFunc) {
+	r.HandleFunc("/debug/vars", guard(app.debugVarsHandler)).Methods("GET").Name("debug-vars")
+	r.HandleFunc("/debug/pprof/cmdline", guard(pprof.Cmdline)).Methods("GET").Name("debug-pprof")
+	r.HandleFunc("/debug/pprof/profile", guard(pprof.Profile)).Methods("GET").Name("debug-pprof")
+	r.HandleFunc("/debug/pprof/symbol", guard(pprof.Symbol)).Methods("GET", "POST").Name("debug-pprof")
+	r.HandleFunc("/debug/pprof/trace", guard(pprof.Trace)).Methods("GET").Name("debug-pprof")
+	// the index serves the named profiles too - heap, goroutine, block, mutex...
+	r.PathPrefix("/debug/pprof/").Handler(guard(pprof.Index)).Methods("GET").Name("debug-pprof")
+}
+
+// debugServer is the DEBUG_ADDR listener. no write timeout since cpu profiles
+// and traces stream for as long as ?seconds= asks
+func (app *App) debugServer() *http.Server {
+	if host, _, err := net.SplitHostPort(app.Config.DebugAddr); err == nil && host == "" {
+		log.Printf("warning: DEBUG_ADDR %s listens on every interface, the debug endpoints have no auth", app.Config.DebugAddr)
+	}
+	r := mux.NewRouter()
+	app.mountDebug(r, func(h http.HandlerFunc) http.HandlerFunc { return h })
+	return &http.Server{
+		Addr:        app.Config.DebugAddr,
+		Handler:     r,
+		ReadTimeout: 15 * time.Second,
+		IdleTimeout: 60 * time.Second,
+	}
+}
+
+type DebugVars struct {
+	Uptime     string      `json:"uptime"`
+	GoVersion  string      `json:"go_version"`
+	Goroutines int         `json:"goroutines"`
+	Memory     DebugMemory `json:"memory"`
+	Cache      DebugCache  `json:"cache"`
+	Bolt       DebugBolt   `json:"bolt"`
+}
+
+type DebugMemory struct {
+	HeapAlloc    uint64 `json:"heap_alloc"` // bytes
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys"` // bytes from the os
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+	LastPauseNs  uint64 `json:"last_pause_ns"`
+}
+
+type DebugCache struct {
+	Entries    int `json:"entries"`
+	MaxEntries int `json:"max_entries,omitempty"` // CACHE_MAX_ENTRIES, 0 = unbounded
+}
+
+type DebugBolt struct {
+	FileSize      int64 `json:"file_size"` // bytes
+	TxN           int   `json:"tx_n"`      // read transactions started
+	OpenTxN       int   `json:"open_tx_n"` // read transactions open right now
+	FreePageN     int   `json:"free_page_n"`
+	PendingPageN  int   `json:"pending_page_n"`
+	FreeAlloc     int   `json:"free_alloc"`     // bytes in free pages
+	FreelistInuse int   `json:"freelist_inuse"` // bytes used by the freelist
+	Writes        int64 `json:"writes"`         // page writes
+	WriteTime     int64 `json:"write_time_ns"`
+	Spills        int64 `json:"spills"`
+	Rebalances    int64 `json:"rebalances"`
+}
+
+func (app *App) debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := app.DB.Stats()
+	var size int64
+	app.DB.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+
+	vars := DebugVars{
+		Uptime:     time.Since(processStart).Round(time.Second).String(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: DebugMemory{
+			HeapAlloc:    mem.HeapAlloc,
+			HeapObjects:  mem.HeapObjects,
+			Sys:          mem.Sys,
+			NumGC:        mem.NumGC,
+			PauseTotalNs: mem.PauseTotalNs,
+			LastPauseNs:  mem.PauseNs[(mem.NumGC+255)%256],
+		},
+		Cache: DebugCache{
+			Entries:    app.Cache.ItemCount(),
+			MaxEntries: app.Config.CacheMaxEntries,
+		},
+		Bolt: DebugBolt{
+			FileSize:      size,
+			TxN:           stats.TxN,
+			OpenTxN:       stats.OpenTxN,
+			FreePageN:     stats.FreePageN,
+			PendingPageN:  stats.PendingPageN,
+			FreeAlloc:     stats.FreeAlloc,
+			FreelistInuse: stats.FreelistInuse,
+			Writes:        stats.TxStats.GetWrite(),
+			WriteTime:     int64(stats.TxStats.GetWriteTime()),
+			Spills:        stats.TxStats.GetSpill(),
+			Rebalances:    stats.TxStats.GetRebalance(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(vars)
+}
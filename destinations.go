@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// destinations a link may not point at: this shortener itself, where a short
+// link to a short link can loop forever, and (unless ALLOW_PRIVATE_DESTINATIONS)
+// addresses inside the network, which the metadata scraper, link checker and
+// abuse checks would otherwise happily fetch for anyone
+
+var (
+	errSelfLink        = errors.New("url points back at this shortener")
+	errPrivateLink     = errors.New("url points at an internal address")
+	privateLookupLimit = 3 * time.Second
+)
+
+// internalAddr is loopback, private, link-local and the like - nothing a
+// public link should lead to
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// isOwnURL is true for urls on a host this server answers for: BASE_URL's,
+// SHORT_DOMAINS and verified custom domains. a port only tells urls apart when
+// BASE_URL spells one out, so local setups can still link to other local apps
+func (app *App) isOwnURL(u *url.URL) bool {
+	host := hostOnly(u.Host)
+	if app.allowedDomain(host) {
+		return true
+	}
+	base, err := url.Parse(app.Config.BaseURL)
+	if err != nil || host != hostOnly(base.Host) {
+		return false
+	}
+	if base.Port() == "" {
+		return true
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return port == base.Port()
+}
+
+// checkDestination refuses self links and internal destinations. with resolve
+// the host's dns records are checked too, otherwise only literal ips are -
+// a lookup that fails lets the url through, the outbound dialer refuses
+// internal addresses again when anything actually connects
+func (app *App) checkDestination(ctx context.Context, raw string, resolve bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !networkSchemes[strings.ToLower(u.Scheme)] {
+		return nil // mailto:, tel: and app links don't go anywhere on the network
+	}
+	if !app.Config.AllowSelfLinks && app.isOwnURL(u) {
+		return errSelfLink
+	}
+	if app.Config.AllowPrivateDestinations {
+		return nil
+	}
+
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil {
+		if internalAddr(ip) {
+			return errPrivateLink
+		}
+		return nil
+	}
+	if !resolve {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, privateLookupLimit)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, ip := range addrs {
+		if internalAddr(ip) {
+			return errPrivateLink
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// hostOnly lowercases a Host header and drops the port
+func hostOnly(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// primaryHost is the BASE_URL host - where the api and web ui live, and the
+// domain of links created without one
+func (app *App) primaryHost() string {
+	u, err := url.Parse(app.Config.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return hostOnly(u.Host)
+}
+
+// onShortDomain is a mux matcher for requests arriving on one of
+// SHORT_DOMAINS - those hosts only serve links, never the api or ui
+func (app *App) onShortDomain(r *http.Request, _ *mux.RouteMatch) bool {
+	host := hostOnly(r.Host)
+	return host != app.primaryHost() && app.allowedDomain(host)
+}
+
+// servesLink says whether a link on the given domain resolves on this request's
+// host. codes are unique across all domains, so by default any domain works -
+// DOMAIN_SCOPED_CODES keeps each domain to its own links
+func (app *App) servesLink(r *http.Request, domain string) bool {
+	if !app.Config.DomainScopedCodes {
+		return true
+	}
+	if domain == "" {
+		domain = app.primaryHost()
+	}
+	return strings.EqualFold(domain, hostOnly(r.Host))
+}
+
+// handles GET / on a short domain - there's nothing to see, send people to the main site
+func (app *App) shortDomainRootHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, app.Config.BaseURL+"/", http.StatusFound)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ephemeral links are stateless: the destination and expiry live in the url
+// itself, /e/<payload>.<signature>, signed with EPHEMERAL_SECRET. minting one
+// writes nothing to the database and following one reads nothing, so they're
+// for huge batches of short-lived links (an email campaign send) where a row
+// per link isn't worth it. the flip side - no stats, no editing, and the only
+// way to kill one early is rotating the secret
+
+const (
+	defaultEphemeralTTL = 24 * time.Hour
+	maxEphemeralURLs    = 1000
+	ephemeralMACBytes   = 16
+)
+
+// EphemeralRequest is the body of POST /api/ephemeral - url or urls, or both
+type EphemeralRequest struct {
+	URL       string   `json:"url,omitempty"`
+	URLs      []string `json:"urls,omitempty"`
+	ExpiresIn string   `json:"expires_in,omitempty"` // go duration, default 24h
+}
+
+// EphemeralLink is one minted link, or why it couldn't be
+type EphemeralLink struct {
+	OriginalURL string `json:"original_url"`
+	ShortURL    string `json:"short_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type EphemeralResponse struct {
+	Links     []EphemeralLink `json:"links"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// ephemeralMAC signs a payload. the "ephemeral:" prefix keeps it from being
+// valid for anything else signed with the same key
+func (app *App) ephemeralMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, app.Config.EphemeralSecret)
+	mac.Write([]byte("ephemeral:"))
+	mac.Write(payload)
+	return mac.Sum(nil)[:ephemeralMACBytes]
+}
+
+// signEphemeral makes the token for a destination: 8 bytes of unix expiry then the url
+func (app *App) signEphemeral(dest string, expires time.Time) string {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	payload = append(payload, dest...)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(app.ephemeralMAC(payload))
+}
+
+// openEphemeral checks a token's signature and returns what it carries
+func (app *App) openEphemeral(token string) (dest string, expires time.Time, ok bool) {
+	encoded, sig, found := strings.Cut(token, ".")
+	if !found {
+		return "", time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) <= 8 {
+		return "", time.Time{}, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, app.ephemeralMAC(payload)) {
+		return "", time.Time{}, false
+	}
+	return string(payload[8:]), time.Unix(int64(binary.BigEndian.Uint64(payload)), 0), true
+}
+
+// handles POST /api/ephemeral - signs links for each url, nothing is stored
+func (app *App) ephemeralHandler(w http.ResponseWriter, r *http.Request) {
+	if len(app.Config.EphemeralSecret) == 0 {
+		writeError(w, http.StatusNotFound, errorDisabled, "ephemeral links are not enabled on this server")
+		return
+	}
+
+	var req EphemeralRequest
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	urls := req.URLs
+	if req.URL != "" {
+		urls = append([]string{req.URL}, urls...)
+	}
+	if len(urls) == 0 || len(urls) > maxEphemeralURLs {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "urls", fmt.Sprintf("send between 1 and %d urls", maxEphemeralURLs))
+		return
+	}
+	ttl := defaultEphemeralTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > app.Config.EphemeralMaxTTL {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "expires_in", fmt.Sprintf("expires_in must be a positive duration up to %s", app.Config.EphemeralMaxTTL))
+			return
+		}
+		ttl = d
+	}
+
+	expires := app.now().Add(ttl).Truncate(time.Second)
+	resp := EphemeralResponse{Links: make([]EphemeralLink, 0, len(urls)), ExpiresAt: expires}
+	for _, raw := range urls {
+		link := EphemeralLink{OriginalURL: normalizeURL(raw)}
+		// no dns lookups, a campaign send has thousands of these
+		err := app.validateDestination(link.OriginalURL)
+		if err == nil {
+			err = app.checkURLLength(link.OriginalURL)
+		}
+		if err == nil {
+			err = app.checkDestination(r.Context(), link.OriginalURL, false)
+		}
+		if err != nil {
+			link.Error = err.Error()
+		} else {
+			link.ShortURL = app.shortURL("e/" + app.signEphemeral(link.OriginalURL, expires))
+		}
+		resp.Links = append(resp.Links, link)
+	}
+	app.Metrics.incr("ephemeral.minted")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handles GET /e/{token} - redirects without touching the database
+func (app *App) ephemeralRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	if len(app.Config.EphemeralSecret) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	dest, expires, ok := app.openEphemeral(mux.Vars(r)["token"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	link := cachedLink{URL: dest, ExpiresAt: &expires}
+	if link.expired(app.now()) {
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+	app.redirect(w, r, link)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEphemeralLinks(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"EPHEMERAL_SECRET": "test-ephemeral-secret"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	if rec := ta.do(http.MethodPost, "/api/v1/ephemeral", EphemeralRequest{URL: "https://example.com/a"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status %d, want 401", rec.Code)
+	}
+	req := EphemeralRequest{URLs: []string{"https://example.com/offer?id=1", "javascript:alert(1)"}, ExpiresIn: "1h"}
+	rec := ta.do(http.MethodPost, "/api/v1/ephemeral", req, admin...)
+	var resp EphemeralResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Links) != 2 || resp.Links[0].ShortURL == "" || resp.Links[1].Error == "" {
+		t.Fatalf("mint: %d %+v", rec.Code, resp)
+	}
+	path := strings.TrimPrefix(resp.Links[0].ShortURL, "http://sho.rt")
+
+	if rec := ta.do(http.MethodGet, path, nil); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://example.com/offer?id=1" {
+		t.Errorf("redirect: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	// flipping a byte of the payload breaks the signature
+	tampered := strings.Replace(path, "/e/A", "/e/B", 1)
+	if tampered == path {
+		tampered = strings.Replace(path, "/e/", "/e/A", 1)
+	}
+	if rec := ta.do(http.MethodGet, tampered, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("tampered token: status %d, want 404", rec.Code)
+	}
+
+	ta.clock.Advance(2 * time.Hour)
+	if rec := ta.do(http.MethodGet, path, nil); rec.Code != http.StatusGone {
+		t.Errorf("expired: status %d, want 410", rec.Code)
+	}
+
+	req.ExpiresIn = "8760h"
+	if rec := ta.do(http.MethodPost, "/api/v1/ephemeral", req, admin...); rec.Code != http.StatusBadRequest {
+		t.Errorf("past EPHEMERAL_MAX_TTL: status %d, want 400", rec.Code)
+	}
+}
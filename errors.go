@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// every api error has the same shape:
+//
+//	{"code": "invalid_field", "field": "days", "message": "days must be between 1 and 3650", "error": "..."}
+//
+// code is what programs branch on, it never changes once it's out. message
+// is for people and may be reworded (or translated, see i18n.go). field is
+// the request field at fault, when it's down to one. error repeats message
+// for clients written before codes. the codes are listed in the README
+
+const (
+	errorInvalidRequest   = "invalid_request"     // 400, something about the request as a whole
+	errorInvalidJSON      = "invalid_json"        // 400, the body didn't decode
+	errorInvalidField     = "invalid_field"       // 400, see field
+	errorMissingField     = "missing_field"       // 400, a required field is empty, see field
+	errorDestination      = "destination_blocked" // 400, the destination isn't allowed
+	errorUnauthorized     = "unauthorized"        // 401, no credentials or bad ones
+	errorTwoFactor        = "two_factor_required" // 401, right password, now the two-factor code
+	errorForbidden        = "forbidden"           // 403, the caller may not do this
+	errorVerification     = "verification_failed" // 403, the captcha or abuse check said no
+	errorNotFound         = "not_found"           // 404
+	errorMethodNotAllowed = "method_not_allowed"  // 405
+	errorConflict         = "conflict"            // 409, clashes with something that exists
+	errorCodeTaken        = "code_taken"          // 409, the custom short code is in use
+	errorInProgress       = "in_progress"         // 409, the same Idempotency-Key is still running
+	errorGone             = "gone"                // 410
+	errorTooLarge         = "too_large"           // 413, the body or a list in it is too big
+	errorKeyReused        = "key_reused"          // 422, the Idempotency-Key came with a different request
+	errorNotVerified      = "not_verified"        // 422, a custom domain's TXT record isn't there yet
+	errorRateLimited      = "rate_limited"        // 429, slow down, see Retry-After
+	errorDisabled         = "disabled"            // the feature is switched off on this server, any status
+	errorServer           = "server_error"        // 500
+	errorUpstream         = "upstream_error"      // 502, a site or service we called failed
+	errorUnavailable      = "unavailable"         // 503, try again
+)
+
+// ErrorResponse is the body of every api error, see above
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Error   string `json:"error"`
+
+	Errors []FieldError `json:"errors,omitempty"` // every problem with the request, see validation.go
+}
+
+// writeError answers with an api error
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeFieldError(w, status, code, "", message)
+}
+
+// writeFieldError is writeError blaming one field of the request
+func writeFieldError(w http.ResponseWriter, status int, code, field, message string) {
+	writeErrorResponse(w, status, ErrorResponse{Code: code, Message: message, Field: field, Error: message})
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// apiFallback answers whatever nothing under /api matched, instead of mux's
+// plain text 404 and 405. it works out the 405 itself - mux 1.8 reports most
+// wrong methods as not found once there's more than one route
+func apiFallback(api *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if method == r.Method {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if api.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			writeError(w, http.StatusNotFound, errorNotFound, "no such api endpoint")
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, errorMethodNotAllowed, r.Method+" is not allowed here")
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestErrorResponses(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	taken := ta.shorten(ShortenRequest{URL: "https://example.com/taken", Code: "taken-code"}, http.StatusOK).ShortCode
+
+	for _, tc := range []struct {
+		name, method, path string
+		body               any
+		header             []string
+		status             int
+		want               ErrorResponse
+	}{
+		{"bad url", "POST", "/api/v1/shorten", ShortenRequest{URL: "http://"}, nil, http.StatusBadRequest,
+			ErrorResponse{Code: errorInvalidField, Field: "url"}},
+		{"code taken", "POST", "/api/v1/shorten", ShortenRequest{URL: "https://example.com/other", Code: taken}, nil, http.StatusConflict,
+			ErrorResponse{Code: errorCodeTaken, Field: "code"}},
+		{"bad json", "POST", "/api/v1/shorten", "{nope", []string{"Content-Type", "application/json"}, http.StatusBadRequest,
+			ErrorResponse{Code: errorInvalidJSON}},
+		{"bad query", "GET", "/api/v1/links/" + taken + "/stats?days=0", nil, admin, http.StatusBadRequest,
+			ErrorResponse{Code: errorInvalidField, Field: "days"}},
+		{"no auth", "GET", "/api/v1/links", nil, nil, http.StatusUnauthorized,
+			ErrorResponse{Code: errorUnauthorized}},
+		{"missing link", "GET", "/api/v1/links/nope-nope", nil, admin, http.StatusNotFound,
+			ErrorResponse{Code: errorNotFound}},
+		{"no endpoint", "GET", "/api/v1/nothing/here", nil, nil, http.StatusNotFound,
+			ErrorResponse{Code: errorNotFound}},
+		{"wrong method", "PUT", "/api/v1/shorten", nil, nil, http.StatusMethodNotAllowed,
+			ErrorResponse{Code: errorMethodNotAllowed}},
+	} {
+		rec := ta.do(tc.method, tc.path, tc.body, tc.header...)
+		var got ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Errorf("%s: status %d, %v", tc.name, rec.Code, err)
+			continue
+		}
+		if rec.Code != tc.status || got.Code != tc.want.Code || got.Field != tc.want.Field || got.Message == "" || got.Error != got.Message {
+			t.Errorf("%s: status %d, %+v", tc.name, rec.Code, got)
+		}
+	}
+
+	if rec := ta.do(http.MethodPut, "/api/v1/shorten", nil); rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("Allow = %q", rec.Header().Get("Allow"))
+	}
+}
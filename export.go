@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// handles GET /api/export/qr?campaign=x (or ?tag=y, or ?field.name=z) - streams a zip
+// with a png qr code per link plus a manifest.csv, so print vendors get everything in one go
+func (app *App) qrExportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	campaign := q.Get("campaign")
+	tag := strings.ToLower(strings.TrimSpace(q.Get("tag")))
+	fields := fieldParams(q)
+
+	if campaign == "" && tag == "" && len(fields) == 0 {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, "campaign, tag or a field filter is required")
+		return
+	}
+
+	size, err := strconv.Atoi(q.Get("size"))
+	if err != nil || size < 64 || size > 2048 {
+		size = 512
+	}
+
+	// collect everything up front so we can 404 cleanly instead of sending an empty zip
+	var links []URL
+	after := ""
+	for {
+		page, next, err := app.listURLs(after, 500, func(u *URL) bool {
+			if u.TrashedAt != nil {
+				return false
+			}
+			if campaign != "" && u.Campaign != campaign {
+				return false
+			}
+			if tag != "" && !hasTag(u.Tags, tag) {
+				return false
+			}
+			return matchFields(u, fields)
+		})
+		if err != nil {
+			log.Printf("qr export list error: %v", err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+		links = append(links, page...)
+		if next == "" {
+			break
+		}
+		after = next
+	}
+
+	if len(links) == 0 {
+		writeError(w, http.StatusNotFound, errorNotFound, "no links found")
+		return
+	}
+
+	name := campaign
+	switch {
+	case name == "" && tag != "":
+		name = "tag-" + tag
+	case name == "":
+		name = "fields"
+	}
+
+	// one manifest column per custom field any exported link carries
+	var fieldNames []string
+	for _, link := range links {
+		for k := range link.Fields {
+			if !slices.Contains(fieldNames, k) {
+				fieldNames = append(fieldNames, k)
+			}
+		}
+	}
+	slices.Sort(fieldNames)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-qr.zip"`, safeFilename(name)))
+
+	// from here on headers are sent, so errors can only be logged
+	zw := zip.NewWriter(w)
+	header := []string{"file", "short_code", "short_url", "original_url", "campaign", "tags", "click_count"}
+	manifest := [][]string{append(header, fieldNames...)}
+
+	for _, link := range links {
+		shortURL := app.linkShortURL(&link)
+		png, err := qrcode.Encode(shortURL, qrcode.Medium, size)
+		if err != nil {
+			log.Printf("qr encode error for %s: %v", link.ShortCode, err)
+			continue
+		}
+
+		file := link.ShortCode + ".png"
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			log.Printf("qr export zip error: %v", err)
+			return
+		}
+		if _, err := f.Write(png); err != nil {
+			log.Printf("qr export write error: %v", err)
+			return
+		}
+
+		row := []string{
+			file, link.ShortCode, shortURL, link.OriginalURL, link.Campaign,
+			strings.Join(link.Tags, ";"), strconv.Itoa(link.ClickCount),
+		}
+		for _, k := range fieldNames {
+			row = append(row, fieldText(link.Fields[k]))
+		}
+		manifest = append(manifest, row)
+	}
+
+	f, err := zw.Create("manifest.csv")
+	if err != nil {
+		log.Printf("qr export zip error: %v", err)
+		return
+	}
+	if err := csv.NewWriter(f).WriteAll(manifest); err != nil {
+		log.Printf("qr export manifest error: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("qr export close error: %v", err)
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// safeFilename keeps content-disposition names to boring characters
+func safeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
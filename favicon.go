@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// destination favicons for the link list. the dashboard can't load them from
+// the sites itself (cross-origin, and it'd tell every site who's looking), so
+// GET /api/links/{code}/favicon serves our copy. icons are kept per host in
+// the favicons bucket. the first request for a host gets a 404 with
+// Retry-After while the icon is fetched in the background, and a stale one is
+// still served while it's refreshed. sites without an icon are remembered
+// too, so every page of the list doesn't ask them again
+
+const (
+	faviconTTL      = 7 * 24 * time.Hour
+	faviconRetry    = 24 * time.Hour // how soon a site without an icon is tried again
+	maxFaviconBytes = 100 * 1024
+)
+
+// storedFavicon is a host's icon in the favicons bucket
+type storedFavicon struct {
+	Type      string    `json:"type,omitempty"` // empty when the site has none
+	Data      []byte    `json:"data,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func (f *storedFavicon) stale(now time.Time) bool {
+	ttl := faviconTTL
+	if len(f.Data) == 0 {
+		ttl = faviconRetry
+	}
+	return now.Sub(f.FetchedAt) > ttl
+}
+
+// handles GET /api/links/{shortCode}/favicon
+func (app *App) faviconHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	urlData, ok := app.authorizeLink(w, r, shortCode)
+	if !ok {
+		return
+	}
+	u, err := url.Parse(urlData.OriginalURL)
+	if err != nil || !webURL(urlData.OriginalURL) || u.Host == "" {
+		writeError(w, http.StatusNotFound, errorNotFound, "only web destinations have a favicon")
+		return
+	}
+
+	host := strings.ToLower(u.Host)
+	icon, err := app.loadFavicon(host)
+	if err != nil {
+		log.Printf("favicon lookup error for %s: %v", host, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if icon == nil || icon.stale(app.now()) {
+		app.refreshFavicon(host, u.Scheme+"://"+u.Host+"/")
+	}
+	if icon == nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusNotFound, errorNotFound, "favicon not fetched yet, try again shortly")
+		return
+	}
+	if len(icon.Data) == 0 {
+		writeError(w, http.StatusNotFound, errorNotFound, "destination has no favicon")
+		return
+	}
+
+	// an svg is a document - opened on its own it mustn't run anything as us
+	w.Header().Set("Content-Type", icon.Type)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", icon.FetchedAt, bytes.NewReader(icon.Data))
+}
+
+func (app *App) loadFavicon(host string) (*storedFavicon, error) {
+	var icon *storedFavicon
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("favicons"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get(storeCipherOf(tx).indexKey([]byte(host)))
+		if v == nil {
+			return nil
+		}
+		icon = &storedFavicon{}
+		return json.Unmarshal(v, icon)
+	})
+	return icon, err
+}
+
+// icons are keyed by an hmac of the host under STORE_KEY, the host says where links go
+func (app *App) saveFavicon(host string, icon storedFavicon) error {
+	data, err := json.Marshal(icon)
+	if err != nil {
+		return err
+	}
+	return app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("favicons"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(storeCipherOf(tx).indexKey([]byte(host)), data)
+	})
+}
+
+// refreshFavicon fetches a host's icon in the background, one fetch per host
+// at a time. Favicons tracks them so anything closing the database can wait
+func (app *App) refreshFavicon(host, root string) {
+	key := "favicon:" + host
+	if app.Lookups.Add(key, true, time.Minute) != nil {
+		return // already on it
+	}
+	app.Favicons.Add(1)
+	go func() {
+		defer app.Favicons.Done()
+		defer app.Lookups.Delete(key)
+		icon, err := app.fetchFavicon(context.Background(), root)
+		if err != nil {
+			log.Printf("favicon fetch for %s failed: %v", host, err)
+		}
+		icon.FetchedAt = app.now()
+		if err := app.saveFavicon(host, icon); err != nil {
+			log.Printf("favicon save error for %s: %v", host, err)
+		}
+	}()
+}
+
+// fetchFavicon finds the icon the site's home page points at (or
+// /favicon.ico) and downloads it. on an error the icon is empty, which is
+// stored as "has none" until faviconRetry
+func (app *App) fetchFavicon(ctx context.Context, root string) (storedFavicon, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	iconURL := root + "favicon.ico"
+	if meta, err := app.fetchMetadata(ctx, root); err == nil && webURL(meta.Favicon) {
+		iconURL = meta.Favicon
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iconURL, nil)
+	if err != nil {
+		return storedFavicon{}, err
+	}
+	req.Header.Set("User-Agent", outboundUserAgent)
+	req.Header.Set("Accept", "image/*")
+	resp, err := app.Outbound.Do(req)
+	if err != nil {
+		return storedFavicon{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return storedFavicon{}, errors.New("icon returned " + resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFaviconBytes+1))
+	if err != nil {
+		return storedFavicon{}, err
+	}
+	if len(data) > maxFaviconBytes {
+		return storedFavicon{}, fmt.Errorf("icon is over %d bytes", maxFaviconBytes)
+	}
+	// servers say all sorts of things about .ico files, sniff when it isn't an image type
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !strings.HasPrefix(mediaType, "image/") || len(data) == 0 {
+		return storedFavicon{}, errors.New("icon is not an image")
+	}
+	return storedFavicon{Type: mediaType, Data: data}, nil
+}
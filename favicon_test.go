@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01")
+
+func TestFavicon(t *testing.T) {
+	var iconFetches atomic.Int32
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><head><link rel="icon" href="/img/icon.png"></head></html>`))
+		case "/img/icon.png":
+			iconFetches.Add(1)
+			w.Header().Set("Content-Type", "application/octet-stream") // sniffed instead
+			w.Write(testPNG)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+	bare := httptest.NewServer(http.NotFoundHandler())
+	defer bare.Close()
+
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	code := ta.shorten(ShortenRequest{URL: site.URL + "/some/page"}, http.StatusOK).ShortCode
+	other := ta.shorten(ShortenRequest{URL: site.URL + "/other"}, http.StatusOK).ShortCode
+	none := ta.shorten(ShortenRequest{URL: bare.URL + "/page"}, http.StatusOK).ShortCode
+
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+code+"/favicon", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status %d", rec.Code)
+	}
+
+	// the first ask starts the fetch
+	rec := ta.do(http.MethodGet, "/api/v1/links/"+code+"/favicon", nil, admin...)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("first: status %d, retry-after %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	ta.Favicons.Wait()
+
+	// then every link on the host gets it from the bucket
+	for _, c := range []string{code, other} {
+		rec = ta.do(http.MethodGet, "/api/v1/links/"+c+"/favicon", nil, admin...)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rec.Body.Bytes(), testPNG) {
+			t.Errorf("%s: status %d, type %q", c, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+	if n := iconFetches.Load(); n != 1 {
+		t.Errorf("icon fetched %d times", n)
+	}
+
+	// a site without one is remembered as such
+	ta.do(http.MethodGet, "/api/v1/links/"+none+"/favicon", nil, admin...)
+	ta.Favicons.Wait()
+	rec = ta.do(http.MethodGet, "/api/v1/links/"+none+"/favicon", nil, admin...)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("no icon: status %d, retry-after %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// a stale icon is still served while a new one is fetched
+	ta.clock.Advance(faviconTTL + time.Hour)
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+code+"/favicon", nil, admin...); rec.Code != http.StatusOK {
+		t.Errorf("stale: status %d", rec.Code)
+	}
+	ta.Favicons.Wait()
+	if n := iconFetches.Load(); n != 2 {
+		t.Errorf("stale icon fetched %d times in all", n)
+	}
+}
+
+func TestFaviconOnlyOwnLinks(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/theirs"}, http.StatusOK).ShortCode
+
+	rec := ta.do(http.MethodPost, "/api/v1/admin/keys", map[string]string{"name": "ui"}, "Authorization", "Bearer "+testAdminToken)
+	var created CreateAPIKeyResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+code+"/favicon", nil, "X-API-Key", created.Key); rec.Code != http.StatusNotFound {
+		t.Errorf("someone else's link: status %d", rec.Code)
+	}
+}
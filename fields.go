@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// custom field types an org can declare
+const (
+	fieldString = "string"
+	fieldNumber = "number"
+	fieldBool   = "bool"
+)
+
+// limits so a schema (and every link carrying it) stays small
+const (
+	maxFieldDefs     = 20
+	maxFieldValueLen = 256
+)
+
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// FieldDef is one custom field in an org's schema, e.g. cost_center: string
+type FieldDef struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // string, number or bool
+	Required bool     `json:"required,omitempty"`
+	Options  []string `json:"options,omitempty"` // allowed values, string fields only
+}
+
+// validateSchema checks a whole schema before it replaces the stored one
+func validateSchema(defs []FieldDef) error {
+	if len(defs) > maxFieldDefs {
+		return fmt.Errorf("at most %d custom fields", maxFieldDefs)
+	}
+	seen := map[string]bool{}
+	for _, def := range defs {
+		if !fieldNamePattern.MatchString(def.Name) {
+			return fmt.Errorf("field name %q must be lowercase letters, digits and _ (max 32)", def.Name)
+		}
+		if seen[def.Name] {
+			return fmt.Errorf("field %q is defined twice", def.Name)
+		}
+		seen[def.Name] = true
+
+		switch def.Type {
+		case fieldString, fieldNumber, fieldBool:
+		default:
+			return fmt.Errorf("field %q: type must be string, number or bool", def.Name)
+		}
+		if len(def.Options) > 0 && def.Type != fieldString {
+			return fmt.Errorf("field %q: options only apply to string fields", def.Name)
+		}
+	}
+	return nil
+}
+
+// coerce turns a submitted value into the field's type. query strings and
+// csv only have strings, so "42" and "true" are accepted for number/bool too
+func (def *FieldDef) coerce(v any) (any, error) {
+	switch def.Type {
+	case fieldNumber:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(n), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("field %q must be a number", def.Name)
+
+	case fieldBool:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+				return parsed, nil
+			}
+		}
+		return nil, fmt.Errorf("field %q must be true or false", def.Name)
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("field %q must be a string", def.Name)
+	}
+	s = strings.TrimSpace(s)
+	if len(s) > maxFieldValueLen {
+		return nil, fmt.Errorf("field %q must be at most %d characters", def.Name, maxFieldValueLen)
+	}
+	if len(def.Options) > 0 && s != "" && !slices.Contains(def.Options, s) {
+		return nil, fmt.Errorf("field %q must be one of %s", def.Name, strings.Join(def.Options, ", "))
+	}
+	return s, nil
+}
+
+// checkFields validates a new link's custom fields against its org's schema
+// and returns them typed. links outside an org can't carry fields at all
+func (app *App) checkFields(orgID string, in map[string]any) (map[string]any, error) {
+	var defs []FieldDef
+	if orgID != "" {
+		org, err := app.getOrg(orgID)
+		if err != nil {
+			return nil, err
+		}
+		if org != nil {
+			defs = org.Fields
+		}
+	}
+	if len(defs) == 0 {
+		switch {
+		case len(in) == 0:
+			return nil, nil
+		case orgID == "":
+			return nil, fmt.Errorf("custom fields need an account that belongs to an org")
+		}
+		return nil, fmt.Errorf("no custom fields are defined for your org")
+	}
+
+	out := map[string]any{}
+	for name, v := range in {
+		i := slices.IndexFunc(defs, func(d FieldDef) bool { return d.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if v == nil {
+			continue
+		}
+		typed, err := defs[i].coerce(v)
+		if err != nil {
+			return nil, err
+		}
+		if typed != "" {
+			out[name] = typed
+		}
+	}
+	for _, def := range defs {
+		if _, ok := out[def.Name]; def.Required && !ok {
+			return nil, fmt.Errorf("field %q is required", def.Name)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// fieldText is how a field value shows up in filters and csv
+func fieldText(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return fmt.Sprint(v)
+}
+
+// fieldParams pulls field.<name>=value pairs out of a query string - used for
+// filtering listings and for passing fields to the batch endpoint
+func fieldParams(q url.Values) map[string]string {
+	var out map[string]string
+	for k, v := range q {
+		name, ok := strings.CutPrefix(k, "field.")
+		if !ok || name == "" {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[name] = strings.TrimSpace(v[0])
+	}
+	return out
+}
+
+// queryFields is fieldParams for creating links - values stay strings and
+// checkFields coerces them to the schema's types
+func queryFields(q url.Values) map[string]any {
+	var out map[string]any
+	for name, v := range fieldParams(q) {
+		if out == nil {
+			out = map[string]any{}
+		}
+		out[name] = v
+	}
+	return out
+}
+
+// matchFields reports whether a link has every filtered field set to the given value
+func matchFields(u *URL, filters map[string]string) bool {
+	for name, want := range filters {
+		if fieldText(u.Fields[name]) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// handles GET /api/orgs/{orgID}/fields - any member can read the schema they have to fill in
+func (app *App) getFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["orgID"]
+	identity := identityFromContext(r.Context())
+
+	var org *Org
+	if identity.OrgID() == orgID || identity.managesOrg(orgID) {
+		var err error
+		if org, err = app.getOrg(orgID); err != nil {
+			log.Printf("org lookup error: %v", err)
+		}
+	}
+	if org == nil {
+		writeError(w, http.StatusNotFound, errorNotFound, "org not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append([]FieldDef{}, org.Fields...))
+}
+
+// handles PUT /api/orgs/{orgID}/fields - replaces the whole schema. existing links
+// keep whatever they were created with, the schema only applies to new ones
+func (app *App) putFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var defs []FieldDef
+	if err := decodeJSON(r, &defs); err != nil {
+		msg := bodyErrorMessage(err)
+		if msg == "invalid json" {
+			msg = "invalid json, expected a list of fields"
+		}
+		writeError(w, bodyErrorStatus(err), bodyErrorCode(err), msg)
+		return
+	}
+	if err := validateSchema(defs); err != nil {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, err.Error())
+		return
+	}
+
+	_, err := app.updateOrg(org.ID, func(o *Org) error {
+		o.Fields = defs
+		return nil
+	})
+	if err != nil {
+		log.Printf("field schema save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save fields")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append([]FieldDef{}, defs...))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// feature flags switch optional behaviors on and off at runtime, for everyone
+// or a percentage of subjects - handy for trying something on part of the
+// traffic of a shared deployment first. they're kept in the flags bucket and
+// mirrored in memory like the blocklist. a flag nobody has set is at its default
+
+const (
+	flagCaptcha      = "captcha"       // risk checks on CAPTCHA_ROUTES, rolled out by client ip
+	flagAnalytics    = "analytics"     // click counting on redirects, rolled out by short code
+	flagAbuseScoring = "abuse_scoring" // scoring new links for the review queue, rolled out by client ip
+)
+
+// knownFlags is every flag there is, with what it does when nobody has set it
+var knownFlags = map[string]struct {
+	Default     bool
+	Description string
+}{
+	flagCaptcha:      {true, "captcha and risk checks on the routes in CAPTCHA_ROUTES"},
+	flagAnalytics:    {true, "counting clicks and recording their breakdowns"},
+	flagAbuseScoring: {true, "scoring new links and holding risky ones for review"},
+}
+
+// Flag is a flag's stored state, keyed by name in the flags bucket
+type Flag struct {
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+	Rollout   int       `json:"rollout"` // percent of subjects it's on for when enabled
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+}
+
+// on says whether the flag applies to subject. the same subject always lands
+// in the same bucket, so raising the rollout only ever adds subjects
+func (f *Flag) on(subject string) bool {
+	if !f.Enabled {
+		return false
+	}
+	if f.Rollout >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(f.Name + ":" + subject))
+	return int(h.Sum32()%100) < f.Rollout
+}
+
+// flagSet is the in-memory copy of the flags bucket
+type flagSet struct {
+	mu    sync.RWMutex
+	flags map[string]*Flag
+}
+
+func (s *flagSet) get(name string) *Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.flags[name]
+}
+
+func (s *flagSet) set(flags map[string]*Flag) {
+	s.mu.Lock()
+	s.flags = flags
+	s.mu.Unlock()
+}
+
+// flagOn is the check call sites use
+func (app *App) flagOn(name, subject string) bool {
+	if f := app.Flags.get(name); f != nil {
+		return f.on(subject)
+	}
+	return knownFlags[name].Default
+}
+
+// loadFlags reads the flags bucket into memory
+func (app *App) loadFlags() error {
+	flags := map[string]*Flag{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("flags"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var f Flag
+			if err := json.Unmarshal(v, &f); err != nil {
+				log.Printf("skipping bad flag %q: %v", k, err)
+				return nil
+			}
+			flags[f.Name] = &f
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	app.Flags.set(flags)
+	return nil
+}
+
+// FlagStatus is a flag as GET /api/admin/flags shows it
+type FlagStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Default     bool       `json:"default"`
+	Enabled     bool       `json:"enabled"`
+	Rollout     int        `json:"rollout"`
+	Set         bool       `json:"set"` // false = running on the default
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+}
+
+// handles GET /api/admin/flags
+func (app *App) listFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := []FlagStatus{}
+	for name, known := range knownFlags {
+		status := FlagStatus{Name: name, Description: known.Description, Default: known.Default, Enabled: known.Default, Rollout: 100}
+		if f := app.Flags.get(name); f != nil {
+			status.Enabled, status.Rollout, status.Set = f.Enabled, f.Rollout, true
+			status.UpdatedAt, status.UpdatedBy = &f.UpdatedAt, f.UpdatedBy
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// handles PUT /api/admin/flags/{name} - takes effect immediately
+func (app *App) setFlagHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok := knownFlags[name]; !ok {
+		writeError(w, http.StatusNotFound, errorNotFound, fmt.Sprintf("unknown flag %q", name))
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+		Rollout *int  `json:"rollout"` // defaults to 100
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if req.Enabled == nil {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "enabled", "enabled is required")
+		return
+	}
+	rollout := 100
+	if req.Rollout != nil {
+		rollout = *req.Rollout
+	}
+	if rollout < 0 || rollout > 100 {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "rollout", "rollout must be between 0 and 100")
+		return
+	}
+
+	flag := Flag{Name: name, Enabled: *req.Enabled, Rollout: rollout, UpdatedAt: time.Now(), UpdatedBy: requestActor(r).Actor}
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("flags"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(flag)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(name), data)
+	})
+	if err == nil {
+		err = app.loadFlags()
+	}
+	if err != nil {
+		log.Printf("flag update error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	log.Printf("flag %s set to enabled=%t rollout=%d%% by %s", name, flag.Enabled, flag.Rollout, flag.UpdatedBy)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+// handles DELETE /api/admin/flags/{name} - back to the default
+func (app *App) resetFlagHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, ok := knownFlags[name]; !ok {
+		writeError(w, http.StatusNotFound, errorNotFound, fmt.Sprintf("unknown flag %q", name))
+		return
+	}
+
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("flags"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(name))
+	})
+	if err == nil {
+		err = app.loadFlags()
+	}
+	if err != nil {
+		log.Printf("flag reset error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	log.Printf("flag %s reset to its default by %s", name, requestActor(r).Actor)
+	w.WriteHeader(http.StatusNoContent)
+}
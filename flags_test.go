@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAnalyticsFlag(t *testing.T) {
+	ta := newTestApp(t, nil)
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/flagged"}, http.StatusOK).ShortCode
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	browser := []string{"User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"}
+
+	clicks := func() int {
+		ta.Clicks.Wait()
+		u, err := ta.getURL(code)
+		if err != nil || u == nil {
+			t.Fatalf("get link: %v", err)
+		}
+		return u.ClickCount
+	}
+
+	ta.do(http.MethodGet, "/"+code, nil, browser...)
+	if got := clicks(); got != 1 {
+		t.Fatalf("clicks with the default flag = %d, want 1", got)
+	}
+
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/flags/analytics", `{"enabled":false}`, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("set flag: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(http.MethodGet, "/"+code, nil, browser...); rec.Code != http.StatusMovedPermanently {
+		t.Fatalf("redirect with analytics off: status %d", rec.Code)
+	}
+	if got := clicks(); got != 1 {
+		t.Fatalf("clicks with analytics off = %d, want still 1", got)
+	}
+
+	if rec := ta.do(http.MethodDelete, "/api/v1/admin/flags/analytics", nil, admin...); rec.Code != http.StatusNoContent {
+		t.Fatalf("reset flag: status %d", rec.Code)
+	}
+	ta.do(http.MethodGet, "/"+code, nil, browser...)
+	if got := clicks(); got != 2 {
+		t.Fatalf("clicks after reset = %d, want 2", got)
+	}
+}
+
+func TestFlagValidation(t *testing.T) {
+	ta := newTestApp(t, nil)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	tests := []struct {
+		path, body string
+		status     int
+	}{
+		{"/api/v1/admin/flags/nope", `{"enabled":true}`, http.StatusNotFound},
+		{"/api/v1/admin/flags/captcha", `{}`, http.StatusBadRequest},
+		{"/api/v1/admin/flags/captcha", `{"enabled":true,"rollout":101}`, http.StatusBadRequest},
+		{"/api/v1/admin/flags/captcha", `{"enabled":true,"rollout":25}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := ta.do(http.MethodPut, tt.path, tt.body, admin...); rec.Code != tt.status {
+			t.Errorf("PUT %s %s: status %d, want %d", tt.path, tt.body, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestFlagRollout(t *testing.T) {
+	f := &Flag{Name: "captcha", Enabled: true, Rollout: 30}
+	on := 0
+	for i := 0; i < 1000; i++ {
+		subject := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		if f.on(subject) != f.on(subject) {
+			t.Fatalf("%s flipped between calls", subject)
+		}
+		if f.on(subject) {
+			on++
+		}
+	}
+	if on < 230 || on > 370 {
+		t.Errorf("30%% rollout hit %d of 1000 subjects", on)
+	}
+
+	f.Enabled = false
+	if f.on("10.0.0.1") {
+		t.Error("disabled flag is on")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// click fraud detection. every tracked click is counted per link per minute,
+// per client ip and per network (/24 for ipv4, /48 for ipv6 - a cheap stand-in
+// for an asn). clicks past FRAUD_IP_CLICKS from one ip, or FRAUD_NETWORK_CLICKS
+// from one network, within the minute are suspect: they go in
+// SuspectClickCount instead of ClickCount and stay out of the breakdowns, like
+// bot clicks do. a minute far above the link's usual rate is a spike - those
+// clicks still count, but like the others it raises a link.click_anomaly
+// alert to integrations and FRAUD_ALERT_EMAIL, at most once per
+// FRAUD_ALERT_COOLDOWN per link. it all lives in memory, per process
+
+const (
+	fraudBaselineWeight = 0.1 // how fast a link's usual rate follows its traffic
+	fraudIdleAfter      = time.Hour
+)
+
+// fraudWatch is the per-link traffic the detector has seen
+type fraudWatch struct {
+	mu        sync.Mutex
+	links     map[string]*linkTraffic
+	lastPrune time.Time
+}
+
+type linkTraffic struct {
+	minute    time.Time // the window counts are for
+	count     int
+	ips       map[string]int
+	networks  map[string]int
+	baseline  float64 // clicks per minute, moving average of the windows before
+	alertedAt time.Time
+}
+
+// fraudVerdict is what the detector makes of one click
+type fraudVerdict struct {
+	suspect bool     // counted apart from real clicks
+	alert   []string // reasons to alert about, empty = nothing new to say
+}
+
+func newFraudWatch() *fraudWatch {
+	return &fraudWatch{links: map[string]*linkTraffic{}}
+}
+
+// clickNetwork is the network an address is counted under
+func clickNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// observe counts a click and judges it
+func (f *fraudWatch) observe(cfg *Config, shortCode, ip string, now time.Time) fraudVerdict {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	minute := now.Truncate(time.Minute)
+	if now.Sub(f.lastPrune) > 10*time.Minute {
+		for code, t := range f.links {
+			if now.Sub(t.minute) > fraudIdleAfter {
+				delete(f.links, code)
+			}
+		}
+		f.lastPrune = now
+	}
+
+	t := f.links[shortCode]
+	if t == nil {
+		t = &linkTraffic{minute: minute, ips: map[string]int{}, networks: map[string]int{}}
+		f.links[shortCode] = t
+	}
+	if minute.After(t.minute) {
+		// the window that just closed, then a quiet minute for each one skipped
+		t.baseline += fraudBaselineWeight * (float64(t.count) - t.baseline)
+		if skipped := int(minute.Sub(t.minute)/time.Minute) - 1; skipped > 0 {
+			t.baseline *= math.Pow(1-fraudBaselineWeight, float64(min(skipped, 120)))
+		}
+		t.minute, t.count = minute, 0
+		clear(t.ips)
+		clear(t.networks)
+	}
+	t.count++
+	t.ips[ip]++
+	network := clickNetwork(ip)
+	t.networks[network]++
+
+	var v fraudVerdict
+	var reasons []string
+	if cfg.FraudIPClicks > 0 && t.ips[ip] > cfg.FraudIPClicks {
+		v.suspect = true
+		reasons = append(reasons, fmt.Sprintf("%d clicks from %s in a minute", t.ips[ip], ip))
+	} else if cfg.FraudNetworkClicks > 0 && t.networks[network] > cfg.FraudNetworkClicks {
+		v.suspect = true
+		reasons = append(reasons, fmt.Sprintf("%d clicks from %s in a minute", t.networks[network], network))
+	}
+	if cfg.FraudSpikeFactor > 0 && t.count > cfg.FraudSpikeMinClicks && float64(t.count) > cfg.FraudSpikeFactor*t.baseline {
+		reasons = append(reasons, fmt.Sprintf("%d clicks this minute, usually %.1f", t.count, t.baseline))
+	}
+	if len(reasons) > 0 && now.Sub(t.alertedAt) >= cfg.FraudAlertCooldown {
+		t.alertedAt = now
+		v.alert = reasons
+	}
+	return v
+}
+
+// judgeClick runs the detector on a click, when it's on
+func (app *App) judgeClick(shortCode string, click *Click) []string {
+	if !app.Config.FraudDetection || click.Bot {
+		return nil
+	}
+	v := app.Fraud.observe(app.Config, shortCode, click.IP, app.now())
+	click.Suspect = v.suspect
+	if v.suspect {
+		app.Metrics.incr("clicks.suspect")
+	}
+	return v.alert
+}
+
+// clickAnomaly tells integrations and FRAUD_ALERT_EMAIL about odd traffic on
+// a link - runs in the background
+func (app *App) clickAnomaly(shortCode string, reasons []string) {
+	u, err := app.getURL(shortCode)
+	if err != nil || u == nil {
+		return
+	}
+	text := fmt.Sprintf("Unusual traffic on %s (-> %s): %s", app.linkShortURL(u), u.OriginalURL, strings.Join(reasons, "; "))
+	log.Print(text)
+	app.notify(u.OrgID, Event{Type: eventClickAnomaly, Time: time.Now(), Link: u, Text: text})
+
+	if len(app.Config.FraudAlertEmail) == 0 || !app.mailConfigured() {
+		return
+	}
+	for _, to := range app.Config.FraudAlertEmail {
+		if err := app.sendMail(to, "Unusual traffic on "+app.linkShortURL(u), text+"\n"); err != nil {
+			log.Printf("fraud alert mail to %s failed: %v", to, err)
+		}
+	}
+}
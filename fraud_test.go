@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFraudWatch(t *testing.T) {
+	cfg := &Config{FraudIPClicks: 3, FraudNetworkClicks: 5, FraudSpikeFactor: 2, FraudSpikeMinClicks: 4, FraudAlertCooldown: time.Hour}
+	f := newFraudWatch()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// a steady two clicks a minute builds the baseline
+	for m := range 30 {
+		for _, ip := range []string{"198.51.100.1", "203.0.113.9"} {
+			if v := f.observe(cfg, "aB3xY7zQ", ip, now.Add(time.Duration(m)*time.Minute)); v.suspect || v.alert != nil {
+				t.Fatalf("minute %d: normal traffic judged %+v", m, v)
+			}
+		}
+	}
+
+	now = now.Add(30 * time.Minute)
+	var verdicts []fraudVerdict
+	for i := range 6 {
+		verdicts = append(verdicts, f.observe(cfg, "aB3xY7zQ", []string{"192.0.2.1", "192.0.2.2"}[i%2], now))
+	}
+	// 192.0.2.1 and .2 share a /24: the 6th click from it is over the network limit
+	if verdicts[4].suspect || !verdicts[5].suspect {
+		t.Errorf("network limit: %+v", verdicts)
+	}
+	// the spike alerts on the 5th click, and the cooldown keeps the 6th quiet
+	if verdicts[4].alert == nil || verdicts[5].alert != nil {
+		t.Errorf("spike alert: %+v", verdicts)
+	}
+	if v := f.observe(cfg, "other123", "192.0.2.1", now); v.suspect {
+		t.Error("counts leaked into another link")
+	}
+}
+
+func TestSuspectClicks(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"FRAUD_DETECTION": "true", "FRAUD_IP_CLICKS": "2"})
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/ad"}, http.StatusOK).ShortCode
+	for range 5 {
+		ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0")
+	}
+	ta.Clicks.Wait()
+	u, err := ta.getURL(code)
+	if err != nil || u.ClickCount != 2 || u.SuspectClickCount != 3 {
+		t.Errorf("clicks %d, suspect %d (err %v), want 2 and 3", u.ClickCount, u.SuspectClickCount, err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// who made a link - lets the gc report tell people apart from integrations
+const (
+	sourceWeb     = "web" // no api key, i.e. a person using the form or curl
+	sourceAPI     = "api"
+	sourceUnknown = "unknown" // links from before we recorded this
+)
+
+// GCReport summarizes codes that were created but never clicked
+type GCReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	OlderThan   string         `json:"older_than"`
+	Total       int            `json:"total"`
+	BySource    map[string]int `json:"by_source"`
+	ByAPIKey    map[string]int `json:"by_api_key"` // api key id -> count, finds the integration gone wrong
+	Candidates  []string       `json:"candidates"` // short codes, capped at maxGCCandidates
+	Reclaimed   int            `json:"reclaimed,omitempty"`
+}
+
+const maxGCCandidates = 1000
+
+func linkSource(u *URL) string {
+	if u.CreatedVia == "" {
+		return sourceUnknown
+	}
+	return u.CreatedVia
+}
+
+// neverClicked walks every link and collects the ones older than minAge with no
+// human clicks. source filters to web/api/unknown when set
+func (app *App) neverClicked(minAge time.Duration, source string) (*GCReport, []string, error) {
+	cutoff := time.Now().Add(-minAge)
+	report := &GCReport{
+		GeneratedAt: time.Now(),
+		OlderThan:   minAge.String(),
+		BySource:    map[string]int{},
+		ByAPIKey:    map[string]int{},
+		Candidates:  []string{},
+	}
+
+	var codes []string
+	after := ""
+	for {
+		links, next, err := app.listURLs(after, 500, func(u *URL) bool {
+			return u.ClickCount == 0 && u.TrashedAt == nil && u.CreatedAt.Before(cutoff) && (source == "" || linkSource(u) == source)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, link := range links {
+			report.Total++
+			report.BySource[linkSource(&link)]++
+			if link.APIKeyID != "" {
+				report.ByAPIKey[link.APIKeyID]++
+			}
+			if len(report.Candidates) < maxGCCandidates {
+				report.Candidates = append(report.Candidates, link.ShortCode)
+			}
+			codes = append(codes, link.ShortCode)
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	return report, codes, nil
+}
+
+// reclaim deletes the given codes so they (and their destinations) can be reused
+func (app *App) reclaim(codes []string) int {
+	reclaimed := 0
+	for _, code := range codes {
+		if err := app.deleteURL(code, AuditEntry{Actor: "system:gc"}, "reclaim"); err != nil {
+			log.Printf("gc reclaim error for %s: %v", code, err)
+			continue
+		}
+		reclaimed++
+	}
+	return reclaimed
+}
+
+// gcReport produces the never-clicked report, reclaiming if configured
+func (app *App) gcReport() {
+	report, codes, err := app.neverClicked(app.Config.GCMinAge, "")
+	if err != nil {
+		log.Printf("gc report error: %v", err)
+		return
+	}
+	if app.Config.GCReclaim {
+		report.Reclaimed = app.reclaim(codes)
+	}
+	log.Printf("gc report: %d never-clicked links older than %s (web %d, api %d, unknown %d), %d reclaimed",
+		report.Total, report.OlderThan, report.BySource[sourceWeb], report.BySource[sourceAPI],
+		report.BySource[sourceUnknown], report.Reclaimed)
+}
+
+// gcParams reads ?days= and ?source= shared by the report and reclaim endpoints
+func (app *App) gcParams(r *http.Request) (time.Duration, string, bool) {
+	minAge := app.Config.GCMinAge
+	if days := r.URL.Query().Get("days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, "", false
+		}
+		minAge = time.Duration(n) * 24 * time.Hour
+	}
+
+	source := r.URL.Query().Get("source")
+	switch source {
+	case "", sourceWeb, sourceAPI, sourceUnknown:
+		return minAge, source, true
+	}
+	return 0, "", false
+}
+
+// handles GET /api/admin/gc/report and POST /api/admin/gc/reclaim
+func (app *App) gcHandler(w http.ResponseWriter, r *http.Request) {
+	minAge, source, ok := app.gcParams(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, "days must be a positive number and source one of web, api, unknown")
+		return
+	}
+
+	report, codes, err := app.neverClicked(minAge, source)
+	if err != nil {
+		log.Printf("gc report error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		report.Reclaimed = app.reclaim(codes)
+		log.Printf("gc: reclaimed %d never-clicked links older than %s", report.Reclaimed, report.OlderThan)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// per-link country restrictions. a link can carry an allow list or a block
+// list of iso 3166 country codes, matched against the visitor's country from
+// COUNTRY_HEADER. visitors it turns away get a 451 instead of the redirect,
+// and visitors whose country isn't known only get through a block list
+
+const maxCountryRules = 250
+
+// GeoRule is who a link redirects, by country
+type GeoRule struct {
+	Allow []string `json:"allow,omitempty"` // only these countries
+	Block []string `json:"block,omitempty"` // everyone but these
+}
+
+// allows says whether a visitor from country gets the redirect. nil allows everyone
+func (g *GeoRule) allows(country string) bool {
+	switch {
+	case g == nil:
+		return true
+	case len(g.Allow) > 0:
+		return slices.Contains(g.Allow, country)
+	default:
+		return !slices.Contains(g.Block, country)
+	}
+}
+
+// validateGeoRule checks a rule before it's saved. nil or empty lists mean none
+func (app *App) validateGeoRule(g *GeoRule) (*GeoRule, error) {
+	if g == nil || (len(g.Allow) == 0 && len(g.Block) == 0) {
+		return nil, nil
+	}
+	if app.Config.CountryHeader == "" {
+		return nil, errors.New("country restrictions need COUNTRY_HEADER to be set on this server")
+	}
+	if len(g.Allow) > 0 && len(g.Block) > 0 {
+		return nil, errors.New("countries takes an allow list or a block list, not both")
+	}
+	clean := func(list []string) ([]string, error) {
+		if len(list) > maxCountryRules {
+			return nil, fmt.Errorf("at most %d countries", maxCountryRules)
+		}
+		var out []string
+		for _, c := range list {
+			c = strings.ToUpper(strings.TrimSpace(c))
+			if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+				return nil, fmt.Errorf("%q is not a two letter country code", c)
+			}
+			if !slices.Contains(out, c) {
+				out = append(out, c)
+			}
+		}
+		return out, nil
+	}
+	allow, err := clean(g.Allow)
+	if err != nil {
+		return nil, err
+	}
+	block, err := clean(g.Block)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoRule{Allow: allow, Block: block}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCountryRestrictions(t *testing.T) {
+	if _, err := newTestApp(t, nil).validateGeoRule(&GeoRule{Block: []string{"DE"}}); err == nil {
+		t.Error("country rule accepted without COUNTRY_HEADER")
+	}
+
+	ta := newTestApp(t, map[string]string{"COUNTRY_HEADER": "CF-IPCountry", "ALLOW_PRIVATE_DESTINATIONS": "true"})
+	for _, g := range []GeoRule{{Allow: []string{"US"}, Block: []string{"DE"}}, {Block: []string{"Germany"}}} {
+		if rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/bad-geo", Countries: &g}); rec.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status %d, want 400", g, rec.Code)
+		}
+	}
+
+	allow := ta.shorten(ShortenRequest{URL: "https://example.com/us-only", Countries: &GeoRule{Allow: []string{"us", "ca"}}}, http.StatusOK).ShortCode
+	block := ta.shorten(ShortenRequest{URL: "https://example.com/not-de", Countries: &GeoRule{Block: []string{"DE"}}}, http.StatusOK).ShortCode
+	tests := []struct {
+		code, country string
+		status        int
+	}{
+		{allow, "US", http.StatusMovedPermanently},
+		{allow, "DE", http.StatusUnavailableForLegalReasons},
+		{allow, "", http.StatusUnavailableForLegalReasons}, // unknown
+		{block, "DE", http.StatusUnavailableForLegalReasons},
+		{block, "FR", http.StatusMovedPermanently},
+		{block, "", http.StatusMovedPermanently},
+	}
+	for range 2 { // the second round comes from the redirect cache
+		for _, tt := range tests {
+			if rec := ta.do(http.MethodGet, "/"+tt.code, nil, "CF-IPCountry", tt.country); rec.Code != tt.status {
+				t.Errorf("%s from %q: status %d, want %d", tt.code, tt.country, rec.Code, tt.status)
+			}
+		}
+	}
+
+	// lifting the restriction takes effect right away
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	if rec := ta.do(http.MethodPatch, "/api/v1/links/"+allow, map[string]any{"countries": GeoRule{}}, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("patch: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodGet, "/"+allow, nil, "CF-IPCountry", "DE"); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("after clearing: status %d", rec.Code)
+	}
+}
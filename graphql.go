@@ -0,0 +1,777 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// /api/graphql lets dashboards ask for exactly the links, stats, tags and
+// campaigns they show in one round trip. it's read only and sees what the
+// rest api would show the caller: their own links, or everything for admins.
+// the query language bits live in graphqlparse.go. there's no introspection,
+// GET /api/graphql/schema serves the schema below for tooling
+
+const (
+	defaultGraphQLPage = 50
+	maxGraphQLPage     = 200
+)
+
+const graphqlSchema = `type Query {
+  link(code: String!): Link
+  links(first: Int = 50, after: String, search: String, tag: String, campaign: String, domain: String, state: String = "active"): LinkConnection!
+  tags: [TagCount!]!
+  campaigns: [Campaign!]!
+  campaign(name: String!): Campaign
+}
+
+type LinkConnection {
+  nodes: [Link!]!
+  pageInfo: PageInfo!
+}
+
+type PageInfo {
+  hasNextPage: Boolean!
+  endCursor: String
+}
+
+type Link {
+  shortCode: String!
+  shortUrl: String!
+  originalUrl: String!
+  title: String
+  description: String
+  tags: [String!]!
+  campaign: String
+  domain: String
+  namespace: String
+  createdAt: String!
+  expiresAt: String
+  clickCount: Int!
+  botClickCount: Int!
+  suspectClickCount: Int!
+  health: String
+  trashed: Boolean!
+  private: Boolean!
+  interstitial: Boolean!
+  stats(days: Int): LinkStats!
+}
+
+type LinkStats {
+  total: Int!
+  referrers: [StatCount!]!
+  browsers: [StatCount!]!
+  os: [StatCount!]!
+  countries: [StatCount!]!
+  daily: [DayCount!]!
+}
+
+type StatCount {
+  key: String!
+  count: Int!
+}
+
+type DayCount {
+  day: String!
+  count: Int!
+}
+
+type TagCount {
+  name: String!
+  links: Int!
+  clicks: Int!
+}
+
+type Campaign {
+  name: String!
+  linkCount: Int!
+  clicks: Int!
+  botClicks: Int!
+  suspectClicks: Int!
+  firstCreated: String!
+  lastCreated: String!
+  links(first: Int = 50, after: String): LinkConnection!
+  daily(days: Int = 30): [DayCount!]!
+}
+`
+
+// gqlType is an object type: its fields and how to get each one from the go
+// value standing for it
+type gqlType struct {
+	name   string
+	fields map[string]*gqlField
+}
+
+// gqlField resolves one field. typ is the object type it returns (a list of
+// them when the resolver returns []any), nil for scalars
+type gqlField struct {
+	typ     *gqlType
+	args    []string
+	resolve func(e *gqlExec, src any, args map[string]any) (any, error)
+}
+
+// gqlScalar is a field read straight off the source value
+func gqlScalar[T any](get func(T) any) *gqlField {
+	return &gqlField{resolve: func(_ *gqlExec, src any, _ map[string]any) (any, error) {
+		return get(src.(T)), nil
+	}}
+}
+
+// gqlItems turns a slice into list items for an object field
+func gqlItems[T any](s []T) []any {
+	items := make([]any, len(s))
+	for i := range s {
+		items[i] = &s[i]
+	}
+	return items
+}
+
+// gqlConnection is a page of links
+type gqlConnection struct {
+	links []URL
+	next  string
+}
+
+// TagCount is how much a tag is used
+type TagCount struct {
+	Name   string
+	Links  int
+	Clicks int
+}
+
+var gqlStatCountType = &gqlType{name: "StatCount", fields: map[string]*gqlField{
+	"key":   gqlScalar(func(s *StatCount) any { return s.Key }),
+	"count": gqlScalar(func(s *StatCount) any { return s.Count }),
+}}
+
+var gqlDayCountType = &gqlType{name: "DayCount", fields: map[string]*gqlField{
+	"day":   gqlScalar(func(d *DayCount) any { return d.Day.Format("2006-01-02") }),
+	"count": gqlScalar(func(d *DayCount) any { return d.Count }),
+}}
+
+var gqlLinkStatsType = &gqlType{name: "LinkStats", fields: map[string]*gqlField{
+	"total":     gqlScalar(func(s *LinkStatsResponse) any { return s.Total }),
+	"referrers": {typ: gqlStatCountType, resolve: gqlStatRows(func(s *LinkStatsResponse) []StatCount { return s.Referrers })},
+	"browsers":  {typ: gqlStatCountType, resolve: gqlStatRows(func(s *LinkStatsResponse) []StatCount { return s.Browsers })},
+	"os":        {typ: gqlStatCountType, resolve: gqlStatRows(func(s *LinkStatsResponse) []StatCount { return s.OS })},
+	"countries": {typ: gqlStatCountType, resolve: gqlStatRows(func(s *LinkStatsResponse) []StatCount { return s.Countries })},
+	"daily": {typ: gqlDayCountType, resolve: func(_ *gqlExec, src any, _ map[string]any) (any, error) {
+		return gqlItems(src.(*LinkStatsResponse).Daily), nil
+	}},
+}}
+
+func gqlStatRows(get func(*LinkStatsResponse) []StatCount) func(*gqlExec, any, map[string]any) (any, error) {
+	return func(_ *gqlExec, src any, _ map[string]any) (any, error) {
+		return gqlItems(get(src.(*LinkStatsResponse))), nil
+	}
+}
+
+var gqlLinkType = &gqlType{name: "Link", fields: map[string]*gqlField{
+	"shortCode":         gqlScalar(func(u *URL) any { return u.ShortCode }),
+	"originalUrl":       gqlScalar(func(u *URL) any { return u.OriginalURL }),
+	"title":             gqlScalar(func(u *URL) any { return gqlNullable(u.Title) }),
+	"description":       gqlScalar(func(u *URL) any { return gqlNullable(u.Description) }),
+	"tags":              gqlScalar(func(u *URL) any { return append([]string{}, u.Tags...) }),
+	"campaign":          gqlScalar(func(u *URL) any { return gqlNullable(u.Campaign) }),
+	"domain":            gqlScalar(func(u *URL) any { return gqlNullable(u.Domain) }),
+	"namespace":         gqlScalar(func(u *URL) any { return gqlNullable(u.Namespace) }),
+	"createdAt":         gqlScalar(func(u *URL) any { return u.CreatedAt }),
+	"expiresAt":         gqlScalar(func(u *URL) any { return u.ExpiresAt }),
+	"clickCount":        gqlScalar(func(u *URL) any { return u.ClickCount }),
+	"botClickCount":     gqlScalar(func(u *URL) any { return u.BotClickCount }),
+	"suspectClickCount": gqlScalar(func(u *URL) any { return u.SuspectClickCount }),
+	"health":            gqlScalar(func(u *URL) any { return gqlNullable(u.Health) }),
+	"trashed":           gqlScalar(func(u *URL) any { return u.TrashedAt != nil }),
+	"private":           gqlScalar(func(u *URL) any { return u.Private }),
+	"interstitial":      gqlScalar(func(u *URL) any { return u.Interstitial }),
+	"shortUrl": {resolve: func(e *gqlExec, src any, _ map[string]any) (any, error) {
+		return e.app.linkShortURL(src.(*URL)), nil
+	}},
+	"stats": {typ: gqlLinkStatsType, args: []string{"days"}, resolve: func(e *gqlExec, src any, args map[string]any) (any, error) {
+		days, err := gqlIntArg(args, "days", 0)
+		if err != nil {
+			return nil, err
+		}
+		if days < 0 || days > maxStatsDays {
+			return nil, fmt.Errorf("days must be between 1 and %d", maxStatsDays)
+		}
+		dimensions := []string{"referrers", "browsers", "os"}
+		if e.app.Config.CountryHeader != "" {
+			dimensions = append(dimensions, "countries")
+		}
+		stats, err := e.app.linkStats(src.(*URL).ShortCode, dimensions, days)
+		if err != nil {
+			log.Printf("graphql stats error for %s: %v", src.(*URL).ShortCode, err)
+			return nil, errors.New("server error")
+		}
+		return &stats, nil
+	}},
+}}
+
+var gqlPageInfoType = &gqlType{name: "PageInfo", fields: map[string]*gqlField{
+	"hasNextPage": gqlScalar(func(c *gqlConnection) any { return c.next != "" }),
+	"endCursor":   gqlScalar(func(c *gqlConnection) any { return gqlNullable(c.next) }),
+}}
+
+var gqlConnectionType = &gqlType{name: "LinkConnection", fields: map[string]*gqlField{
+	"nodes": {typ: gqlLinkType, resolve: func(_ *gqlExec, src any, _ map[string]any) (any, error) {
+		return gqlItems(src.(*gqlConnection).links), nil
+	}},
+	"pageInfo": {typ: gqlPageInfoType, resolve: func(_ *gqlExec, src any, _ map[string]any) (any, error) {
+		return src, nil
+	}},
+}}
+
+var gqlTagCountType = &gqlType{name: "TagCount", fields: map[string]*gqlField{
+	"name":   gqlScalar(func(t *TagCount) any { return t.Name }),
+	"links":  gqlScalar(func(t *TagCount) any { return t.Links }),
+	"clicks": gqlScalar(func(t *TagCount) any { return t.Clicks }),
+}}
+
+var gqlCampaignType = &gqlType{name: "Campaign", fields: map[string]*gqlField{
+	"name":          gqlScalar(func(c *CampaignSummary) any { return c.Name }),
+	"linkCount":     gqlScalar(func(c *CampaignSummary) any { return c.Links }),
+	"clicks":        gqlScalar(func(c *CampaignSummary) any { return c.Clicks }),
+	"botClicks":     gqlScalar(func(c *CampaignSummary) any { return c.BotClicks }),
+	"suspectClicks": gqlScalar(func(c *CampaignSummary) any { return c.SuspectClicks }),
+	"firstCreated":  gqlScalar(func(c *CampaignSummary) any { return c.FirstCreated }),
+	"lastCreated":   gqlScalar(func(c *CampaignSummary) any { return c.LastCreated }),
+	"links": {typ: gqlConnectionType, args: []string{"first", "after"}, resolve: func(e *gqlExec, src any, args map[string]any) (any, error) {
+		name := src.(*CampaignSummary).Name
+		return e.links(args, func(u *URL) bool { return u.Campaign == name && u.TrashedAt == nil })
+	}},
+	"daily": {typ: gqlDayCountType, args: []string{"days"}, resolve: func(e *gqlExec, src any, args map[string]any) (any, error) {
+		days, err := gqlIntArg(args, "days", campaignDays)
+		if err != nil {
+			return nil, err
+		}
+		if days <= 0 || days > maxStatsDays {
+			return nil, fmt.Errorf("days must be between 1 and %d", maxStatsDays)
+		}
+		name := src.(*CampaignSummary).Name
+		groups, err := e.app.campaignLinks(e.id, name)
+		var total []DayCount
+		for i := 0; err == nil && i < len(groups[name]); i++ {
+			var daily []DayCount
+			daily, err = e.app.dailyClicks(groups[name][i].ShortCode, days)
+			if total == nil {
+				total = daily
+				continue
+			}
+			for d := range daily {
+				total[d].Count += daily[d].Count
+			}
+		}
+		if err != nil {
+			log.Printf("graphql campaign error for %s: %v", name, err)
+			return nil, errors.New("server error")
+		}
+		return gqlItems(total), nil
+	}},
+}}
+
+var gqlQueryType = &gqlType{name: "Query", fields: map[string]*gqlField{
+	"link": {typ: gqlLinkType, args: []string{"code"}, resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+		code, err := gqlStringArg(args, "code")
+		if err != nil {
+			return nil, err
+		}
+		u, err := e.app.getURL(e.app.foldCode(code))
+		if err != nil {
+			log.Printf("graphql link error for %s: %v", code, err)
+			return nil, errors.New("server error")
+		}
+		// other people's links look the same as missing ones
+		if u == nil || !e.id.owns(u) {
+			return nil, nil
+		}
+		return u, nil
+	}},
+	"links": {typ: gqlConnectionType, args: []string{"first", "after", "search", "tag", "campaign", "domain", "state"}, resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+		var filters [5]string
+		for i, name := range []string{"search", "tag", "campaign", "domain", "state"} {
+			v, err := gqlStringArg(args, name)
+			if err != nil {
+				return nil, err
+			}
+			filters[i] = v
+		}
+		search, tag := strings.ToLower(strings.TrimSpace(filters[0])), strings.ToLower(strings.TrimSpace(filters[1]))
+		campaign, domain, state := filters[2], filters[3], filters[4]
+		switch state {
+		case "":
+			state = stateActive
+		case stateActive, stateTrashed, stateAll:
+		default:
+			return nil, errors.New("state must be active, trashed or all")
+		}
+		return e.links(args, func(u *URL) bool {
+			return !((state == stateActive && u.TrashedAt != nil) || (state == stateTrashed && u.TrashedAt == nil)) &&
+				(campaign == "" || u.Campaign == campaign) &&
+				(domain == "" || strings.EqualFold(u.Domain, domain)) &&
+				(search == "" || matchesSearch(u, search)) &&
+				(tag == "" || hasTag(u.Tags, tag))
+		})
+	}},
+	"tags": {typ: gqlTagCountType, resolve: func(e *gqlExec, _ any, _ map[string]any) (any, error) {
+		links, _, err := e.app.listURLs("", -1, func(u *URL) bool { return len(u.Tags) > 0 && u.TrashedAt == nil && e.id.owns(u) })
+		if err != nil {
+			log.Printf("graphql tags error: %v", err)
+			return nil, errors.New("server error")
+		}
+		counts := map[string]*TagCount{}
+		for _, u := range links {
+			for _, tag := range u.Tags {
+				if counts[tag] == nil {
+					counts[tag] = &TagCount{Name: tag}
+				}
+				counts[tag].Links++
+				counts[tag].Clicks += u.ClickCount
+			}
+		}
+		tags := make([]TagCount, 0, len(counts))
+		for _, t := range counts {
+			tags = append(tags, *t)
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			if tags[i].Links != tags[j].Links {
+				return tags[i].Links > tags[j].Links
+			}
+			return tags[i].Name < tags[j].Name
+		})
+		return gqlItems(tags), nil
+	}},
+	"campaigns": {typ: gqlCampaignType, resolve: func(e *gqlExec, _ any, _ map[string]any) (any, error) {
+		groups, err := e.app.campaignLinks(e.id, "")
+		if err != nil {
+			log.Printf("graphql campaigns error: %v", err)
+			return nil, errors.New("server error")
+		}
+		out := []CampaignSummary{}
+		for name, links := range groups {
+			summary := CampaignSummary{Name: name}
+			for i := range links {
+				summary.add(&links[i])
+			}
+			out = append(out, summary)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].LastCreated.After(out[j].LastCreated) })
+		return gqlItems(out), nil
+	}},
+	"campaign": {typ: gqlCampaignType, args: []string{"name"}, resolve: func(e *gqlExec, _ any, args map[string]any) (any, error) {
+		name, err := gqlStringArg(args, "name")
+		if err != nil {
+			return nil, err
+		}
+		groups, err := e.app.campaignLinks(e.id, name)
+		if err != nil {
+			log.Printf("graphql campaign error for %s: %v", name, err)
+			return nil, errors.New("server error")
+		}
+		if len(groups[name]) == 0 {
+			return nil, nil
+		}
+		summary := &CampaignSummary{Name: name}
+		for i := range groups[name] {
+			summary.add(&groups[name][i])
+		}
+		return summary, nil
+	}},
+}}
+
+// gqlNullable makes an empty string null
+func gqlNullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func gqlIntArg(args map[string]any, name string, def int) (int, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64: // variables come in as json numbers
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
+
+func gqlStringArg(args map[string]any, name string) (string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("argument %q must be a String", name)
+}
+
+// links is a page of the caller's links, for the fields taking first/after
+func (e *gqlExec) links(args map[string]any, match func(u *URL) bool) (any, error) {
+	first, err := gqlIntArg(args, "first", defaultGraphQLPage)
+	if err != nil {
+		return nil, err
+	}
+	if first <= 0 || first > maxGraphQLPage {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxGraphQLPage)
+	}
+	after, err := gqlStringArg(args, "after")
+	if err != nil {
+		return nil, err
+	}
+	links, next, err := e.app.listURLs(after, first, func(u *URL) bool { return e.id.owns(u) && match(u) })
+	if err != nil {
+		log.Printf("graphql links error: %v", err)
+		return nil, errors.New("server error")
+	}
+	return &gqlConnection{links: links, next: next}, nil
+}
+
+// GraphQLRequest is the body of POST /api/graphql
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+}
+
+type GraphQLError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type GraphQLResponse struct {
+	Data   gqlResult      `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// gqlResult is an object in the response, fields in the order they were asked for
+type gqlResult []gqlResultField
+
+type gqlResultField struct {
+	key   string
+	value any
+}
+
+func (r gqlResult) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// gqlExec runs one operation
+type gqlExec struct {
+	app       *App
+	id        *Identity
+	vars      map[string]any
+	fragments map[string]*gqlFragment
+	errors    []GraphQLError
+	invalid   bool // the query itself is wrong, see validate
+}
+
+func (e *gqlExec) fail(path []any, invalid bool, format string, args ...any) {
+	e.errors = append(e.errors, GraphQLError{Message: fmt.Sprintf(format, args...), Path: append([]any{}, path...)})
+	e.invalid = e.invalid || invalid
+}
+
+// value swaps variable references in an argument for what was passed
+func (e *gqlExec) value(path []any, v any) any {
+	switch v := v.(type) {
+	case gqlVar:
+		value, declared := e.vars[string(v)]
+		if !declared {
+			e.fail(path, true, "variable $%s is not defined", v)
+		}
+		return value
+	case gqlEnum:
+		return string(v)
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			out[i] = e.value(path, v[i])
+		}
+		return out
+	case map[string]any:
+		out := map[string]any{}
+		for k := range v {
+			out[k] = e.value(path, v[k])
+		}
+		return out
+	}
+	return v
+}
+
+// included applies @include and @skip
+func (e *gqlExec) included(path []any, dirs []gqlDirective) bool {
+	for _, d := range dirs {
+		cond, ok := e.value(path, d.args["if"]).(bool)
+		switch {
+		case d.name != "include" && d.name != "skip":
+			e.fail(path, true, "unknown directive @%s", d.name)
+		case !ok:
+			e.fail(path, true, "@%s needs an if: Boolean argument", d.name)
+		case (d.name == "include") != cond:
+			return false
+		}
+	}
+	return true
+}
+
+// collect flattens fragments into the fields to resolve, grouped by response key
+func (e *gqlExec) collect(t *gqlType, sels []gqlSelection, path []any, keys *[]string, fields map[string][]*gqlSelection, seen map[string]bool) {
+	for i := range sels {
+		sel := &sels[i]
+		if !e.included(path, sel.directives) {
+			continue
+		}
+		switch {
+		case sel.spread != "":
+			frag := e.fragments[sel.spread]
+			if frag == nil {
+				e.fail(path, true, "unknown fragment %q", sel.spread)
+				continue
+			}
+			// a fragment spread inside itself would never end
+			if seen[sel.spread] {
+				e.fail(path, true, "fragment %q spreads itself", sel.spread)
+				continue
+			}
+			if frag.on == t.name {
+				seen[sel.spread] = true
+				e.collect(t, frag.selections, path, keys, fields, seen)
+				delete(seen, sel.spread)
+			}
+		case sel.inline:
+			if sel.on == "" || sel.on == t.name {
+				e.collect(t, sel.selections, path, keys, fields, seen)
+			}
+		default:
+			key := sel.key()
+			if fields[key] == nil {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		}
+	}
+}
+
+// validate checks a selection set against the schema before anything runs,
+// so a wrong query fails as a whole, even where there's no data to resolve
+func (e *gqlExec) validate(t *gqlType, sels []gqlSelection, path []any) {
+	var keys []string
+	fields := map[string][]*gqlSelection{}
+	e.collect(t, sels, path, &keys, fields, map[string]bool{})
+
+	for _, key := range keys {
+		fieldPath := append(path[:len(path):len(path)], key)
+		sel := fields[key][0]
+		var subs []gqlSelection
+		for _, s := range fields[key] {
+			if s.name != sel.name {
+				e.fail(fieldPath, true, "%q is asked for as both %q and %q", key, sel.name, s.name)
+			}
+			subs = append(subs, s.selections...)
+		}
+
+		if sel.name == "__typename" {
+			if len(subs) > 0 {
+				e.fail(fieldPath, true, "field \"__typename\" is a scalar and has no fields to select")
+			}
+			continue
+		}
+		def := t.fields[sel.name]
+		if def == nil {
+			e.fail(fieldPath, true, "cannot query field %q on type %q", sel.name, t.name)
+			continue
+		}
+		for _, s := range fields[key] {
+			for name, v := range s.args {
+				if !containsString(def.args, name) {
+					e.fail(fieldPath, true, "unknown argument %q on field %q", name, sel.name)
+				}
+				e.value(fieldPath, v)
+			}
+		}
+		switch {
+		case def.typ == nil && len(subs) > 0:
+			e.fail(fieldPath, true, "field %q is a scalar and has no fields to select", sel.name)
+		case def.typ != nil && len(subs) == 0:
+			e.fail(fieldPath, true, "field %q of type %q needs a selection of fields", sel.name, def.typ.name)
+		case def.typ != nil:
+			e.validate(def.typ, subs, fieldPath)
+		}
+	}
+}
+
+// object resolves a selection set against a value of type t. the query has
+// been through validate, so everything asked for exists
+func (e *gqlExec) object(t *gqlType, src any, sels []gqlSelection, path []any) gqlResult {
+	var keys []string
+	fields := map[string][]*gqlSelection{}
+	e.collect(t, sels, path, &keys, fields, map[string]bool{})
+
+	result := make(gqlResult, 0, len(keys))
+	for _, key := range keys {
+		sel := fields[key][0]
+		fieldPath := append(path[:len(path):len(path)], key)
+		if sel.name == "__typename" {
+			result = append(result, gqlResultField{key, t.name})
+			continue
+		}
+		def := t.fields[sel.name]
+		args := map[string]any{}
+		for name, v := range sel.args {
+			args[name] = e.value(fieldPath, v)
+		}
+		// the same key asked for twice gets one answer with both selections
+		var subs []gqlSelection
+		for _, s := range fields[key] {
+			subs = append(subs, s.selections...)
+		}
+
+		value, err := def.resolve(e, src, args)
+		switch {
+		case err != nil:
+			e.fail(fieldPath, false, "%s", err)
+			result = append(result, gqlResultField{key, nil})
+		case def.typ == nil:
+			result = append(result, gqlResultField{key, value})
+		default:
+			result = append(result, gqlResultField{key, e.objects(def.typ, value, subs, fieldPath)})
+		}
+	}
+	return result
+}
+
+// objects resolves an object field's value, or each item of a list of them
+func (e *gqlExec) objects(t *gqlType, value any, subs []gqlSelection, path []any) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			out[i] = e.object(t, v[i], subs, append(path[:len(path):len(path)], i))
+		}
+		return out
+	}
+	return e.object(t, value, subs, path)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runGraphQL parses and runs a request. ok is false when the request as a
+// whole is wrong and there's no data to give
+func (app *App) runGraphQL(id *Identity, req GraphQLRequest) (resp GraphQLResponse, ok bool) {
+	fail := func(format string, args ...any) (GraphQLResponse, bool) {
+		return GraphQLResponse{Errors: []GraphQLError{{Message: fmt.Sprintf(format, args...)}}}, false
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return fail("query is required")
+	}
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	var op *gqlOperation
+	for _, o := range doc.operations {
+		if req.OperationName == "" || o.name == req.OperationName {
+			if op != nil {
+				return fail("operationName is required when the document has several operations")
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return fail("no operation named %q", req.OperationName)
+	}
+	if op.kind != "query" {
+		return fail("only queries are supported")
+	}
+
+	e := &gqlExec{app: app, id: id, vars: map[string]any{}, fragments: doc.fragments}
+	for _, def := range op.vars {
+		v, given := req.Variables[def.name]
+		if !given || v == nil {
+			v = def.def
+		}
+		if v == nil && def.nonNull {
+			return fail("variable $%s is required", def.name)
+		}
+		e.vars[def.name] = v
+	}
+
+	e.validate(gqlQueryType, op.selections, nil)
+	if e.invalid {
+		return GraphQLResponse{Errors: e.errors}, false
+	}
+	data := e.object(gqlQueryType, nil, op.selections, nil)
+	return GraphQLResponse{Data: data, Errors: e.errors}, true
+}
+
+// handles GET and POST /api/graphql
+func (app *App) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(GraphQLResponse{Errors: []GraphQLError{{Message: "variables must be a json object"}}})
+				return
+			}
+		}
+	} else if err := decodeJSON(r, &req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(bodyErrorStatus(err))
+		json.NewEncoder(w).Encode(GraphQLResponse{Errors: []GraphQLError{{Message: bodyErrorMessage(err)}}})
+		return
+	}
+
+	resp, ok := app.runGraphQL(identityFromContext(r.Context()), req)
+	app.Metrics.incr("graphql.queries")
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handles GET /api/graphql/schema
+func (app *App) graphqlSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(graphqlSchema))
+}
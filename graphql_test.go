@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// gql runs a query and returns the raw response
+func (ta *testApp) gql(query string, vars map[string]any, header ...string) (int, map[string]any) {
+	ta.t.Helper()
+	rec := ta.do(http.MethodPost, "/api/v1/graphql", GraphQLRequest{Query: query, Variables: vars}, header...)
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		ta.t.Fatalf("decode graphql response: %v: %s", err, rec.Body)
+	}
+	return rec.Code, resp
+}
+
+func TestGraphQLLinks(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	var codes []string
+	for _, path := range []string{"a", "b", "c"} {
+		req := ShortenRequest{URL: "https://example.com/" + path}
+		req.Campaign = "launch"
+		req.Tags = []string{"docs"}
+		codes = append(codes, ta.shorten(req, http.StatusOK).ShortCode)
+	}
+	ta.do(http.MethodGet, "/"+codes[0], nil, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	ta.Clicks.Wait()
+
+	if code, _ := ta.gql(`{ links { nodes { shortCode } } }`, nil); code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status %d", code)
+	}
+
+	query := `
+		query Page($first: Int!, $after: String) {
+			page: links(first: $first, after: $after) {
+				nodes { ...linkBits stats { total referrers { key count } } }
+				pageInfo { hasNextPage endCursor }
+			}
+		}
+		fragment linkBits on Link { shortCode originalUrl clickCount __typename }`
+	status, resp := ta.gql(query, map[string]any{"first": 2}, admin...)
+	if status != http.StatusOK || resp["errors"] != nil {
+		t.Fatalf("page 1: status %d: %v", status, resp)
+	}
+	page := resp["data"].(map[string]any)["page"].(map[string]any)
+	nodes := page["nodes"].([]any)
+	info := page["pageInfo"].(map[string]any)
+	if len(nodes) != 2 || info["hasNextPage"] != true {
+		t.Fatalf("page 1 = %v", page)
+	}
+	first := nodes[0].(map[string]any)
+	if first["__typename"] != "Link" || first["originalUrl"] == nil || first["stats"] == nil {
+		t.Errorf("node = %v", first)
+	}
+
+	status, resp = ta.gql(query, map[string]any{"first": 2, "after": info["endCursor"]}, admin...)
+	page = resp["data"].(map[string]any)["page"].(map[string]any)
+	if status != http.StatusOK || len(page["nodes"].([]any)) != 1 || page["pageInfo"].(map[string]any)["hasNextPage"] != false {
+		t.Errorf("page 2: status %d: %v", status, resp)
+	}
+
+	// one link, the tags and the campaign together
+	status, resp = ta.gql(`{
+		link(code: "`+codes[0]+`") { clickCount }
+		missing: link(code: "nope-nope") { clickCount }
+		tags { name links }
+		campaign(name: "launch") { linkCount clicks daily(days: 7) { count } }
+	}`, nil, admin...)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	data := resp["data"].(map[string]any)
+	if data["link"].(map[string]any)["clickCount"] != float64(1) || data["missing"] != nil {
+		t.Errorf("links = %v, %v", data["link"], data["missing"])
+	}
+	if tags := data["tags"].([]any); len(tags) != 1 || tags[0].(map[string]any)["links"] != float64(3) {
+		t.Errorf("tags = %v", tags)
+	}
+	campaign := data["campaign"].(map[string]any)
+	if campaign["linkCount"] != float64(3) || campaign["clicks"] != float64(1) || len(campaign["daily"].([]any)) != 7 {
+		t.Errorf("campaign = %v", campaign)
+	}
+
+	// fields come back in the order they were asked for
+	rec := ta.do(http.MethodGet, "/api/v1/graphql?query="+url.QueryEscape(`{ link(code: "`+codes[1]+`") { shortCode originalUrl } }`), nil, admin...)
+	if !strings.Contains(rec.Body.String(), `{"shortCode":"`+codes[1]+`","originalUrl":"https://example.com/b"}`) {
+		t.Errorf("GET = %s", rec.Body)
+	}
+}
+
+func TestGraphQLOnlyOwnLinks(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	theirs := ta.shorten(ShortenRequest{URL: "https://example.com/theirs"}, http.StatusOK).ShortCode
+
+	rec := ta.do(http.MethodPost, "/api/v1/admin/keys", map[string]string{"name": "dash"}, admin...)
+	var created CreateAPIKeyResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	key := []string{"X-API-Key", created.Key}
+	rec = ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/mine"}, key...)
+	var mine ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&mine)
+
+	_, resp := ta.gql(`{ links { nodes { shortCode } } link(code: "`+theirs+`") { shortCode } }`, nil, key...)
+	data := resp["data"].(map[string]any)
+	nodes := data["links"].(map[string]any)["nodes"].([]any)
+	if len(nodes) != 1 || nodes[0].(map[string]any)["shortCode"] != mine.ShortCode || data["link"] != nil {
+		t.Errorf("key sees %v", data)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	ta := newTestApp(t, nil)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	for _, query := range []string{
+		`{ links { nodes { shortCode } }`,             // unclosed
+		`{ links { nodes { nope } } }`,                // unknown field
+		`{ links(limit: 5) { nodes { shortCode } } }`, // unknown argument
+		`{ links }`,               // object without a selection
+		`{ tags { name { x } } }`, // scalar with one
+		`mutation { links { nodes { shortCode } } }`,
+		`query($code: String!) { link(code: $code) { shortCode } }`, // variable not given
+		`{ link(code: $code) { shortCode } }`,                       // not declared
+		`{ ...missing }`,
+		`{ ...loop } fragment loop on Query { ...loop }`,
+		`{ tags @defer { name } }`,
+	} {
+		status, resp := ta.gql(query, nil, admin...)
+		if status != http.StatusBadRequest || resp["data"] != nil || resp["errors"] == nil {
+			t.Errorf("%s: status %d: %v", query, status, resp)
+		}
+	}
+
+	// a field that fails is null with an error, the rest still comes back
+	status, resp := ta.gql(`{ links(first: 500) { nodes { shortCode } } tags { name } }`, nil, admin...)
+	errs, _ := resp["errors"].([]any)
+	data, _ := resp["data"].(map[string]any)
+	if status != http.StatusOK || len(errs) != 1 || data["links"] != nil || data["tags"] == nil {
+		t.Fatalf("partial: status %d: %v", status, resp)
+	}
+	if path := errs[0].(map[string]any)["path"].([]any); len(path) != 1 || path[0] != "links" {
+		t.Errorf("error path = %v", path)
+	}
+
+	// @skip and @include
+	_, resp = ta.gql(`query($no: Boolean = false) { tags @include(if: $no) { name } campaigns @skip(if: false) { name } }`, nil, admin...)
+	data = resp["data"].(map[string]any)
+	if _, ok := data["tags"]; ok || data["campaigns"] == nil {
+		t.Errorf("directives: %v", resp)
+	}
+}
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# a comment
+		query Q($n: [Int!]! = [1, 2]) {
+			a: link(code: "x\"yé") @skip(if: false) { shortCode }
+			... on Query { tags { name } }
+			links(first: -3, state: trashed, extra: {x: 1.5e2, y: null}) { nodes { shortCode } }
+		}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.operations[0]
+	if op.name != "Q" || len(op.vars) != 1 || !op.vars[0].nonNull || len(op.vars[0].def.([]any)) != 2 {
+		t.Errorf("operation = %+v", op)
+	}
+	sels := op.selections
+	if sels[0].alias != "a" || sels[0].args["code"] != "x\"yé" || sels[0].directives[0].name != "skip" {
+		t.Errorf("aliased field = %+v", sels[0])
+	}
+	if !sels[1].inline || sels[1].on != "Query" {
+		t.Errorf("inline fragment = %+v", sels[1])
+	}
+	args := sels[2].args
+	if args["first"] != -3 || args["state"] != gqlEnum("trashed") || args["extra"].(map[string]any)["x"] != 150.0 {
+		t.Errorf("args = %+v", args)
+	}
+
+	for _, bad := range []string{"", "{", "{ a(b: ) }", `{ a(b: "unterminated) }`, "{ a } }", "{ a(b: 1.) }", "{ a(b: 1, b: 2) }", "{ % }"} {
+		if _, err := parseGraphQL(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
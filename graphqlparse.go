@@ -0,0 +1,640 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// just enough of the graphql query language for /api/graphql, see graphql.go:
+// operations with variables, fields with aliases and arguments, fragments
+// (named and inline) and the @include/@skip directives. no schema language,
+// no subscriptions
+
+// gqlDocument is a parsed request
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	vars       []gqlVarDef
+	selections []gqlSelection
+}
+
+type gqlVarDef struct {
+	name    string
+	nonNull bool
+	def     any // default value, nil for none
+}
+
+type gqlFragment struct {
+	on         string
+	selections []gqlSelection
+}
+
+// gqlSelection is a field, a ...Spread or an inline ... on Type { }
+type gqlSelection struct {
+	alias, name string
+	args        map[string]any
+	directives  []gqlDirective
+	selections  []gqlSelection
+
+	spread string // fragment name
+	inline bool
+	on     string // type condition of an inline fragment, empty = any
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]any
+}
+
+// key is what the field comes back under
+func (s *gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// values in a document: variable references and enum names keep their own
+// types until execution, everything else is already a go value
+type gqlVar string
+type gqlEnum string
+
+// token kinds
+const (
+	gqlEOF = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind int
+	val  string
+	pos  int
+}
+
+type gqlParser struct {
+	src string
+	pos int
+	tok gqlToken
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{src: src}
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != gqlEOF {
+		switch {
+		case p.is(gqlPunct, "{"):
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: sels})
+		case p.is(gqlName, "fragment"):
+			name, frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[name] != nil {
+				return nil, fmt.Errorf("there can be only one fragment named %q", name)
+			}
+			doc.fragments[name] = frag
+		case p.is(gqlName, "query"), p.is(gqlName, "mutation"), p.is(gqlName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		default:
+			return nil, p.unexpected("an operation or fragment")
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operations")
+	}
+	return doc, nil
+}
+
+// errorf puts a line and column on a syntax error
+func (p *gqlParser) errorf(pos int, format string, args ...any) error {
+	line, col := 1, 1
+	for _, c := range p.src[:pos] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("syntax error at line %d, column %d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (p *gqlParser) unexpected(want string) error {
+	found := strconv.Quote(p.tok.val)
+	if p.tok.kind == gqlEOF {
+		found = "the end of the document"
+	}
+	return p.errorf(p.tok.pos, "expected %s, found %s", want, found)
+}
+
+func (p *gqlParser) is(kind int, val string) bool {
+	return p.tok.kind == kind && p.tok.val == val
+}
+
+// expect consumes a punctuator
+func (p *gqlParser) expect(punct string) error {
+	if !p.is(gqlPunct, punct) {
+		return p.unexpected(strconv.Quote(punct))
+	}
+	return p.next()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.tok.kind != gqlName {
+		return "", p.unexpected("a name")
+	}
+	name := p.tok.val
+	return name, p.next()
+}
+
+// next reads the following token into p.tok
+func (p *gqlParser) next() error {
+	// whitespace, commas and comments mean nothing
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = gqlToken{kind: gqlEOF, pos: start}
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = gqlToken{kind: gqlPunct, val: "...", pos: start}
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		p.pos++
+		p.tok = gqlToken{kind: gqlPunct, val: string(c), pos: start}
+	case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		for p.pos < len(p.src) && isGQLNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok = gqlToken{kind: gqlName, val: p.src[start:p.pos], pos: start}
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c == '"':
+		return p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		return p.errorf(start, "unexpected character %q", r)
+	}
+	return nil
+}
+
+func isGQLNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *gqlParser) number() error {
+	start := p.pos
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	if digits() == 0 {
+		return p.errorf(start, "invalid number")
+	}
+	kind := gqlInt
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			return p.errorf(start, "invalid number")
+		}
+		kind = gqlFloat
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return p.errorf(start, "invalid number")
+		}
+		kind = gqlFloat
+	}
+	if p.pos < len(p.src) && (isGQLNameChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		return p.errorf(start, "invalid number")
+	}
+	p.tok = gqlToken{kind: kind, val: p.src[start:p.pos], pos: start}
+	return nil
+}
+
+func (p *gqlParser) string() error {
+	start := p.pos
+	// """block strings""" are taken as they are
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			return p.errorf(start, "unterminated string")
+		}
+		p.tok = gqlToken{kind: gqlString, val: p.src[p.pos+3 : p.pos+3+end], pos: start}
+		p.pos += end + 6
+		return nil
+	}
+
+	var b strings.Builder
+	p.pos++
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' || p.src[p.pos] == '\r' {
+			return p.errorf(start, "unterminated string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			p.tok = gqlToken{kind: gqlString, val: b.String(), pos: start}
+			return nil
+		case c != '\\':
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			return p.errorf(start, "unterminated string")
+		}
+		esc := p.src[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				return p.errorf(p.pos-2, "invalid unicode escape")
+			}
+			r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				return p.errorf(p.pos-2, "invalid unicode escape")
+			}
+			b.WriteRune(rune(r))
+			p.pos += 4
+		default:
+			return p.errorf(p.pos-2, "invalid escape \\%c", esc)
+		}
+	}
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{kind: p.tok.val}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == gqlName {
+		op.name = p.tok.val
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is(gqlPunct, "(") {
+		vars, err := p.varDefs()
+		if err != nil {
+			return nil, err
+		}
+		op.vars = vars
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+func (p *gqlParser) varDefs() ([]gqlVarDef, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var defs []gqlVarDef
+	for !p.is(gqlPunct, ")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		def := gqlVarDef{name: name}
+		if def.nonNull, err = p.varType(); err != nil {
+			return nil, err
+		}
+		if p.is(gqlPunct, "=") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if def.def, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.directives(); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, p.next()
+}
+
+// varType reads a type like [String!]! - only whether the outside is
+// non-null matters here, the resolvers check what they're given
+func (p *gqlParser) varType() (nonNull bool, err error) {
+	if p.is(gqlPunct, "[") {
+		if err := p.next(); err != nil {
+			return false, err
+		}
+		if _, err := p.varType(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.is(gqlPunct, "!") {
+		return true, p.next()
+	}
+	return false, nil
+}
+
+func (p *gqlParser) fragment() (string, *gqlFragment, error) {
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, p.errorf(p.tok.pos, "a fragment can't be named \"on\"")
+	}
+	if !p.is(gqlName, "on") {
+		return "", nil, p.unexpected(`"on"`)
+	}
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+	frag := &gqlFragment{}
+	if frag.on, err = p.name(); err != nil {
+		return "", nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return "", nil, err
+	}
+	if frag.selections, err = p.selectionSet(); err != nil {
+		return "", nil, err
+	}
+	return name, frag, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.is(gqlPunct, "}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.unexpected("a field")
+	}
+	return sels, p.next()
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var sel gqlSelection
+	var err error
+	if p.is(gqlPunct, "...") {
+		if err := p.next(); err != nil {
+			return sel, err
+		}
+		if p.tok.kind == gqlName && p.tok.val != "on" {
+			sel.spread = p.tok.val
+			if err := p.next(); err != nil {
+				return sel, err
+			}
+			sel.directives, err = p.directives()
+			return sel, err
+		}
+		sel.inline = true
+		if p.is(gqlName, "on") {
+			if err := p.next(); err != nil {
+				return sel, err
+			}
+			if sel.on, err = p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return sel, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.is(gqlPunct, ":") {
+		if err := p.next(); err != nil {
+			return sel, err
+		}
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if p.is(gqlPunct, "(") {
+		if sel.args, err = p.arguments(); err != nil {
+			return sel, err
+		}
+	}
+	if sel.directives, err = p.directives(); err != nil {
+		return sel, err
+	}
+	if p.is(gqlPunct, "{") {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *gqlParser) arguments() (map[string]any, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := map[string]any{}
+	for !p.is(gqlPunct, ")") {
+		pos := p.tok.pos
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := args[name]; dup {
+			return nil, p.errorf(pos, "argument %q given twice", name)
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.next()
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var dirs []gqlDirective
+	for p.is(gqlPunct, "@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		dir := gqlDirective{name: name}
+		if p.is(gqlPunct, "(") {
+			if dir.args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// value reads a literal. variables aren't allowed in constants (defaults)
+func (p *gqlParser) value(constant bool) (any, error) {
+	tok := p.tok
+	switch tok.kind {
+	case gqlInt:
+		n, err := strconv.Atoi(tok.val)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "integer out of range")
+		}
+		return n, p.next()
+	case gqlFloat:
+		f, err := strconv.ParseFloat(tok.val, 64)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "invalid number")
+		}
+		return f, p.next()
+	case gqlString:
+		return tok.val, p.next()
+	case gqlName:
+		var v any
+		switch tok.val {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = gqlEnum(tok.val)
+		}
+		return v, p.next()
+	}
+
+	switch {
+	case p.is(gqlPunct, "$") && !constant:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return gqlVar(name), err
+	case p.is(gqlPunct, "["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for !p.is(gqlPunct, "]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.next()
+	case p.is(gqlPunct, "{"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		obj := map[string]any{}
+		for !p.is(gqlPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.next()
+	}
+	return nil, p.unexpected("a value")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the test harness: a full app over a throwaway bolt file, driven through the
+// real router with httptest so middleware, auth and routing are all exercised
+
+const testAdminToken = "test-admin-token"
+
+// fakeClock only moves when a test tells it to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// testApp is an app plus its handler, ready for requests
+type testApp struct {
+	*App
+	t       testing.TB
+	handler http.Handler
+	clock   *fakeClock
+}
+
+// newTestApp builds an app on a temp bolt file (or STORE=memory) with a fake
+// clock and a seeded random source. settings go through the environment like
+// in production, env overrides the defaults here
+func newTestApp(t testing.TB, env map[string]string, opts ...AppOption) *testApp {
+	t.Helper()
+	settings := map[string]string{
+		"DB_PATH":                filepath.Join(t.TempDir(), "urls.db"),
+		"BASE_URL":               "http://sho.rt",
+		"ADMIN_TOKEN":            testAdminToken,
+		"JWT_SECRET":             "test-jwt-secret",
+		"PREVIEW_SECRET":         "test-preview-secret",
+		"TOTP_KEY":               "test-totp-key",
+		"ABUSE_NETWORK_CHECKS":   "false",
+		"ABUSE_QUARANTINE_SCORE": "0",
+	}
+	for key, value := range env {
+		settings[key] = value
+	}
+	for key, value := range settings {
+		t.Setenv(key, value)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	db, err := openDatabase(config)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := setupDatabase(db); err != nil {
+		t.Fatalf("setup db: %v", err)
+	}
+
+	clock := newFakeClock()
+	opts = append([]AppOption{WithClock(clock), WithRandom(rand.New(rand.NewPCG(1, 2)))}, opts...)
+	app, err := newApp(db, config, opts...)
+	if err != nil {
+		t.Fatalf("new app: %v", err)
+	}
+	// cleanups run last first, so this waits before the db closes
+	t.Cleanup(app.Clicks.Wait)
+	t.Cleanup(app.Mails.Wait)
+	if app.AccessLog != nil {
+		t.Cleanup(func() { app.AccessLog.Close() })
+	}
+	return &testApp{App: app, t: t, handler: app.routes(), clock: clock}
+}
+
+// do sends one request through the router. a string body goes as is, anything
+// else is encoded as json
+func (ta *testApp) do(method, target string, body any, header ...string) *httptest.ResponseRecorder {
+	ta.t.Helper()
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+		contentType = "application/json"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			ta.t.Fatalf("encode body: %v", err)
+		}
+		reader = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	ta.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// shorten posts a url and decodes the reply, failing the test on anything but wantStatus
+func (ta *testApp) shorten(req ShortenRequest, wantStatus int) ShortenResponse {
+	ta.t.Helper()
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", req)
+	if rec.Code != wantStatus {
+		ta.t.Fatalf("shorten %s: status %d, want %d: %s", req.URL, rec.Code, wantStatus, rec.Body)
+	}
+	var resp ShortenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		ta.t.Fatalf("decode shorten response: %v", err)
+	}
+	return resp
+}
+
+// errorOf pulls the message out of a json error reply
+func errorOf(t testing.TB, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return resp.Error
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// links can carry a few extra response headers that go out with their
+// redirect, e.g. a stricter Referrer-Policy for a link to a partner site or a
+// Link header for preconnecting. only names in LINK_HEADERS are taken, and the
+// ones we set ourselves (caching, cookies, robots, security policies) never are
+
+var defaultLinkHeaders = []string{"Referrer-Policy", "Link", "Accept-CH", "Timing-Allow-Origin"}
+
+// reservedHeaders are ours to set, LINK_HEADERS can't hand them out
+var reservedHeaders = map[string]bool{
+	"Location": true, "Set-Cookie": true, "Cache-Control": true, "Expires": true, "Vary": true,
+	"Content-Type": true, "Content-Length": true, "Content-Encoding": true, "Transfer-Encoding": true,
+	"Connection": true, "Keep-Alive": true, "Upgrade": true, "Trailer": true, "Te": true,
+	"Strict-Transport-Security": true, "Content-Security-Policy": true, "X-Frame-Options": true,
+	"X-Robots-Tag": true, "Www-Authenticate": true, "Alt-Svc": true, "Clear-Site-Data": true,
+}
+
+const (
+	maxLinkHeaders      = 10
+	maxLinkHeaderLength = 1024
+)
+
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// envLinkHeaders reads the allowlist. a name that isn't a header, or is one
+// of ours, goes on errs
+func envLinkHeaders(key string, fallback []string, errs *[]error) []string {
+	list := envList(key)
+	if len(list) == 0 {
+		return fallback
+	}
+	var out []string
+	for _, name := range list {
+		name = http.CanonicalHeaderKey(name)
+		if !headerNamePattern.MatchString(name) || reservedHeaders[name] || strings.HasPrefix(name, "Access-Control-") {
+			*errs = append(*errs, fmt.Errorf("invalid %s entry %q", key, name))
+			continue
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+func (app *App) linkHeaderAllowed(name string) bool {
+	for _, h := range app.Config.LinkHeaders {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLinkHeaders checks a link's headers against LINK_HEADERS and
+// canonicalizes the names. an empty set comes back nil
+func (app *App) validateLinkHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(headers) > maxLinkHeaders {
+		return nil, fmt.Errorf("at most %d headers", maxLinkHeaders)
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := map[string]string{}
+	for _, name := range names {
+		value := strings.TrimSpace(headers[name])
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if !app.linkHeaderAllowed(name) {
+			return nil, fmt.Errorf("header %q isn't allowed, the ones that are: %s", name, strings.Join(app.Config.LinkHeaders, ", "))
+		}
+		if value == "" || len(value) > maxLinkHeaderLength {
+			return nil, fmt.Errorf("header %s needs a value of at most %d characters", name, maxLinkHeaderLength)
+		}
+		for _, c := range []byte(value) {
+			if (c < 0x20 && c != '\t') || c == 0x7f {
+				return nil, fmt.Errorf("header %s has a control character in its value", name)
+			}
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// setLinkHeaders adds the link's headers to its redirect. they go first, so
+// the pages in cloak.go and interstitial.go keep a Referrer-Policy the link set
+func setLinkHeaders(w http.ResponseWriter, link cachedLink) {
+	for name, value := range link.Headers {
+		w.Header().Set(name, value)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLinkHeaders(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"LINK_HEADERS": "referrer-policy,x-campaign-id"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	shorten := func(headers map[string]string) int {
+		return ta.do(http.MethodPost, "/api/v1/shorten", map[string]any{"url": "https://partner.example/", "reuse": false, "headers": headers}, admin...).Code
+	}
+	for _, bad := range []map[string]string{
+		{"Set-Cookie": "session=1"},
+		{"Link": "<https://cdn.example>; rel=preconnect"}, // a default, but not in this LINK_HEADERS
+		{"X-Campaign-Id": "spring\r\nSet-Cookie: session=1"},
+		{"X-Campaign-Id": ""},
+	} {
+		if status := shorten(bad); status != http.StatusBadRequest {
+			t.Errorf("headers %q: status %d, want 400", bad, status)
+		}
+	}
+
+	resp := ta.shorten(ShortenRequest{URL: "https://partner.example/", Headers: map[string]string{"referrer-policy": "no-referrer", "X-Campaign-ID": "spring-24"}}, http.StatusOK)
+	for _, path := range []string{"/" + resp.ShortCode, "/" + resp.ShortCode} { // db, then cache
+		rec := ta.do(http.MethodGet, path, nil)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Referrer-Policy") != "no-referrer" || rec.Header().Get("X-Campaign-Id") != "spring-24" {
+			t.Fatalf("redirect: status %d, headers %v", rec.Code, rec.Header())
+		}
+	}
+
+	// an interstitial page keeps the link's Referrer-Policy over its own
+	ta.do(http.MethodPatch, "/api/v1/links/"+resp.ShortCode, map[string]bool{"interstitial": true}, admin...)
+	if rec := ta.do(http.MethodGet, "/"+resp.ShortCode, nil); rec.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("interstitial Referrer-Policy = %q", rec.Header().Get("Referrer-Policy"))
+	}
+
+	if rec := ta.do(http.MethodPatch, "/api/v1/links/"+resp.ShortCode, map[string]any{"headers": map[string]string{}}, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("clear headers: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodGet, "/"+resp.ShortCode, nil); rec.Header().Get("X-Campaign-Id") != "" {
+		t.Errorf("cleared headers still sent: %v", rec.Header())
+	}
+}
+
+func TestLinkHeadersSetting(t *testing.T) {
+	t.Setenv("LINK_HEADERS", "X-Campaign-Id,Set-Cookie")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "Set-Cookie") {
+		t.Errorf("loadConfig = %v, want Set-Cookie refused", err)
+	}
+}
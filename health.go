@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// link health states - empty means the checker hasn't looked at it yet
+const (
+	healthOK     = "ok"
+	healthBroken = "broken"
+)
+
+// reason we stamp on links the checker switched off, so it knows it may switch them back on
+const deadLinkReason = "dead link"
+
+// healthCheck probes every destination, see liveApp.runJobs for the schedule
+func (app *App) healthCheck() {
+	start := time.Now()
+	checked, broken := app.checkAllLinks()
+	log.Printf("health check done: %d links, %d broken (%s)", checked, broken, time.Since(start).Round(time.Millisecond))
+}
+
+// checkAllLinks walks the whole urls bucket a page at a time and probes each
+// destination with a small worker pool
+func (app *App) checkAllLinks() (checked, broken int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 4)
+
+	after := ""
+	for {
+		links, next, err := app.listURLs(after, 200, func(u *URL) bool {
+			// leave links an operator disabled alone, only recheck ones we disabled
+			if u.TrashedAt != nil || !webURL(u.OriginalURL) {
+				return false
+			}
+			return !u.Disabled || u.DisabledReason == deadLinkReason
+		})
+		if err != nil {
+			log.Printf("health check list error: %v", err)
+			break
+		}
+
+		for _, link := range links {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(link URL) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				ok := app.checkLink(link)
+				mu.Lock()
+				checked++
+				if !ok {
+					broken++
+				}
+				mu.Unlock()
+			}(link)
+		}
+
+		if next == "" {
+			break
+		}
+		after = next
+	}
+
+	wg.Wait()
+	return checked, broken
+}
+
+// checkLink probes one destination and records the outcome on the link
+func (app *App) checkLink(link URL) bool {
+	status, err := app.probeURL(link.OriginalURL)
+	ok := err == nil && status < 400
+
+	now := time.Now()
+	// only switching a link off or back on goes in the audit log, not every check
+	_, err = app.auditedUpdate(AuditEntry{Actor: "system:health"}, link.ShortCode, func(u *URL) (string, error) {
+		u.LastStatus = status
+		u.LastChecked = &now
+		u.HealthError = ""
+		if err != nil {
+			u.HealthError = err.Error()
+		}
+
+		if ok {
+			u.Health = healthOK
+			u.FailCount = 0
+			if u.Disabled && u.DisabledReason == deadLinkReason {
+				u.Disabled = false
+				u.DisabledReason = ""
+				return "enable", nil
+			}
+			return "", nil
+		}
+
+		u.Health = healthBroken
+		u.FailCount++
+		if app.Config.HealthDisableBroken && u.FailCount >= app.Config.HealthFailThreshold && !u.Disabled {
+			u.Disabled = true
+			u.DisabledReason = deadLinkReason
+			return "disable", nil
+		}
+		return "", nil
+	})
+	if err != nil {
+		log.Printf("health check update error for %s: %v", link.ShortCode, err)
+	}
+
+	// disabled links must stop redirecting straight away, not when the cache entry expires
+	app.Cache.Delete(link.ShortCode)
+	return ok
+}
+
+// probeURL HEADs a destination, falling back to GET for servers that don't do HEAD
+func (app *App) probeURL(dest string) (int, error) {
+	status, err := app.probe(http.MethodHead, dest)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		return app.probe(http.MethodGet, dest)
+	}
+	return status, err
+}
+
+func (app *App) probe(method, dest string) (int, error) {
+	req, err := http.NewRequest(method, dest, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", outboundUserAgent)
+
+	resp, err := app.Outbound.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// languages the pages and api errors come in. english is the source text -
+// locales/<lang>.json maps english strings to their translation and anything
+// missing there stays english. like templates, ASSETS_DIR can override a catalog
+var supportedLangs = []string{"en", "es", "de", "fr"}
+
+var langMatcher = language.NewMatcher([]language.Tag{language.English, language.Spanish, language.German, language.French})
+
+// picked with ?lang= on any page, so the choice sticks while clicking around
+const langCookie = "lf_lang"
+
+// catalog is one language's translations, keyed by the english text
+type catalog map[string]string
+
+// t translates msg, formatting it with args when there are any
+func (c catalog) t(msg string, args ...any) string {
+	if tr, ok := c[msg]; ok && tr != "" {
+		msg = tr
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// loadCatalogs reads a catalog per supported language, english gets an empty one
+func loadCatalogs(assets fs.FS) (map[string]catalog, error) {
+	catalogs := map[string]catalog{"en": {}}
+	for _, lang := range supportedLangs[1:] {
+		data, err := fs.ReadFile(assets, "locales/"+lang+".json")
+		if err != nil {
+			return nil, err
+		}
+		c := catalog{}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("locales/%s.json: %w", lang, err)
+		}
+		catalogs[lang] = c
+	}
+	return catalogs, nil
+}
+
+func supportedLang(lang string) bool {
+	for _, l := range supportedLangs {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// requestLang picks the language for a request: ?lang=, then the cookie it
+// leaves behind, then Accept-Language
+func requestLang(r *http.Request) string {
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); supportedLang(lang) {
+		return lang
+	}
+	if cookie, err := r.Cookie(langCookie); err == nil && supportedLang(cookie.Value) {
+		return cookie.Value
+	}
+	tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, i, _ := langMatcher.Match(tags...)
+	return supportedLangs[i]
+}
+
+type langContextKey struct{}
+
+// langFromContext returns the request's language, english if the middleware didn't run
+func langFromContext(ctx context.Context) string {
+	if lang, ok := ctx.Value(langContextKey{}).(string); ok {
+		return lang
+	}
+	return "en"
+}
+
+// localeMiddleware works out the language and translates json error messages
+// into it. only messages found word for word in the catalog change, ones with
+// details filled in stay english
+func (app *App) localeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := requestLang(r)
+		if q := r.URL.Query().Get("lang"); q != "" && supportedLang(strings.ToLower(q)) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     langCookie,
+				Value:    lang,
+				Path:     app.path("/"),
+				MaxAge:   365 * 24 * 3600,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), langContextKey{}, lang))
+		if lang == "en" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		lw := &localizedErrorWriter{ResponseWriter: w, lang: lang, messages: app.Catalogs[lang]}
+		next.ServeHTTP(lw, r)
+		lw.finish()
+	})
+}
+
+// localizedErrorWriter holds back json error responses so their message can
+// be swapped for the translation. everything else goes straight through
+type localizedErrorWriter struct {
+	http.ResponseWriter
+	lang     string
+	messages catalog
+	status   int
+	buf      *bytes.Buffer // set once an error status was written
+}
+
+func (w *localizedErrorWriter) WriteHeader(code int) {
+	if code >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.status, w.buf = code, &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *localizedErrorWriter) Write(b []byte) (int, error) {
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *localizedErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *localizedErrorWriter) finish() {
+	if w.buf == nil {
+		return
+	}
+	body := w.buf.Bytes()
+	var resp map[string]any
+	if json.Unmarshal(body, &resp) == nil {
+		// the code stays as it is, that's what programs look at
+		changed := false
+		if msg, ok := resp["message"].(string); ok {
+			if tr, ok := w.messages[msg]; ok && tr != "" {
+				resp["message"], resp["error"] = tr, tr
+				changed = true
+			}
+		}
+		// field errors get translated one by one, and the joined message
+		// at the top rebuilt from them
+		if list, ok := resp["errors"].([]any); ok && resp["code"] != nil {
+			var problems fieldErrors
+			for _, item := range list {
+				fe, _ := item.(map[string]any)
+				field, _ := fe["field"].(string)
+				msg, _ := fe["message"].(string)
+				if tr, ok := w.messages[msg]; ok && tr != "" {
+					fe["message"], msg = tr, tr
+					changed = true
+				}
+				problems = append(problems, FieldError{Field: field, Message: msg})
+			}
+			if changed && len(problems) > 1 {
+				resp["message"], resp["error"] = problems.Error(), problems.Error()
+			}
+		}
+		if changed {
+			if data, err := json.Marshal(resp); err == nil {
+				body = append(data, '\n')
+			}
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Language", w.lang)
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
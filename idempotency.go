@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// how long a finished request's response is kept for replay, and how long a
+// request that never finished (crash, panic) blocks its key
+const (
+	idempotencyTTL         = 24 * time.Hour
+	idempotencyPendingTTL  = time.Minute
+	maxIdempotencyKeyLen   = 255
+	maxIdempotentBodyBytes = 1 << 20
+)
+
+// idempotentResult is what's stored per key - Pending until the first
+// request finishes, then the response to replay
+type idempotentResult struct {
+	RequestHash string    `json:"request_hash"` // the same key with a different request is an error
+	Pending     bool      `json:"pending,omitempty"`
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (res *idempotentResult) expired(now time.Time) bool {
+	if res.Pending {
+		return now.Sub(res.CreatedAt) > idempotencyPendingTTL
+	}
+	return now.Sub(res.CreatedAt) > idempotencyTTL
+}
+
+// responseCapture passes a response through while keeping a copy of it
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	if rc.status == 0 {
+		rc.status = status
+	}
+	rc.ResponseWriter.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+// idempotent wraps a create endpoint so a retried request carrying the same
+// Idempotency-Key gets the first response back instead of running again.
+// keys are per caller (credential, or client ip for anonymous requests) and
+// only successful responses are kept, so a failed request can be retried as is
+func (app *App) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "Idempotency-Key", "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodyBytes+1))
+		if err != nil || len(body) > maxIdempotentBodyBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, errorTooLarge, "request body is too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		identity := identityFromContext(r.Context())
+		scope := "ip:" + clientIP(r)
+		if !identity.Anonymous() {
+			scope = identity.Method + ":" + identity.Subject
+		}
+		storeKey := hashToken(scope + "\x00" + key)
+
+		fingerprint := sha256.New()
+		for _, part := range []string{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Content-Type")} {
+			fingerprint.Write([]byte(part))
+			fingerprint.Write([]byte{0})
+		}
+		fingerprint.Write(body)
+		requestHash := hex.EncodeToString(fingerprint.Sum(nil))
+
+		// claim the key, or find what's already there, in one transaction so two
+		// racing retries can't both get through
+		var prior *idempotentResult
+		now := time.Now()
+		err = app.DB.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte("idempotency"))
+			if err != nil {
+				return err
+			}
+			if v := bucket.Get([]byte(storeKey)); v != nil {
+				var stored idempotentResult
+				if openJSON(tx, v, &stored) == nil && !stored.expired(now) {
+					prior = &stored
+					return nil
+				}
+			}
+			data, err := sealJSON(tx, idempotentResult{RequestHash: requestHash, Pending: true, CreatedAt: now})
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte(storeKey), data)
+		})
+		if err != nil {
+			log.Printf("idempotency lookup error: %v", err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+
+		if prior != nil {
+			switch {
+			case prior.RequestHash != requestHash:
+				writeError(w, http.StatusUnprocessableEntity, errorKeyReused, "Idempotency-Key was already used for a different request")
+			case prior.Pending:
+				writeError(w, http.StatusConflict, errorInProgress, "a request with this Idempotency-Key is still in progress")
+			default:
+				w.Header().Set("Content-Type", prior.ContentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(prior.Status)
+				w.Write(prior.Body)
+			}
+			return
+		}
+
+		capture := &responseCapture{ResponseWriter: w}
+		stored := false
+		defer func() {
+			// anything but success frees the key up for the retry
+			if !stored {
+				app.dropIdempotencyKey(storeKey)
+			}
+		}()
+		next(capture, r)
+
+		if capture.status < 200 || capture.status > 299 {
+			return
+		}
+		// the body has the destination in it, so it's sealed like the link
+		err = app.DB.Update(func(tx *bolt.Tx) error {
+			data, err := sealJSON(tx, idempotentResult{
+				RequestHash: requestHash,
+				Status:      capture.status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        capture.body.Bytes(),
+				CreatedAt:   now,
+			})
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte("idempotency")).Put([]byte(storeKey), data)
+		})
+		if err != nil {
+			log.Printf("idempotency save error: %v", err)
+			return
+		}
+		stored = true
+	}
+}
+
+func (app *App) dropIdempotencyKey(storeKey string) {
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("idempotency"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(storeKey))
+	})
+	if err != nil {
+		log.Printf("idempotency cleanup error: %v", err)
+	}
+}
+
+// runIdempotencyCleanup drops replay entries once they're past their ttl
+func (app *App) runIdempotencyCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		removed := 0
+		err := app.DB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte("idempotency"))
+			if bucket == nil {
+				return nil
+			}
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var stored idempotentResult
+				if openJSON(tx, v, &stored) == nil && !stored.expired(now) {
+					continue
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("idempotency cleanup error: %v", err)
+		} else if removed > 0 {
+			log.Printf("idempotency cleanup: removed %d expired keys", removed)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// kinds of integration an org can set up - all of them are an http endpoint,
+// they differ in the payload shape and what the url has to look like
+const (
+	integrationWebhook   = "webhook"   // our event json, optionally hmac signed
+	integrationSlack     = "slack"     // slack incoming webhook
+	integrationDiscord   = "discord"   // discord channel webhook
+	integrationAnalytics = "analytics" // event json forwarded to an analytics collector, with custom headers
+)
+
+var integrationTypes = []string{integrationWebhook, integrationSlack, integrationDiscord, integrationAnalytics}
+
+// events integrations can subscribe to. an empty list means all of them
+const (
+	eventLinkCreated    = "link.created"
+	eventClickThreshold = "link.click_threshold" // a link's click count reached the integration's click_threshold
+	eventClickAnomaly   = "link.click_anomaly"   // a link's traffic looks like click fraud, see fraud.go
+	eventTest           = "test"                 // only ever sent by the test-fire endpoint
+)
+
+var integrationEvents = []string{eventLinkCreated, eventClickThreshold, eventClickAnomaly}
+
+const (
+	maxIntegrations    = 20
+	maxIntegrationName = 64
+	maskedValue        = "********"
+	signatureHeader    = "X-LinkFast-Signature"
+	eventTypeHeader    = "X-LinkFast-Event"
+)
+
+// Integration is one outbound hook configured by an org
+type Integration struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	Events    []string          `json:"events,omitempty"`
+	Secret    string            `json:"secret,omitempty"`  // webhook signing key, never returned
+	Headers   map[string]string `json:"headers,omitempty"` // sent with every delivery, values never returned
+	Disabled  bool              `json:"disabled,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+
+	ClickThreshold int `json:"click_threshold,omitempty"` // for link.click_threshold, 0 = never
+}
+
+// masked is how an integration goes back out over the api - secrets are
+// replaced so they can't be read back, only overwritten
+func (in Integration) masked() Integration {
+	if in.Secret != "" {
+		in.Secret = maskedValue
+	}
+	if len(in.Headers) > 0 {
+		headers := make(map[string]string, len(in.Headers))
+		for k := range in.Headers {
+			headers[k] = maskedValue
+		}
+		in.Headers = headers
+	}
+	return in
+}
+
+// wants reports whether the integration should get an event
+func (in *Integration) wants(ev *Event) bool {
+	if in.Disabled {
+		return false
+	}
+	if ev.Type == eventClickThreshold && (in.ClickThreshold <= 0 || ev.Link == nil || ev.Link.ClickCount != in.ClickThreshold) {
+		return false
+	}
+	return ev.Type == eventTest || len(in.Events) == 0 || slices.Contains(in.Events, ev.Type)
+}
+
+// validate checks an integration config and normalizes it in place
+func (in *Integration) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > maxIntegrationName {
+		return fmt.Errorf("name is required and at most %d characters", maxIntegrationName)
+	}
+	if !slices.Contains(integrationTypes, in.Type) {
+		return fmt.Errorf("type must be one of %s", strings.Join(integrationTypes, ", "))
+	}
+
+	u, err := url.Parse(strings.TrimSpace(in.URL))
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return errors.New("url must be an absolute http(s) url")
+	}
+	in.URL = u.String()
+	switch in.Type {
+	case integrationSlack:
+		if u.Scheme != "https" || u.Host != "hooks.slack.com" {
+			return errors.New("slack url must be an https://hooks.slack.com/... incoming webhook")
+		}
+	case integrationDiscord:
+		if u.Scheme != "https" || (u.Host != "discord.com" && u.Host != "discordapp.com") || !strings.HasPrefix(u.Path, "/api/webhooks/") {
+			return errors.New("discord url must be an https://discord.com/api/webhooks/... url")
+		}
+	}
+
+	for _, ev := range in.Events {
+		if !slices.Contains(integrationEvents, ev) {
+			return fmt.Errorf("unknown event %q", ev)
+		}
+	}
+	if in.Secret != "" && in.Type != integrationWebhook {
+		return errors.New("secret only applies to webhook integrations")
+	}
+	for k := range in.Headers {
+		if http.CanonicalHeaderKey(k) == "Content-Type" || !validHeaderName(k) {
+			return fmt.Errorf("header %q can't be set", k)
+		}
+	}
+	if len(in.Headers) > 0 && in.Type != integrationWebhook && in.Type != integrationAnalytics {
+		return errors.New("headers only apply to webhook and analytics integrations")
+	}
+	if in.ClickThreshold < 0 {
+		return errors.New("click_threshold can't be negative")
+	}
+	return nil
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Event is what gets delivered to integrations
+type Event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	OrgID string    `json:"org_id,omitempty"`
+	Link  *URL      `json:"link,omitempty"`
+	Text  string    `json:"text"` // one line summary, all chat integrations show
+}
+
+// deliver posts one event to one integration
+func (app *App) deliver(ctx context.Context, in *Integration, ev Event) error {
+	var payload any = ev
+	switch in.Type {
+	case integrationSlack:
+		payload = map[string]string{"text": ev.Text}
+	case integrationDiscord:
+		payload = map[string]string{"content": ev.Text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range in.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", outboundUserAgent)
+	req.Header.Set(eventTypeHeader, ev.Type)
+	if in.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(in.Secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := app.Webhooks.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// integrationsFor returns the operator's global integrations plus the org's own
+func (app *App) integrationsFor(orgID string) []Integration {
+	out := app.GlobalIntegrations
+	if orgID == "" {
+		return out
+	}
+	org, err := app.getOrg(orgID)
+	if err != nil {
+		log.Printf("org lookup error: %v", err)
+	}
+	if org == nil {
+		return out
+	}
+	return append(slices.Clip(out), org.Integrations...)
+}
+
+// notify sends an event to every integration that wants it. runs in the
+// background - a slow or broken endpoint only costs a log line
+func (app *App) notify(orgID string, ev Event) {
+	ev.OrgID = orgID
+	integrations := app.integrationsFor(orgID)
+	for i := range integrations {
+		in := &integrations[i]
+		if !in.wants(&ev) || ev.Type == eventTest {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := app.deliver(ctx, in, ev); err != nil {
+			log.Printf("integration %s (%s) delivery of %s failed: %v", in.ID, in.Type, ev.Type, err)
+		}
+		cancel()
+	}
+}
+
+// linkCreatedEvent describes a new link for notify
+func (app *App) linkCreatedEvent(u *URL) Event {
+	return Event{
+		Type: eventLinkCreated,
+		Time: time.Now(),
+		Link: u,
+		Text: fmt.Sprintf("New short link %s -> %s", app.linkShortURL(u), u.OriginalURL),
+	}
+}
+
+// clickMilestone tells integrations when a link hits their click threshold.
+// counts go up one at a time inside a transaction, so exactly one click sees
+// each number and the event fires once
+func (app *App) clickMilestone(u *URL) {
+	ev := Event{
+		Type: eventClickThreshold,
+		Time: time.Now(),
+		Link: u,
+		Text: fmt.Sprintf("%s just reached %d clicks (-> %s)", app.linkShortURL(u), u.ClickCount, u.OriginalURL),
+	}
+	for _, in := range app.integrationsFor(u.OrgID) {
+		if in.wants(&ev) {
+			app.notify(u.OrgID, ev)
+			return
+		}
+	}
+}
+
+// globalIntegrations turns the NOTIFY_* settings into integrations that get
+// events for every link on the server, on top of whatever orgs set up
+func globalIntegrations(cfg *Config) ([]Integration, error) {
+	var out []Integration
+	var errs []error
+	add := func(typ, name, url string) {
+		if url == "" {
+			return
+		}
+		in := Integration{ID: name, Type: typ, Name: name, URL: url, Events: cfg.NotifyEvents, ClickThreshold: cfg.NotifyClickThreshold}
+		if err := in.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s notification settings: %w", typ, err))
+			return
+		}
+		out = append(out, in)
+	}
+	add(integrationSlack, "global-slack", cfg.NotifySlackWebhook)
+	add(integrationDiscord, "global-discord", cfg.NotifyDiscordWebhook)
+	return out, errors.Join(errs...)
+}
+
+// orgFromRequest loads the {orgID} org if the caller manages it, writing the 404 otherwise
+func (app *App) orgFromRequest(w http.ResponseWriter, r *http.Request) (*Org, bool) {
+	orgID := mux.Vars(r)["orgID"]
+	if identityFromContext(r.Context()).managesOrg(orgID) {
+		org, err := app.getOrg(orgID)
+		if err != nil {
+			log.Printf("org lookup error: %v", err)
+		}
+		if org != nil {
+			return org, true
+		}
+	}
+
+	writeError(w, http.StatusNotFound, errorNotFound, "org not found")
+	return nil, false
+}
+
+// handles GET /api/orgs/{orgID}/integrations
+func (app *App) listIntegrationsHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	out := []Integration{}
+	for _, in := range org.Integrations {
+		out = append(out, in.masked())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handles POST /api/orgs/{orgID}/integrations/validate - checks a config without saving it
+func (app *App) validateIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.orgFromRequest(w, r); !ok {
+		return
+	}
+
+	var in Integration
+	if err := decodeJSON(r, &in); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if err := in.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"valid": true})
+}
+
+// handles POST /api/orgs/{orgID}/integrations
+func (app *App) createIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var in Integration
+	if err := decodeJSON(r, &in); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if err := in.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, err.Error())
+		return
+	}
+
+	id := make([]byte, 6)
+	rand.Read(id)
+	in.ID = hex.EncodeToString(id)
+	in.CreatedAt = time.Now()
+
+	full := false
+	_, err := app.updateOrg(org.ID, func(org *Org) error {
+		if len(org.Integrations) >= maxIntegrations {
+			full = true
+			return nil
+		}
+		org.Integrations = append(org.Integrations, in)
+		return nil
+	})
+	if err != nil {
+		log.Printf("integration save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save integration")
+		return
+	}
+	if full {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, fmt.Sprintf("at most %d integrations per org", maxIntegrations))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(in.masked())
+}
+
+// handles PUT /api/orgs/{orgID}/integrations/{id} - masked or missing secrets
+// keep their stored value, so a client can send back what it read
+func (app *App) updateIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	var in Integration
+	if err := decodeJSON(r, &in); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+
+	var validationErr error
+	var saved *Integration
+	_, err := app.updateOrg(org.ID, func(org *Org) error {
+		for i := range org.Integrations {
+			old := &org.Integrations[i]
+			if old.ID != id {
+				continue
+			}
+			in.ID, in.CreatedAt = old.ID, old.CreatedAt
+			if in.Secret == "" || in.Secret == maskedValue {
+				in.Secret = old.Secret
+			}
+			for k, v := range in.Headers {
+				if v == maskedValue {
+					in.Headers[k] = old.Headers[k]
+				}
+			}
+			if validationErr = in.validate(); validationErr != nil {
+				return nil
+			}
+			*old = in
+			saved = old
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("integration save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save integration")
+		return
+	}
+	if validationErr != nil {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, validationErr.Error())
+		return
+	}
+	if saved == nil {
+		writeError(w, http.StatusNotFound, errorNotFound, "integration not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved.masked())
+}
+
+// handles DELETE /api/orgs/{orgID}/integrations/{id}
+func (app *App) deleteIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	found := false
+	_, err := app.updateOrg(org.ID, func(org *Org) error {
+		org.Integrations = slices.DeleteFunc(org.Integrations, func(in Integration) bool {
+			if in.ID == id {
+				found = true
+				return true
+			}
+			return false
+		})
+		return nil
+	})
+	if err != nil {
+		log.Printf("integration delete error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errorNotFound, "integration not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles POST /api/orgs/{orgID}/integrations/{id}/test - fires a test event
+// and reports what the endpoint said, so config mistakes show up immediately
+func (app *App) testIntegrationHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	idx := slices.IndexFunc(org.Integrations, func(in Integration) bool { return in.ID == id })
+	if idx < 0 {
+		writeError(w, http.StatusNotFound, errorNotFound, "integration not found")
+		return
+	}
+	in := &org.Integrations[idx]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	err := app.deliver(ctx, in, Event{
+		Type:  eventTest,
+		Time:  time.Now(),
+		OrgID: org.ID,
+		Text:  fmt.Sprintf("Test message from LinkFast for integration %q", in.Name),
+	})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, errorUpstream, "delivery failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"delivered": true})
+}
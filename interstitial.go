@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// interstitial links answer with a page saying where the link goes and a
+// continue button, instead of sending the visitor straight there. it's for
+// orgs that want people to see they're leaving, and can be on for every new
+// link through the settings, see settings.go. frame and meta links are pages
+// already, so they're served as they are
+
+// interstitial serves the warning page. like the cloak pages it's never
+// cached, every view is a click
+func (app *App) interstitial(w http.ResponseWriter, r *http.Request, link cachedLink) {
+	host := link.URL
+	if u, err := url.Parse(link.URL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if w.Header().Get("Referrer-Policy") == "" {
+		w.Header().Set("Referrer-Policy", "no-referrer-when-downgrade")
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	err := app.page(r).ExecuteTemplate(w, "interstitial.html", map[string]any{
+		"URL":  link.URL,
+		"Host": host,
+	})
+	if err != nil {
+		log.Printf("interstitial render error: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// every request body is capped at MAX_BODY_BYTES before a handler sees it, so a
+// slow or endless upload can't hold a connection and memory. json bodies are
+// read strictly: a misspelled field is an error instead of being quietly ignored
+
+// bodyLimitMiddleware puts the MAX_BODY_BYTES cap on every request body
+func (app *App) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && app.Config.MaxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, app.Config.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errTrailingJSON = errors.New("unexpected data after the json value")
+
+// decodeJSON reads a single json value from the body into v, refusing fields
+// v doesn't have
+func decodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return errTrailingJSON
+	}
+	return nil
+}
+
+// bodyErrorStatus is 413 for a body over the limit, 400 for anything else
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// bodyErrorCode is too_large or invalid_json, see errors.go
+func bodyErrorCode(err error) string {
+	if bodyErrorStatus(err) == http.StatusRequestEntityTooLarge {
+		return errorTooLarge
+	}
+	return errorInvalidJSON
+}
+
+// bodyErrorMessage says what was wrong with a body that didn't decode. the
+// decoder's own text is only passed on for unknown fields, the rest of it talks
+// about go types
+func bodyErrorMessage(err error) string {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return "request body is too large"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "invalid json: " + strings.TrimPrefix(err.Error(), "json: ")
+	case errors.Is(err, errTrailingJSON):
+		return "invalid json: " + err.Error()
+	}
+	return "invalid json"
+}
+
+// jsonBodyError answers a request whose json body didn't decode
+func jsonBodyError(w http.ResponseWriter, err error) {
+	writeError(w, bodyErrorStatus(err), bodyErrorCode(err), bodyErrorMessage(err))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// LinkCache is the short code -> destination cache in front of the db. go-cache
+// does the default age based expiry, lruCache is for a bounded cache
+type LinkCache interface {
+	Get(k string) (any, bool)
+	Set(k string, x any, d time.Duration) // d follows go-cache: 0 = default ttl, -1 = never
+	Delete(k string)
+	ItemCount() int // entries held, possibly counting expired ones not swept yet
+}
+
+// newLinkCache picks the cache from CACHE_* settings. CACHE_TTL=0 with
+// CACHE_MAX_ENTRIES set is the "never expire, evict least recently used" mode
+func newLinkCache(cfg *Config) LinkCache {
+	ttl := cfg.CacheTTL
+	if ttl <= 0 {
+		ttl = cache.NoExpiration
+	}
+	if cfg.CacheMaxEntries > 0 {
+		return newLRUCache(cfg.CacheMaxEntries, ttl, cfg.CacheCleanupInterval)
+	}
+	if ttl == cache.NoExpiration {
+		log.Printf("warning: CACHE_TTL=0 without CACHE_MAX_ENTRIES - the cache grows with every link ever visited")
+	}
+	return cache.New(ttl, cfg.CacheCleanupInterval)
+}
+
+// lruCache is a size capped cache - when full, the least recently used entry
+// goes. per entry expiry still applies so links with an expires_at don't outlive it
+type lruCache struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List // front is most recently used
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	expires time.Time // zero = never
+}
+
+func newLRUCache(maxEntries int, ttl, cleanupInterval time.Duration) *lruCache {
+	c := &lruCache{max: maxEntries, ttl: ttl, items: map[string]*list.Element{}, order: list.New()}
+	if cleanupInterval > 0 {
+		go c.sweep(cleanupInterval)
+	}
+	return c
+}
+
+func (c *lruCache) Get(k string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, k)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *lruCache) Set(k string, x any, d time.Duration) {
+	if d == cache.DefaultExpiration {
+		d = c.ttl
+	}
+	var expires time.Time
+	if d > 0 {
+		expires = time.Now().Add(d)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[k]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expires = x, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[k] = c.order.PushFront(&lruEntry{key: k, value: x, expires: expires})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) Delete(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[k]; ok {
+		c.order.Remove(el)
+		delete(c.items, k)
+	}
+}
+
+func (c *lruCache) ItemCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// sweep drops expired entries now and then so they don't hold slots until evicted
+func (c *lruCache) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		c.mu.Lock()
+		for el := c.order.Back(); el != nil; {
+			prev := el.Prev()
+			if entry := el.Value.(*lruEntry); !entry.expires.IsZero() && now.After(entry.expires) {
+				c.order.Remove(el)
+				delete(c.items, entry.key)
+			}
+			el = prev
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// limits on link labels - keeps records small and listings readable
+const (
+	maxTags        = 10
+	maxTagLen      = 32
+	maxCampaignLen = 64
+
+	maxTitleLen       = 200
+	maxDescriptionLen = 2000
+)
+
+// normalizeTags trims, lowercases and dedupes tags, rejecting oversized input
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLen {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLen)
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("at most %d tags per link", maxTags)
+	}
+	return out, nil
+}
+
+// normalizeNotes trims a link's title and description and enforces their limits
+func normalizeNotes(title, description string) (string, string, error) {
+	var problems fieldErrors
+	title, description = strings.TrimSpace(title), strings.TrimSpace(description)
+	if utf8.RuneCountInString(title) > maxTitleLen {
+		problems.add("title", fmt.Errorf("title must be at most %d characters", maxTitleLen))
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLen {
+		problems.add("description", fmt.Errorf("description must be at most %d characters", maxDescriptionLen))
+	}
+	return title, description, problems.err()
+}
+
+// ListedLink is a link as listings return it, with its full short url
+type ListedLink struct {
+	URL
+	ShortURL string `json:"short_url"`
+}
+
+// response for GET /api/links
+type LinkListResponse struct {
+	Links []ListedLink `json:"links"`
+	Next  string       `json:"next,omitempty"` // pass back as ?after= to get the next page
+}
+
+func (app *App) linkList(links []URL, next string) LinkListResponse {
+	resp := LinkListResponse{Links: make([]ListedLink, len(links)), Next: next}
+	for i := range links {
+		resp.Links[i] = ListedLink{URL: links[i], ShortURL: app.linkShortURL(&links[i])}
+	}
+	return resp
+}
+
+// handles GET /api/links - paginated listing with optional filters. admins see
+// every link, everyone else just their own
+func (app *App) listLinksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	identity := identityFromContext(r.Context())
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	health := q.Get("health")
+	switch health {
+	case "", healthOK, healthBroken, "unchecked":
+	default:
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "health", "health must be ok, broken or unchecked")
+		return
+	}
+
+	state := q.Get("state")
+	switch state {
+	case "":
+		state = stateActive
+	case stateActive, stateTrashed, stateAll:
+	default:
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "state", "state must be active, trashed or all")
+		return
+	}
+
+	campaign := q.Get("campaign")
+	domain := q.Get("domain")
+	namespace := q.Get("namespace")
+	search := strings.ToLower(strings.TrimSpace(q.Get("q")))
+	tag := strings.ToLower(strings.TrimSpace(q.Get("tag")))
+	fields := fieldParams(q)
+
+	links, next, err := app.listURLs(q.Get("after"), limit, func(u *URL) bool {
+		if !identity.owns(u) {
+			return false
+		}
+		if (state == stateActive && u.TrashedAt != nil) || (state == stateTrashed && u.TrashedAt == nil) {
+			return false
+		}
+		if campaign != "" && u.Campaign != campaign {
+			return false
+		}
+		if domain != "" && !strings.EqualFold(u.Domain, domain) {
+			return false
+		}
+		if namespace != "" && u.Namespace != namespace {
+			return false
+		}
+		if search != "" && !matchesSearch(u, search) {
+			return false
+		}
+		if tag != "" && !hasTag(u.Tags, tag) {
+			return false
+		}
+		if !matchFields(u, fields) {
+			return false
+		}
+		switch health {
+		case "unchecked":
+			return u.Health == ""
+		case "":
+			return true
+		}
+		return u.Health == health
+	})
+	if err != nil {
+		log.Printf("list links error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	// no Last-Modified, a link dropping out of the page doesn't leave a
+	// newer time behind - the ETag still catches it
+	serveConditional(w, r, time.Time{}, app.linkList(links, next))
+}
+
+// matchesSearch is the ?q= filter - a lowercase substring of the code, title,
+// description or destination
+func matchesSearch(u *URL, search string) bool {
+	for _, s := range []string{u.ShortCode, u.Title, u.Description, u.OriginalURL} {
+		if strings.Contains(strings.ToLower(s), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// handles PATCH /api/links/{shortCode} - edits a link's title, description,
+// click webhook, rule, rewrite, countries, card, headers, private and
+// interstitial flags. fields left out of the body are kept, an empty string (or
+// a rewrite with no pattern, countries with no lists, an empty card or headers
+// object) clears one
+func (app *App) updateLinkHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	urlData, ok := app.authorizeLink(w, r, shortCode)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Title        *string           `json:"title"`
+		Description  *string           `json:"description"`
+		ClickWebhook *string           `json:"click_webhook"`
+		Rule         *string           `json:"rule"`
+		Rewrite      *Rewrite          `json:"rewrite"`
+		Countries    *GeoRule          `json:"countries"`
+		Card         *LinkCard         `json:"card"`
+		Headers      map[string]string `json:"headers"`
+		Private      *bool             `json:"private"`
+		Interstitial *bool             `json:"interstitial"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	title, description, webhook, rule, rewrite, countries, card, private := urlData.Title, urlData.Description, urlData.ClickWebhook, urlData.Rule, urlData.Rewrite, urlData.Countries, urlData.Card, urlData.Private
+	interstitial, headers := urlData.Interstitial, urlData.Headers
+	if req.Title != nil {
+		title = *req.Title
+	}
+	if req.Description != nil {
+		description = *req.Description
+	}
+	var problems fieldErrors
+	title, description, err := normalizeNotes(title, description)
+	problems.add("", err)
+	if req.ClickWebhook != nil {
+		webhook, err = normalizeClickWebhook(*req.ClickWebhook)
+		problems.add("click_webhook", err)
+	}
+	if req.Rule != nil {
+		rule, err = app.normalizeRule(*req.Rule)
+		problems.add("rule", err)
+	}
+	if req.Rewrite != nil {
+		rewrite, err = app.validateRewrite(r.Context(), req.Rewrite)
+		problems.add("rewrite", err)
+	}
+	if req.Countries != nil {
+		countries, err = app.validateGeoRule(req.Countries)
+		problems.add("countries", err)
+	}
+	if req.Card != nil {
+		card, err = validateCard(req.Card)
+		problems.add("card", err)
+	}
+	if req.Headers != nil {
+		headers, err = app.validateLinkHeaders(req.Headers)
+		problems.add("headers", err)
+	}
+	if req.Private != nil {
+		private = *req.Private
+	}
+	if req.Interstitial != nil {
+		interstitial = *req.Interstitial
+	}
+	if len(problems) > 0 {
+		writeValidationError(w, problems)
+		return
+	}
+
+	urlData, err = app.auditedUpdate(requestActor(r), shortCode, func(u *URL) (string, error) {
+		if u.Title == title && u.Description == description && u.ClickWebhook == webhook && u.Rule == rule && reflect.DeepEqual(u.Rewrite, rewrite) && reflect.DeepEqual(u.Countries, countries) && reflect.DeepEqual(u.Card, card) && u.Private == private && u.Interstitial == interstitial && reflect.DeepEqual(u.Headers, headers) {
+			return "", nil
+		}
+		u.Title, u.Description, u.ClickWebhook, u.Rule, u.Rewrite, u.Countries, u.Card, u.Private = title, description, webhook, rule, rewrite, countries, card, private
+		u.Interstitial, u.Headers = interstitial, headers
+		return "edit", nil
+	})
+	if err != nil {
+		log.Printf("link update error for %s: %v", shortCode, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	// the redirect cache holds the webhook, rule, rewrite, countries, card and privacy
+	app.Cache.Delete(shortCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(urlData)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadtest hammers a running instance's redirect path and reports latency.
+// it's a subcommand of the server binary so there's nothing extra to build:
+//
+//	urlshortener loadtest -url http://localhost:8080 -c 50 -d 30s
+//
+// it shortens -links urls first, then every worker loops picking one at random
+// (or a code that doesn't exist, -miss of the time) until the duration is up
+
+type loadResult struct {
+	latency time.Duration
+	status  int // 0 when the request itself failed
+}
+
+func runLoadTest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := fs.String("url", "http://localhost:8080", "base url of the instance to hit")
+	concurrency := fs.Int("c", 20, "concurrent workers")
+	duration := fs.Duration("d", 10*time.Second, "how long to run")
+	links := fs.Int("links", 100, "links to create before the run")
+	miss := fs.Float64("miss", 0, "fraction of requests for codes that don't exist (0-1)")
+	token := fs.String("token", "", "bearer token for creating links, if the instance needs one")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *concurrency < 1 || *links < 1 || *miss < 0 || *miss > 1 {
+		fmt.Fprintln(os.Stderr, "loadtest: -c and -links must be at least 1, -miss between 0 and 1")
+		return 2
+	}
+	base := strings.TrimRight(*target, "/")
+
+	// redirects are the thing being measured, never follow them
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: &http.Transport{
+			MaxIdleConns:        *concurrency,
+			MaxIdleConnsPerHost: *concurrency,
+		},
+	}
+
+	codes, err := createLoadLinks(client, base, *token, *links)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		return 1
+	}
+	fmt.Printf("created %d links, running %d workers for %s\n", len(codes), *concurrency, *duration)
+
+	deadline := time.Now().Add(*duration)
+	results := make([][]loadResult, *concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				code := codes[rand.IntN(len(codes))]
+				if rand.Float64() < *miss {
+					code = fmt.Sprintf("zz%06d", rand.IntN(1000000))
+				}
+				results[i] = append(results[i], loadRequest(client, base+"/"+code))
+			}
+		}(i)
+	}
+	start := time.Now()
+	wg.Wait()
+	printLoadReport(os.Stdout, results, time.Since(start))
+	return 0
+}
+
+// createLoadLinks shortens n distinct urls and returns their codes
+func createLoadLinks(client *http.Client, base, token string, n int) ([]string, error) {
+	run := time.Now().UnixNano()
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		body, _ := json.Marshal(ShortenRequest{URL: fmt.Sprintf("https://example.com/loadtest/%d/%d", run, i)})
+		req, err := http.NewRequest(http.MethodPost, base+"/api/v1/shorten", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var out ShortenResponse
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("creating link %d: %s: %s", i, resp.Status, bytes.TrimSpace(msg))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("creating link %d: %w", i, err)
+		}
+		if out.ShortCode == "" {
+			return nil, errors.New("shorten reply had no short_code")
+		}
+		codes = append(codes, out.ShortCode)
+	}
+	return codes, nil
+}
+
+func loadRequest(client *http.Client, target string) loadResult {
+	start := time.Now()
+	resp, err := client.Get(target)
+	if err != nil {
+		return loadResult{latency: time.Since(start)}
+	}
+	// drain so the connection goes back in the pool
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return loadResult{latency: time.Since(start), status: resp.StatusCode}
+}
+
+func printLoadReport(w io.Writer, results [][]loadResult, elapsed time.Duration) {
+	var latencies []time.Duration
+	statuses := map[int]int{}
+	failed := 0
+	for _, worker := range results {
+		for _, r := range worker {
+			latencies = append(latencies, r.latency)
+			if r.status == 0 {
+				failed++
+			} else {
+				statuses[r.status]++
+			}
+		}
+	}
+	if len(latencies) == 0 {
+		fmt.Fprintln(w, "no requests completed")
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Fprintf(w, "requests: %d in %s (%.0f/s), %d failed\n",
+		len(latencies), elapsed.Round(time.Millisecond), float64(len(latencies))/elapsed.Seconds(), failed)
+	fmt.Fprintf(w, "latency:  p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), latencies[len(latencies)-1].Round(time.Microsecond))
+
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %d: %d\n", code, statuses[code])
+	}
+}
+
+// percentile of an already sorted slice, nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	if i < 1 {
+		i = 1
+	}
+	return sorted[i-1].Round(time.Microsecond)
+}
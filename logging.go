@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// where the server's own log lines go. LOG_OUTPUTS takes any mix of
+// stderr (the default), stdout, file (LOG_FILE), syslog (SYSLOG_ADDR, empty
+// for the local daemon) and journald. syslog and journald stamp their own
+// time, so when only those are in use the timestamp prefix is dropped
+
+const (
+	logStderr   = "stderr"
+	logStdout   = "stdout"
+	logFile     = "file"
+	logSyslog   = "syslog"
+	logJournald = "journald"
+)
+
+// journald's native protocol socket
+const journalSocket = "/run/systemd/journal/socket"
+
+// setupLogging points the log package at every output in LOG_OUTPUTS. early
+// is whatever was logged to stderr before, it's copied to the other outputs
+func setupLogging(cfg *Config, early []byte) error {
+	var writers []io.Writer
+	stamped := false
+	for _, output := range cfg.LogOutputs {
+		var w io.Writer
+		switch strings.ToLower(output) {
+		case logStderr:
+			writers = append(writers, os.Stderr)
+			stamped = true
+			continue
+		case logStdout:
+			w, stamped = os.Stdout, true
+		case logFile:
+			if cfg.LogFile == "" {
+				return fmt.Errorf("LOG_OUTPUTS has file but LOG_FILE is not set")
+			}
+			f, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return err
+			}
+			w, stamped = f, true
+		case logSyslog:
+			sw, err := dialSyslog(cfg.SyslogAddr, cfg.LogTag)
+			if err != nil {
+				return fmt.Errorf("syslog: %w", err)
+			}
+			w = sw
+		case logJournald:
+			jw, err := dialJournal(journalSocket, cfg.LogTag)
+			if err != nil {
+				return fmt.Errorf("journald: %w", err)
+			}
+			w = jw
+		default:
+			return fmt.Errorf("unknown log output %q", output)
+		}
+		// a line at a time, syslog and journald make an entry of each write
+		for _, line := range bytes.SplitAfter(early, []byte("\n")) {
+			if len(line) > 0 {
+				w.Write(line)
+			}
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+
+	log.SetOutput(io.MultiWriter(writers...))
+	if !stamped {
+		log.SetFlags(0)
+	}
+	return nil
+}
+
+// syslogTarget splits SYSLOG_ADDR into what syslog.Dial wants. "udp://host:514"
+// and "tcp://host:601" pick the protocol, a bare host:port means udp and empty
+// is the local daemon
+func syslogTarget(addr string) (network, raddr string) {
+	if addr == "" {
+		return "", ""
+	}
+	if network, raddr, ok := strings.Cut(addr, "://"); ok {
+		return network, raddr
+	}
+	return "udp", addr
+}
+
+// journalWriter sends each log line to journald as one entry
+type journalWriter struct {
+	conn net.Conn
+	tag  string
+}
+
+func dialJournal(socket, tag string) (*journalWriter, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, err
+	}
+	return &journalWriter{conn: conn, tag: tag}, nil
+}
+
+func (j *journalWriter) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	journalField(&b, "PRIORITY", "6") // info, the log package has no levels
+	journalField(&b, "SYSLOG_IDENTIFIER", j.tag)
+	journalField(&b, "MESSAGE", strings.TrimSuffix(string(p), "\n"))
+	if _, err := j.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journalField writes one field in the native protocol. values with a
+// newline in them need the length prefixed form
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
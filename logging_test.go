@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalWriter(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("no unix datagram sockets here: %v", err)
+	}
+	defer ln.Close()
+
+	w, err := dialJournal(socket, "shortener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.conn.Close()
+
+	tests := []struct{ line, want string }{
+		{"server starting\n", "PRIORITY=6\nSYSLOG_IDENTIFIER=shortener\nMESSAGE=server starting\n"},
+		{"two\nlines\n", "PRIORITY=6\nSYSLOG_IDENTIFIER=shortener\nMESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n"},
+	}
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		if _, err := w.Write([]byte(tt.line)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := ln.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != tt.want {
+			t.Errorf("write %q sent %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSyslogTarget(t *testing.T) {
+	tests := []struct{ addr, network, raddr string }{
+		{"", "", ""},
+		{"logs.internal:514", "udp", "logs.internal:514"},
+		{"tcp://logs.internal:601", "tcp", "logs.internal:601"},
+		{"udp://10.0.0.5:514", "udp", "10.0.0.5:514"},
+	}
+	for _, tt := range tests {
+		if network, raddr := syslogTarget(tt.addr); network != tt.network || raddr != tt.raddr {
+			t.Errorf("syslogTarget(%q) = %q, %q, want %q, %q", tt.addr, network, raddr, tt.network, tt.raddr)
+		}
+	}
+}
@@ -1,20 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/patrickmn/go-cache"
 	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // URL struct for our data model - keeps it simple
@@ -23,7 +32,57 @@ type URL struct {
 	OriginalURL string    `json:"original_url"`
 	ShortCode   string    `json:"short_code"`
 	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"` // last write of any kind, clicks included - see conditional.go
 	ClickCount  int       `json:"click_count"`
+	Campaign    string    `json:"campaign,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+
+	Title       string `json:"title,omitempty"`       // free text so people can tell links apart later
+	Description string `json:"description,omitempty"` // longer notes, never shown to visitors
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // redirect answers 410 after this
+	Domain    string     `json:"domain,omitempty"`     // short domain the link was minted on, empty = BASE_URL
+	Namespace string     `json:"namespace,omitempty"`  // path prefix it answers under, see namespaces.go
+	Redirect  string     `json:"redirect,omitempty"`   // permanent (default), temporary, frame or meta, see cachecontrol.go
+
+	ClickWebhook string   `json:"click_webhook,omitempty"` // gets a POST on every click, see clickhook.go
+	Rule         string   `json:"rule,omitempty"`          // cel expression picking the destination per click, see rules.go
+	Rewrite      *Rewrite `json:"rewrite,omitempty"`       // turns /{code}/{rest} into a destination, see rewrite.go
+	Countries    *GeoRule `json:"countries,omitempty"`     // countries it redirects or refuses, see geoblock.go
+	Card         *LinkCard `json:"card,omitempty"`         // what it unfurls as when shared, see card.go
+	Headers      map[string]string `json:"headers,omitempty"` // extra response headers on the redirect, see headers.go
+	RedirectChain []string `json:"redirect_chain,omitempty"` // hops followed to the destination at creation, see unwrap.go
+	ChainFlags    []string `json:"chain_flags,omitempty"`    // shorteners and trackers among them
+	ArchiveURL   string   `json:"archive_url,omitempty"`   // wayback snapshot of the destination, see archive.go
+	Private      bool     `json:"private,omitempty"`       // redirects only with a login or an access url, see private.go
+	Interstitial bool     `json:"interstitial,omitempty"`  // a warning page before the destination, see interstitial.go
+
+	CreatedVia string `json:"created_via,omitempty"` // web or api
+	APIKeyID   string `json:"api_key_id,omitempty"`  // which key created it, if any
+	UserID     string `json:"user_id,omitempty"`     // which logged-in user created it, if any
+	OrgID      string `json:"org_id,omitempty"`      // org of whoever created it
+
+	Fields map[string]any `json:"fields,omitempty"` // org defined custom fields, see fields.go
+
+	BotClickCount     int `json:"bot_click_count,omitempty"`     // crawlers/tools, kept out of ClickCount
+	SuspectClickCount int `json:"suspect_click_count,omitempty"` // flagged by the fraud checks, kept out of ClickCount
+
+	// filled in by the background health checker
+	Health      string     `json:"health,omitempty"` // "ok" or "broken", empty until first check
+	LastStatus  int        `json:"last_status,omitempty"`
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	HealthError string     `json:"health_error,omitempty"`
+	FailCount   int        `json:"fail_count,omitempty"` // consecutive failed checks
+
+	TrashedAt *time.Time `json:"trashed_at,omitempty"` // deleted by its owner, answers 410 until restored or purged
+
+	Disabled       bool   `json:"disabled,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+
+	// submission abuse scoring - quarantined links wait for admin approval
+	Quarantined bool     `json:"quarantined,omitempty"`
+	RiskScore   float64  `json:"risk_score,omitempty"`
+	RiskReasons []string `json:"risk_reasons,omitempty"`
 }
 
 // response structs for api endpoints
@@ -31,27 +90,79 @@ type ShortenResponse struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
 	ShortCode   string `json:"short_code"`
-}
+	Created     bool   `json:"created"` // false when an existing code for the url was returned
 
-type ErrorResponse struct {
-	Error string `json:"error"`
+	PendingReview bool       `json:"pending_review,omitempty"` // held for admin approval, not live yet
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	ClaimToken    string     `json:"claim_token,omitempty"` // anonymous links only - see /api/links/{code}/claim
+	Warnings      []string   `json:"warnings,omitempty"`    // the link was made, but something about it is worth a look
 }
 
 // main app struct - holds db connection and cache
 type App struct {
-	DB    *bolt.DB
-	Cache *cache.Cache // in-memory cache for hot urls - way faster than hitting db everytime
+	DB     *bolt.DB
+	Cache  LinkCache // in-memory cache for hot urls - way faster than hitting db everytime
+	Config *Config
+
+	Outbound      *http.Client            // for anything that talks to destination sites
+	Lookups       *cache.Cache            // memoized outbound lookups (rdap etc)
+	Previews      LinkCache               // page metadata for /api/preview, size capped, see metadata.go
+	RiskProviders map[string]RiskProvider // route name -> captcha/abuse check
+	Blocklist     *prefixSet              // blocked client ranges, mirrored from the blocklist bucket
+	BotNets       *prefixSet              // known crawler ranges, clicks from these count as bots
+	CustomDomains *domainSet              // verified user domains, mirrored from the domains bucket
+	Namespaces    *namespaceSet           // path prefixes for links, mirrored from the namespaces bucket
+	Codes         *codeAlphabet           // what generated codes are made of, see codes.go
+	Flags         *flagSet                // runtime feature flags, mirrored from the flags bucket
+	Settings      *settingsBox            // admin settings, mirrored from the settings bucket
+	AccessLog     *rotatingFile           // ACCESS_LOG, nil when off - see accesslog.go
+	Metrics       *metrics                // statsd counters, nil when off - see metrics.go
+	Shadow        *shadowMirror           // SHADOW_URL, nil when off - see shadow.go
+	ClickHooks    *clickHooks             // rate limits and breakers for click webhooks
+	Fraud         *fraudWatch             // recent traffic per link, see fraud.go
+	Rules         *ruleCache              // compiled link rules
+	Clock         Clock                   // time for code generation and expiry, see clock.go
+	Random        RandomSource            // randomness for code generation
+	Clicks        sync.WaitGroup          // click writes still running, see trackClick
+	Favicons      sync.WaitGroup          // favicon fetches still running, see favicon.go
+	Archives      *archiveQueue           // destination snapshots, see archive.go
+	Mails         sync.WaitGroup          // account emails still sending, see accounts.go
+	MailLimits    *mailLimits             // how many account emails an address or ip may still ask for
+	Reload        func() error            // rereads the settings and swaps in a new app, see reload.go
+
+	Assets        fs.FS                         // templates and static files, see assets.go
+	Brand         *Brand                        // name and colors from THEME/BRAND_*, see theme.go
+	Pages         map[string]*template.Template // html pages by language, then file name
+	Catalogs      map[string]catalog            // translations by language, see i18n.go
+	MailTemplates *texttemplate.Template        // plain text emails (and robots.txt) by file name
+	Auth          []Authenticator               // tried in order on every request, see authChain
+	Webhooks      *http.Client                  // for org integrations - no redirects, no internal addresses
+
+	GlobalIntegrations []Integration // from NOTIFY_* settings, get every event
 }
 
 // base62 chars for encoding - same approach tinyurl uses
 const base62Chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-// generateShortCode creates unique short codes using md5 hash + base62 encoding
-// this approach prevents collisions better than just random strings
-func (app *App) generateShortCode(originalURL string) (string, error) {
+// newShortCode picks an unused code for a url, inside the caller's write
+// transaction so nothing can take it between the check and the put
+func (app *App) newShortCode(bucket *bolt.Bucket, originalURL string) string {
+	shortCode := app.hashShortCode(originalURL)
+	for attempt := 1; bucket.Get([]byte(shortCode)) != nil; attempt++ {
+		shortCode = app.hashShortCode(originalURL + "#" + strconv.Itoa(attempt))
+	}
+	return shortCode
+}
+
+// hashShortCode derives a candidate code from the url, the time and a random
+// number, callers still have to check it isn't taken
+func (app *App) hashShortCode(originalURL string) string {
 	// create hash from url + timestamp to ensure uniquness
 	hasher := md5.New()
-	hasher.Write([]byte(originalURL + fmt.Sprintf("%d", time.Now().UnixNano())))
+	hasher.Write([]byte(originalURL + fmt.Sprintf("%d-%d", app.now().UnixNano(), app.random().Uint64())))
+	if app.Codes.name != alphabetBase62 {
+		return app.Codes.encode(hasher.Sum(nil))
+	}
 	hash := hex.EncodeToString(hasher.Sum(nil))
 	
 	// convert first 8 chars of hash to base62 - gives us good distribution
@@ -62,30 +173,20 @@ func (app *App) generateShortCode(originalURL string) (string, error) {
 			shortCode += string(base62Chars[charIndex])
 		}
 	}
-	
-	// double check if this code already exists (very unlikely but safety first)
-	exists := false
-	err := app.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("urls"))
-		if bucket != nil {
-			v := bucket.Get([]byte(shortCode))
-			if v != nil {
-				exists = true
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return "", err
+	// CASE_INSENSITIVE_CODES, see codes.go
+	if app.Config.CaseInsensitiveCodes {
+		shortCode = strings.ToLower(shortCode)
 	}
-	
-	// if somehow we got collision, try again with different timestamp
-	if exists {
-		time.Sleep(time.Nanosecond) // tiny delay to change timestamp
-		return app.generateShortCode(originalURL)
+	return shortCode
+}
+
+// normalizeURL adds https:// if the scheme is missing - user friendly feature
+func normalizeURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if urlScheme(raw) == "" {
+		raw = "https://" + raw
 	}
-	
-	return shortCode, nil
+	return raw
 }
 
 // validates if url is properly formatted - basic but effective
@@ -94,126 +195,357 @@ func isValidURL(str string) bool {
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
 
-// handles POST /api/shorten - main endpoint for creating short urls
+// checkURLLength holds destinations to MAX_URL_LENGTH - each one is a key in the
+// reverse bucket and sits in the cache, so there has to be a ceiling
+func (app *App) checkURLLength(u string) error {
+	if max := app.Config.MaxURLLength; max > 0 && len(u) > max {
+		return fmt.Errorf("url is longer than %d characters", max)
+	}
+	return nil
+}
+
+// shortURL builds the public link for a code from the configured base url
+func (app *App) shortURL(shortCode string) string {
+	return app.Config.BaseURL + "/" + shortCode
+}
+
+// linkShortURL is shortURL but honours the link's own short domain and
+// namespace if it has them
+func (app *App) linkShortURL(u *URL) string {
+	code := u.ShortCode
+	if u.Namespace != "" {
+		code = u.Namespace + "/" + code
+	}
+	if u.Domain == "" {
+		return app.shortURL(code)
+	}
+	scheme, _, _ := strings.Cut(app.Config.BaseURL, "://")
+	return scheme + "://" + u.Domain + app.Config.PathPrefix + "/" + code
+}
+
+// cacheTTL keeps links with an expiry from outliving it in the cache
+func (app *App) cacheTTL(expiresAt *time.Time) time.Duration {
+	ttl := app.Config.CacheTTL
+	if expiresAt == nil {
+		return cache.DefaultExpiration
+	}
+	if left := expiresAt.Sub(app.now()); ttl <= 0 || left < ttl {
+		// go-cache treats negative durations as "never expire", so clamp
+		return max(left, time.Nanosecond)
+	}
+	return cache.DefaultExpiration
+}
+
+// clientIP pulls the caller's address out of RemoteAddr (no port)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handles POST /api/shorten - main endpoint for creating short urls. also
+// GET /api/shorten?url=, forms and text/plain bodies for tools that can't do json
 func (app *App) shortenHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	req, plain, err := decodeShortenRequest(w, r)
+	if err != nil {
+		var failed *shortenErr
+		if !errors.As(err, &failed) {
+			failed = &shortenErr{status: http.StatusBadRequest, code: errorInvalidRequest, msg: err.Error()}
+		}
+		shortenError(w, plain, failed)
 		return
 	}
 	
-	// parse json request
-	var req struct {
-		URL string `json:"url"`
+	resp, status, err := app.createLink(r, req)
+	if err != nil {
+		var failed *shortenErr
+		if !errors.As(err, &failed) {
+			failed = &shortenErr{status: http.StatusInternalServerError, code: errorServer, msg: "server error"}
+		}
+		shortenError(w, plain, failed)
+		return
 	}
+	if resp.Created {
+		app.Metrics.incr("shorten.created")
+	} else {
+		app.Metrics.incr("shorten.existing")
+	}
+	shortenReply(w, plain, status, resp)
+}
+
+// createLink does the work of a shorten request for whichever endpoint it came
+// in on - validation, dedupe, abuse scoring and the insert. failures come back
+// as *shortenErr with the status to answer with
+func (app *App) createLink(r *http.Request, req ShortenRequest) (ShortenResponse, int, error) {
+	// api keys can have saved defaults for anything the request leaves out
+	apiKey := apiKeyFromContext(r.Context())
+	if apiKey != nil {
+		req.fillFrom(apiKey.Defaults)
+	}
+	// then the admin's defaults for every link, see settings.go
+	req.fillFrom(app.linkDefaults())
 	
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid json"})
-		return
+	identity := identityFromContext(r.Context())
+	
+	// everything wrong with the request itself goes back in one answer, see validation.go
+	var problems fieldErrors
+	urlOK := false
+	if strings.TrimSpace(req.URL) == "" {
+		problems.missing("url")
+	} else {
+		// add http if missing - user friendly feature, then check the format and
+		// that its scheme is one we take
+		req.URL = normalizeURL(req.URL)
+		urlOK = !problems.add("url", app.validateDestination(req.URL))
+	}
+	optionsOK := !problems.add("", app.validateOptions(&req.ShortenOptions))
+	title, description, err := normalizeNotes(req.Title, req.Description)
+	problems.add("", err)
+	rule, err := app.normalizeRule(req.Rule)
+	problems.add("rule", err)
+	rewrite, err := app.validateRewrite(r.Context(), req.Rewrite)
+	problems.add("rewrite", err)
+	countries, err := app.validateGeoRule(req.Countries)
+	problems.add("countries", err)
+	card, err := validateCard(req.Card)
+	problems.add("card", err)
+	headers, err := app.validateLinkHeaders(req.Headers)
+	problems.add("headers", err)
+	// a custom code always gets its own link, see vanity.go
+	req.Code = strings.TrimSpace(req.Code)
+	if app.Config.CaseInsensitiveCodes {
+		req.Code = strings.ToLower(req.Code)
+	}
+	if req.Code != "" {
+		problems.add("code", app.validateCustomCode(req.Code))
+	}
+	// custom fields are checked against the org's schema
+	fields, err := app.checkFields(identity.OrgID(), req.Fields)
+	problems.add("fields", err)
+	given := req.URL
+	if urlOK && optionsOK {
+		req.URL = req.applyUTM(req.URL)
+		problems.add("url", app.checkURLLength(req.URL))
+		problems.add("redirect", checkCloak(req.Redirect, req.URL))
+	}
+	if len(problems) > 0 {
+		return ShortenResponse{}, 0, &shortenErr{status: http.StatusBadRequest, problems: problems, msg: problems.Error()}
 	}
 	
-	// add http if missing - user friendly feature
-	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
-		req.URL = "https://" + req.URL
+	if err := app.checkDomain(identity, req.Domain); err != nil {
+		return ShortenResponse{}, 0, &shortenErr{status: http.StatusForbidden, code: errorForbidden, field: "domain", msg: err.Error()}
+	}
+	if err := app.checkNamespace(identity, req.Namespace); err != nil {
+		return ShortenResponse{}, 0, &shortenErr{status: http.StatusForbidden, code: errorForbidden, field: "namespace", msg: err.Error()}
+	}
+	// last, since it can go out to dns and the safe browsing api
+	if err := app.checkDestination(r.Context(), req.URL, true); err != nil {
+		return ShortenResponse{}, 0, &shortenErr{status: http.StatusBadRequest, code: errorDestination, field: "url", msg: err.Error()}
 	}
 	
-	// validate the url format
-	if !isValidURL(req.URL) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid url format"})
-		return
+	// the destination's own redirects are only followed for a request that's
+	// otherwise good, it's a round trip per hop. where they end is checked the
+	// same way as a url given to us
+	var unwrapped unwrapResult
+	if req.FollowRedirects {
+		unwrapped = app.unwrap(r.Context(), given)
+	}
+	if len(unwrapped.Chain) > 0 {
+		req.URL = req.applyUTM(unwrapped.URL)
+		problems.add("url", app.checkURLLength(req.URL))
+		problems.add("redirect", checkCloak(req.Redirect, req.URL))
+		if len(problems) > 0 {
+			return ShortenResponse{}, 0, &shortenErr{status: http.StatusBadRequest, problems: problems, msg: problems.Error()}
+		}
+		if err := app.checkDestination(r.Context(), req.URL, true); err != nil {
+			return ShortenResponse{}, 0, &shortenErr{status: http.StatusBadRequest, code: errorDestination, field: "url", msg: err.Error()}
+		}
 	}
 	
-	// check if we already have this url shortened - avoid duplicates
-	var existingCode string
-	err := app.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("reverse"))
-		if bucket != nil {
-			v := bucket.Get([]byte(req.URL))
-			if v != nil {
-				existingCode = string(v)
-			}
+	// fast path for urls we've already shortened - skips scoring and the write lock.
+	// the insert below checks again, so this is only an optimization. only the
+	// caller's own links count, see linkOwner
+	owner := ""
+	if identity.User != nil {
+		owner = linkOwner(identity.User.ID, "")
+	} else if apiKey != nil {
+		owner = linkOwner("", apiKey.ID)
+	}
+	var existing *URL
+	err = app.DB.View(func(tx *bolt.Tx) error {
+		if req.Code == "" && req.reuses() {
+			existing = liveLinkTx(tx, owner, req.Domain, req.Namespace, req.URL, app.now())
 		}
 		return nil
 	})
-	if err == nil && existingCode != "" {
-		// found existing, return it instead of creating new one
-		shortURL := fmt.Sprintf("http://localhost:8080/%s", existingCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ShortenResponse{
-			ShortURL:    shortURL,
-			OriginalURL: req.URL,
-			ShortCode:   existingCode,
-		})
-		return
+	if err == nil && existing != nil {
+		resp := app.existingResponse(existing)
+		resp.Warnings = unwrapped.Warnings
+		return resp, http.StatusOK, nil
 	}
 	
-	// generate new short code
-	shortCode, err := app.generateShortCode(req.URL)
-	if err != nil {
-		log.Printf("error generating short code: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "server error"})
-		return
+	// score the submission - risky ones get held for review instead of going live
+	var risk SubmissionRisk
+	quarantine := false
+	if app.Config.AbuseQuarantineScore > 0 && app.flagOn(flagAbuseScoring, clientIP(r)) {
+		risk = app.scoreSubmission(r.Context(), req.URL, app.Config.AbuseNetworkChecks)
+		quarantine = risk.Score >= app.Config.AbuseQuarantineScore
+		if quarantine {
+			log.Printf("quarantining submission from %s (score %.2f): %s", clientIP(r), risk.Score, strings.Join(risk.Reasons, ", "))
+		}
+	}
+	
+	// store url data as json - the short code is picked inside the transaction
+	now := app.now()
+	urlData := URL{
+		OriginalURL:  req.URL,
+		CreatedAt:    now,
+		ClickCount:   0,
+		Campaign:     req.Campaign,
+		Tags:         req.Tags,
+		Title:        title,
+		Description:  description,
+		ExpiresAt:    req.expiresAt(now),
+		Domain:       req.Domain,
+		Namespace:    req.Namespace,
+		Redirect:     req.Redirect,
+		ClickWebhook: req.ClickWebhook,
+		Rule:         rule,
+		Rewrite:      rewrite,
+		Countries:    countries,
+		Card:         card,
+		Headers:      headers,
+		RedirectChain: unwrapped.Chain,
+		ChainFlags:   unwrapped.Flagged,
+		Private:      req.private(),
+		Interstitial: req.interstitial(),
+		Fields:       fields,
+		Quarantined:  quarantine,
+		RiskScore:    risk.Score,
+		RiskReasons:  risk.Reasons,
+		CreatedVia:   sourceWeb,
+	}
+	if apiKey != nil {
+		urlData.CreatedVia = sourceAPI
+		urlData.APIKeyID = apiKey.ID
 	}
+	if identity.User != nil {
+		urlData.UserID = identity.User.ID
+	}
+	urlData.OrgID = identity.OrgID()
 	
-	// save to database 
+	// dedupe check and insert in one write transaction, so two requests for the
+	// same url can't both miss the reverse lookup and mint two codes
+	var claimToken string
 	err = app.DB.Update(func(tx *bolt.Tx) error {
+		if req.Code == "" && req.reuses() {
+			if existing = liveLinkTx(tx, owner, req.Domain, req.Namespace, req.URL, app.now()); existing != nil {
+				return nil
+			}
+		}
+		
 		bucket, err := tx.CreateBucketIfNotExists([]byte("urls"))
 		if err != nil {
 			return err
 		}
-		
-		// store url data as json
-		urlData := URL{
-			OriginalURL: req.URL,
-			ShortCode:   shortCode,
-			CreatedAt:   time.Now(),
-			ClickCount:  0,
+		urlData.ShortCode = req.Code
+		if req.Code == "" {
+			urlData.ShortCode = app.newShortCode(bucket, req.URL)
+		} else if bucket.Get([]byte(req.Code)) != nil {
+			return errCodeTaken
 		}
 		
-		urlJSON, err := json.Marshal(urlData)
+		urlJSON, err := sealJSON(tx, urlData)
 		if err != nil {
 			return err
 		}
 		
 		// store short code -> url data
-		err = bucket.Put([]byte(shortCode), urlJSON)
+		err = bucket.Put([]byte(urlData.ShortCode), urlJSON)
 		if err != nil {
 			return err
 		}
 		
-		// also store reverse mapping for duplicate detection
+		// also store reverse mapping for duplicate detection - custom codes and
+		// reuse: false links stay out of it, plain requests for the url keep
+		// getting the shared generated one
 		reverseBucket, err := tx.CreateBucketIfNotExists([]byte("reverse"))
 		if err != nil {
 			return err
 		}
 		
-		return reverseBucket.Put([]byte(req.URL), []byte(shortCode))
+		if req.Code == "" && req.reuses() {
+			err = reverseBucket.Put(reverseKey(tx, linkOwner(urlData.UserID, urlData.APIKeyID), req.Domain, req.Namespace, req.URL), []byte(urlData.ShortCode))
+			if err != nil {
+				return err
+			}
+		}
+		
+		// nobody owns it yet - hand out a token so it can be claimed after signing up
+		if identity.Anonymous() {
+			if claimToken, err = newClaimToken(tx, urlData.ShortCode); err != nil {
+				return err
+			}
+		}
+		
+		// and let sync clients know
+		if err := appendChange(tx, syncEntry(&urlData)); err != nil {
+			return err
+		}
+		
+		entry := requestActor(r)
+		entry.Action, entry.ShortCode, entry.New = "create", urlData.ShortCode, linkFields(&urlData)
+		return auditTx(tx, entry)
 	})
 	
+	if errors.Is(err, errCodeTaken) {
+		return ShortenResponse{}, 0, &shortenErr{status: http.StatusConflict, code: errorCodeTaken, field: "code", msg: err.Error()}
+	}
 	if err != nil {
 		log.Printf("database insert error: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to save url"})
-		return
+		return ShortenResponse{}, 0, &shortenErr{status: http.StatusInternalServerError, code: errorServer, msg: "failed to save url"}
 	}
 	
-	// cache the new url for fast access later
-	app.Cache.Set(shortCode, req.URL, cache.DefaultExpiration)
+	// lost the race to another request for the same url - theirs wins
+	if existing != nil {
+		resp := app.existingResponse(existing)
+		resp.Warnings = unwrapped.Warnings
+		return resp, http.StatusOK, nil
+	}
 	
-	// return success response
-	shortURL := fmt.Sprintf("http://localhost:8080/%s", shortCode)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(ShortenResponse{
-		ShortURL:    shortURL,
-		OriginalURL: req.URL,
-		ShortCode:   shortCode,
-	})
+	// return success response - 202 when it still needs an admin to approve it
+	status := http.StatusOK
+	if quarantine {
+		status = http.StatusAccepted
+	} else {
+		// cache the new url for fast access later
+		app.cacheLink(&urlData)
+		app.archiveLink(&urlData)
+		go app.notify(urlData.OrgID, app.linkCreatedEvent(&urlData))
+	}
+	return ShortenResponse{
+		ShortURL:      app.linkShortURL(&urlData),
+		OriginalURL:   req.URL,
+		ShortCode:     urlData.ShortCode,
+		Created:       true,
+		PendingReview: quarantine,
+		ExpiresAt:     urlData.ExpiresAt,
+		ClaimToken:    claimToken,
+		Warnings:      append(app.codeWarnings(req.Code), unwrapped.Warnings...),
+	}, status, nil
+}
+
+// existingResponse is what a shorten call gets when the url already has a live code
+func (app *App) existingResponse(existing *URL) ShortenResponse {
+	return ShortenResponse{
+		ShortURL:      app.linkShortURL(existing),
+		OriginalURL:   existing.OriginalURL,
+		ShortCode:     existing.ShortCode,
+		PendingReview: existing.Quarantined,
+		ExpiresAt:     existing.ExpiresAt,
+	}
 }
 
 // handles GET /{shortCode} - redirects to original url
@@ -226,222 +558,128 @@ func (app *App) redirectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// grab what we need from the request now - crawlers and tools get the
+	// redirect but shouldn't inflate the human click count
+	click := app.newClick(r)
+	
+	// HEAD is link checkers and chat apps preflighting, not a visit, and
+	// neither is a chat app building its preview, see unfurl.go
+	track := (r.Method != http.MethodHead || app.Config.CountHeadRequests) && app.countsUnfurl(r)
+	
 	// try cache first - much faster than db lookup
-	if originalURL, found := app.Cache.Get(shortCode); found {
+	// the cache expires entries on the wall clock, so the link's own expiry is
+	// checked against the app clock too - an expired one falls through to the 410
+	if cached, found := app.Cache.Get(shortCode); found && !cached.(cachedLink).expired(app.now()) {
+		link := cached.(cachedLink)
+		app.Metrics.incr("cache.hit")
+		if !app.servesLink(r, link.Domain) || !inNamespace(r, link.Namespace) {
+			http.NotFound(w, r)
+			return
+		}
+		if link.Private && !app.allowPrivate(w, r, shortCode) {
+			return
+		}
+		if !link.Countries.allows(click.Country) {
+			http.Error(w, "this link is not available in your country", http.StatusUnavailableForLegalReasons)
+			return
+		}
+		
 		// increment click counter in background - dont make user wait
-		go func() {
-			app.DB.Update(func(tx *bolt.Tx) error {
-				bucket := tx.Bucket([]byte("urls"))
-				if bucket != nil {
-					v := bucket.Get([]byte(shortCode))
-					if v != nil {
-						var urlData URL
-						if json.Unmarshal(v, &urlData) == nil {
-							urlData.ClickCount++
-							if updatedJSON, err := json.Marshal(urlData); err == nil {
-								bucket.Put([]byte(shortCode), updatedJSON)
-							}
-						}
-					}
-				}
-				return nil
-			})
-		}()
+		link, ok := app.applyRewrite(r, link)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		link = app.applyRule(r, shortCode, link, click)
+		if track {
+			app.trackClick(shortCode, click)
+			app.clickWebhook(shortCode, link, click)
+		}
 		
-		http.Redirect(w, r, originalURL.(string), http.StatusMovedPermanently)
+		app.redirect(w, r, link)
 		return
 	}
 	
 	// not in cache, check database
-	var originalURL string
+	app.Metrics.incr("cache.miss")
+	var urlData *URL
 	err := app.DB.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("urls"))
 		if bucket != nil {
 			v := bucket.Get([]byte(shortCode))
 			if v != nil {
-				var urlData URL
-				if json.Unmarshal(v, &urlData) == nil {
-					originalURL = urlData.OriginalURL
+				var u URL
+				if openJSON(tx, v, &u) == nil && !u.Disabled {
+					urlData = &u
 				}
 			}
 		}
 		return nil
 	})
 	
-	if err != nil || originalURL == "" {
+	if err != nil || urlData == nil || !app.servesLink(r, urlData.Domain) || !inNamespace(r, urlData.Namespace) {
 		http.NotFound(w, r)
 		return
 	}
 	
+	// held for admin review - don't serve (or cache) it yet
+	if urlData.Quarantined {
+		http.Error(w, "this link is pending review", http.StatusForbidden)
+		return
+	}
+	
+	if urlData.TrashedAt != nil {
+		http.Error(w, "this link has been deleted", http.StatusGone)
+		return
+	}
+	
+	if urlData.ExpiresAt != nil && !urlData.ExpiresAt.After(app.now()) {
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+	
 	// add to cache for next time
-	app.Cache.Set(shortCode, originalURL, cache.DefaultExpiration)
+	app.cacheLink(urlData)
+	
+	if urlData.Private && !app.allowPrivate(w, r, shortCode) {
+		return
+	}
+	if !urlData.Countries.allows(click.Country) {
+		http.Error(w, "this link is not available in your country", http.StatusUnavailableForLegalReasons)
+		return
+	}
+	
+	link, ok := app.applyRewrite(r, toCachedLink(urlData))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 	
 	// increment click counter in background
-	go func() {
-		app.DB.Update(func(tx *bolt.Tx) error {
-			bucket := tx.Bucket([]byte("urls"))
-			if bucket != nil {
-				v := bucket.Get([]byte(shortCode))
-				if v != nil {
-					var urlData URL
-					if json.Unmarshal(v, &urlData) == nil {
-						urlData.ClickCount++
-						if updatedJSON, err := json.Marshal(urlData); err == nil {
-							bucket.Put([]byte(shortCode), updatedJSON)
-						}
-					}
-				}
-			}
-			return nil
-		})
-	}()
+	link = app.applyRule(r, shortCode, link, click)
+	if track {
+		app.trackClick(shortCode, click)
+		app.clickWebhook(shortCode, link, click)
+	}
 	
-	http.Redirect(w, r, originalURL, http.StatusMovedPermanently)
+	app.redirect(w, r, link)
 }
 
 // serves the main html page
 func (app *App) indexHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := `<!DOCTYPE html>
-<html>
-<head>
-    <title>LinkFast</title>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        body { 
-            font-family: Arial, sans-serif;
-            background: #f5f5f5;
-            min-height: 100vh;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-        }
-        .container {
-            background: white;
-            padding: 30px;
-            border-radius: 8px;
-            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
-            width: 100%;
-            max-width: 400px;
-        }
-        h1 {
-            text-align: center;
-            color: #333;
-            margin-bottom: 20px;
-            font-size: 24px;
-        }
-        input {
-            width: 100%;
-            padding: 12px;
-            border: 1px solid #ddd;
-            border-radius: 4px;
-            font-size: 16px;
-            margin-bottom: 15px;
-        }
-        button {
-            width: 100%;
-            padding: 12px;
-            background: #007bff;
-            color: white;
-            border: none;
-            border-radius: 4px;
-            font-size: 16px;
-            cursor: pointer;
-        }
-        button:hover {
-            background: #0056b3;
-        }
-        .result {
-            margin-top: 15px;
-            padding: 15px;
-            background: #f8f9fa;
-            border-radius: 4px;
-            display: none;
-        }
-        .result.show { display: block; }
-        .short-url {
-            color: #007bff;
-            font-weight: bold;
-            word-break: break-all;
-        }
-        .error {
-            color: #dc3545;
-            margin-top: 10px;
-        }
-        .copy-btn {
-            margin-top: 10px;
-            padding: 8px 16px;
-            background: #28a745;
-            font-size: 14px;
-            width: auto;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>LinkFast</h1>
-        <input type="url" id="urlInput" placeholder="Enter URL to shorten">
-        <button onclick="shortenUrl()">Shorten</button>
-        <div id="result" class="result">
-            <p>Short URL: <span class="short-url" id="shortUrl"></span></p>
-            <button class="copy-btn" onclick="copyToClipboard()">Copy</button>
-        </div>
-        <div id="error" class="error"></div>
-    </div>
-
-    <script>
-        async function shortenUrl() {
-            const url = document.getElementById('urlInput').value;
-            const errorDiv = document.getElementById('error');
-            const resultDiv = document.getElementById('result');
-            
-            errorDiv.textContent = '';
-            resultDiv.classList.remove('show');
-            
-            if (!url) {
-                errorDiv.textContent = 'Please enter a URL';
-                return;
-            }
-            
-            try {
-                const response = await fetch('/api/shorten', {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ url: url })
-                });
-                
-                const data = await response.json();
-                
-                if (response.ok) {
-                    document.getElementById('shortUrl').textContent = data.short_url;
-                    resultDiv.classList.add('show');
-                } else {
-                    errorDiv.textContent = data.error || 'Error occurred';
-                }
-            } catch (error) {
-                errorDiv.textContent = 'Network error';
-            }
-        }
-        
-        function copyToClipboard() {
-            const shortUrl = document.getElementById('shortUrl').textContent;
-            navigator.clipboard.writeText(shortUrl).then(() => {
-                const btn = document.querySelector('.copy-btn');
-                btn.textContent = 'Copied!';
-                setTimeout(() => btn.textContent = 'Copy', 2000);
-            });
-        }
-        
-        document.getElementById('urlInput').addEventListener('keypress', function(e) {
-            if (e.key === 'Enter') shortenUrl();
-        });
-    </script>
-</body>
-</html>`
-	
 	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprint(w, tmpl)
+	csrf := csrfFor(r)
+	if csrf != "" {
+		// the token belongs to this visitor's session
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	err := app.page(r).ExecuteTemplate(w, "index.html", map[string]any{
+		"Captcha": app.captchaWidget("shorten"),
+		"CSRF":    csrf,
+	})
+	if err != nil {
+		log.Printf("index render error: %v", err)
+	}
 }
 
 // database setup function
@@ -456,16 +694,332 @@ func setupDatabase(db *bolt.DB) error {
 		
 		// bucket for reverse mapping (original url -> short code)
 		_, err = tx.CreateBucketIfNotExists([]byte("reverse"))
+		if err != nil {
+			return err
+		}
+		
+		// api keys, keyed by sha256 of the key
+		_, err = tx.CreateBucketIfNotExists([]byte("apikeys"))
+		if err != nil {
+			return err
+		}
+		
+		// blocked client ip ranges, keyed by cidr
+		_, err = tx.CreateBucketIfNotExists([]byte("blocklist"))
 		return err
 	})
 }
 
+// newApp wires up everything the server needs around an open database. opts
+// replace the clock or randomness, see clock.go
+func newApp(db *bolt.DB, config *Config, opts ...AppOption) (*App, error) {
+	// captcha/abuse checks are opt-in per route via CAPTCHA_ROUTES
+	riskProviders, err := newRiskProviders(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid captcha config: %w", err)
+	}
+	integrations, err := globalIntegrations(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := validAnalyticsLevel(config.AnalyticsLevel); err != nil {
+		return nil, fmt.Errorf("invalid ANALYTICS_LEVEL: %w", err)
+	}
+	if err := validUnfurlMode(config.UnfurlMode); err != nil {
+		return nil, fmt.Errorf("invalid UNFURL_MODE: %w", err)
+	}
+	if err := validRobots(config.RobotsTxt); err != nil {
+		return nil, fmt.Errorf("invalid ROBOTS_TXT: %w", err)
+	}
+	codes, err := newCodeAlphabet(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CODE_ALPHABET: %w", err)
+	}
+	
+	// create app instance
+	app := &App{
+		DB:            db,
+		Config:        config,
+		Outbound:      newOutboundClient(10*time.Second, config.AllowPrivateDestinations),
+		Webhooks:      newWebhookClient(10*time.Second, config.IntegrationsAllowPrivate),
+		RiskProviders: riskProviders,
+		Blocklist:     &prefixSet{},
+		BotNets:       &prefixSet{},
+		CustomDomains: &domainSet{},
+		Namespaces:    &namespaceSet{},
+		Codes:         codes,
+		Flags:         &flagSet{},
+		Settings:      &settingsBox{},
+		Assets:        newAssets(config.AssetsDir),
+		Clock:         systemClock{},
+		Random:        systemRandom{},
+
+		GlobalIntegrations: integrations,
+	}
+	app.apply(opts...)
+	app.Auth = app.authChain()
+	
+	// redirect cache - 5 minute expiry and a sweep every 10 by default, see CACHE_*
+	// this will keep hot urls super fast to access. a reload hands the old one over
+	if app.Cache == nil {
+		app.Cache = newLinkCache(config)
+	}
+	if app.Lookups == nil {
+		app.Lookups = newLookupCache()
+	}
+	if app.Previews == nil {
+		app.Previews = newLRUCache(maxCachedPreviews, time.Hour, 10*time.Minute)
+	}
+	if app.Fraud == nil {
+		app.Fraud = newFraudWatch()
+	}
+	if app.ClickHooks == nil {
+		app.ClickHooks = newClickHooks()
+	}
+	if app.Rules == nil {
+		app.Rules = newRuleCache()
+	}
+	if app.Archives == nil {
+		app.Archives = newArchiveQueue()
+	}
+	if app.MailLimits == nil {
+		app.MailLimits = newMailLimits()
+	}
+	if app.Metrics == nil && config.StatsDAddr != "" {
+		app.Metrics, err = newMetrics(config, func() map[string]int64 {
+			return map[string]int64{"cache.entries": int64(app.Cache.ItemCount())}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up statsd: %w", err)
+		}
+	}
+	if app.AccessLog == nil && config.AccessLog != "" {
+		app.AccessLog, err = openRotatingFile(config.AccessLog, int64(config.AccessLogMaxMB)<<20, config.AccessLogRotate, config.AccessLogKeep)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+	}
+	if config.ShadowURL != "" {
+		app.Shadow, err = newShadowMirror(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up shadowing: %w", err)
+		}
+	}
+	
+	if config.BotIPFile != "" {
+		botNets, err := loadBotNets(config.BotIPFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load bot ip list: %w", err)
+		}
+		app.BotNets.set(botNets)
+	}
+	
+	if err := app.loadBlocklist(); err != nil {
+		return nil, fmt.Errorf("failed to load blocklist: %w", err)
+	}
+	if err := app.loadCustomDomains(); err != nil {
+		return nil, fmt.Errorf("failed to load custom domains: %w", err)
+	}
+	if err := app.loadFlags(); err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	if err := app.loadSettings(); err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+	if err := app.checkTOTPKey(); err != nil {
+		return nil, err
+	}
+	if err := app.loadNamespaces(); err != nil {
+		return nil, fmt.Errorf("failed to load namespaces: %w", err)
+	}
+	if err := app.loadTemplates(); err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+	return app, nil
+}
+
+// routes builds the router with every endpoint and middleware, under PATH_PREFIX
+func (app *App) routes() http.Handler {
+	// names are what CAPTCHA_ROUTES refers to
+	r := mux.NewRouter()
+	
+	// SHORT_DOMAINS only answer for links - matched first so the api and ui
+	// stay on the main host
+	short := r.MatcherFunc(app.onShortDomain).Subrouter()
+	short.HandleFunc("/", app.shortDomainRootHandler).Methods("GET").Name("short-domain-root")
+	app.linkRoutes(short)
+	short.PathPrefix("/").HandlerFunc(http.NotFound)
+	
+	r.HandleFunc("/", app.indexHandler).Methods("GET").Name("index")
+	// the api is versioned by path, see versions.go
+	api := r.PathPrefix("/api/" + apiVersion).Subrouter()
+	api.NotFoundHandler = apiFallback(api)
+	api.MethodNotAllowedHandler = api.NotFoundHandler
+	api.HandleFunc("/shorten", app.idempotent(app.shortenHandler)).Methods("GET", "POST").Name("shorten")
+	api.HandleFunc("/shorten/batch", app.idempotent(app.batchShortenHandler)).Methods("POST").Name("shorten-batch")
+	api.HandleFunc("/preview", app.require(authIdentified, app.urlMetadataHandler)).Methods("GET").Name("url-preview")
+	api.HandleFunc("/suggest", app.suggestHandler).Methods("GET").Name("suggest")
+	api.HandleFunc("/resolve", app.require(authIdentified, app.resolveHandler)).Methods("POST").Name("resolve")
+	api.HandleFunc("/resolve/stream", app.require(authIdentified, app.resolveStreamHandler)).Methods("POST").Name("resolve-stream")
+	api.HandleFunc("/graphql", app.require(authIdentified, app.graphqlHandler)).Methods("GET", "POST").Name("graphql")
+	api.HandleFunc("/graphql/schema", app.graphqlSchemaHandler).Methods("GET").Name("graphql-schema")
+	api.HandleFunc("/ephemeral", app.require(authIdentified, app.ephemeralHandler)).Methods("POST").Name("ephemeral")
+	api.HandleFunc("/keys/me/defaults", app.keyDefaultsHandler).Methods("GET", "PUT", "DELETE").Name("key-defaults")
+	api.HandleFunc("/sync", app.require(authIdentified, app.syncHandler)).Methods("GET").Name("sync")
+	api.HandleFunc("/links", app.require(authIdentified, app.listLinksHandler)).Methods("GET").Name("links")
+	api.HandleFunc("/export/qr", app.require(authAdmin, app.qrExportHandler)).Methods("GET").Name("export-qr")
+	api.HandleFunc("/admin/quarantine", app.require(authAdmin, app.quarantineListHandler)).Methods("GET").Name("quarantine")
+	api.HandleFunc("/admin/quarantine/{shortCode}/{action:approve|reject}", app.require(authAdmin, app.quarantineActionHandler)).Methods("POST").Name("quarantine-action")
+	api.HandleFunc("/admin/purge", app.require(authAdmin, app.purgeDomainHandler)).Methods("POST").Name("purge-domain")
+	api.HandleFunc("/admin/reload", app.require(authAdmin, app.reloadHandler)).Methods("POST").Name("reload")
+	api.HandleFunc("/admin/flags", app.require(authAdmin, app.listFlagsHandler)).Methods("GET").Name("flags")
+	api.HandleFunc("/admin/flags/{name}", app.require(authAdmin, app.setFlagHandler)).Methods("PUT").Name("flag-set")
+	api.HandleFunc("/admin/flags/{name}", app.require(authAdmin, app.resetFlagHandler)).Methods("DELETE").Name("flag-reset")
+	api.HandleFunc("/admin/settings", app.require(authAdmin, app.settingsHandler)).Methods("GET", "PUT", "DELETE").Name("admin-settings")
+	api.HandleFunc("/admin/namespaces", app.require(authAdmin, app.listNamespacesHandler)).Methods("GET").Name("namespaces")
+	api.HandleFunc("/admin/namespaces/{name}", app.require(authAdmin, app.putNamespaceHandler)).Methods("PUT").Name("namespace-put")
+	api.HandleFunc("/admin/namespaces/{name}", app.require(authAdmin, app.deleteNamespaceHandler)).Methods("DELETE").Name("namespace-delete")
+	api.HandleFunc("/auth/login", app.loginHandler).Methods("POST").Name("login")
+	api.HandleFunc("/auth/refresh", app.refreshHandler).Methods("POST").Name("refresh")
+	api.HandleFunc("/auth/logout", app.logoutHandler).Methods("POST").Name("logout")
+	api.HandleFunc("/auth/verify-email", app.verifyEmailHandler).Methods("POST").Name("verify-email")
+	api.HandleFunc("/auth/verify-email/send", app.require(authIdentified, app.sendVerificationHandler)).Methods("POST").Name("verify-email-send")
+	api.HandleFunc("/auth/password-reset", app.requestResetHandler).Methods("POST").Name("password-reset")
+	api.HandleFunc("/auth/password-reset/confirm", app.confirmResetHandler).Methods("POST").Name("password-reset-confirm")
+	api.HandleFunc("/me/settings", app.require(authIdentified, app.getSettingsHandler)).Methods("GET").Name("settings")
+	api.HandleFunc("/me/settings", app.require(authIdentified, app.putSettingsHandler)).Methods("PUT").Name("settings-update")
+	api.HandleFunc("/me/2fa/setup", app.require(authIdentified, app.twoFactorSetupHandler)).Methods("POST").Name("2fa-setup")
+	api.HandleFunc("/me/2fa/enable", app.require(authIdentified, app.twoFactorEnableHandler)).Methods("POST").Name("2fa-enable")
+	api.HandleFunc("/me/2fa/disable", app.require(authIdentified, app.twoFactorDisableHandler)).Methods("POST").Name("2fa-disable")
+	api.HandleFunc("/me/2fa/recovery-codes", app.require(authIdentified, app.recoveryCodesHandler)).Methods("POST").Name("2fa-recovery-codes")
+	api.HandleFunc("/admin/users", app.require(authAdmin, app.listUsersHandler)).Methods("GET").Name("admin-users")
+	api.HandleFunc("/admin/users", app.require(authAdmin, app.createUserHandler)).Methods("POST").Name("admin-users-create")
+	api.HandleFunc("/admin/users/{id}", app.require(authAdmin, app.deleteUserHandler)).Methods("DELETE").Name("admin-users-delete")
+	api.HandleFunc("/admin/orgs", app.require(authAdmin, app.listOrgsHandler)).Methods("GET").Name("admin-orgs")
+	api.HandleFunc("/admin/orgs", app.require(authAdmin, app.createOrgHandler)).Methods("POST").Name("admin-orgs-create")
+	api.HandleFunc("/orgs/{orgID}/fields", app.require(authIdentified, app.getFieldsHandler)).Methods("GET").Name("fields")
+	api.HandleFunc("/orgs/{orgID}/fields", app.require(authIdentified, app.putFieldsHandler)).Methods("PUT").Name("fields-update")
+	api.HandleFunc("/orgs/{orgID}/integrations", app.require(authIdentified, app.listIntegrationsHandler)).Methods("GET").Name("integrations")
+	api.HandleFunc("/orgs/{orgID}/integrations", app.require(authIdentified, app.createIntegrationHandler)).Methods("POST").Name("integrations-create")
+	api.HandleFunc("/orgs/{orgID}/integrations/validate", app.require(authIdentified, app.validateIntegrationHandler)).Methods("POST").Name("integrations-validate")
+	api.HandleFunc("/orgs/{orgID}/integrations/{id}", app.require(authIdentified, app.updateIntegrationHandler)).Methods("PUT").Name("integrations-update")
+	api.HandleFunc("/orgs/{orgID}/integrations/{id}", app.require(authIdentified, app.deleteIntegrationHandler)).Methods("DELETE").Name("integrations-delete")
+	api.HandleFunc("/orgs/{orgID}/integrations/{id}/test", app.require(authIdentified, app.testIntegrationHandler)).Methods("POST").Name("integrations-test")
+	api.HandleFunc("/campaigns", app.require(authIdentified, app.listCampaignsHandler)).Methods("GET").Name("campaigns")
+	api.HandleFunc("/campaigns/{name}", app.require(authIdentified, app.campaignHandler)).Methods("GET").Name("campaign")
+	api.HandleFunc("/campaigns/{name}/transfer", app.require(authIdentified, app.transferCampaignHandler)).Methods("POST").Name("campaign-transfer")
+	api.HandleFunc("/domains", app.require(authIdentified, app.listDomainsHandler)).Methods("GET").Name("domains")
+	api.HandleFunc("/domains", app.require(authIdentified, app.createDomainHandler)).Methods("POST").Name("domains-create")
+	api.HandleFunc("/domains/{domain}/verify", app.require(authIdentified, app.verifyDomainHandler)).Methods("POST").Name("domains-verify")
+	api.HandleFunc("/domains/{domain}", app.require(authIdentified, app.deleteDomainHandler)).Methods("DELETE").Name("domains-delete")
+	api.HandleFunc("/admin/keys", app.require(authAdmin, app.listAPIKeysHandler)).Methods("GET").Name("keys")
+	api.HandleFunc("/admin/keys", app.require(authAdmin, app.createAPIKeyHandler)).Methods("POST").Name("create-key")
+	api.HandleFunc("/admin/keys/{id}", app.require(authAdmin, app.revokeAPIKeyHandler)).Methods("DELETE").Name("revoke-key")
+	api.HandleFunc("/admin/blocklist", app.require(authAdmin, app.listBlocklistHandler)).Methods("GET").Name("blocklist")
+	api.HandleFunc("/admin/blocklist", app.require(authAdmin, app.addBlocklistHandler)).Methods("POST").Name("block")
+	api.HandleFunc("/admin/blocklist", app.require(authAdmin, app.removeBlocklistHandler)).Methods("DELETE").Name("unblock")
+	api.HandleFunc("/admin/audit", app.require(authAdmin, app.auditLogHandler)).Methods("GET").Name("audit")
+	api.HandleFunc("/admin/gc/report", app.require(authAdmin, app.gcHandler)).Methods("GET").Name("gc-report")
+	api.HandleFunc("/admin/gc/reclaim", app.require(authAdmin, app.gcHandler)).Methods("POST").Name("gc-reclaim")
+	r.HandleFunc("/login", app.loginPageHandler).Methods("GET").Name("login-page")
+	r.HandleFunc("/login", app.loginFormHandler).Methods("POST").Name("login-form")
+	r.HandleFunc("/logout", app.logoutFormHandler).Methods("POST").Name("logout-form")
+	r.HandleFunc("/verify-email", app.verifyEmailPageHandler).Methods("GET").Name("verify-email-page")
+	r.HandleFunc("/reset-password", app.resetPageHandler).Methods("GET").Name("reset-page")
+	r.HandleFunc("/reset-password", app.resetFormHandler).Methods("POST").Name("reset-form")
+	r.HandleFunc("/shorten", app.bookmarkletHandler).Methods("GET").Name("bookmarklet")
+	r.PathPrefix("/static/").Handler(app.staticHandler()).Methods("GET").Name("static")
+	r.HandleFunc("/manifest.webmanifest", app.manifestHandler).Methods("GET").Name("manifest")
+	r.HandleFunc("/sw.js", app.serviceWorkerHandler).Methods("GET").Name("service-worker")
+	r.HandleFunc("/icon-{size:192|512}.png", app.appIconHandler).Methods("GET").Name("app-icon")
+	r.HandleFunc("/admin", app.dashboardHandler).Methods("GET").Name("dashboard")
+	r.HandleFunc("/analytics", app.analyticsHandler).Methods("GET").Name("analytics")
+	api.HandleFunc("/links/{shortCode}", app.require(authIdentified, app.getLinkHandler)).Methods("GET").Name("link")
+	api.HandleFunc("/links/{shortCode}", app.require(authIdentified, app.updateLinkHandler)).Methods("PATCH").Name("link-update")
+	api.HandleFunc("/links/{shortCode}", app.require(authIdentified, app.trashLinkHandler)).Methods("DELETE").Name("link-delete")
+	api.HandleFunc("/links/{shortCode}/restore", app.require(authIdentified, app.restoreLinkHandler)).Methods("POST").Name("link-restore")
+	api.HandleFunc("/links/{shortCode}/stats", app.require(authIdentified, app.linkStatsHandler)).Methods("GET").Name("link-stats")
+	api.HandleFunc("/links/{shortCode}/stats/{dimension:referrers|browsers|os|countries}", app.require(authIdentified, app.linkStatsHandler)).Methods("GET").Name("link-stats")
+	api.HandleFunc("/links/{shortCode}/favicon", app.require(authIdentified, app.faviconHandler)).Methods("GET").Name("link-favicon")
+	api.HandleFunc("/links/{shortCode}/claim", app.require(authIdentified, app.claimLinkHandler)).Methods("POST").Name("claim")
+	api.HandleFunc("/links/{shortCode}/transfer", app.require(authIdentified, app.transferLinkHandler)).Methods("POST").Name("transfer")
+	api.HandleFunc("/transfers", app.require(authIdentified, app.listTransfersHandler)).Methods("GET").Name("transfers")
+	api.HandleFunc("/transfers/{id}/accept", app.require(authIdentified, app.acceptTransferHandler)).Methods("POST").Name("transfer-accept")
+	api.HandleFunc("/transfers/{id}/decline", app.require(authIdentified, app.declineTransferHandler)).Methods("POST").Name("transfer-decline")
+	api.HandleFunc("/links/{shortCode}/access-token", app.require(authIdentified, app.accessTokenHandler)).Methods("POST").Name("access-token")
+	api.HandleFunc("/links/{shortCode}/preview-token", app.require(authAdmin, app.previewTokenHandler)).Methods("POST").Name("preview-token")
+	// with DEBUG_ADDR set they get their own listener instead, see debug.go
+	if app.Config.DebugAddr == "" {
+		app.mountDebug(r, func(h http.HandlerFunc) http.HandlerFunc { return app.require(authAdmin, h) })
+	}
+	// last, custom codes would shadow anything after them
+	app.linkRoutes(r)
+	app.useMiddleware(r)
+	
+	return app.wrap(r)
+}
+
+// publicRoutes is only the links themselves, for PORT when ADMIN_ADDR takes
+// the api and ui somewhere private
+func (app *App) publicRoutes() http.Handler {
+	r := mux.NewRouter()
+	app.linkRoutes(r)
+	app.useMiddleware(r)
+	return app.wrap(r)
+}
+
+// linkRoutes are what every listener and short domain answers
+func (app *App) linkRoutes(r *mux.Router) {
+	// namespaced links first, so /docs/aB3xY7zQ isn't taken for a sub-path of code "docs"
+	r.HandleFunc("/{namespace}/{shortCode:"+app.Codes.route+"}", app.redirectHandler).MatcherFunc(app.onNamespace).Methods("GET", "HEAD").Name("redirect")
+	r.HandleFunc("/e/{token:[A-Za-z0-9_-]+\\.[A-Za-z0-9_-]+}", app.ephemeralRedirectHandler).Methods("GET", "HEAD").Name("ephemeral-redirect")
+	r.HandleFunc("/robots.txt", app.robotsHandler).Methods("GET", "HEAD").Name("robots")
+	// the app's own paths never look like codes, /api/nope is a 404 and not a missing link
+	links := r.MatcherFunc(notReservedPath).Subrouter()
+	links.HandleFunc("/{shortCode:"+app.Codes.route+"}", app.redirectHandler).Methods("GET", "HEAD").Name("redirect")
+	links.HandleFunc("/{shortCode:"+app.Codes.route+"}/preview", app.previewHandler).Methods("GET").Name("preview")
+	links.HandleFunc("/{shortCode:"+app.Codes.route+"}/stats", app.statsPageHandler).Methods("GET").Name("stats-page")
+	// sub-paths of links with a rewrite - same name, so CAPTCHA_ROUTES covers both
+	links.HandleFunc("/{shortCode:"+app.Codes.route+"}/{rest:.+}", app.redirectHandler).Methods("GET", "HEAD").Name("redirect")
+}
+
+// wrap adds what has to see every request, matched or not. the real client ip
+// goes first so the shadow, the access log and everything after it has it
+func (app *App) wrap(r *mux.Router) http.Handler {
+	return app.realIPMiddleware(app.shadowMiddleware(app.accessLogMiddleware(app.metricsMiddleware(app.compressMiddleware(app.withPathPrefix(app.apiVersionMiddleware(r)))))))
+}
+
+func (app *App) useMiddleware(r *mux.Router) {
+	r.Use(app.codeCaseMiddleware, app.bodyLimitMiddleware, app.localeMiddleware, app.blocklistMiddleware, app.authMiddleware, app.riskMiddleware)
+}
+
 func main() {
-	// use boltdb for embedded database - runs entirely in your go process
-	dbPath := "urls.db"
+	// urlshortener loadtest ... - see loadtest.go
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		os.Exit(runLoadTest(os.Args[2:]))
+	}
+
+	// settings that can change without a restart, see reload.go
+	env := newEnvFile(os.Getenv("ENV_FILE"))
+	if err := env.load(); err != nil {
+		log.Fatal("failed to read ENV_FILE:", err)
+	}
+	// what loading the config logs goes to stderr as usual, and is copied to
+	// the other LOG_OUTPUTS once they're set up
+	var early bytes.Buffer
+	log.SetOutput(io.MultiWriter(os.Stderr, &early))
+	config, err := loadConfig()
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatal("invalid settings: ", err)
+	}
+	if err := setupLogging(config, early.Bytes()); err != nil {
+		log.Fatal("failed to set up logging: ", err)
+	}
 	
-	// connect to boltdb database (creates file if doesn't exist)
-	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	// use boltdb for embedded database - runs entirely in your go process.
+	// STORE=memory keeps it off disk, see memstore.go
+	db, err := openDatabase(config)
 	if err != nil {
 		log.Fatal("failed to connect to database:", err)
 	}
@@ -476,39 +1030,89 @@ func main() {
 		log.Fatal("failed to setup database:", err)
 	}
 	
-	// create cache with 5 minute default expiration, cleanup every 10 minutes
-	// this will keep hot urls super fast to access
-	cache := cache.New(5*time.Minute, 10*time.Minute)
-	
-	// create app instance
-	app := &App{
-		DB:    db,
-		Cache: cache,
+	app, err := newApp(db, config)
+	if err != nil {
+		log.Fatal(err)
 	}
 	
-	// setup routes
-	r := mux.NewRouter()
-	r.HandleFunc("/", app.indexHandler).Methods("GET")
-	r.HandleFunc("/api/shorten", app.shortenHandler).Methods("POST")
-	r.HandleFunc("/{shortCode:[a-zA-Z0-9]{8}}", app.redirectHandler).Methods("GET")
+	// SIGHUP and POST /api/admin/reload swap in a fresh app, the listeners
+	// below go through live so they pick it up
+	live := newLiveApp(app, env)
+	go live.watchSignals()
+	
+	
+	go app.runTokenCleanup(time.Hour)
+	go app.runIdempotencyCleanup(time.Hour)
+	
+	// health checks, trash purge, click rollups, reports and gc - these follow
+	// reloads, see reload.go
+	live.runJobs()
 	
-	// get port from environment or default to 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// pprof and /debug/vars away from the public port
+	if config.DebugAddr != "" {
+		debug := app.debugServer()
+		go func() {
+			log.Printf("debug endpoints on %s", config.DebugAddr)
+			log.Fatal(debug.ListenAndServe())
+		}()
 	}
 	
+	port := config.Port
+	
 	log.Printf("server starting on port %s", port)
-	log.Printf("visit http://localhost:%s to use the url shortener", port)
+	if config.AdminAddr == "" {
+		log.Printf("visit %s to use the url shortener", config.BaseURL)
+	}
+	
+	// ADMIN_ADDR splits the api, ui and admin endpoints off onto their own
+	// listener, PORT then serves nothing but the links
+	handler := live.routes()
+	if config.AdminAddr != "" {
+		admin := &http.Server{
+			Addr:         config.AdminAddr,
+			Handler:      handler,
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			log.Printf("api and ui on %s", config.AdminAddr)
+			log.Fatal(admin.ListenAndServe())
+		}()
+		handler = live.publicRoutes()
+	}
 	
 	// start server with timeouts for production readiness
 	srv := &http.Server{
 		Addr:         ":" + port,
-		Handler:      r,
+		Handler:      handler,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		Protocols:    plainProtocols(config.H2C),
+	}
+	
+	if !config.Autocert {
+		log.Fatal(srv.ListenAndServe())
 	}
 	
-	log.Fatal(srv.ListenAndServe())
+	// AUTOCERT - certificates come and go with custom domains, no restarts needed
+	certs := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.AutocertDir),
+		HostPolicy: live.certHostPolicy,
+		Email:      config.AutocertEmail,
+	}
+	go func() {
+		challenges := &http.Server{Addr: srv.Addr, Handler: certs.HTTPHandler(nil), ReadTimeout: 15 * time.Second}
+		log.Fatal(challenges.ListenAndServe())
+	}()
+	srv.Addr = ":" + config.HTTPSPort
+	srv.TLSConfig = certs.TLSConfig()
+	tlsProtocols(srv, config.HTTP2)
+	if config.HTTP3 {
+		srv.Handler = startHTTP3(srv.Addr, srv.Handler, certs.TLSConfig())
+	}
+	log.Printf("serving https on port %s", config.HTTPSPort)
+	log.Fatal(srv.ListenAndServeTLS("", ""))
 }
\ No newline at end of file
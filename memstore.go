@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// where links live, picked with STORE
+const (
+	storeBolt   = "bolt"   // the DB_PATH file, urls.db by default
+	storeMemory = "memory" // gone when the process exits - demos, tests, throwaway deployments
+)
+
+// the memory store is still bbolt, just over a file that only exists in
+// memory. every handler talks to buckets, cursors and transactions directly,
+// so this keeps their behaviour (and bbolt's locking) identical to disk
+// instead of maintaining a second implementation of all of it
+
+// openDatabase opens the store STORE asks for, encrypted when there's a
+// STORE_KEY (see storecrypt.go)
+func openDatabase(cfg *Config) (*bolt.DB, error) {
+	db, err := openStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupStoreCipher(db, cfg.StoreKey); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func openStore(cfg *Config) (*bolt.DB, error) {
+	switch cfg.Store {
+	case storeBolt:
+		// connect to boltdb database (creates file if doesn't exist)
+		return bolt.Open(cfg.DBPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	case storeMemory:
+		path, release, err := memoryFile()
+		if err != nil {
+			return nil, fmt.Errorf("memory store: %w", err)
+		}
+		defer release()
+		return bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, NoSync: true, NoFreelistSync: true})
+	}
+	return nil, fmt.Errorf("unknown STORE %q, want %s or %s", cfg.Store, storeBolt, storeMemory)
+}
+
+// tempMemoryFile is the fallback where there are no anonymous memory files:
+// a temp file that's unlinked as soon as bbolt has it open, which on unix
+// leaves nothing behind
+func tempMemoryFile() (string, func(), error) {
+	f, err := os.CreateTemp("", "urlshortener-*.db")
+	if err != nil {
+		return "", nil, err
+	}
+	f.Close()
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
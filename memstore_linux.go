@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// memoryFile makes an anonymous memory file and hands out a path bbolt can
+// open it by. release drops our own descriptor, the file lives on for as long
+// as bbolt keeps its copy open
+func memoryFile() (string, func(), error) {
+	fd, err := unix.MemfdCreate("urlshortener", unix.MFD_CLOEXEC)
+	if err != nil {
+		return tempMemoryFile()
+	}
+	return "/proc/self/fd/" + strconv.Itoa(fd), func() { unix.Close(fd) }, nil
+}
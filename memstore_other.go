@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func memoryFile() (string, func(), error) {
+	return tempMemoryFile()
+}
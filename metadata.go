@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// PageMetadata is what we can tell the user about a destination before they shorten it
+type PageMetadata struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Favicon     string `json:"favicon,omitempty"`
+}
+
+// only the <head> matters, no point reading whole pages
+const maxMetadataBody = 512 * 1024
+
+// every url typed in is a different entry, so the cache of them is capped
+const maxCachedPreviews = 5000
+
+// fetchMetadata GETs a page through the outbound client and scrapes its head.
+// results (including failures) are cached so the ui can call this on every keystroke
+func (app *App) fetchMetadata(ctx context.Context, rawURL string) (*PageMetadata, error) {
+	if v, found := app.Previews.Get(rawURL); found {
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v.(*PageMetadata), nil
+	}
+
+	meta, err := app.scrapeMetadata(ctx, rawURL)
+	if err != nil {
+		app.Previews.Set(rawURL, err, 5*time.Minute)
+		return nil, err
+	}
+	app.Previews.Set(rawURL, meta, time.Hour)
+	return meta, nil
+}
+
+func (app *App) scrapeMetadata(ctx context.Context, rawURL string) (*PageMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", outboundUserAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := app.Outbound.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, errors.New("destination returned " + resp.Status)
+	}
+
+	// resolve relative favicon paths against wherever redirects landed us
+	base := resp.Request.URL
+	meta := &PageMetadata{
+		URL:     base.String(),
+		Favicon: base.ResolveReference(&url.URL{Path: "/favicon.ico"}).String(),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return meta, nil
+	}
+
+	parseHead(io.LimitReader(resp.Body, maxMetadataBody), base, meta)
+	return meta, nil
+}
+
+// parseHead tokenizes until </head> (or the body starts) picking out title,
+// description and icon. og: tags win over plain ones when both exist
+func parseHead(r io.Reader, base *url.URL, meta *PageMetadata) {
+	z := html.NewTokenizer(r)
+	var ogTitle, ogDesc string
+	inTitle := false
+
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == "head" {
+				break loop
+			}
+			if string(name) == "title" {
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle && meta.Title == "" {
+				meta.Title = strings.TrimSpace(string(z.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				attrs[string(k)] = string(v)
+			}
+
+			switch string(name) {
+			case "body":
+				break loop
+			case "title":
+				inTitle = true
+			case "meta":
+				content := strings.TrimSpace(attrs["content"])
+				switch strings.ToLower(attrs["name"] + attrs["property"]) {
+				case "description":
+					meta.Description = content
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDesc = content
+				}
+			case "link":
+				rel := strings.ToLower(attrs["rel"])
+				if (rel == "icon" || rel == "shortcut icon" || rel == "apple-touch-icon") && attrs["href"] != "" {
+					if href, err := base.Parse(attrs["href"]); err == nil {
+						meta.Favicon = href.String()
+					}
+				}
+			}
+		}
+	}
+
+	if ogTitle != "" {
+		meta.Title = ogTitle
+	}
+	if ogDesc != "" {
+		meta.Description = ogDesc
+	}
+	meta.Title = truncate(meta.Title, 300)
+	meta.Description = truncate(meta.Description, 500)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "…"
+}
+
+// handles GET /api/preview?url=... - title/description/favicon of a url that
+// hasn't been shortened yet, for the creation form. it needs a login, or
+// anyone could use the server to fetch pages for them
+func (app *App) urlMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	target := normalizeURL(r.URL.Query().Get("url"))
+
+	if !isValidURL(target) {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "url", "invalid url format")
+		return
+	}
+
+	meta, err := app.fetchMetadata(r.Context(), target)
+	if err != nil {
+		log.Printf("metadata fetch failed for %s: %v", target, err)
+		writeError(w, http.StatusBadGateway, errorUpstream, "could not fetch url")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	json.NewEncoder(w).Encode(meta)
+}
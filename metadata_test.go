@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestURLPreview(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<html><head><title>Page %s</title></head></html>", r.URL.Path)
+	}))
+	defer page.Close()
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	preview := "/api/v1/preview?url=" + url.QueryEscape(page.URL+"/one")
+
+	// anonymous callers don't get to make the server fetch things
+	if rec := ta.do(http.MethodGet, preview, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: status %d, want 401", rec.Code)
+	}
+	rec := ta.do(http.MethodGet, preview, nil, "Authorization", "Bearer "+testAdminToken)
+	var meta PageMetadata
+	json.NewDecoder(rec.Body).Decode(&meta)
+	if rec.Code != http.StatusOK || meta.Title != "Page /one" {
+		t.Fatalf("preview: status %d, %+v", rec.Code, meta)
+	}
+
+	for i := range maxCachedPreviews + 10 {
+		ta.Previews.Set(fmt.Sprintf("https://example.com/%d", i), &PageMetadata{}, 0)
+	}
+	if n := ta.Previews.ItemCount(); n > maxCachedPreviews {
+		t.Errorf("preview cache holds %d, want at most %d", n, maxCachedPreviews)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// STATSD_ADDR pushes a handful of counters to statsd (or the datadog agent,
+// which speaks the same protocol plus tags). counts add up in memory and go
+// out every STATSD_FLUSH, so the redirect path never touches the network.
+//
+//	redirects                 short links followed
+//	cache.hit / cache.miss    redirect cache lookups, for the hit ratio
+//	shorten.created           new links, from the api, forms and batches
+//	shorten.existing          shorten calls answered with an existing code
+//	responses.2xx ... 5xx     every response by status class, errors included
+//	click_webhook.sent        per-link click webhook posts, see clickhook.go
+//	click_webhook.failed      ... that errored or got a non-2xx
+//	click_webhook.dropped     ... skipped by the rate limit or an open breaker
+//	cache.entries             gauge, sent with each flush
+
+// metrics is the statsd client. a nil *metrics does nothing, so call sites
+// don't need to check whether it's on
+type metrics struct {
+	mu       sync.Mutex
+	counts   map[string]int64
+	conn     net.Conn
+	prefix   string
+	tags     string // "|#env:prod,region:eu" or empty
+	gauges   func() map[string]int64
+	maxBatch int
+}
+
+// newMetrics dials the statsd server (udp, so nothing fails if it's down)
+// and starts flushing. gauges is read at every flush
+func newMetrics(cfg *Config, gauges func() map[string]int64) (*metrics, error) {
+	conn, err := net.Dial("udp", cfg.StatsDAddr)
+	if err != nil {
+		return nil, err
+	}
+	m := &metrics{
+		counts:   map[string]int64{},
+		conn:     conn,
+		prefix:   cfg.StatsDPrefix,
+		gauges:   gauges,
+		maxBatch: 1432, // fits one packet on a typical mtu
+	}
+	if len(cfg.StatsDTags) > 0 {
+		m.tags = "|#" + strings.Join(cfg.StatsDTags, ",")
+	}
+	if cfg.StatsDFlush > 0 {
+		go m.run(cfg.StatsDFlush)
+	}
+	return m, nil
+}
+
+func (m *metrics) incr(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.counts[name]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := m.flush(); err != nil {
+			log.Printf("statsd flush error: %v", err)
+		}
+	}
+}
+
+// flush sends what's been counted since the last one, packed into as few
+// packets as fit
+func (m *metrics) flush() error {
+	m.mu.Lock()
+	counts := m.counts
+	m.counts = map[string]int64{}
+	m.mu.Unlock()
+
+	var lines []string
+	for name, n := range counts {
+		lines = append(lines, fmt.Sprintf("%s%s:%d|c%s", m.prefix, name, n, m.tags))
+	}
+	if m.gauges != nil {
+		for name, v := range m.gauges() {
+			lines = append(lines, fmt.Sprintf("%s%s:%d|g%s", m.prefix, name, v, m.tags))
+		}
+	}
+	sort.Strings(lines)
+
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > m.maxBatch {
+			if _, err := m.conn.Write([]byte(packet.String())); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		_, err := m.conn.Write([]byte(packet.String()))
+		return err
+	}
+	return nil
+}
+
+// metricsMiddleware counts every response by status class. it wraps the whole
+// router so 404s for paths nothing matched count too
+func (app *App) metricsMiddleware(next http.Handler) http.Handler {
+	if app.Metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		app.Metrics.incr(fmt.Sprintf("responses.%dxx", rec.status/100))
+	})
+}
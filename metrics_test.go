@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ta := newTestApp(t, map[string]string{
+		"STATSD_ADDR":  server.LocalAddr().String(),
+		"STATSD_TAGS":  "env:test",
+		"STATSD_FLUSH": "0", // flushed by hand below
+	})
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/counted"}, http.StatusOK).ShortCode
+	ta.shorten(ShortenRequest{URL: "https://example.com/counted"}, http.StatusOK)
+	ta.Cache.Delete(code)
+	ta.do(http.MethodGet, "/"+code, nil) // miss
+	ta.do(http.MethodGet, "/"+code, nil) // hit
+	ta.do(http.MethodGet, "/-nope", nil)
+
+	if err := ta.Metrics.flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(got)
+	want := []string{
+		"urlshortener.cache.entries:1|g|#env:test",
+		"urlshortener.cache.hit:1|c|#env:test",
+		"urlshortener.cache.miss:1|c|#env:test",
+		"urlshortener.redirects:2|c|#env:test",
+		"urlshortener.responses.2xx:2|c|#env:test",
+		"urlshortener.responses.3xx:2|c|#env:test",
+		"urlshortener.responses.4xx:1|c|#env:test",
+		"urlshortener.shorten.created:1|c|#env:test",
+		"urlshortener.shorten.existing:1|c|#env:test",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sent:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
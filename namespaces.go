@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// namespaces put links under a path prefix - /docs/aB3xY7zQ, /t/aB3xY7zQ -
+// each with its own defaults for new links and optionally owned by one org.
+// a link made in a namespace only resolves under it, and codes stay unique
+// across all of them like they are across short domains. they're kept in the
+// namespaces bucket and mirrored in memory, the router consults them on
+// every request
+
+// names that would shadow the app's own pages
+var reservedNamespaces = []string{"api", "static", "admin", "analytics", "login", "logout", "verify-email", "reset-password", "shorten", "debug", "e"}
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// Namespace is one path prefix and its settings
+type Namespace struct {
+	Name      string    `json:"name"`
+	Redirect  string    `json:"redirect,omitempty"` // default redirect type for new links
+	Domain    string    `json:"domain,omitempty"`   // default short domain for new links
+	OrgID     string    `json:"org_id,omitempty"`   // only this org (and admins) can make links in it, empty = anyone
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// usableBy says whether an identity may make links in the namespace
+func (ns *Namespace) usableBy(id *Identity) bool {
+	return ns.OrgID == "" || id.Admin || id.OrgID() == ns.OrgID
+}
+
+// namespaceSet is the in-memory copy of the namespaces bucket
+type namespaceSet struct {
+	mu         sync.RWMutex
+	namespaces map[string]*Namespace
+}
+
+func (s *namespaceSet) get(name string) *Namespace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.namespaces[name]
+}
+
+func (s *namespaceSet) set(namespaces map[string]*Namespace) {
+	s.mu.Lock()
+	s.namespaces = namespaces
+	s.mu.Unlock()
+}
+
+func (s *namespaceSet) list() []Namespace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []Namespace{}
+	for _, ns := range s.namespaces {
+		out = append(out, *ns)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// loadNamespaces reads the namespaces bucket into memory
+func (app *App) loadNamespaces() error {
+	namespaces := map[string]*Namespace{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("namespaces"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var ns Namespace
+			if err := json.Unmarshal(v, &ns); err != nil {
+				log.Printf("skipping bad namespace %q: %v", k, err)
+				return nil
+			}
+			namespaces[ns.Name] = &ns
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	app.Namespaces.set(namespaces)
+	return nil
+}
+
+// onNamespace is a mux matcher for paths starting with a namespace that exists
+func (app *App) onNamespace(r *http.Request, _ *mux.RouteMatch) bool {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return app.Namespaces.get(name) != nil
+}
+
+// inNamespace says whether a link in the given namespace resolves on this
+// request's path - namespaced links only under their namespace, the rest only without one
+func inNamespace(r *http.Request, namespace string) bool {
+	return mux.Vars(r)["namespace"] == namespace
+}
+
+// applyNamespace fills in the namespace's defaults for whatever the request left out
+func (app *App) applyNamespace(o *ShortenOptions) error {
+	o.Namespace = strings.ToLower(strings.TrimSpace(o.Namespace))
+	if o.Namespace == "" {
+		return nil
+	}
+	ns := app.Namespaces.get(o.Namespace)
+	if ns == nil {
+		return fmt.Errorf("unknown namespace %q", o.Namespace)
+	}
+	if o.Redirect == "" {
+		o.Redirect = ns.Redirect
+	}
+	if o.Domain == "" {
+		o.Domain = ns.Domain
+	}
+	return nil
+}
+
+// checkNamespace makes sure the caller may make links in the namespace
+func (app *App) checkNamespace(id *Identity, name string) error {
+	if name == "" {
+		return nil
+	}
+	if ns := app.Namespaces.get(name); ns != nil && !ns.usableBy(id) {
+		return fmt.Errorf("namespace %q belongs to another team", name)
+	}
+	return nil
+}
+
+// handles GET /api/admin/namespaces
+func (app *App) listNamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.Namespaces.list())
+}
+
+// handles PUT /api/admin/namespaces/{name} - creates the namespace or
+// replaces its settings. links already in it keep what they were made with
+func (app *App) putNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var ns Namespace
+	if err := decodeJSON(r, &ns); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	ns.Name = name
+	ns.Domain = strings.ToLower(strings.TrimSpace(ns.Domain))
+	if err := app.validateNamespace(&ns); err != nil {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	ns.CreatedAt = time.Now()
+	if old := app.Namespaces.get(name); old != nil {
+		ns.CreatedAt = old.CreatedAt
+	} else {
+		status = http.StatusCreated
+	}
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("namespaces"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(ns)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(name), data)
+	})
+	if err == nil {
+		err = app.loadNamespaces()
+	}
+	if err != nil {
+		log.Printf("namespace save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	log.Printf("namespace %s saved by %s", name, requestActor(r).Actor)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ns)
+}
+
+func (app *App) validateNamespace(ns *Namespace) error {
+	if !namespaceName.MatchString(ns.Name) {
+		return fmt.Errorf("namespace names are 1-32 lowercase letters, digits and dashes")
+	}
+	if slices.Contains(reservedNamespaces, ns.Name) {
+		return fmt.Errorf("%q is reserved", ns.Name)
+	}
+	switch ns.Redirect {
+	case "", redirectPermanent, redirectTemporary, redirectFrame, redirectMeta:
+	default:
+		return fmt.Errorf("redirect must be permanent, temporary, frame or meta")
+	}
+	if ns.Domain != "" && !app.allowedDomain(ns.Domain) {
+		return fmt.Errorf("domain %q is not one of this server's short domains", ns.Domain)
+	}
+	if ns.OrgID != "" {
+		org, err := app.getOrg(ns.OrgID)
+		if err != nil || org == nil {
+			return fmt.Errorf("org %q not found", ns.OrgID)
+		}
+	}
+	return nil
+}
+
+// handles DELETE /api/admin/namespaces/{name} - only once it has no links,
+// they'd stop resolving otherwise
+func (app *App) deleteNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if app.Namespaces.get(name) == nil {
+		writeError(w, http.StatusNotFound, errorNotFound, "namespace not found")
+		return
+	}
+
+	links := 0
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte("urls")); bucket != nil {
+			err := bucket.ForEach(func(k, v []byte) error {
+				var u URL
+				if openJSON(tx, v, &u) == nil && u.Namespace == name {
+					links++
+				}
+				return nil
+			})
+			if err != nil || links > 0 {
+				return err
+			}
+		}
+		bucket := tx.Bucket([]byte("namespaces"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(name))
+	})
+	if err == nil {
+		err = app.loadNamespaces()
+	}
+	if err != nil {
+		log.Printf("namespace delete error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if links > 0 {
+		writeError(w, http.StatusConflict, errorConflict, fmt.Sprintf("namespace still has %d links", links))
+		return
+	}
+
+	log.Printf("namespace %s deleted by %s", name, requestActor(r).Actor)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	ta := newTestApp(t, nil)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/namespaces/api", map[string]string{}, admin...); rec.Code != http.StatusBadRequest {
+		t.Errorf("reserved name: status %d, want 400", rec.Code)
+	}
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/namespaces/docs", Namespace{Redirect: redirectTemporary}, admin...); rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+
+	req := ShortenRequest{URL: "https://example.com/guide"}
+	req.Namespace = "docs"
+	resp := ta.shorten(req, http.StatusOK)
+	if resp.ShortURL != "http://sho.rt/docs/"+resp.ShortCode {
+		t.Errorf("short_url = %s", resp.ShortURL)
+	}
+	// namespaces dedupe on their own
+	if plain := ta.shorten(ShortenRequest{URL: "https://example.com/guide"}, http.StatusOK); plain.ShortCode == resp.ShortCode {
+		t.Error("the same url outside the namespace got the namespaced link")
+	}
+
+	for range 2 { // the second round comes from the redirect cache
+		if rec := ta.do(http.MethodGet, "/docs/"+resp.ShortCode, nil); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/guide" {
+			t.Errorf("namespaced redirect: %d %s, want the namespace's temporary redirect", rec.Code, rec.Header().Get("Location"))
+		}
+		if rec := ta.do(http.MethodGet, "/"+resp.ShortCode, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("without the namespace: status %d, want 404", rec.Code)
+		}
+	}
+
+	req.Namespace = "nope"
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", req); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown namespace: status %d, want 400", rec.Code)
+	}
+
+	// a team's namespace is off limits to everyone else
+	rec := ta.do(http.MethodPost, "/api/v1/admin/orgs", map[string]string{"name": "Docs team"}, admin...)
+	var org Org
+	json.NewDecoder(rec.Body).Decode(&org)
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/namespaces/team", Namespace{OrgID: org.ID}, admin...); rec.Code != http.StatusCreated {
+		t.Fatalf("create team namespace: status %d: %s", rec.Code, rec.Body)
+	}
+	req.Namespace = "team"
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", req); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's namespace: status %d, want 403", rec.Code)
+	}
+
+	if rec := ta.do(http.MethodDelete, "/api/v1/admin/namespaces/docs", nil, admin...); rec.Code != http.StatusConflict {
+		t.Errorf("delete with links: status %d, want 409", rec.Code)
+	}
+	if rec := ta.do(http.MethodDelete, "/api/v1/admin/namespaces/team", nil, admin...); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d, want 204", rec.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Org is a tenant - a team sharing settings like integrations. users and api
+// keys can belong to one, and org admins manage its settings themselves
+type Org struct {
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Integrations []Integration `json:"integrations,omitempty"`
+	Fields       []FieldDef    `json:"fields,omitempty"` // custom fields links in this org carry
+}
+
+func (app *App) getOrg(id string) (*Org, error) {
+	var org *Org
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("orgs"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		org = &Org{}
+		return json.Unmarshal(v, org)
+	})
+	return org, err
+}
+
+// updateOrg is updateURL for orgs - fn isn't called when the org doesn't exist
+func (app *App) updateOrg(id string, fn func(org *Org) error) (found bool, err error) {
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("orgs"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		found = true
+
+		var org Org
+		if err := json.Unmarshal(v, &org); err != nil {
+			return err
+		}
+		if err := fn(&org); err != nil {
+			return err
+		}
+		data, err := json.Marshal(org)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+	return found, err
+}
+
+// OrgID is the org a request acts for, empty when it isn't tied to one
+func (id *Identity) OrgID() string {
+	switch {
+	case id.User != nil:
+		return id.User.OrgID
+	case id.Key != nil:
+		return id.Key.OrgID
+	}
+	return ""
+}
+
+// managesOrg reports whether an identity may change an org's settings -
+// operators always, otherwise only the org's own admins
+func (id *Identity) managesOrg(orgID string) bool {
+	return id.Admin || (id.User != nil && id.User.OrgID == orgID && id.User.OrgAdmin)
+}
+
+// handles POST /api/admin/orgs
+func (app *App) createOrgHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "name", "name is required")
+		return
+	}
+
+	id := make([]byte, 6)
+	rand.Read(id)
+	org := Org{
+		ID:        hex.EncodeToString(id),
+		Name:      strings.TrimSpace(req.Name),
+		CreatedAt: time.Now(),
+	}
+
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("orgs"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(org)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(org.ID), data)
+	})
+	if err != nil {
+		log.Printf("org save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save org")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
+
+// handles GET /api/admin/orgs
+func (app *App) listOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	orgs := []Org{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("orgs"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var org Org
+			if json.Unmarshal(v, &org) == nil {
+				org.Integrations = nil // listed per org, with secrets masked
+				orgs = append(orgs, org)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("org list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// user agent we identify as when talking to destination sites
+const outboundUserAgent = "LinkFast/1.0 (+link checker)"
+
+// newOutboundClient builds the http client used for talking to arbitrary
+// destinations - tight timeouts and a redirect cap so a slow or looping site can't hang us.
+// internal addresses are refused unless allowPrivate, redirects included
+func newOutboundClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = denyPrivateAddrs
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// denyPrivateAddrs is a dialer hook that refuses loopback, private and
+// link-local targets, checked after dns so a public name pointing inside
+// the network doesn't get through either
+func denyPrivateAddrs(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := addrPort.Addr().Unmap(); internalAddr(ip) {
+		return fmt.Errorf("refusing to connect to internal address %s", ip)
+	}
+	return nil
+}
+
+// newWebhookClient is for posting to urls that tenants configure - no
+// redirects, and internal addresses are off limits unless allowPrivate
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = denyPrivateAddrs
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// withPathPrefix mounts the router under PATH_PREFIX, for deployments that
+// share a host with other things (example.com/s/...). routes and middleware
+// all see paths with the prefix already stripped
+func (app *App) withPathPrefix(h http.Handler) http.Handler {
+	prefix := app.Config.PathPrefix
+	if prefix == "" {
+		return h
+	}
+	stripped := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the pages use relative urls, which only resolve right from prefix + "/"
+		if r.URL.Path == prefix {
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+// path turns a route path into the one clients see, for redirects and cookies
+func (app *App) path(p string) string {
+	return app.Config.PathPrefix + p
+}
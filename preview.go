@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// request body for POST /api/links/{code}/preview-token
+type PreviewTokenRequest struct {
+	TTL string `json:"ttl"` // go duration, e.g. "72h"
+}
+
+type PreviewTokenResponse struct {
+	URL       string    `json:"url"`
+	StatsURL  string    `json:"stats_url"` // same signature works for the stats page
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// preview links are capped so a leaked url doesn't stay useful forever
+const (
+	defaultPreviewTTL = 7 * 24 * time.Hour
+	maxPreviewTTL     = 90 * 24 * time.Hour
+)
+
+// signPreview makes the signature for a code + expiry pair. the "preview:" prefix
+// keeps these from ever being valid for anything else we sign with the same key
+func (app *App) signPreview(shortCode string, expires int64) string {
+	mac := hmac.New(sha256.New, app.Config.PreviewSecret)
+	fmt.Fprintf(mac, "preview:%s:%d", shortCode, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validPreviewToken checks the exp/sig query params for a preview url
+func (app *App) validPreviewToken(shortCode string, q url.Values) bool {
+	expires, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	expected := app.signPreview(shortCode, expires)
+	return hmac.Equal([]byte(expected), []byte(q.Get("sig")))
+}
+
+// handles POST /api/links/{code}/preview-token - mints a time limited url that lets
+// someone without credentials look at the link's preview/stats page (not the api)
+func (app *App) previewTokenHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req PreviewTokenRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			jsonBodyError(w, err)
+			return
+		}
+	}
+
+	ttl := defaultPreviewTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxPreviewTTL {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "ttl", "ttl must be a positive duration up to 2160h")
+			return
+		}
+		ttl = d
+	}
+
+	urlData, err := app.getURL(shortCode)
+	if err != nil {
+		log.Printf("preview token lookup error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if urlData == nil {
+		writeError(w, http.StatusNotFound, errorNotFound, "link not found")
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	q := url.Values{
+		"exp": {strconv.FormatInt(expiresAt.Unix(), 10)},
+		"sig": {app.signPreview(shortCode, expiresAt.Unix())},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PreviewTokenResponse{
+		URL:       app.shortURL(shortCode) + "/preview?" + q.Encode(),
+		StatsURL:  app.shortURL(shortCode) + "/stats?" + q.Encode(),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handles GET /{shortCode}/preview - read only page for whoever holds a valid signed url
+func (app *App) previewHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	if !app.validPreviewToken(shortCode, r.URL.Query()) {
+		http.Error(w, "this preview link is invalid or has expired", http.StatusForbidden)
+		return
+	}
+
+	urlData, err := app.getURL(shortCode)
+	if err != nil || urlData == nil || !app.servesLink(r, urlData.Domain) {
+		http.NotFound(w, r)
+		return
+	}
+
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("exp"), 10, 64)
+
+	// signed urls shouldn't end up in shared caches or referrer headers
+	app.noindex(w)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Type", "text/html")
+	err = app.page(r).ExecuteTemplate(w, "preview.html", map[string]any{
+		"ShortCode": shortCode,
+		"ShortURL":  app.linkShortURL(urlData),
+		"Link":      urlData,
+		"ExpiresAt": time.Unix(expires, 0),
+	})
+	if err != nil {
+		log.Printf("preview render error: %v", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// how much a click leaves behind, ANALYTICS_LEVEL. visitors sending DNT: 1 or
+// Sec-GPC: 1 get aggregate whatever the level (unless RESPECT_DNT=false)
+const (
+	analyticsOff       = "off"       // clicks aren't counted at all
+	analyticsAggregate = "aggregate" // the click count and per-day totals, nothing about the visitor
+	analyticsFull      = "full"      // plus referrer, browser, os and country breakdowns and the click log
+)
+
+func validAnalyticsLevel(level string) error {
+	switch level {
+	case analyticsOff, analyticsAggregate, analyticsFull:
+		return nil
+	}
+	return fmt.Errorf("unknown level %q, want off, aggregate or full", level)
+}
+
+// optedOut says whether the visitor asked not to be tracked
+func optedOut(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// privateClick says whether only aggregate counts may be kept for this request
+func (app *App) privateClick(r *http.Request) bool {
+	return app.Config.AnalyticsLevel != analyticsFull || (app.Config.RespectDNT && optedOut(r))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAnalyticsLevels(t *testing.T) {
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	stats := func(ta *testApp, code string) LinkStatsResponse {
+		var resp LinkStatsResponse
+		json.NewDecoder(ta.do(http.MethodGet, "/api/v1/links/"+code+"/stats", nil, admin...).Body).Decode(&resp)
+		return resp
+	}
+
+	// no dns lookups for the destinations, see rewrite_test.go
+	env := map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"}
+	ta := newTestApp(t, env)
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/private"}, http.StatusOK).ShortCode
+	ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0", "DNT", "1")
+	ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0", "Sec-GPC", "1")
+	ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0")
+	ta.Clicks.Wait()
+	if u, _ := ta.getURL(code); u.ClickCount != 3 {
+		t.Errorf("click count %d, want all 3", u.ClickCount)
+	}
+	if got := stats(ta, code); got.Total != 1 {
+		t.Errorf("breakdowns cover %d clicks, want only the one that didn't opt out", got.Total)
+	}
+
+	for level, want := range map[string]int{"aggregate": 1, "off": 0} {
+		env["ANALYTICS_LEVEL"] = level
+		ta := newTestApp(t, env)
+		code := ta.shorten(ShortenRequest{URL: "https://example.com/" + level}, http.StatusOK).ShortCode
+		ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0")
+		ta.Clicks.Wait()
+		if u, _ := ta.getURL(code); u.ClickCount != want {
+			t.Errorf("%s: click count %d, want %d", level, u.ClickCount, want)
+		}
+		if got := stats(ta, code); got.Total != 0 {
+			t.Errorf("%s: breakdowns cover %d clicks, want none", level, got.Total)
+		}
+	}
+}
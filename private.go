@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// private links only redirect for someone logged in - any user, api key or
+// admin, it's for internal links on an otherwise public shortener - or for
+// whoever has an access url the owner handed out, the short url plus
+// ?access=<expiry>.<signature>. browsers without either go to the login page
+// and come back after, anything else gets a 401
+
+// request body for POST /api/links/{code}/access-token
+type AccessTokenRequest struct {
+	TTL string `json:"ttl"` // go duration, e.g. "72h"
+}
+
+type AccessTokenResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// access urls are capped like preview ones, a leaked one runs out
+const (
+	defaultAccessTTL = 7 * 24 * time.Hour
+	maxAccessTTL     = 90 * 24 * time.Hour
+)
+
+const accessParam = "access"
+
+// signAccess signs with the preview key, the "access:" prefix keeps the two
+// kinds of signature apart
+func (app *App) signAccess(shortCode string, expires int64) string {
+	mac := hmac.New(sha256.New, app.Config.PreviewSecret)
+	fmt.Fprintf(mac, "access:%s:%d", shortCode, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (app *App) validAccessToken(shortCode, token string) bool {
+	exp, sig, ok := strings.Cut(token, ".")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if !ok || err != nil || app.now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(app.signAccess(shortCode, expires)), []byte(sig))
+}
+
+// allowPrivate says whether a request may follow a private link, answering it
+// when it may not. browsers go to log in, except with ADMIN_ADDR set - the
+// public port has no /login then, so they get the 401 like everyone else
+func (app *App) allowPrivate(w http.ResponseWriter, r *http.Request, shortCode string) bool {
+	if !identityFromContext(r.Context()).Anonymous() || app.validAccessToken(shortCode, r.URL.Query().Get(accessParam)) {
+		return true
+	}
+	app.noindex(w)
+	w.Header().Set("Cache-Control", "no-store")
+	if app.Config.AdminAddr == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, app.path("/login?next="+url.QueryEscape(r.URL.RequestURI())), http.StatusSeeOther)
+		return false
+	}
+	http.Error(w, "this link is private, log in or use an access link", http.StatusUnauthorized)
+	return false
+}
+
+// handles POST /api/links/{code}/access-token - an access url for someone
+// without a login, the owner's to hand out
+func (app *App) accessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req AccessTokenRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &req); err != nil {
+			jsonBodyError(w, err)
+			return
+		}
+	}
+	ttl := defaultAccessTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxAccessTTL {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "ttl", "ttl must be a positive duration up to 2160h")
+			return
+		}
+		ttl = d
+	}
+
+	urlData, ok := app.authorizeLink(w, r, mux.Vars(r)["shortCode"])
+	if !ok {
+		return
+	}
+	if !urlData.Private {
+		writeError(w, http.StatusConflict, errorConflict, "the link isn't private, its short url works for everyone")
+		return
+	}
+
+	expiresAt := app.now().Add(ttl).Truncate(time.Second)
+	token := strconv.FormatInt(expiresAt.Unix(), 10) + "." + app.signAccess(urlData.ShortCode, expiresAt.Unix())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AccessTokenResponse{
+		URL:       app.linkShortURL(urlData) + "?" + url.Values{accessParam: {token}}.Encode(),
+		ExpiresAt: expiresAt,
+	})
+}
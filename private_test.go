@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPrivateLinks(t *testing.T) {
+	ta := newTestApp(t, nil)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", map[string]any{"url": "https://intranet.example/wiki", "private": true}, admin...)
+	var created ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	code := created.ShortCode
+
+	// a logged in visit caches it, the anonymous one after still gets stopped
+	rec = ta.do(http.MethodGet, "/"+code, nil, admin...)
+	if rec.Code != http.StatusFound || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("logged in: status %d, Cache-Control %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	if rec := ta.do(http.MethodGet, "/"+code, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: status %d, want 401", rec.Code)
+	}
+	rec = ta.do(http.MethodGet, "/"+code, nil, "Accept", "text/html")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?next=%2F"+code {
+		t.Fatalf("browser: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// the same url shortened in public gets a link of its own
+	if resp := ta.shorten(ShortenRequest{URL: "https://intranet.example/wiki"}, http.StatusOK); !resp.Created {
+		t.Errorf("public shorten got the private link back: %+v", resp)
+	}
+
+	rec = ta.do(http.MethodPost, "/api/v1/links/"+code+"/access-token", map[string]string{"ttl": "1h"}, admin...)
+	var access AccessTokenResponse
+	json.NewDecoder(rec.Body).Decode(&access)
+	link, err := url.Parse(access.URL)
+	if rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("access token: status %d, %+v", rec.Code, access)
+	}
+	if rec := ta.do(http.MethodGet, link.RequestURI(), nil); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://intranet.example/wiki" {
+		t.Fatalf("access url: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := ta.do(http.MethodGet, link.RequestURI()+"x", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("tampered access url: status %d, want 401", rec.Code)
+	}
+	ta.clock.Advance(time.Hour + time.Second)
+	if rec := ta.do(http.MethodGet, link.RequestURI(), nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired access url: status %d, want 401", rec.Code)
+	}
+
+	if rec := ta.do(http.MethodPatch, "/api/v1/links/"+code, map[string]bool{"private": false}, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("make public: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodGet, "/"+code, nil); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("public again: status %d, want 301", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/links/"+code+"/access-token", nil, admin...); rec.Code != http.StatusConflict {
+		t.Errorf("access token for a public link: status %d, want 409", rec.Code)
+	}
+}
+
+// with ADMIN_ADDR the public port has no /login to send browsers to
+func TestPrivateLinksOnPublicRoutes(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ADMIN_ADDR": "localhost:0"})
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", map[string]any{"url": "https://intranet.example/docs", "private": true}, "Authorization", "Bearer "+testAdminToken)
+	var created ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	public := ta.publicRoutes()
+
+	req := httptest.NewRequest(http.MethodGet, "/"+created.ShortCode, nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	public.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Location") != "" {
+		t.Fatalf("browser: status %d, Location %q, want 401", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = httptest.NewRecorder()
+	public.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("public /login: status %d, want 404", rec.Code)
+	}
+}
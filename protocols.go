@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// which http versions each listener speaks:
+//   - PORT is plain http/1.1, H2C=true adds cleartext http/2 for proxies that speak it
+//   - HTTPS_PORT (AUTOCERT) negotiates h2 unless HTTP2=false
+//   - HTTP3=true also serves QUIC on HTTPS_PORT over udp, and https responses
+//     carry an Alt-Svc header so browsers switch over on their next request
+
+// plainProtocols is what PORT speaks when it serves the app
+func plainProtocols(h2c bool) *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetUnencryptedHTTP2(h2c)
+	return &p
+}
+
+// tlsProtocols sets up the https listener. without http/2 the h2 alpn token
+// has to go too, or clients would negotiate it and get http/1.1 answers
+func tlsProtocols(srv *http.Server, http2 bool) {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(http2)
+	srv.Protocols = &p
+	if !http2 {
+		srv.TLSConfig.NextProtos = slices.DeleteFunc(slices.Clone(srv.TLSConfig.NextProtos), func(proto string) bool {
+			return proto == "h2"
+		})
+	}
+}
+
+// startHTTP3 serves handler over QUIC on addr and returns handler wrapped to
+// advertise it
+func startHTTP3(addr string, handler http.Handler, tlsConfig *tls.Config) http.Handler {
+	h3 := &http3.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+	go func() {
+		log.Printf("serving http/3 on udp %s", addr)
+		log.Fatal(h3.ListenAndServe())
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			h3.SetQUICHeaders(w.Header())
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
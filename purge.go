@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// purging by destination domain is the big hammer for abuse reports - one
+// call takes down every link to a phishing host and its subdomains, rather
+// than hunting them down code by code
+
+const (
+	purgeDisable = "disable" // links stay but stop resolving
+	purgeDelete  = "delete"  // links are gone for good and their codes free again
+
+	purgedReason = "destination domain purged"
+)
+
+// PurgeReport is what POST /api/admin/purge returns
+type PurgeReport struct {
+	Domain  string   `json:"domain"`
+	Action  string   `json:"action"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+	Matched int      `json:"matched"`
+	Purged  int      `json:"purged"`
+	Codes   []string `json:"codes"`
+}
+
+// onDomain is true when a destination's host is domain or one of its subdomains
+func onDomain(rawURL, domain string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := hostOnly(u.Host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// handles POST /api/admin/purge?domain=evil.example - ?action=delete removes the
+// links instead of disabling them, ?dry_run=true only lists what would be hit
+func (app *App) purgeDomainHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	domain := hostOnly(strings.TrimSpace(q.Get("domain")))
+	if domain == "" || strings.ContainsAny(domain, "/:@ ") || !strings.Contains(domain, ".") {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "domain", "domain must be a host name like evil.example")
+		return
+	}
+	action := q.Get("action")
+	if action == "" {
+		action = purgeDisable
+	}
+	if action != purgeDisable && action != purgeDelete {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "action", "action must be disable or delete")
+		return
+	}
+
+	// deleting also takes links that are already off, disabling skips them
+	links, _, err := app.listURLs("", -1, func(u *URL) bool {
+		return onDomain(u.OriginalURL, domain) && (action == purgeDelete || !u.Disabled)
+	})
+	if err != nil {
+		log.Printf("purge list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	report := PurgeReport{Domain: domain, Action: action, DryRun: q.Get("dry_run") == "true", Matched: len(links), Codes: []string{}}
+	for _, link := range links {
+		report.Codes = append(report.Codes, link.ShortCode)
+	}
+	if report.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	who := requestActor(r)
+	for _, link := range links {
+		if action == purgeDelete {
+			err = app.deleteURL(link.ShortCode, who, "purge")
+		} else {
+			_, err = app.auditedUpdate(who, link.ShortCode, func(u *URL) (string, error) {
+				if u.Disabled {
+					return "", nil
+				}
+				u.Disabled = true
+				u.DisabledReason = purgedReason
+				return "disable", nil
+			})
+		}
+		if err != nil {
+			log.Printf("purge error for %s: %v", link.ShortCode, err)
+			continue
+		}
+		app.Cache.Delete(link.ShortCode)
+		report.Purged++
+	}
+	log.Printf("purge: %s %d of %d links to %s", action+"d", report.Purged, report.Matched, domain)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
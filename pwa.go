@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// installable app bits: the web manifest, a service worker that keeps the
+// home page usable offline, and icons drawn in the brand color. the manifest's
+// share target sends "share -> app" on android to the bookmarklet page
+
+// WebManifest is what /manifest.webmanifest returns. urls in it are relative to
+// the manifest, so PATH_PREFIX needs no special handling
+type WebManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	ThemeColor      string         `json:"theme_color"`
+	BackgroundColor string         `json:"background_color"`
+	Icons           []ManifestIcon `json:"icons"`
+	ShareTarget     ShareTarget    `json:"share_target"`
+}
+
+type ManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+type ShareTarget struct {
+	Action string            `json:"action"`
+	Method string            `json:"method"`
+	Params map[string]string `json:"params"`
+}
+
+var appIconSizes = []int{192, 512}
+
+// handles GET /manifest.webmanifest
+func (app *App) manifestHandler(w http.ResponseWriter, r *http.Request) {
+	manifest := WebManifest{
+		Name:            app.Brand.Name,
+		ShortName:       app.Brand.Name,
+		StartURL:        "./",
+		Scope:           "./",
+		Display:         "standalone",
+		ThemeColor:      app.Brand.Color,
+		BackgroundColor: "#f5f5f5",
+		ShareTarget: ShareTarget{
+			Action: "shorten",
+			Method: "GET",
+			Params: map[string]string{"url": "url", "text": "text", "title": "title"},
+		},
+	}
+	if app.Brand.Theme == themeDark {
+		manifest.BackgroundColor = "#121212"
+	}
+	for _, size := range appIconSizes {
+		s := strconv.Itoa(size)
+		manifest.Icons = append(manifest.Icons, ManifestIcon{
+			Src:     "icon-" + s + ".png",
+			Sizes:   s + "x" + s,
+			Type:    "image/png",
+			Purpose: "any maskable",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// handles GET /sw.js - the worker has to live at the root of what it controls,
+// so it's served from here rather than /static/
+func (app *App) serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := fs.ReadFile(app.Assets, "static/sw.js")
+	if err != nil {
+		log.Printf("service worker read error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript")
+	// browsers check for a new worker on navigation, don't make them wait on a cache
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+// app icons only depend on the brand color, so each size is drawn once
+var (
+	appIconMu    sync.Mutex
+	appIconCache = map[int][]byte{}
+)
+
+// handles GET /icon-{size}.png
+func (app *App) appIconHandler(w http.ResponseWriter, r *http.Request) {
+	size, _ := strconv.Atoi(mux.Vars(r)["size"])
+
+	appIconMu.Lock()
+	data, ok := appIconCache[size]
+	if !ok {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, drawAppIcon(size, app.Brand.Color)); err != nil {
+			appIconMu.Unlock()
+			log.Printf("icon render error: %v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+		appIconCache[size] = data
+	}
+	appIconMu.Unlock()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
+
+// drawAppIcon paints two white interlocking rings (a chain link) on the brand
+// color, kept inside the middle 80% so it survives maskable cropping
+func drawAppIcon(size int, hex string) image.Image {
+	bg := parseHexColor(hex)
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	s := float64(size)
+	outer, inner := 0.19*s, 0.12*s
+	centers := [][2]float64{{0.39 * s, 0.5 * s}, {0.61 * s, 0.5 * s}}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := bg
+			for _, center := range centers {
+				d := math.Hypot(float64(x)+0.5-center[0], float64(y)+0.5-center[1])
+				if d <= outer && d >= inner {
+					c = color.RGBA{255, 255, 255, 255}
+				}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+var sharedURLPattern = regexp.MustCompile(`https?://\S+`)
+
+// sharedURL digs the link out of what a share target got. android apps mostly
+// put it in text, often after a headline, rather than in url
+func sharedURL(q url.Values) string {
+	if u := strings.TrimSpace(q.Get("url")); u != "" {
+		return u
+	}
+	text := strings.TrimSpace(q.Get("text"))
+	if u := sharedURLPattern.FindString(text); u != "" {
+		return u
+	}
+	return text
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ShortenOptions are the optional knobs on a shorten request. api keys can save
+// a set of these as defaults ("recipes") that fill in whatever a request leaves out
+type ShortenOptions struct {
+	Campaign  string            `json:"campaign,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	UTM       map[string]string `json:"utm,omitempty"`        // utm_* params added to the destination, {campaign} is substituted
+	ExpiresIn string            `json:"expires_in,omitempty"` // go duration, e.g. "720h", or "never"
+	Domain    string            `json:"domain,omitempty"`     // one of SHORT_DOMAINS
+	Redirect  string            `json:"redirect,omitempty"`   // permanent (301, cacheable) or temporary (302, never cached)
+
+	ClickWebhook string `json:"click_webhook,omitempty"` // posted to on every click, see clickhook.go
+	Namespace    string `json:"namespace,omitempty"`     // path prefix the link lives under, see namespaces.go
+	Reuse        *bool  `json:"reuse,omitempty"`         // see reuses
+	Private      *bool  `json:"private,omitempty"`       // redirects only with a login or an access url, see private.go
+	Interstitial *bool  `json:"interstitial,omitempty"`  // a warning page before the destination, see interstitial.go
+}
+
+// request body for POST /api/shorten
+type ShortenRequest struct {
+	URL             string            `json:"url"`
+	Title           string            `json:"title,omitempty"`
+	Description     string            `json:"description,omitempty"`
+	Code            string            `json:"code,omitempty"`             // a custom code instead of a generated one, see vanity.go
+	Fields          map[string]any    `json:"fields,omitempty"`           // custom fields from the org's schema
+	Rule            string            `json:"rule,omitempty"`             // picks the destination per click, see rules.go
+	Rewrite         *Rewrite          `json:"rewrite,omitempty"`          // answers /{code}/{rest} too, see rewrite.go
+	Countries       *GeoRule          `json:"countries,omitempty"`        // who gets the redirect, see geoblock.go
+	Card            *LinkCard         `json:"card,omitempty"`             // what it unfurls as when shared, see card.go
+	Headers         map[string]string `json:"headers,omitempty"`          // sent with the redirect, see headers.go
+	FollowRedirects bool              `json:"follow_redirects,omitempty"` // link to where the destination's redirects end, see unwrap.go
+	ShortenOptions
+}
+
+// links can't be set to live longer than this via expires_in
+const maxExpiresIn = 10 * 365 * 24 * time.Hour
+
+// expires_in for a link that never expires, whatever the defaults say
+const expiresNever = "never"
+
+// the utm params we know how to add
+var utmParams = map[string]bool{
+	"utm_source": true, "utm_medium": true, "utm_campaign": true, "utm_term": true, "utm_content": true,
+}
+
+// fillFrom copies any option that isn't set on o from defaults
+func (o *ShortenOptions) fillFrom(defaults *ShortenOptions) {
+	if defaults == nil {
+		return
+	}
+	if o.Campaign == "" {
+		o.Campaign = defaults.Campaign
+	}
+	if len(o.Tags) == 0 {
+		o.Tags = defaults.Tags
+	}
+	if len(o.UTM) == 0 {
+		o.UTM = defaults.UTM
+	}
+	if o.ExpiresIn == "" {
+		o.ExpiresIn = defaults.ExpiresIn
+	}
+	if o.Domain == "" {
+		o.Domain = defaults.Domain
+	}
+	if o.Redirect == "" {
+		o.Redirect = defaults.Redirect
+	}
+	if o.ClickWebhook == "" {
+		o.ClickWebhook = defaults.ClickWebhook
+	}
+	if o.Namespace == "" {
+		o.Namespace = defaults.Namespace
+	}
+	if o.Reuse == nil {
+		o.Reuse = defaults.Reuse
+	}
+	if o.Private == nil {
+		o.Private = defaults.Private
+	}
+	if o.Interstitial == nil {
+		o.Interstitial = defaults.Interstitial
+	}
+}
+
+// reuses says whether a url that already has a live code gets that code back
+// (the default) instead of a new one. reuse: false always mints a fresh code,
+// so every campaign can count its own clicks for the same destination. those
+// links stay out of the reverse index, so they're never handed to anyone else,
+// and so do private ones
+func (o *ShortenOptions) reuses() bool {
+	return (o.Reuse == nil || *o.Reuse) && !o.private()
+}
+
+func (o *ShortenOptions) private() bool {
+	return o.Private != nil && *o.Private
+}
+
+func (o *ShortenOptions) interstitial() bool {
+	return o.Interstitial != nil && *o.Interstitial
+}
+
+// validate checks everything except the url and normalizes tags/utm keys in place
+func (app *App) validateOptions(o *ShortenOptions) error {
+	var problems fieldErrors
+	problems.add("namespace", app.applyNamespace(o))
+	o.Campaign = strings.TrimSpace(o.Campaign)
+	if len(o.Campaign) > maxCampaignLen {
+		problems.add("campaign", fmt.Errorf("campaign must be at most %d characters", maxCampaignLen))
+	}
+
+	tags, err := normalizeTags(o.Tags)
+	if !problems.add("tags", err) {
+		o.Tags = tags
+	}
+
+	if len(o.UTM) > 0 {
+		utm := map[string]string{}
+		for k, v := range o.UTM {
+			k = strings.ToLower(strings.TrimSpace(k))
+			if !strings.HasPrefix(k, "utm_") {
+				k = "utm_" + k
+			}
+			if !utmParams[k] {
+				problems.add("utm", fmt.Errorf("unknown utm parameter %q", k))
+				continue
+			}
+			utm[k] = v
+		}
+		o.UTM = utm
+	}
+
+	if o.ExpiresIn != "" && o.ExpiresIn != expiresNever {
+		d, err := time.ParseDuration(o.ExpiresIn)
+		if err != nil || d <= 0 || d > maxExpiresIn {
+			problems.add("expires_in", fmt.Errorf("expires_in must be a positive duration like 720h, up to %s, or never", maxExpiresIn))
+		}
+	}
+
+	o.Domain = strings.ToLower(o.Domain)
+	if o.Domain != "" && !app.allowedDomain(o.Domain) {
+		problems.add("domain", fmt.Errorf("domain %q is not one of this server's short domains", o.Domain))
+	}
+
+	switch o.Redirect {
+	case "", redirectPermanent, redirectTemporary, redirectFrame, redirectMeta:
+	default:
+		problems.add("redirect", fmt.Errorf("redirect must be permanent, temporary, frame or meta"))
+	}
+
+	webhook, err := normalizeClickWebhook(o.ClickWebhook)
+	if !problems.add("click_webhook", err) {
+		o.ClickWebhook = webhook
+	}
+	return problems.err()
+}
+
+// expiresAt turns expires_in into an absolute time (nil = never expires)
+func (o *ShortenOptions) expiresAt(now time.Time) *time.Time {
+	if o.ExpiresIn == "" || o.ExpiresIn == expiresNever {
+		return nil
+	}
+	d, _ := time.ParseDuration(o.ExpiresIn)
+	t := now.Add(d)
+	return &t
+}
+
+// applyUTM appends utm params the destination doesn't already have. the
+// existing query string is left untouched byte for byte
+func (o *ShortenOptions) applyUTM(rawURL string) string {
+	if len(o.UTM) == 0 || !webURL(rawURL) {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	existing := u.Query()
+	extra := url.Values{}
+	for k, v := range o.UTM {
+		if existing.Has(k) {
+			continue
+		}
+		extra.Set(k, strings.ReplaceAll(v, "{campaign}", o.Campaign))
+	}
+	if len(extra) == 0 {
+		return rawURL
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+	return u.String()
+}
+
+// allowedDomain is SHORT_DOMAINS plus verified custom domains - see
+// checkDomain for who may use the latter
+func (app *App) allowedDomain(domain string) bool {
+	if app.CustomDomains.get(domain) != nil {
+		return true
+	}
+	for _, d := range app.Config.ShortDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveAPIKey writes a key record back under its hash
+func (app *App) saveAPIKey(apiKey *APIKey) error {
+	return app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("apikeys"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(apiKey.hash), data)
+	})
+}
+
+// handles /api/keys/me/defaults - lets a key read, replace (PUT) or clear
+// (DELETE) its own saved shorten defaults
+func (app *App) keyDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey := apiKeyFromContext(r.Context())
+	if apiKey == nil {
+		writeError(w, http.StatusUnauthorized, errorUnauthorized, "api key required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var defaults ShortenOptions
+		if err := decodeJSON(r, &defaults); err != nil {
+			jsonBodyError(w, err)
+			return
+		}
+		if err := app.validateOptions(&defaults); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		apiKey.Defaults = &defaults
+	case http.MethodDelete:
+		apiKey.Defaults = nil
+	}
+
+	if r.Method != http.MethodGet {
+		if err := app.saveAPIKey(apiKey); err != nil {
+			log.Printf("api key defaults save error: %v", err)
+			writeError(w, http.StatusInternalServerError, errorServer, "failed to save defaults")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if apiKey.Defaults == nil {
+		json.NewEncoder(w).Encode(ShortenOptions{})
+		return
+	}
+	json.NewEncoder(w).Encode(apiKey.Defaults)
+}
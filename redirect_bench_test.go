@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchmarks for the redirect hot path, through the full router and middleware.
+// run with go test -bench Redirect -run '^$'
+
+// benchmarkRedirect requests one existing link (or missing when set) over and
+// over. evict drops it from the cache before every request so each one reads bolt
+func benchmarkRedirect(b *testing.B, missing, evict bool, wantStatus int) {
+	ta := newTestApp(b, nil)
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/bench"}, http.StatusOK).ShortCode
+	path := "/" + code
+	if missing {
+		path = "/zzzz9999"
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if evict {
+			ta.Cache.Delete(code)
+		}
+		rec := httptest.NewRecorder()
+		ta.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != wantStatus {
+			b.Fatalf("status %d, want %d", rec.Code, wantStatus)
+		}
+	}
+	b.StopTimer()
+	ta.Clicks.Wait()
+}
+
+func BenchmarkRedirectCacheHit(b *testing.B) {
+	benchmarkRedirect(b, false, false, http.StatusMovedPermanently)
+}
+
+func BenchmarkRedirectCacheMiss(b *testing.B) {
+	benchmarkRedirect(b, false, true, http.StatusMovedPermanently)
+}
+
+func BenchmarkRedirectNotFound(b *testing.B) {
+	benchmarkRedirect(b, true, false, http.StatusNotFound)
+}
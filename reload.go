@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// hot reload. settings live in the environment, so to change them without a
+// restart they go in ENV_FILE (KEY=value lines), which is read at startup and
+// again on SIGHUP or POST /api/admin/reload. the process environment wins
+// over the file.
+//
+// a reload builds a whole new App from the fresh settings - blocklist, bot
+// ranges, captcha and rate limits, templates, integrations - and swaps it in.
+// requests already running finish on the old one, so nothing gets dropped.
+// the db, the caches, the access log, statsd client and click webhook state carry over. listeners,
+// the store and the background jobs keep what they started with, see restartOnly
+
+// envFile loads ENV_FILE into the environment, keeping track of what it set
+// so a key removed from the file goes away on the next load
+type envFile struct {
+	path string
+	set  map[string]bool // keys we put in the environment
+}
+
+func newEnvFile(path string) *envFile {
+	return &envFile{path: path, set: map[string]bool{}}
+}
+
+func (e *envFile) load() error {
+	if e.path == "" {
+		return nil
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("%s line %d: expected KEY=value", e.path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for key := range e.set {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(e.set, key)
+		}
+	}
+	for key, value := range values {
+		// set outside the file - the real environment wins
+		if _, exists := os.LookupEnv(key); exists && !e.set[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		e.set[key] = true
+	}
+	return nil
+}
+
+// liveApp is the app currently answering requests
+type liveApp struct {
+	mu      sync.Mutex // one reload at a time
+	current atomic.Pointer[servingApp]
+	env     *envFile
+}
+
+// servingApp is an app with its routers built
+type servingApp struct {
+	app    *App
+	routes http.Handler
+	public http.Handler
+}
+
+func newLiveApp(app *App, env *envFile) *liveApp {
+	live := &liveApp{env: env}
+	live.serve(app)
+	return live
+}
+
+func (l *liveApp) serve(app *App) {
+	app.Reload = l.reload
+	l.current.Store(&servingApp{app: app, routes: app.routes(), public: app.publicRoutes()})
+}
+
+func (l *liveApp) app() *App {
+	return l.current.Load().app
+}
+
+// routes and publicRoutes are the handlers to give the listeners, they follow reloads
+func (l *liveApp) routes() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.current.Load().routes.ServeHTTP(w, r)
+	})
+}
+
+func (l *liveApp) publicRoutes() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.current.Load().public.ServeHTTP(w, r)
+	})
+}
+
+func (l *liveApp) certHostPolicy(ctx context.Context, host string) error {
+	return l.app().certHostPolicy(ctx, host)
+}
+
+// reload rereads the settings and swaps in a new app. on any error the old
+// one keeps serving
+func (l *liveApp) reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.env.load(); err != nil {
+		return fmt.Errorf("reading ENV_FILE: %w", err)
+	}
+	prev := l.app()
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("loading settings: %w", err)
+	}
+	if ignored := restartOnly(config, prev.Config); len(ignored) > 0 {
+		log.Printf("reload: %s changed but only take effect on restart", strings.Join(ignored, ", "))
+	}
+
+	app, err := newApp(prev.DB, config, WithClock(prev.Clock), WithRandom(prev.Random), withStateFrom(prev))
+	if err != nil {
+		return err
+	}
+	l.serve(app)
+	log.Printf("configuration reloaded")
+	return nil
+}
+
+// runJobs starts the scheduled jobs. they run on the live app, so their
+// settings - including 0 to turn one off - follow reloads like everything else
+func (l *liveApp) runJobs() {
+	hourlyIf := func(on func(app *App) bool) func(*App) time.Duration {
+		return func(app *App) time.Duration {
+			if on(app) {
+				return time.Hour
+			}
+			return 0
+		}
+	}
+	// dead link checker - HEALTH_CHECK_INTERVAL=0 turns it off
+	go l.every(func(app *App) time.Duration { return app.Config.HealthCheckInterval }, (*App).healthCheck)
+	// trashed links are purged after TRASH_RETENTION, 0 keeps them until purged by hand
+	go l.every(hourlyIf(func(app *App) bool { return app.Config.TrashRetention > 0 }), (*App).purgeTrash)
+	// raw clicks past CLICK_RETENTION become daily rollups, 0 keeps them all
+	go l.every(hourlyIf(func(app *App) bool { return app.Config.ClickRetention > 0 }), (*App).compactOldClicks)
+	// emailed link reports for users who opted in
+	go l.every(hourlyIf(func(app *App) bool { return app.mailConfigured() && app.Config.ReportInterval > 0 }), (*App).sendDueReports)
+	// never-clicked report, GC_INTERVAL=0 turns it off
+	go l.every(func(app *App) time.Duration { return app.Config.GCInterval }, (*App).gcReport)
+}
+
+// every runs job on the app that's live once interval has gone by since its
+// last run. it looks each minute, so a reload that turns a job on or shortens
+// its interval doesn't wait out the old one
+func (l *liveApp) every(interval func(app *App) time.Duration, job func(app *App)) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for range ticker.C {
+		app := l.app()
+		if wait := interval(app); wait <= 0 || time.Since(last) < wait {
+			continue
+		}
+		job(app)
+		last = time.Now()
+	}
+}
+
+// watchSignals reloads on every SIGHUP
+func (l *liveApp) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := l.reload(); err != nil {
+			log.Printf("reload failed, keeping the old settings: %v", err)
+		}
+	}
+}
+
+// withStateFrom hands the caches to the new app so a reload doesn't start
+// cold, click webhook breakers stay open, fraud checks keep their baselines,
+// archive snapshots keep their queue and account email limits their counts
+func withStateFrom(prev *App) AppOption {
+	return func(app *App) {
+		app.Cache = prev.Cache
+		app.Lookups = prev.Lookups
+		app.Previews = prev.Previews
+		app.AccessLog = prev.AccessLog
+		app.Metrics = prev.Metrics
+		app.ClickHooks = prev.ClickHooks
+		app.Fraud = prev.Fraud
+		app.Archives = prev.Archives
+		app.MailLimits = prev.MailLimits
+	}
+}
+
+// restartOnly puts back the settings a running process can't change and
+// returns the ones that differed. generated secrets are kept too, or every
+// token signed so far would stop verifying
+func restartOnly(next, prev *Config) []string {
+	var ignored []string
+	keep(&ignored, "PORT", &next.Port, prev.Port)
+	keep(&ignored, "ADMIN_ADDR", &next.AdminAddr, prev.AdminAddr)
+	keep(&ignored, "DEBUG_ADDR", &next.DebugAddr, prev.DebugAddr)
+	keep(&ignored, "STORE", &next.Store, prev.Store)
+	keep(&ignored, "DB_PATH", &next.DBPath, prev.DBPath)
+	if string(next.StoreKey) != string(prev.StoreKey) {
+		ignored = append(ignored, "STORE_KEY")
+		next.StoreKey = prev.StoreKey
+	}
+	keep(&ignored, "AUTOCERT", &next.Autocert, prev.Autocert)
+	keep(&ignored, "AUTOCERT_DIR", &next.AutocertDir, prev.AutocertDir)
+	keep(&ignored, "AUTOCERT_EMAIL", &next.AutocertEmail, prev.AutocertEmail)
+	keep(&ignored, "HTTPS_PORT", &next.HTTPSPort, prev.HTTPSPort)
+	keep(&ignored, "H2C", &next.H2C, prev.H2C)
+	keep(&ignored, "HTTP2", &next.HTTP2, prev.HTTP2)
+	keep(&ignored, "HTTP3", &next.HTTP3, prev.HTTP3)
+	keep(&ignored, "STATSD_ADDR", &next.StatsDAddr, prev.StatsDAddr)
+	keep(&ignored, "STATSD_PREFIX", &next.StatsDPrefix, prev.StatsDPrefix)
+	keep(&ignored, "STATSD_FLUSH", &next.StatsDFlush, prev.StatsDFlush)
+	if strings.Join(next.StatsDTags, ",") != strings.Join(prev.StatsDTags, ",") {
+		ignored = append(ignored, "STATSD_TAGS")
+		next.StatsDTags = prev.StatsDTags
+	}
+	keep(&ignored, "ACCESS_LOG", &next.AccessLog, prev.AccessLog)
+	keep(&ignored, "ACCESS_LOG_MAX_MB", &next.AccessLogMaxMB, prev.AccessLogMaxMB)
+	keep(&ignored, "ACCESS_LOG_ROTATE", &next.AccessLogRotate, prev.AccessLogRotate)
+	keep(&ignored, "ACCESS_LOG_KEEP", &next.AccessLogKeep, prev.AccessLogKeep)
+	keep(&ignored, "CACHE_TTL", &next.CacheTTL, prev.CacheTTL)
+	keep(&ignored, "CACHE_CLEANUP_INTERVAL", &next.CacheCleanupInterval, prev.CacheCleanupInterval)
+	keep(&ignored, "CACHE_MAX_ENTRIES", &next.CacheMaxEntries, prev.CacheMaxEntries)
+
+	if !secretSet("JWT_SECRET") {
+		next.JWTSecret = prev.JWTSecret
+	}
+	if !secretSet("PREVIEW_SECRET") {
+		next.PreviewSecret = prev.PreviewSecret
+	}
+	if !secretSet("TOTP_KEY") {
+		next.TOTPKey = prev.TOTPKey
+	}
+	return ignored
+}
+
+func keep[T comparable](ignored *[]string, key string, next *T, prev T) {
+	if *next != prev {
+		*ignored = append(*ignored, key)
+		*next = prev
+	}
+}
+
+// reloadHandler is POST /api/admin/reload, the same as sending SIGHUP
+func (app *App) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if app.Reload == nil {
+		writeError(w, http.StatusServiceUnavailable, errorUnavailable, "reload is not available")
+		return
+	}
+	if err := app.Reload(); err != nil {
+		log.Printf("reload failed, keeping the old settings: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "reload failed: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "reloaded"})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	ta := newTestApp(t, nil)
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/before-reload"}, http.StatusOK).ShortCode
+
+	path := filepath.Join(t.TempDir(), "app.env")
+	if err := os.WriteFile(path, []byte("# tighter limits\nMAX_URL_LENGTH=30\nexport BRAND_NAME=\"Reloaded\"\nDB_PATH=/elsewhere.db\nGC_INTERVAL=1h\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := newEnvFile(path)
+	t.Cleanup(func() {
+		for key := range env.set {
+			os.Unsetenv(key)
+		}
+	})
+
+	live := newLiveApp(ta.App, env)
+	// clicks after the reload are the new app's to wait for
+	t.Cleanup(func() { live.app().Clicks.Wait() })
+	handler := live.routes()
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodPost, "/api/v1/admin/reload", ""); rec.Code != http.StatusOK {
+		t.Fatalf("reload: status %d: %s", rec.Code, rec.Body)
+	}
+	next := live.app()
+	if next == ta.App || next.Config.BrandName != "Reloaded" || next.Config.MaxURLLength != 30 {
+		t.Fatalf("settings not reloaded: brand %q, max url length %d", next.Config.BrandName, next.Config.MaxURLLength)
+	}
+	if next.Config.DBPath != ta.Config.DBPath {
+		t.Errorf("DB_PATH changed on reload to %q", next.Config.DBPath)
+	}
+	if next.Cache != ta.Cache {
+		t.Errorf("redirect cache not carried over")
+	}
+	// scheduled jobs run on the live app, so their settings reload too
+	if next.Config.GCInterval != time.Hour {
+		t.Errorf("GC_INTERVAL not reloaded: %s", next.Config.GCInterval)
+	}
+
+	if rec := send(http.MethodPost, "/api/v1/shorten", `{"url":"https://example.com/a-rather-long-path"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("new MAX_URL_LENGTH not applied: status %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/"+code, ""); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("existing link after reload: status %d", rec.Code)
+	}
+
+	// a broken file leaves the running app alone
+	os.WriteFile(path, []byte("not a setting\n"), 0o600)
+	if rec := send(http.MethodPost, "/api/v1/admin/reload", ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("bad file: status %d", rec.Code)
+	}
+	if live.app() != next {
+		t.Errorf("app swapped despite a failed reload")
+	}
+
+	// so does a setting that can't be used, with every bad one named
+	os.WriteFile(path, []byte("TRUSTED_PROXIES=10.0.0.0/33\nALLOWED_SCHEMES=https,not a scheme\n"), 0o600)
+	rec := send(http.MethodPost, "/api/v1/admin/reload", "")
+	if rec.Code != http.StatusInternalServerError || live.app() != next {
+		t.Fatalf("bad values: status %d", rec.Code)
+	}
+	if err := live.reload(); err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") || !strings.Contains(err.Error(), "ALLOWED_SCHEMES") {
+		t.Errorf("reload error = %v, want both settings named", err)
+	}
+}
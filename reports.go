@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// how many links make the "top links" list in a report
+const reportTopLinks = 5
+
+// LinkReport is one user's summary for the report period
+type LinkReport struct {
+	Email    string
+	Days     int
+	Links    int    // links the user owns
+	Clicks   uint64 // human clicks across all of them in the period
+	TopLinks []reportLink
+	Broken   []reportLink
+}
+
+type reportLink struct {
+	ShortURL    string
+	OriginalURL string
+	Clicks      uint64
+}
+
+// buildReport works out a user's summary from their links' daily counters
+func (app *App) buildReport(user *User, days int) (*LinkReport, error) {
+	links, _, err := app.listURLs("", -1, func(u *URL) bool { return u.UserID == user.ID })
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LinkReport{Email: user.Email, Days: days, Links: len(links)}
+	var ranked []reportLink
+	for i := range links {
+		link := &links[i]
+		counts, err := app.dailyClicks(link.ShortCode, days)
+		if err != nil {
+			return nil, err
+		}
+		entry := reportLink{ShortURL: app.linkShortURL(link), OriginalURL: link.OriginalURL}
+		for _, d := range counts {
+			entry.Clicks += d.Count
+		}
+		report.Clicks += entry.Clicks
+		if entry.Clicks > 0 {
+			ranked = append(ranked, entry)
+		}
+		if link.Health == healthBroken {
+			report.Broken = append(report.Broken, entry)
+		}
+	}
+
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].Clicks > ranked[j].Clicks })
+	if len(ranked) > reportTopLinks {
+		ranked = ranked[:reportTopLinks]
+	}
+	report.TopLinks = ranked
+	return report, nil
+}
+
+// sendReport emails one user their summary
+func (app *App) sendReport(user *User) error {
+	days := int(app.Config.ReportInterval.Hours() / 24)
+	if days < 1 {
+		days = 1
+	}
+	report, err := app.buildReport(user, days)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := app.MailTemplates.ExecuteTemplate(&body, "report.txt", report); err != nil {
+		return err
+	}
+	return app.sendMail(user.Email, "Your short links this week", body.String())
+}
+
+// sendDueReports mails opted-in users whose last report is a full interval
+// old. tracking it per user means restarts don't double-send
+func (app *App) sendDueReports() {
+	var due []User
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("users"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var user User
+			if json.Unmarshal(v, &user) != nil || !user.WeeklyReport {
+				return nil
+			}
+			// a little slack so the hourly tick doesn't push it back an hour each week
+			if user.LastReportAt == nil || time.Since(*user.LastReportAt) >= app.Config.ReportInterval-time.Hour {
+				due = append(due, user)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("report scan error: %v", err)
+		return
+	}
+
+	for i := range due {
+		user := &due[i]
+		if err := app.sendReport(user); err != nil {
+			log.Printf("report for %s failed: %v", user.Email, err)
+			continue
+		}
+		now := time.Now()
+		if err := app.updateUser(user.ID, func(u *User) error {
+			u.LastReportAt = &now
+			return nil
+		}); err != nil {
+			log.Printf("report bookkeeping for %s failed: %v", user.Email, err)
+		}
+	}
+	if len(due) > 0 {
+		log.Printf("sent %d link reports", len(due))
+	}
+}
+
+// UserSettings is the part of a user's account they can change themselves
+type UserSettings struct {
+	WeeklyReport bool `json:"weekly_report"`
+}
+
+// handles GET /api/me/settings
+func (app *App) getSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := identityFromContext(r.Context()).User
+	if user == nil {
+		writeError(w, http.StatusForbidden, errorForbidden, "settings need a user login")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UserSettings{WeeklyReport: user.WeeklyReport})
+}
+
+// handles PUT /api/me/settings
+func (app *App) putSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := identityFromContext(r.Context()).User
+	if user == nil {
+		writeError(w, http.StatusForbidden, errorForbidden, "settings need a user login")
+		return
+	}
+
+	var settings UserSettings
+	if err := decodeJSON(r, &settings); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if settings.WeeklyReport && !app.mailConfigured() {
+		writeError(w, http.StatusBadRequest, errorDisabled, "email is not configured on this server")
+		return
+	}
+
+	err := app.updateUser(user.ID, func(u *User) error {
+		u.WeeklyReport = settings.WeeklyReport
+		return nil
+	})
+	if err != nil {
+		log.Printf("settings save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bulk code -> destination lookups for analytics jobs expanding click logs,
+// without a request (and a redirect) per code. POST /api/resolve takes a json
+// list, POST /api/resolve/stream takes one code per line and answers ndjson
+// as it goes, so a big log never has to fit in one response. codes can be
+// given as full short urls too, only the last path segment counts. live links
+// resolve for any caller - following them says as much - but where a dead
+// one pointed only comes back for whoever owns it
+
+const (
+	maxResolveCodes  = 1000
+	resolveChunkSize = 500 // codes looked up per read transaction when streaming
+)
+
+// resolve statuses
+const (
+	resolveActive   = "active"
+	resolveExpired  = "expired"
+	resolveDeleted  = "deleted" // in the trash, or disabled
+	resolvePending  = "pending_review"
+	resolveNotFound = "not_found"
+)
+
+type ResolveRequest struct {
+	Codes []string `json:"codes"`
+}
+
+// ResolvedLink is one code's answer, in the order they were asked
+type ResolvedLink struct {
+	Input       string `json:"input"`
+	ShortCode   string `json:"short_code,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
+	ArchiveURL  string `json:"archive_url,omitempty"` // a snapshot of the destination, see archive.go
+	Status      string `json:"status"`
+}
+
+type ResolveResponse struct {
+	Links []ResolvedLink `json:"links"`
+	Found int            `json:"found"` // how many are active
+}
+
+// resolveCode looks one code up inside the caller's read transaction
+func (app *App) resolveCode(bucket *bolt.Bucket, id *Identity, input string) ResolvedLink {
+	res := ResolvedLink{Input: input, Status: resolveNotFound}
+	code := strings.TrimSpace(input)
+	code = code[strings.LastIndex(code, "/")+1:]
+	if code == "" || bucket == nil {
+		return res
+	}
+	v := bucket.Get([]byte(code))
+	if v == nil && app.Config.CaseInsensitiveCodes {
+		code = strings.ToLower(code)
+		v = bucket.Get([]byte(code))
+	}
+	var u URL
+	if v == nil || openJSON(bucket.Tx(), v, &u) != nil {
+		return res
+	}
+
+	res.ShortCode = code
+	switch {
+	case u.Disabled || u.TrashedAt != nil:
+		res.Status = resolveDeleted
+	case u.Quarantined:
+		res.Status = resolvePending
+	case u.ExpiresAt != nil && !u.ExpiresAt.After(app.now()):
+		res.Status = resolveExpired
+	default:
+		res.Status = resolveActive
+	}
+	if res.Status == resolveActive || id.owns(&u) {
+		res.OriginalURL, res.ArchiveURL = u.OriginalURL, u.ArchiveURL
+	}
+	return res
+}
+
+// resolveAll looks up a batch of codes in one read transaction
+func (app *App) resolveAll(id *Identity, inputs []string) ([]ResolvedLink, error) {
+	links := make([]ResolvedLink, 0, len(inputs))
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("urls"))
+		for _, input := range inputs {
+			links = append(links, app.resolveCode(bucket, id, input))
+		}
+		return nil
+	})
+	return links, err
+}
+
+// handles POST /api/resolve
+func (app *App) resolveHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResolveRequest
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if len(req.Codes) == 0 || len(req.Codes) > maxResolveCodes {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "codes", fmt.Sprintf("send between 1 and %d codes, or use /api/v1/resolve/stream", maxResolveCodes))
+		return
+	}
+
+	links, err := app.resolveAll(identityFromContext(r.Context()), req.Codes)
+	if err != nil {
+		log.Printf("resolve error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	resp := ResolveResponse{Links: links}
+	for _, link := range links {
+		if link.Status == resolveActive {
+			resp.Found++
+		}
+	}
+	app.Metrics.incr("resolve.requests")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handles POST /api/resolve/stream - one code per line in, one json object
+// per line out, written chunk by chunk while the body is still being read.
+// once rows are out it's too late for an error status, so a failure part way
+// just ends the stream early
+func (app *App) resolveStreamHandler(w http.ResponseWriter, r *http.Request) {
+	id := identityFromContext(r.Context())
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	started := false
+
+	fail := func(status int, code, msg string) {
+		if started {
+			return
+		}
+		writeError(w, status, code, msg)
+	}
+	flush := func(chunk []string) bool {
+		links, err := app.resolveAll(id, chunk)
+		if err != nil {
+			log.Printf("resolve error: %v", err)
+			fail(http.StatusInternalServerError, errorServer, "server error")
+			return false
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		for _, link := range links {
+			enc.Encode(link)
+		}
+		rc.Flush()
+		return true
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	chunk := make([]string, 0, resolveChunkSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		chunk = append(chunk, line)
+		if len(chunk) == resolveChunkSize {
+			if !flush(chunk) {
+				return
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		msg := "lines must be short codes"
+		if bodyErrorStatus(err) == http.StatusRequestEntityTooLarge {
+			msg = bodyErrorMessage(err)
+		}
+		fail(bodyErrorStatus(err), bodyErrorCode(err), msg)
+		return
+	}
+	if flush(chunk) {
+		app.Metrics.incr("resolve.requests")
+	}
+}
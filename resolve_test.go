@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	live := ta.shorten(ShortenRequest{URL: "https://example.com/live"}, http.StatusOK).ShortCode
+	gone := ta.shorten(ShortenRequest{URL: "https://example.com/gone"}, http.StatusOK).ShortCode
+	if rec := ta.do(http.MethodDelete, "/api/v1/links/"+gone, nil, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := ta.do(http.MethodPost, "/api/v1/admin/keys", map[string]string{"name": "etl"}, admin...)
+	var created CreateAPIKeyResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	key := []string{"X-API-Key", created.Key}
+
+	if rec := ta.do(http.MethodPost, "/api/v1/resolve", ResolveRequest{Codes: []string{live}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/resolve", ResolveRequest{}, key...); rec.Code != http.StatusBadRequest {
+		t.Errorf("no codes: status %d", rec.Code)
+	}
+
+	rec = ta.do(http.MethodPost, "/api/v1/resolve", ResolveRequest{Codes: []string{"http://sho.rt/" + live, gone, "nope-nope"}}, key...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("resolve: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp ResolveResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	want := []ResolvedLink{
+		{Input: "http://sho.rt/" + live, ShortCode: live, OriginalURL: "https://example.com/live", Status: resolveActive},
+		{Input: gone, ShortCode: gone, Status: resolveDeleted}, // not the key's link, so no destination
+		{Input: "nope-nope", Status: resolveNotFound},
+	}
+	if resp.Found != 1 || len(resp.Links) != len(want) {
+		t.Fatalf("resolve = %+v", resp)
+	}
+	for i := range want {
+		if resp.Links[i] != want[i] {
+			t.Errorf("links[%d] = %+v, want %+v", i, resp.Links[i], want[i])
+		}
+	}
+
+	// the admin owns everything, dead links included
+	rec = ta.do(http.MethodPost, "/api/v1/resolve", ResolveRequest{Codes: []string{gone}}, admin...)
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Links[0].OriginalURL != "https://example.com/gone" {
+		t.Errorf("admin resolve = %+v", resp.Links[0])
+	}
+}
+
+func TestResolveStream(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/streamed"}, http.StatusOK).ShortCode
+
+	// more lines than one chunk, blank ones skipped
+	var body strings.Builder
+	for range resolveChunkSize + 10 {
+		body.WriteString(code + "\n\n")
+	}
+	body.WriteString("missing-code")
+	rec := ta.do(http.MethodPost, "/api/v1/resolve/stream", body.String(), "Authorization", "Bearer "+testAdminToken)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("stream: status %d, type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	var rows []ResolvedLink
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var row ResolvedLink
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != resolveChunkSize+11 {
+		t.Fatalf("got %d rows", len(rows))
+	}
+	if rows[0].OriginalURL != "https://example.com/streamed" || rows[len(rows)-1].Status != resolveNotFound {
+		t.Errorf("first %+v, last %+v", rows[0], rows[len(rows)-1])
+	}
+}
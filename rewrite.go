@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// sub-path rewrites. a link with a rewrite also answers /{code}/anything:
+// the part after the code is matched against the pattern and the target is
+// filled in from it, so one link covers a whole family of urls
+//
+//	{"pattern": "^([^/]+)/([^/]+)$", "target": "https://github.com/$1/$2"}
+//	/aB3xY7zQ/golang/go -> https://github.com/golang/go
+//
+// go regexps run in linear time, so a pattern can't hang a request. the
+// target's scheme and host are fixed when it's saved - only the path, query
+// and fragment can come from the request - and every result goes through
+// the same destination checks as a new link
+
+const (
+	maxRewritePattern = 256
+	maxRewriteTarget  = 2048
+)
+
+// Rewrite turns the path after a link's code into its destination
+type Rewrite struct {
+	Pattern string `json:"pattern"` // matched against the path after /{code}/
+	Target  string `json:"target"`  // destination, with $1 or ${name} from the pattern
+}
+
+// targetBase is the fixed scheme://host part of the target
+func (rw *Rewrite) targetBase() (*url.URL, error) {
+	scheme, rest, ok := strings.Cut(rw.Target, "://")
+	if !ok {
+		return nil, errors.New("rewrite target must be an absolute http(s) url")
+	}
+	host := rest
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host = rest[:i]
+	}
+	if strings.Contains(scheme+host, "$") {
+		return nil, errors.New("rewrite target's scheme and host can't use groups from the pattern")
+	}
+	u, err := url.Parse(scheme + "://" + host)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, errors.New("rewrite target must be an absolute http(s) url")
+	}
+	return u, nil
+}
+
+// validateRewrite checks a rewrite before it's saved. nil or an empty pattern
+// means none
+func (app *App) validateRewrite(ctx context.Context, rw *Rewrite) (*Rewrite, error) {
+	if rw == nil || strings.TrimSpace(rw.Pattern) == "" {
+		return nil, nil
+	}
+	rw = &Rewrite{Pattern: strings.TrimSpace(rw.Pattern), Target: strings.TrimSpace(rw.Target)}
+	if len(rw.Pattern) > maxRewritePattern {
+		return nil, fmt.Errorf("rewrite pattern must be at most %d characters", maxRewritePattern)
+	}
+	if len(rw.Target) > maxRewriteTarget {
+		return nil, fmt.Errorf("rewrite target must be at most %d characters", maxRewriteTarget)
+	}
+	re, err := regexp.Compile(rw.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewrite pattern: %w", err)
+	}
+	// the same references Expand sees - $1x is a group named 1x, not $1 then x
+	for _, ref := range groupRef.FindAllString(rw.Target, -1) {
+		name := strings.Trim(ref, "${}")
+		if re.SubexpIndex(name) < 0 && !validGroupNumber(name, re.NumSubexp()) {
+			return nil, fmt.Errorf("rewrite target uses %s but the pattern has no such group", ref)
+		}
+	}
+	base, err := rw.targetBase()
+	if err != nil {
+		return nil, err
+	}
+	if err := app.checkDestination(ctx, base.String(), true); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+var groupRef = regexp.MustCompile(`\$(\w+|\{\w+\})`)
+
+func validGroupNumber(name string, groups int) bool {
+	n, err := strconv.Atoi(name)
+	return err == nil && n >= 0 && n <= groups
+}
+
+// applyRewrite points a /{code}/{rest} request at its rewritten destination,
+// false when it doesn't go anywhere. plain /{code} requests pass through
+func (app *App) applyRewrite(r *http.Request, link cachedLink) (cachedLink, bool) {
+	rest := mux.Vars(r)["rest"]
+	if rest == "" {
+		return link, true
+	}
+	dest, ok := app.rewriteDestination(r, link.Rewrite, rest)
+	if !ok {
+		return link, false
+	}
+	link.URL = dest
+	return link, true
+}
+
+// rewriteDestination works out where /{code}/{rest} goes, false when the
+// link has no rewrite or the path doesn't match it
+func (app *App) rewriteDestination(r *http.Request, rw *Rewrite, rest string) (string, bool) {
+	if rw == nil {
+		return "", false
+	}
+	re, err := regexp.Compile(rw.Pattern)
+	if err != nil {
+		return "", false
+	}
+	match := re.FindStringSubmatchIndex(rest)
+	if match == nil {
+		return "", false
+	}
+	dest := string(re.ExpandString(nil, rw.Target, rest, match))
+
+	// a group can't move the link to another host, whatever it captured
+	base, err := rw.targetBase()
+	if err != nil {
+		return "", false
+	}
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != base.Scheme || u.Host != base.Host || u.User != nil {
+		return "", false
+	}
+	if app.validateDestination(dest) != nil || app.checkDestination(r.Context(), dest, false) != nil {
+		return "", false
+	}
+	return dest, true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	// skips the dns lookups for private addresses, the test doesn't need the network
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+
+	for _, rw := range []Rewrite{
+		{Pattern: `^(.+)$`, Target: "https://$1.example.com/"}, // host from the request
+		{Pattern: `^(.+)$`, Target: "https://github.com/$2"},   // no such group
+		{Pattern: `^(.+)$`, Target: "https://github.com/$1x"},  // a group named 1x
+		{Pattern: `^(.+$`, Target: "https://github.com/$1"},
+		{Pattern: `^(.+)$`, Target: "ftp://github.com/$1"},
+	} {
+		rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/bad-rewrite", Rewrite: &rw})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status %d, want 400", rw, rec.Code)
+		}
+	}
+
+	rw := &Rewrite{Pattern: `^(?P<user>[^/]+)/([^/]+)$`, Target: "https://github.com/${user}/$2"}
+	code := ta.shorten(ShortenRequest{URL: "https://github.com/", Rewrite: rw}, http.StatusOK).ShortCode
+
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/" + code, http.StatusMovedPermanently, "https://github.com/"},
+		{"/" + code + "/golang/go", http.StatusMovedPermanently, "https://github.com/golang/go"},
+		{"/" + code + "/golang", http.StatusNotFound, ""},
+		{"/" + code + "/stats", http.StatusOK, ""},
+	}
+	for range 2 { // the second round comes from the redirect cache
+		for _, tt := range tests {
+			rec := ta.do(http.MethodGet, tt.path, nil)
+			if rec.Code != tt.status || rec.Header().Get("Location") != tt.want {
+				t.Errorf("%s: %d %q, want %d %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.status, tt.want)
+			}
+		}
+	}
+
+	// links without a rewrite don't answer sub-paths
+	plain := ta.shorten(ShortenRequest{URL: "https://example.com/plain"}, http.StatusOK).ShortCode
+	if rec := ta.do(http.MethodGet, "/"+plain+"/extra", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("sub-path of a plain link: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// search engines. short links are only redirects, there's nothing on them to
+// index, and an indexed short domain is noise at best and a way to find links
+// people thought were unlisted at worst. /robots.txt (ROBOTS_TXT) keeps
+// crawlers to the home page by default while still letting the link preview
+// fetchers that read it in, so cards keep working. it's templates/robots.txt,
+// so ASSETS_DIR can swap in a different one. ROBOTS_NOINDEX=true also sends
+// X-Robots-Tag: noindex with every redirect and link page, for the engines
+// that find them anyway
+
+// what /robots.txt says, ROBOTS_TXT
+const (
+	robotsDisallow = "disallow" // only the home page, and unfurlers everywhere
+	robotsAllow    = "allow"    // everything
+	robotsOff      = "off"      // no robots.txt, a 404 like any other missing path
+)
+
+func validRobots(mode string) error {
+	switch mode {
+	case robotsDisallow, robotsAllow, robotsOff:
+		return nil
+	}
+	return fmt.Errorf("unknown setting %q, want disallow, allow or off", mode)
+}
+
+// handles GET /robots.txt
+func (app *App) robotsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.Config.RobotsTxt == robotsOff {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	err := app.MailTemplates.ExecuteTemplate(w, "robots.txt", map[string]any{
+		"Allow": app.Config.RobotsTxt == robotsAllow,
+	})
+	if err != nil {
+		log.Printf("robots.txt render error: %v", err)
+	}
+}
+
+// noindex keeps a link response out of search results, with ROBOTS_NOINDEX
+func (app *App) noindex(w http.ResponseWriter) {
+	if app.Config.RobotsNoindex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+}
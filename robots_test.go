@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRobotsTxt(t *testing.T) {
+	ta := newTestApp(t, nil)
+	rec := ta.do(http.MethodGet, "/robots.txt", nil)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, "# ") || !strings.HasSuffix(body, "User-agent: *\nAllow: /$\nDisallow: /\n") || !strings.Contains(body, "User-agent: Twitterbot\n") {
+		t.Errorf("default robots.txt:\n%s", body)
+	}
+
+	ta = newTestApp(t, map[string]string{"ROBOTS_TXT": robotsAllow})
+	if body := ta.do(http.MethodGet, "/robots.txt", nil).Body.String(); body != "User-agent: *\nAllow: /\n" {
+		t.Errorf("allow:\n%s", body)
+	}
+
+	ta = newTestApp(t, map[string]string{"ROBOTS_TXT": robotsOff})
+	if rec := ta.do(http.MethodGet, "/robots.txt", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("off: status %d", rec.Code)
+	}
+	if validRobots("nope") == nil {
+		t.Error("bad ROBOTS_TXT accepted")
+	}
+}
+
+func TestRobotsNoindex(t *testing.T) {
+	for _, noindex := range []bool{false, true} {
+		env := map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"}
+		if noindex {
+			env["ROBOTS_NOINDEX"] = "true"
+		}
+		ta := newTestApp(t, env)
+		code := ta.shorten(ShortenRequest{URL: "https://example.com/hidden"}, http.StatusOK).ShortCode
+
+		want := ""
+		if noindex {
+			want = "noindex"
+		}
+		for _, path := range []string{"/" + code, "/" + code + "/stats"} {
+			if got := ta.do(http.MethodGet, path, nil).Header().Get("X-Robots-Tag"); got != want {
+				t.Errorf("ROBOTS_NOINDEX=%v %s: X-Robots-Tag %q", noindex, path, got)
+			}
+		}
+		// the rest of the app isn't a link
+		if got := ta.do(http.MethodGet, "/", nil).Header().Get("X-Robots-Tag"); got != "" {
+			t.Errorf("home page: X-Robots-Tag %q", got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"golang.org/x/text/language"
+)
+
+// redirect rules. with LINK_RULES on, a link can carry a CEL expression that
+// picks its destination per click, so routing by country, device or time of
+// day doesn't need a feature of its own every time:
+//
+//	country == "DE" ? "https://example.de" : os == "iOS" ? "https://apps.apple.com/app/x" : destination
+//
+// an empty string (or anything that isn't a valid destination) falls back to
+// the link's own url. rules are compiled when they're saved and each run is
+// capped in cost and time, so a bad one can only break its own link. links
+// with a rule always answer 302 no-store - the answer depends on who's asking
+
+const (
+	maxRuleLen     = 2000
+	ruleCostLimit  = 10000
+	ruleTimeout    = 10 * time.Millisecond
+	maxCachedRules = 10000
+)
+
+var errRulesOff = errors.New("link rules are not enabled on this server")
+
+// the variables a rule can use
+var ruleEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		ext.Strings(),
+		cel.Variable("destination", cel.StringType), // the link's own url
+		cel.Variable("country", cel.StringType),     // from COUNTRY_HEADER, "" when that's off
+		cel.Variable("user_agent", cel.StringType),
+		cel.Variable("browser", cel.StringType),
+		cel.Variable("os", cel.StringType),
+		cel.Variable("referrer", cel.StringType), // host only, "direct" when there was none
+		cel.Variable("language", cel.StringType), // first Accept-Language, e.g. "de"
+		cel.Variable("bot", cel.BoolType),
+		cel.Variable("ip", cel.StringType),
+		cel.Variable("now", cel.TimestampType),
+	)
+})
+
+// compileRule checks a rule and returns it ready to run
+func compileRule(expr string) (cel.Program, error) {
+	if len(expr) > maxRuleLen {
+		return nil, fmt.Errorf("rule must be at most %d characters", maxRuleLen)
+	}
+	env, err := ruleEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid rule: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.StringType {
+		return nil, fmt.Errorf("rule must return a string, not %s", ast.OutputType())
+	}
+	return env.Program(ast, cel.CostLimit(ruleCostLimit), cel.InterruptCheckFrequency(100))
+}
+
+// normalizeRule checks a rule before it's saved, empty means none
+func (app *App) normalizeRule(expr string) (string, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return "", nil
+	}
+	if !app.Config.LinkRules {
+		return "", errRulesOff
+	}
+	if _, err := compileRule(expr); err != nil {
+		return "", err
+	}
+	return expr, nil
+}
+
+// ruleCache keeps compiled rules so a redirect doesn't parse its rule every time
+type ruleCache struct {
+	mu       sync.Mutex
+	programs map[string]cel.Program
+}
+
+func newRuleCache() *ruleCache {
+	return &ruleCache{programs: map[string]cel.Program{}}
+}
+
+func (c *ruleCache) get(expr string) (cel.Program, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prg, ok := c.programs[expr]; ok {
+		return prg, nil
+	}
+	prg, err := compileRule(expr)
+	if err != nil {
+		return nil, err
+	}
+	// plenty for the rules that are actually hot, start over past that
+	if len(c.programs) >= maxCachedRules {
+		clear(c.programs)
+	}
+	c.programs[expr] = prg
+	return prg, nil
+}
+
+// applyRule runs the link's rule, if it has one, and points the redirect at
+// whatever it picked
+func (app *App) applyRule(r *http.Request, shortCode string, link cachedLink, click Click) cachedLink {
+	if link.Rule == "" || !app.Config.LinkRules {
+		return link
+	}
+	// never cacheable, even when the rule fell back to the default
+	link.Temporary = true
+
+	dest, err := app.evalRule(r, link, click)
+	if err == nil && dest != "" {
+		err = app.validateDestination(dest)
+		if err == nil {
+			err = app.checkDestination(r.Context(), dest, false)
+		}
+	}
+	if err != nil {
+		log.Printf("rule for %s: %v", shortCode, err)
+		return link
+	}
+	if dest != "" {
+		link.URL = dest
+	}
+	return link
+}
+
+func (app *App) evalRule(r *http.Request, link cachedLink, click Click) (string, error) {
+	prg, err := app.Rules.get(link.Rule)
+	if err != nil {
+		return "", err
+	}
+	lang := ""
+	if tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); len(tags) > 0 {
+		base, _ := tags[0].Base()
+		lang = base.String()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), ruleTimeout)
+	defer cancel()
+	out, _, err := prg.ContextEval(ctx, map[string]any{
+		"destination": link.URL,
+		"country":     click.Country,
+		"user_agent":  r.UserAgent(),
+		"browser":     click.Browser,
+		"os":          click.OS,
+		"referrer":    click.Referrer,
+		"language":    lang,
+		"bot":         click.Bot,
+		"ip":          clientIP(r),
+		"now":         app.now(),
+	})
+	if err != nil {
+		return "", err
+	}
+	dest, ok := out.Value().(string)
+	if !ok {
+		return "", fmt.Errorf("rule returned %s, not a string", out.Type())
+	}
+	return strings.TrimSpace(dest), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLinkRules(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"LINK_RULES": "true", "COUNTRY_HEADER": "CF-IPCountry"})
+
+	for _, rule := range []string{`country ==`, `1 + 2`, `"` + strings.Repeat("x", maxRuleLen) + `"`} {
+		rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/bad-rule", Rule: rule})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("rule %.20q: status %d, want 400", rule, rec.Code)
+		}
+	}
+
+	rule := `country == "DE" ? "https://example.de/" : language == "fr" ? "javascript:alert(1)" : ""`
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/ruled", Rule: rule}, http.StatusOK).ShortCode
+
+	tests := []struct {
+		header []string
+		want   string
+	}{
+		{[]string{"CF-IPCountry", "DE"}, "https://example.de/"},
+		{[]string{"CF-IPCountry", "US"}, "https://example.com/ruled"},
+		{[]string{"Accept-Language", "fr-CA,fr;q=0.9"}, "https://example.com/ruled"}, // not a valid destination
+	}
+	// twice, so the second round comes from the redirect cache
+	for range 2 {
+		for _, tt := range tests {
+			rec := ta.do(http.MethodGet, "/"+code, nil, tt.header...)
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != tt.want || rec.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("%v: %d %s (%s), want 302 %s", tt.header, rec.Code, rec.Header().Get("Location"), rec.Header().Get("Cache-Control"), tt.want)
+			}
+		}
+	}
+}
+
+func TestLinkRulesOff(t *testing.T) {
+	ta := newTestApp(t, nil)
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/ruled", Rule: `destination`})
+	if rec.Code != http.StatusBadRequest || errorOf(t, rec) != errRulesOff.Error() {
+		t.Errorf("status %d, want 400 %q", rec.Code, errRulesOff)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// destination schemes. http and https are all most people need, ALLOWED_SCHEMES
+// opens up others - mailto:, tel:, sms: or app schemes like slack:// - and each
+// is checked for what it is rather than for a web host
+
+var (
+	schemePattern   = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+	hostPortPattern = regexp.MustCompile(`^[^:/?#]+:\d+([/?#]|$)`)
+	// rfc 3966 numbers with their visual separators, local or global
+	phonePattern = regexp.MustCompile(`^\+?[0-9().\- ]*[0-9][0-9().\- ]*$`)
+)
+
+var errInvalidURL = errors.New("invalid url format")
+
+// schemes whose host is a real network host, the rest never leave the visitor's device
+var networkSchemes = map[string]bool{"http": true, "https": true, "ftp": true, "ftps": true, "ws": true, "wss": true}
+
+// urlScheme is the scheme raw starts with, lowercased, or "" without one.
+// "example.com:8080/x" is a host and port, not an "example.com" scheme
+func urlScheme(raw string) string {
+	m := schemePattern.FindStringSubmatch(raw)
+	if m == nil || hostPortPattern.MatchString(raw) {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// webURL is true for the destinations that are fetched over http - only those
+// get previews, health checks, utm params and redirect tracing
+func webURL(raw string) bool {
+	scheme := urlScheme(raw)
+	return scheme == "http" || scheme == "https"
+}
+
+// envSchemes reads ALLOWED_SCHEMES, taking "mailto", "mailto:" or "slack://"
+// alike. anything that can't be a scheme goes on errs
+func envSchemes(key string, fallback []string, errs *[]error) []string {
+	list := envList(key)
+	if len(list) == 0 {
+		return fallback
+	}
+	var out []string
+	for _, s := range list {
+		s = strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(s, "//"), ":"))
+		if !schemePattern.MatchString(s + ":") {
+			*errs = append(*errs, fmt.Errorf("invalid %s entry %q", key, s))
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func (app *App) schemeAllowed(scheme string) bool {
+	for _, s := range app.Config.AllowedSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDestination checks a normalized destination against ALLOWED_SCHEMES
+// and the shape its scheme calls for
+func (app *App) validateDestination(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return errInvalidURL
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !app.schemeAllowed(scheme) {
+		return fmt.Errorf("%s: links are not allowed", scheme)
+	}
+
+	switch {
+	case networkSchemes[scheme]:
+		if u.Host == "" {
+			return errInvalidURL
+		}
+	case scheme == "mailto":
+		// mailto:a@example.com,b@example.com?subject=hi
+		to, err := url.PathUnescape(u.Opaque)
+		if err != nil || to == "" {
+			return errInvalidURL
+		}
+		if _, err := mail.ParseAddressList(to); err != nil {
+			return errors.New("invalid email address")
+		}
+	case scheme == "tel" || scheme == "sms":
+		// tel:+1-201-555-0123;ext=22, sms:+15550123?body=hi
+		number, _, _ := strings.Cut(u.Opaque, ";")
+		if number, err = url.PathUnescape(number); err != nil || !phonePattern.MatchString(number) {
+			return errors.New("invalid phone number")
+		}
+	default:
+		// app schemes have all sorts of shapes (slack://open, spotify:track:id),
+		// so they only need something after the scheme
+		if u.Host == "" && u.Opaque == "" && strings.Trim(u.Path, "/") == "" {
+			return errInvalidURL
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"example.com", "https://example.com"},
+		{"  example.com/path  ", "https://example.com/path"},
+		{"http://example.com", "http://example.com"},
+		{"HTTPS://example.com", "HTTPS://example.com"},
+		{"example.com:8080/x", "https://example.com:8080/x"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+		{"slack://open", "slack://open"},
+	}
+	for _, tt := range tests {
+		if got := normalizeURL(tt.in); got != tt.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDestination(t *testing.T) {
+	app := &App{Config: &Config{AllowedSchemes: []string{"http", "https", "mailto", "tel", "sms", "slack"}}}
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/a?b=c", true},
+		{"http://example.com", true},
+		{"https://", false},
+		{"https:///path-only", false},
+		{"ftp://example.com/file", false}, // not in the allowlist
+		{"mailto:someone@example.com", true},
+		{"mailto:a@example.com,b@example.com?subject=hi", true},
+		{"mailto:not-an-address", false},
+		{"mailto:", false},
+		{"tel:+1-201-555-0123", true},
+		{"tel:+1-201-555-0123;ext=22", true},
+		{"tel:(555)%20123-4567", true},
+		{"tel:call-me", false},
+		{"sms:+15550123?body=hi", true},
+		{"slack://open?team=T123", true},
+		{"slack:", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		err := app.validateDestination(tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateDestination(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestURLScheme(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://example.com", "https"},
+		{"MAILTO:x@example.com", "mailto"},
+		{"example.com", ""},
+		{"example.com:443", ""},
+		{"localhost:3000/app", ""},
+		{"spotify:track:123", "spotify"},
+	}
+	for _, tt := range tests {
+		if got := urlScheme(tt.in); got != tt.want {
+			t.Errorf("urlScheme(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
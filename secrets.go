@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// sensitive settings can come from the variable itself, from a file named by
+// <KEY>_FILE (docker and kubernetes secrets), or from vault by setting the
+// variable to vault:<path>#<field>. they're all read up front by loadSecrets,
+// so an unreadable file or a vault outage stops startup (or fails a reload)
+// instead of quietly running with a made up key
+var secretSettings = []string{
+	"ADMIN_TOKEN",
+	"STORE_KEY",
+	"JWT_SECRET",
+	"PREVIEW_SECRET",
+	"TOTP_KEY",
+	"EPHEMERAL_SECRET",
+	"SMTP_USERNAME",
+	"SMTP_PASSWORD",
+	"TURNSTILE_SECRET",
+	"HCAPTCHA_SECRET",
+	"RECAPTCHA_SECRET",
+	"NOTIFY_SLACK_WEBHOOK",
+	"NOTIFY_DISCORD_WEBHOOK",
+}
+
+// hmac keys shorter than this get a warning
+const minSigningKey = 32
+
+// secrets is what loadSecrets found, by setting name
+type secrets map[string]string
+
+// loadSecrets reads every sensitive setting, reporting all the problems at once
+func loadSecrets() (secrets, error) {
+	out := secrets{}
+	vault := &vaultReader{}
+	var errs []error
+	for _, key := range secretSettings {
+		value, err := readSecret(key, vault)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if value != "" {
+			out[key] = value
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+// readSecret is one setting from wherever it was put. vault is nil for the
+// settings vault itself needs
+func readSecret(key string, vault *vaultReader) (string, error) {
+	value, path := os.Getenv(key), os.Getenv(key+"_FILE")
+	if value != "" && path != "" {
+		return "", fmt.Errorf("set %s or %s_FILE, not both", key, key)
+	}
+	if path != "" {
+		return readSecretFile(path)
+	}
+	if ref, ok := strings.CutPrefix(value, "vault:"); ok {
+		if vault == nil {
+			return "", errors.New("can't come from vault")
+		}
+		return vault.read(key, ref)
+	}
+	return value, nil
+}
+
+// readSecretFile skips blank and # comment lines, so an age identity file or
+// a secret with a trailing newline works as is
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// secretSet says whether a secret was configured at all, rather than made up
+// at startup by signingKey
+func secretSet(key string) bool {
+	return os.Getenv(key) != "" || os.Getenv(key+"_FILE") != ""
+}
+
+// key is a setting as bytes, nil when unset
+func (s secrets) key(key string) []byte {
+	if v, ok := s[key]; ok {
+		return []byte(v)
+	}
+	return nil
+}
+
+// signingKey reads an hmac key. without one configured we make one up -
+// whatever it signs then only survives until the next restart, which is
+// fine for local use
+func (s secrets) signingKey(key, what string) []byte {
+	if v, ok := s[key]; ok {
+		if len(v) < minSigningKey {
+			log.Printf("%s is only %d bytes, use %d or more", key, len(v), minSigningKey)
+		}
+		return []byte(v)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("failed to generate %s: %v", strings.ToLower(key), err)
+	}
+	log.Printf("%s not set, %s will stop working on restart", key, what)
+	return secret
+}
+
+// vaultReader fetches vault:<path>#<field> references with VAULT_ADDR and
+// VAULT_TOKEN (or VAULT_TOKEN_FILE), and VAULT_NAMESPACE on enterprise. kv v1
+// and v2 both work, v2 paths include the data/ segment like the http api
+// wants. each path is fetched once per load
+type vaultReader struct {
+	addr, token string
+	client      *http.Client
+	paths       map[string]map[string]any
+}
+
+func (v *vaultReader) read(key, ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if field == "" {
+		field = strings.ToLower(key)
+	}
+	if path == "" {
+		return "", errors.New("vault reference needs a path, vault:<path>#<field>")
+	}
+	if v.client == nil {
+		token, err := readSecret("VAULT_TOKEN", nil)
+		if err != nil {
+			return "", fmt.Errorf("VAULT_TOKEN: %w", err)
+		}
+		v.addr, v.token = strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"), token
+		v.client, v.paths = &http.Client{Timeout: 10 * time.Second}, map[string]map[string]any{}
+	}
+	if v.addr == "" || v.token == "" {
+		return "", errors.New("vault references need VAULT_ADDR and VAULT_TOKEN")
+	}
+
+	fields, ok := v.paths[path]
+	if !ok {
+		var err error
+		if fields, err = v.fetch(path); err != nil {
+			return "", err
+		}
+		v.paths[path] = fields
+	}
+	value, ok := fields[field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("vault %s has no %s", path, field)
+	}
+	return value, nil
+}
+
+func (v *vaultReader) fetch(path string) (map[string]any, error) {
+	req, err := http.NewRequest(http.MethodGet, v.addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]any `json:"data"`
+		Errors []string       `json:"errors"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if len(body.Errors) > 0 {
+			return nil, fmt.Errorf("vault %s: %s: %s", path, resp.Status, strings.Join(body.Errors, ", "))
+		}
+		return nil, fmt.Errorf("vault %s: %s", path, resp.Status)
+	}
+	// kv v2 wraps the secret in data.data next to its metadata
+	if inner, ok := body.Data["data"].(map[string]any); ok && body.Data["metadata"] != nil {
+		return inner, nil
+	}
+	return body.Data, nil
+}
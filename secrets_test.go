@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSecret(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSecretFiles(t *testing.T) {
+	t.Setenv("JWT_SECRET_FILE", writeSecret(t, "jwt-secret-from-a-file-long-enough\n"))
+	t.Setenv("STORE_KEY_FILE", writeSecret(t, "# created: 2024-01-01\n# public key: age1xyz\nAGE-SECRET-KEY-1ABC\n"))
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config.JWTSecret) != "jwt-secret-from-a-file-long-enough" || string(config.StoreKey) != "AGE-SECRET-KEY-1ABC" {
+		t.Errorf("JWTSecret %q, StoreKey %q", config.JWTSecret, config.StoreKey)
+	}
+
+	// every problem is reported, not just the first
+	t.Setenv("ADMIN_TOKEN", "inline")
+	t.Setenv("ADMIN_TOKEN_FILE", writeSecret(t, "from a file"))
+	t.Setenv("SMTP_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = loadConfig()
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_TOKEN") || !strings.Contains(err.Error(), "SMTP_PASSWORD") {
+		t.Errorf("err = %v, want ADMIN_TOKEN and SMTP_PASSWORD", err)
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	fetches := 0
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/linkfast" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"data":     map[string]any{"jwt_secret": "jwt-secret-from-vault", "smtp": "smtp-password"},
+			"metadata": map[string]any{"version": 3},
+		}})
+	}))
+	defer vault.Close()
+
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN_FILE", writeSecret(t, "vault-token\n"))
+	t.Setenv("JWT_SECRET", "vault:secret/data/linkfast")
+	t.Setenv("SMTP_PASSWORD", "vault:secret/data/linkfast#smtp")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config.JWTSecret) != "jwt-secret-from-vault" || config.SMTPPassword != "smtp-password" {
+		t.Errorf("JWTSecret %q, SMTPPassword %q", config.JWTSecret, config.SMTPPassword)
+	}
+	if fetches != 1 {
+		t.Errorf("%d fetches for one path, want 1", fetches)
+	}
+
+	t.Setenv("ADMIN_TOKEN", "vault:secret/data/linkfast#admin")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "has no admin") {
+		t.Errorf("missing field: %v", err)
+	}
+	t.Setenv("VAULT_TOKEN_FILE", writeSecret(t, "wrong"))
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("bad token: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const sessionCookie = "lf_session"
+
+// Session is a browser login - the cookie holds a random token, the
+// "sessions" bucket has its hash so a leaked database can't be replayed
+type Session struct {
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newSession stores a session for the user and returns the cookie value
+func (app *App) newSession(user *User) (string, time.Time, error) {
+	token := randomToken("", 32)
+	now := time.Now()
+	session := Session{UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(app.Config.SessionTTL)}
+
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("sessions"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(hashToken(token)), data)
+	})
+	return token, session.ExpiresAt, err
+}
+
+// setSessionCookie sets (or with an empty token, clears) the login cookie.
+// Lax still sends it when a bookmarklet navigates here from another site
+func (app *App) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     app.path("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.Config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// sessionAuth accepts the login cookie. anything that can change something
+// needs the session's csrf token as well (see csrf.go) - without it the
+// cookie is ignored and the request goes on as whoever else it says it is.
+// that includes GET /api/shorten, which makes links from a plain navigation
+type sessionAuth struct {
+	app *App
+}
+
+func (a *sessionAuth) Name() string { return "session" }
+
+func (a *sessionAuth) Authenticate(r *http.Request) (*Identity, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+
+	var session *Session
+	err = a.app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("sessions"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(hashToken(cookie.Value)))
+		if v == nil {
+			return nil
+		}
+		session = &Session{}
+		return json.Unmarshal(v, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// a stale cookie just means logged out, not a failed request
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+	if (!safeMethod(r) || createsOnGet(r)) && !validCSRF(sentCSRF(r), csrfToken(cookie.Value)) {
+		return nil, nil
+	}
+
+	user, err := a.app.getUser(session.UserID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return &Identity{Method: "session", Subject: user.ID, Admin: a.app.userAdmin(user), User: user}, nil
+}
+
+// createsOnGet is the api's GET shorten, see shorteninput.go. the
+// bookmarklet's /shorten page is the one page meant to shorten for a logged in
+// user on a plain GET, and isn't matched
+func createsOnGet(r *http.Request) bool {
+	return r.URL.Path == "/api/"+apiVersion+"/shorten"
+}
+
+// safeNext only lets login send people back to a page on this site
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (app *App) renderLogin(w http.ResponseWriter, r *http.Request, status int, data map[string]any) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := app.page(r).ExecuteTemplate(w, "login.html", data); err != nil {
+		log.Printf("login render error: %v", err)
+	}
+}
+
+// handles GET /login
+func (app *App) loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	app.renderLogin(w, r, http.StatusOK, map[string]any{
+		"Next": safeNext(r.URL.Query().Get("next")),
+		"CSRF": app.setLoginCSRF(w, r),
+	})
+}
+
+// handles POST /login - the browser counterpart of /api/auth/login
+func (app *App) loginFormHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBody)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(r.PostForm.Get("email")))
+	next := safeNext(r.PostForm.Get("next"))
+	if !loginCSRFOK(r) {
+		// a form posted from somewhere else, or one from before the cookie expired
+		app.renderLogin(w, r, http.StatusForbidden, map[string]any{"Next": next, "Email": email, "CSRF": app.setLoginCSRF(w, r), "Error": "Your login form expired, please try again"})
+		return
+	}
+
+	user, err := app.checkPassword(email, r.PostForm.Get("password"))
+	if err != nil {
+		log.Printf("login error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		app.renderLogin(w, r, http.StatusUnauthorized, map[string]any{"Next": next, "Email": email, "CSRF": app.setLoginCSRF(w, r), "Error": "Wrong email or password"})
+		return
+	}
+	if user.TOTPSecret != "" {
+		// the password has to come again with the code, nothing is kept in between
+		otp := r.PostForm.Get("otp")
+		failed := "Enter the code from your authenticator app"
+		if otp != "" {
+			ok, err := app.useSecondFactor(user.ID, otp)
+			if err != nil {
+				log.Printf("2fa check error for %s: %v", user.ID, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			failed = "Wrong or used two-factor code"
+			if ok {
+				failed = ""
+			}
+		}
+		if failed != "" {
+			app.renderLogin(w, r, http.StatusUnauthorized, map[string]any{"Next": next, "Email": email, "CSRF": app.setLoginCSRF(w, r), "OTP": true, "Error": failed})
+			return
+		}
+	}
+
+	token, expires, err := app.newSession(user)
+	if err != nil {
+		log.Printf("session save error: %v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	app.setSessionCookie(w, token, expires)
+	http.Redirect(w, r, app.path(next), http.StatusSeeOther)
+}
+
+// handles POST /logout - drops the session server side as well as the cookie
+func (app *App) logoutFormHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		if !validCSRF(sentCSRF(r), csrfToken(cookie.Value)) {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+		err := app.DB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte("sessions"))
+			if bucket == nil {
+				return nil
+			}
+			return bucket.Delete([]byte(hashToken(cookie.Value)))
+		})
+		if err != nil {
+			log.Printf("session delete error: %v", err)
+		}
+	}
+	app.setSessionCookie(w, "", time.Time{})
+	http.Redirect(w, r, app.path("/"), http.StatusSeeOther)
+}
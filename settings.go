@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// server-wide settings an admin changes at runtime, kept as one record in the
+// settings bucket and mirrored in memory like the flags. for now that's the
+// defaults every new link starts from - they sit behind the request and the
+// api key's own defaults, so anything set per link wins
+
+// LinkDefaults fill in what a shorten request and its api key leave out
+type LinkDefaults struct {
+	Redirect     string            `json:"redirect,omitempty"`     // permanent or temporary
+	ExpiresIn    string            `json:"expires_in,omitempty"`   // go duration, a link can still say "never"
+	UTM          map[string]string `json:"utm,omitempty"`          // same as the utm option, {campaign} is substituted
+	Interstitial bool              `json:"interstitial,omitempty"` // warning page before the destination, see interstitial.go
+}
+
+// Settings is the settings bucket's record, and what GET/PUT /api/admin/settings take
+type Settings struct {
+	LinkDefaults LinkDefaults `json:"link_defaults"`
+	UpdatedAt    *time.Time   `json:"updated_at,omitempty"`
+	UpdatedBy    string       `json:"updated_by,omitempty"`
+}
+
+const settingsKey = "settings"
+
+// options is the defaults as shorten options, for fillFrom
+func (d LinkDefaults) options() *ShortenOptions {
+	o := &ShortenOptions{Redirect: d.Redirect, ExpiresIn: d.ExpiresIn, UTM: d.UTM}
+	if d.Interstitial {
+		o.Interstitial = &d.Interstitial
+	}
+	return o
+}
+
+// settingsBox is the in-memory copy of the settings bucket
+type settingsBox struct {
+	mu       sync.RWMutex
+	settings Settings
+}
+
+func (s *settingsBox) get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+func (s *settingsBox) set(settings Settings) {
+	s.mu.Lock()
+	s.settings = settings
+	s.mu.Unlock()
+}
+
+// linkDefaults is what createLink and batch fill requests from
+func (app *App) linkDefaults() *ShortenOptions {
+	return app.Settings.get().LinkDefaults.options()
+}
+
+// loadSettings reads the settings bucket into memory
+func (app *App) loadSettings() error {
+	var settings Settings
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("settings"))
+		if bucket == nil {
+			return nil
+		}
+		if data := bucket.Get([]byte(settingsKey)); data != nil {
+			return json.Unmarshal(data, &settings)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	app.Settings.set(settings)
+	return nil
+}
+
+// validateLinkDefaults checks the defaults the same way a link's own options
+// are checked. frame and meta aren't allowed, they'd refuse every link to
+// something other than a web page
+func (app *App) validateLinkDefaults(d *LinkDefaults) error {
+	opts := ShortenOptions{Redirect: d.Redirect, ExpiresIn: d.ExpiresIn, UTM: d.UTM}
+	var problems fieldErrors
+	if d.Redirect != "" && d.Redirect != redirectPermanent && d.Redirect != redirectTemporary {
+		problems.add("redirect", errors.New("the default redirect must be permanent or temporary"))
+		opts.Redirect = ""
+	}
+	if d.ExpiresIn == expiresNever {
+		problems.add("expires_in", errors.New("leave expires_in out for links that don't expire"))
+		opts.ExpiresIn = ""
+	}
+	problems.add("", app.validateOptions(&opts))
+	d.UTM = opts.UTM
+	return problems.err()
+}
+
+// handles /api/admin/settings - GET shows them, PUT replaces them and DELETE
+// goes back to none. links made before a change keep what they were made with
+func (app *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings := app.Settings.get()
+	if r.Method != http.MethodGet {
+		settings = Settings{}
+		if r.Method == http.MethodPut {
+			if err := decodeJSON(r, &settings); err != nil {
+				jsonBodyError(w, err)
+				return
+			}
+			if err := app.validateLinkDefaults(&settings.LinkDefaults); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+		}
+		now := app.now()
+		settings.UpdatedAt, settings.UpdatedBy = &now, requestActor(r).Actor
+
+		err := app.DB.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte("settings"))
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(settings)
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte(settingsKey), data)
+		})
+		if err != nil {
+			log.Printf("settings save error: %v", err)
+			writeError(w, http.StatusInternalServerError, errorServer, "failed to save settings")
+			return
+		}
+		app.Settings.set(settings)
+		log.Printf("settings updated by %s", settings.UpdatedBy)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
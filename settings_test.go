@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLinkDefaultSettings(t *testing.T) {
+	ta := newTestApp(t, nil)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	defaults := map[string]any{"link_defaults": map[string]any{
+		"redirect": "temporary", "expires_in": "24h", "utm": map[string]string{"source": "shortener"}, "interstitial": true,
+	}}
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/settings", defaults); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin token: status %d, want 401", rec.Code)
+	}
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/settings", map[string]any{"link_defaults": map[string]string{"redirect": "frame"}}, admin...); rec.Code != http.StatusBadRequest {
+		t.Errorf("frame default: status %d, want 400", rec.Code)
+	}
+	if rec := ta.do(http.MethodPut, "/api/v1/admin/settings", defaults, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("put settings: status %d: %s", rec.Code, rec.Body)
+	}
+
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/defaults"}, http.StatusOK).ShortCode
+	u, _ := ta.getURL(code)
+	if u.Redirect != redirectTemporary || u.ExpiresAt == nil || !u.Interstitial || u.OriginalURL != "https://example.com/defaults?utm_source=shortener" {
+		t.Errorf("link from the defaults = %+v", u)
+	}
+	rec := ta.do(http.MethodGet, "/"+code, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `href="https://example.com/defaults?utm_source=shortener"`) {
+		t.Errorf("interstitial: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// anything set on the link wins
+	rec = ta.do(http.MethodPost, "/api/v1/shorten", map[string]any{
+		"url": "https://example.com/own", "redirect": "permanent", "expires_in": "never", "interstitial": false, "utm": map[string]string{"source": "newsletter"},
+	}, admin...)
+	var own ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&own)
+	u, _ = ta.getURL(own.ShortCode)
+	if u.Redirect != redirectPermanent || u.ExpiresAt != nil || u.Interstitial || u.OriginalURL != "https://example.com/own?utm_source=newsletter" {
+		t.Errorf("link with its own options = %+v", u)
+	}
+	if rec := ta.do(http.MethodGet, "/"+own.ShortCode, nil); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("own options: status %d, want 301", rec.Code)
+	}
+
+	// and the page can be turned off later
+	if rec := ta.do(http.MethodPatch, "/api/v1/links/"+code, map[string]bool{"interstitial": false}, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("patch: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodGet, "/"+code, nil); rec.Code != http.StatusFound {
+		t.Errorf("after turning the interstitial off: status %d, want 302", rec.Code)
+	}
+
+	if rec := ta.do(http.MethodDelete, "/api/v1/admin/settings", nil, admin...); rec.Code != http.StatusOK {
+		t.Fatalf("delete settings: status %d", rec.Code)
+	}
+	u, _ = ta.getURL(ta.shorten(ShortenRequest{URL: "https://example.com/plain"}, http.StatusOK).ShortCode)
+	if u.Redirect != "" || u.ExpiresAt != nil || u.Interstitial || u.OriginalURL != "https://example.com/plain" {
+		t.Errorf("link after clearing the defaults = %+v", u)
+	}
+}
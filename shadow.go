@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SHADOW_URL mirrors production traffic to a second deployment - a new
+// version, or the same app on a backend being migrated to - so it can be
+// checked against real requests before it takes any. SHADOW_SAMPLE percent of
+// requests, api calls and redirects alike, are replayed there in the
+// background once we've answered: same method, path, headers (Host included,
+// so domain links resolve the same) and body. the visitor never waits on it
+// and its answers are thrown away, only the status is compared with ours.
+// mirrored requests carry X-Shadow-Request so a shadow doesn't pass them on
+// again. writes are mirrored too, so the shadow wants its own database
+
+const shadowHeader = "X-Shadow-Request"
+
+// shadowMirror is where mirrored requests go and how many can be out at once
+type shadowMirror struct {
+	base   string // no trailing slash, the request uri goes after it
+	client *http.Client
+	slots  chan struct{}
+}
+
+func newShadowMirror(config *Config) (*shadowMirror, error) {
+	u, err := url.Parse(config.ShadowURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("SHADOW_URL must be an absolute http(s) url")
+	}
+	if config.ShadowSample < 0 || config.ShadowSample > 100 {
+		return nil, errors.New("SHADOW_SAMPLE must be a percentage between 0 and 100")
+	}
+	return &shadowMirror{
+		base: strings.TrimRight(u.String(), "/"),
+		// the shadow is usually on the internal network, and its redirects are
+		// answers to compare, not something to follow
+		client: newWebhookClient(config.ShadowTimeout, true),
+		slots:  make(chan struct{}, max(config.ShadowMaxInFlight, 1)),
+	}, nil
+}
+
+// shadowRequest is what's kept of a request to replay it, taken before our
+// handlers get to it
+type shadowRequest struct {
+	method string
+	uri    string
+	host   string
+	header http.Header
+	body   []byte
+}
+
+// sampleShadow picks the requests that get mirrored
+func (app *App) sampleShadow() bool {
+	return app.random().Uint64()%10000 < uint64(app.Config.ShadowSample*100)
+}
+
+// shadowMiddleware sits outside the router so requests nothing matched are
+// mirrored too - a route missing on one side is the kind of thing to catch
+func (app *App) shadowMiddleware(next http.Handler) http.Handler {
+	if app.Shadow == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(shadowHeader) != "" || !app.sampleShadow() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		req := shadowRequest{method: r.Method, uri: r.URL.RequestURI(), host: r.Host, header: r.Header.Clone()}
+		// keep a copy of the body for the mirror. one too big gets a 413 from
+		// us, no point sending it on
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(io.LimitReader(r.Body, app.Config.MaxBodyBytes+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if err != nil || int64(len(body)) > app.Config.MaxBodyBytes {
+				next.ServeHTTP(w, r)
+				return
+			}
+			req.body = body
+		}
+		req.header.Set("X-Forwarded-For", clientIP(r))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		select {
+		case app.Shadow.slots <- struct{}{}:
+		default:
+			// the shadow can't keep up, it doesn't get to slow us down
+			app.Metrics.incr("shadow.dropped")
+			return
+		}
+		go func() {
+			defer func() { <-app.Shadow.slots }()
+			app.mirror(req, rec.status)
+		}()
+	})
+}
+
+// mirror replays a request on the shadow and compares its status with ours
+func (app *App) mirror(req shadowRequest, status int) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.Config.ShadowTimeout)
+	defer cancel()
+
+	out, err := http.NewRequestWithContext(ctx, req.method, app.Shadow.base+req.uri, bytes.NewReader(req.body))
+	if err != nil {
+		log.Printf("shadow %s %s: %v", req.method, req.uri, err)
+		app.Metrics.incr("shadow.failed")
+		return
+	}
+	out.Header = req.header
+	out.Header.Del("Connection")
+	out.Header.Set(shadowHeader, "1")
+	out.Host = req.host
+	if req.body == nil {
+		out.Body, out.ContentLength = http.NoBody, 0
+	}
+
+	resp, err := app.Shadow.client.Do(out)
+	if err != nil {
+		log.Printf("shadow %s %s: %v", req.method, req.uri, err)
+		app.Metrics.incr("shadow.failed")
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	app.Metrics.incr("shadow.sent")
+	if resp.StatusCode != status {
+		log.Printf("shadow %s %s: answered %d, we answered %d", req.method, req.uri, resp.StatusCode, status)
+		app.Metrics.incr("shadow.mismatch")
+	}
+}
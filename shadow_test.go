@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mirrored struct {
+	method, uri, host, body, marker string
+}
+
+func TestShadowMirrorsTraffic(t *testing.T) {
+	got := make(chan mirrored, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- mirrored{r.Method, r.URL.RequestURI(), r.Host, string(body), r.Header.Get(shadowHeader)}
+	}))
+	defer shadow.Close()
+
+	ta := newTestApp(t, map[string]string{"SHADOW_URL": shadow.URL + "/", "ALLOW_PRIVATE_DESTINATIONS": "true"})
+	next := func() mirrored {
+		t.Helper()
+		select {
+		case m := <-got:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing was mirrored")
+		}
+		return mirrored{}
+	}
+
+	code := ta.shorten(ShortenRequest{URL: "https://example.com/mirrored"}, http.StatusOK).ShortCode
+	m := next()
+	if m.method != http.MethodPost || m.uri != "/api/v1/shorten" || m.host != "example.com" || !strings.Contains(m.body, "example.com/mirrored") || m.marker != "1" {
+		t.Errorf("mirrored shorten = %+v", m)
+	}
+
+	if rec := ta.do(http.MethodGet, "/"+code+"?utm_source=x", nil); rec.Code != http.StatusMovedPermanently {
+		t.Fatalf("redirect: status %d", rec.Code)
+	}
+	if m := next(); m.method != http.MethodGet || m.uri != "/"+code+"?utm_source=x" || m.body != "" {
+		t.Errorf("mirrored redirect = %+v", m)
+	}
+
+	// already mirrored once, not passed on again
+	ta.do(http.MethodGet, "/"+code, nil, shadowHeader, "1")
+	select {
+	case m := <-got:
+		t.Errorf("mirrored a shadow request: %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestShadowSample(t *testing.T) {
+	calls := make(chan struct{}, 100)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	ta := newTestApp(t, map[string]string{"SHADOW_URL": shadow.URL, "SHADOW_SAMPLE": "0"})
+	for range 20 {
+		ta.do(http.MethodGet, "/", nil)
+	}
+	select {
+	case <-calls:
+		t.Error("SHADOW_SAMPLE=0 still mirrored")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for _, cfg := range []Config{{ShadowURL: "ftp://shadow.internal"}, {ShadowURL: "http://shadow.internal", ShadowSample: 101}} {
+		if _, err := newShadowMirror(&cfg); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// a bare url in a text/plain body - anything bigger isn't a url
+const maxPlainBody = 8 << 10
+
+// a form with a url and a few options
+const maxFormBody = 64 << 10
+
+// decodeShortenRequest reads a shorten request in whichever form the client
+// sent it, failing with a *shortenErr. plain is true for the curl-friendly forms (GET ?url= and text/plain
+// bodies, and forms from browsers), which get the short url back as text instead of json
+func decodeShortenRequest(w http.ResponseWriter, r *http.Request) (req ShortenRequest, plain bool, err error) {
+	q := r.URL.Query()
+	if r.Method == http.MethodGet {
+		return queryRequest(q.Get("url"), q), true, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/plain" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPlainBody+1))
+		if err != nil || len(body) > maxPlainBody {
+			return req, true, &shortenErr{status: http.StatusRequestEntityTooLarge, code: errorTooLarge, msg: "body must be a single url"}
+		}
+		return queryRequest(string(body), q), true, nil
+	}
+
+	// html forms and other tools' webhooks - same field names as the query string.
+	// a browser submitting a form wants to see the short url, not json
+	if mediaType == "application/x-www-form-urlencoded" {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFormBody))
+		if err != nil {
+			return req, false, &shortenErr{status: http.StatusRequestEntityTooLarge, code: errorTooLarge, msg: "form body is too large"}
+		}
+		// curl -d sends json with this content type, which always used to work
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		} else {
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return req, false, errors.New("invalid form body")
+			}
+			req = queryRequest(form.Get("url"), form)
+			return req, prefersText(r), nil
+		}
+	}
+
+	if err := decodeJSON(r, &req); err != nil {
+		return req, false, &shortenErr{status: bodyErrorStatus(err), code: bodyErrorCode(err), msg: bodyErrorMessage(err)}
+	}
+	return req, false, nil
+}
+
+// prefersText is true when the client asked for text (html or plain) and not json
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/") && !strings.Contains(accept, "json")
+}
+
+// queryRequest builds a shorten request from a url and query string / form fields
+func queryRequest(rawURL string, q url.Values) ShortenRequest {
+	return ShortenRequest{
+		URL:             strings.TrimSpace(rawURL),
+		Title:           q.Get("title"),
+		Description:     q.Get("description"),
+		Code:            q.Get("code"),
+		Fields:          queryFields(q),
+		ShortenOptions:  queryOptions(q),
+		FollowRedirects: queryTrue(q.Get("follow_redirects")),
+	}
+}
+
+// queryOptions reads the shorten options that can ride along in a query string
+func queryOptions(q url.Values) ShortenOptions {
+	return ShortenOptions{
+		Campaign:     q.Get("campaign"),
+		Tags:         splitList(q.Get("tags")),
+		ExpiresIn:    q.Get("expires_in"),
+		Domain:       q.Get("domain"),
+		Redirect:     q.Get("redirect"),
+		ClickWebhook: q.Get("click_webhook"),
+		Namespace:    q.Get("namespace"),
+		Reuse:        queryBool(q.Get("reuse")),
+		Private:      queryBool(q.Get("private")),
+		Interstitial: queryBool(q.Get("interstitial")),
+	}
+}
+
+// queryBool is nil for a value that's missing or not a bool, so the default applies.
+// yes/no and on/off count too, the last is what a form checkbox sends
+func queryBool(v string) *bool {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch v {
+	case "yes", "on":
+		v = "true"
+	case "no", "off":
+		v = "false"
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil
+	}
+	return &b
+}
+
+// queryTrue is queryBool for a plain bool, missing or not a bool is false
+func queryTrue(v string) bool {
+	b := queryBool(v)
+	return b != nil && *b
+}
+
+// shortenErr is a shorten that failed with something to tell the client
+type shortenErr struct {
+	status int
+	code   string // see errors.go
+	field  string
+	msg    string
+
+	problems fieldErrors // a request that failed its checks, see validation.go
+}
+
+func (e *shortenErr) Error() string { return e.msg }
+
+// shortenError reports a failed shorten in the same shape the request came in
+func shortenError(w http.ResponseWriter, plain bool, e *shortenErr) {
+	if plain {
+		http.Error(w, e.msg, e.status)
+		return
+	}
+	if len(e.problems) > 0 {
+		writeErrorResponse(w, e.status, e.problems.response())
+		return
+	}
+	writeFieldError(w, e.status, e.code, e.field, e.msg)
+}
+
+// shortenReply writes a successful shorten - plain clients just get the short url
+func shortenReply(w http.ResponseWriter, plain bool, status int, resp ShortenResponse) {
+	if plain {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, resp.ShortURL)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
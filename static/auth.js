@@ -0,0 +1,83 @@
+// translated ui text - the page puts its language's catalog in `messages`.
+// %s and %d are filled from args in order, like the go side
+function tr(msg, ...args) {
+    let out = (typeof messages !== 'undefined' && messages[msg]) || msg;
+    for (const arg of args) out = out.replace(/%[sd]/, () => arg);
+    return out;
+}
+
+// login for the pages. they sign in through /api/auth/login and keep the
+// tokens for the tab. without tab tokens the login cookie (if any) goes along
+// by itself, and changes need the page's csrf token with it
+let session = JSON.parse(sessionStorage.getItem('session') || 'null');
+
+function saveSession(tokens) {
+    session = tokens;
+    if (tokens) sessionStorage.setItem('session', JSON.stringify(tokens));
+    else sessionStorage.removeItem('session');
+}
+
+async function logIn(email, password) {
+    let response;
+    try {
+        response = await fetch('api/v1/auth/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ email: email, password: password })
+        });
+    } catch (error) {
+        throw new Error(tr('Network error'));
+    }
+    const data = await response.json();
+    if (!response.ok) throw new Error(data.message || tr('Error occurred'));
+    saveSession(data);
+}
+
+function logOut() {
+    if (session) {
+        fetch('api/v1/auth/logout', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: session.refresh_token })
+        });
+    }
+    saveSession(null);
+}
+
+// fetch with the access token, trading the refresh token for a new pair once
+// when it has expired. a dead refresh token fires "signedout" on the document
+async function authFetch(path, options = {}) {
+    if (!session) {
+        const csrf = document.querySelector('meta[name="csrf-token"]');
+        if (!csrf) return fetch(path, options);
+        return fetch(path, { ...options, headers: { ...options.headers, 'X-CSRF-Token': csrf.content } });
+    }
+    const send = () => fetch(path, {
+        ...options,
+        headers: { ...options.headers, 'Authorization': 'Bearer ' + session.access_token }
+    });
+    let response = await send();
+    if (response.status === 401) {
+        const refreshed = await fetch('api/v1/auth/refresh', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: session.refresh_token })
+        });
+        if (!refreshed.ok) {
+            saveSession(null);
+            document.dispatchEvent(new Event('signedout'));
+            return response;
+        }
+        saveSession(await refreshed.json());
+        response = await send();
+    }
+    return response;
+}
+
+async function api(path, options) {
+    const response = await authFetch(path, options);
+    if (response.status === 204) return null;
+    const data = await response.json();
+    if (!response.ok) throw new Error(data.message || response.statusText);
+    return data;
+}
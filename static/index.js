@@ -0,0 +1,280 @@
+async function shortenUrl() {
+    const url = document.getElementById('urlInput').value;
+    const errorDiv = document.getElementById('error');
+    const resultDiv = document.getElementById('result');
+
+    errorDiv.textContent = '';
+    resultDiv.classList.remove('show');
+
+    if (!url) {
+        errorDiv.textContent = tr('Please enter a URL');
+        return;
+    }
+
+    try {
+        // whichever captcha widget is on the page drops its token in one of these
+        const headers = { 'Content-Type': 'application/json' };
+        const captchaField = document.querySelector('[name="cf-turnstile-response"], [name="h-captcha-response"], [name="g-recaptcha-response"]');
+        if (captchaField) headers['X-Captcha-Token'] = captchaField.value;
+
+        // logged in, the link is theirs and shows up in the history below
+        const response = await authFetch('api/v1/shorten', {
+            method: 'POST',
+            headers: headers,
+            body: JSON.stringify({ url: url })
+        });
+        resetCaptcha();
+
+        const data = await response.json();
+
+        if (response.ok) {
+            document.getElementById('shortUrl').textContent = data.short_url;
+            resultDiv.classList.add('show');
+            if (session) loadHistory();
+        } else {
+            errorDiv.textContent = data.message || tr('Error occurred');
+        }
+    } catch (error) {
+        errorDiv.textContent = tr('Network error');
+    }
+}
+
+// captcha tokens are single use, get a fresh one for the next submit
+function resetCaptcha() {
+    if (window.turnstile) turnstile.reset();
+    if (window.hcaptcha) hcaptcha.reset();
+    if (window.grecaptcha) grecaptcha.reset();
+}
+
+function copyToClipboard() {
+    const shortUrl = document.getElementById('shortUrl').textContent;
+    navigator.clipboard.writeText(shortUrl).then(() => {
+        const btn = document.querySelector('.copy-btn');
+        btn.textContent = tr('Copied!');
+        setTimeout(() => btn.textContent = tr('Copy'), 2000);
+    });
+}
+
+document.getElementById('urlInput').addEventListener('keypress', function(e) {
+    if (e.key === 'Enter') shortenUrl();
+});
+
+// show what the url points at before it gets shortened
+let previewTimer;
+document.getElementById('urlInput').addEventListener('input', function(e) {
+    clearTimeout(previewTimer);
+    const box = document.getElementById('pagePreview');
+    const url = e.target.value.trim();
+    if (!url.includes('.')) {
+        box.classList.remove('show');
+        return;
+    }
+    previewTimer = setTimeout(async () => {
+        try {
+            const response = await fetch('api/v1/preview?url=' + encodeURIComponent(url));
+            if (!response.ok) {
+                box.classList.remove('show');
+                return;
+            }
+            const data = await response.json();
+            document.getElementById('previewIcon').src = data.favicon || '';
+            document.getElementById('previewTitle').textContent = data.title || data.url;
+            document.getElementById('previewDesc').textContent = data.description || '';
+            box.classList.add('show');
+        } catch (error) {
+            box.classList.remove('show');
+        }
+    }, 600);
+});
+
+// link history, once logged in (see auth.js)
+const historyPage = 20;
+let links = [];
+let shown = historyPage;
+
+function showAccount() {
+    document.getElementById('signIn').hidden = !!session;
+    document.getElementById('history').hidden = !session;
+    if (session) loadHistory();
+}
+
+document.getElementById('signIn').addEventListener('submit', async function(e) {
+    e.preventDefault();
+    const errorDiv = document.getElementById('signInError');
+    errorDiv.textContent = '';
+    try {
+        await logIn(document.getElementById('email').value, document.getElementById('password').value);
+        document.getElementById('password').value = '';
+        showAccount();
+    } catch (error) {
+        errorDiv.textContent = error.message;
+    }
+});
+
+document.getElementById('signOut').addEventListener('click', function() {
+    logOut();
+    showAccount();
+});
+
+document.addEventListener('signedout', showAccount);
+
+// the listing pages by short code, so pull the user's links in and sort them
+// newest first here
+async function loadHistory() {
+    const status = document.getElementById('historyStatus');
+    try {
+        const search = document.getElementById('search').value.trim();
+        const all = [];
+        let after = '';
+        do {
+            const data = await api('api/v1/links?limit=1000' + (search ? '&q=' + encodeURIComponent(search) : '') +
+                (after ? '&after=' + encodeURIComponent(after) : ''));
+            all.push(...data.links);
+            after = data.next || '';
+        } while (after);
+        links = all.sort((a, b) => new Date(b.created_at) - new Date(a.created_at));
+        status.textContent = links.length ? '' : (search ? tr('Nothing matches') : tr('Links you shorten will show up here'));
+        renderHistory();
+    } catch (error) {
+        status.textContent = error.message;
+    }
+}
+
+function renderHistory() {
+    const list = document.getElementById('linkList');
+    list.innerHTML = '';
+    for (const link of links.slice(0, shown)) list.appendChild(linkItem(link));
+    document.getElementById('showMore').hidden = links.length <= shown;
+}
+
+function el(tag, cls, text) {
+    const node = document.createElement(tag);
+    if (cls) node.className = cls;
+    if (text !== undefined) node.textContent = text;
+    return node;
+}
+
+function action(label, fn, cls) {
+    const btn = el('button', 'link-btn' + (cls ? ' ' + cls : ''), label);
+    btn.type = 'button';
+    btn.addEventListener('click', fn);
+    return btn;
+}
+
+function linkItem(link) {
+    const item = el('li');
+    item.append(el('div', 'title', link.title || link.short_url));
+    if (link.title) item.append(el('div', 'dest', link.short_url));
+    item.append(el('div', 'dest', link.original_url));
+    if (link.description) item.title = link.description;
+
+    const meta = el('div', 'meta');
+    meta.append(el('span', 'clicks', tr(link.click_count === 1 ? '%d click' : '%d clicks', link.click_count)));
+    meta.append(action(tr('Copy'), function() {
+        navigator.clipboard.writeText(link.short_url).then(() => {
+            this.textContent = tr('Copied!');
+            setTimeout(() => this.textContent = tr('Copy'), 2000);
+        });
+    }));
+    meta.append(action(tr('Stats'), () => toggleStats(item, link)));
+    meta.append(action(tr('Charts'), () => location.href = 'analytics?code=' + encodeURIComponent(link.short_code)));
+    meta.append(action(tr('Edit'), () => toggleEdit(item, link)));
+    meta.append(action(tr('Delete'), () => deleteLink(item, link), 'danger'));
+    item.append(meta);
+    return item;
+}
+
+async function toggleStats(item, link) {
+    const open = item.querySelector('.stats');
+    if (open) {
+        open.remove();
+        return;
+    }
+    const box = el('div', 'stats', tr('Loading...'));
+    item.append(box);
+    try {
+        const data = await api('api/v1/links/' + link.short_code + '/stats?days=30');
+        const top = (rows) => (rows || []).slice(0, 3).map(r => r.key + ' (' + r.count + ')').join(', ') || tr('none yet');
+        box.textContent = tr('%d clicks in the last 30 days. Top referrers: %s. Browsers: %s.',
+            data.total, top(data.referrers), top(data.browsers));
+    } catch (error) {
+        box.textContent = error.message;
+    }
+}
+
+function toggleEdit(item, link) {
+    const open = item.querySelector('form');
+    if (open) {
+        open.remove();
+        return;
+    }
+    const form = el('form');
+    const title = el('input');
+    title.placeholder = tr('Title');
+    title.value = link.title || '';
+    const description = el('input');
+    description.placeholder = tr('Description');
+    description.value = link.description || '';
+    const save = el('button', 'secondary', tr('Save'));
+    const error = el('div', 'error');
+    form.append(title, description, save, error);
+    form.addEventListener('submit', async function(e) {
+        e.preventDefault();
+        try {
+            const updated = await api('api/v1/links/' + link.short_code, {
+                method: 'PATCH',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ title: title.value, description: description.value })
+            });
+            Object.assign(link, { title: updated.title, description: updated.description });
+            item.replaceWith(linkItem(link));
+        } catch (err) {
+            error.textContent = err.message;
+        }
+    });
+    item.append(form);
+    title.focus();
+}
+
+// deleting only moves the link to the trash, so offer an undo
+async function deleteLink(item, link) {
+    if (!confirm(tr('Delete %s? It will stop redirecting.', link.short_url))) return;
+    try {
+        await api('api/v1/links/' + link.short_code, { method: 'DELETE' });
+    } catch (error) {
+        document.getElementById('historyStatus').textContent = error.message;
+        return;
+    }
+    const undo = el('li', 'muted', tr('%s deleted.', link.short_url) + ' ');
+    undo.append(action(tr('Undo'), async () => {
+        try {
+            await api('api/v1/links/' + link.short_code + '/restore', { method: 'POST' });
+            undo.replaceWith(linkItem(link));
+        } catch (error) {
+            undo.textContent = error.message;
+        }
+    }));
+    item.replaceWith(undo);
+    links = links.filter(l => l !== link);
+}
+
+document.getElementById('showMore').addEventListener('click', function() {
+    shown += historyPage;
+    renderHistory();
+});
+
+let searchTimer;
+document.getElementById('search').addEventListener('input', function() {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(() => {
+        shown = historyPage;
+        loadHistory();
+    }, 300);
+});
+
+showAccount();
+
+// makes the page installable, see sw.js
+if ('serviceWorker' in navigator) {
+    navigator.serviceWorker.register('sw.js').catch(() => {});
+}
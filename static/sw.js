@@ -0,0 +1,33 @@
+// service worker for the installed app. the home page and its files come from
+// the network when there is one and from the cache when there isn't - nothing
+// else goes through here, so short links and the api always hit the server
+const CACHE = 'shell-v1';
+const SHELL = ['./', 'static/index.css', 'static/auth.js', 'static/index.js', 'manifest.webmanifest', 'icon-192.png'];
+const shellURLs = SHELL.map(path => new URL(path, self.registration.scope).href);
+
+self.addEventListener('install', (event) => {
+    event.waitUntil(caches.open(CACHE).then(cache => cache.addAll(SHELL)));
+    self.skipWaiting();
+});
+
+self.addEventListener('activate', (event) => {
+    event.waitUntil(caches.keys()
+        .then(keys => Promise.all(keys.filter(key => key !== CACHE).map(key => caches.delete(key))))
+        .then(() => self.clients.claim()));
+});
+
+self.addEventListener('fetch', (event) => {
+    const url = new URL(event.request.url);
+    url.search = '';
+    if (event.request.method !== 'GET' || !shellURLs.includes(url.href)) return;
+
+    event.respondWith(fetch(event.request)
+        .then(response => {
+            if (response.ok) {
+                const copy = response.clone();
+                caches.open(CACHE).then(cache => cache.put(url.href, copy));
+            }
+            return response;
+        })
+        .catch(() => caches.match(url.href)));
+});
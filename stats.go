@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Click is what we keep about a single redirect, captured before the handler
+// returns since the request is gone by the time the background write runs
+type Click struct {
+	Time     time.Time
+	Referrer string // host only, "direct" when there was none
+	Browser  string
+	OS       string
+	Country  string // iso 3166 alpha-2, empty when COUNTRY_HEADER is off
+	Bot      bool
+	IP       string // client address, only used in memory by the fraud checks
+	Suspect  bool   // flagged by the fraud checks, see fraud.go
+	Private  bool   // only aggregate counts may be kept, see privacy.go
+}
+
+func (app *App) newClick(r *http.Request) Click {
+	browser, os := parseUserAgent(r.UserAgent())
+	return Click{
+		Time:     time.Now(),
+		Referrer: referrerHost(r.Referer()),
+		Browser:  browser,
+		OS:       os,
+		Country:  app.clickCountry(r),
+		Bot:      app.isBot(r),
+		IP:       clientIP(r),
+		Private:  app.privateClick(r),
+	}
+}
+
+// clickCountry reads the country code our proxy (cloudflare's CF-IPCountry,
+// a geoip module in nginx...) resolved for the visitor
+func (app *App) clickCountry(r *http.Request) string {
+	if app.Config.CountryHeader == "" {
+		return ""
+	}
+	code := strings.ToUpper(strings.TrimSpace(r.Header.Get(app.Config.CountryHeader)))
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' || code == "XX" {
+		return "unknown"
+	}
+	return code
+}
+
+// referrerHost boils a Referer header down to a lowercase host without www.
+func referrerHost(ref string) string {
+	if ref == "" {
+		return "direct"
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Hostname() == "" {
+		return "unknown"
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// stats live in a sub-bucket per link under "stats", as prefixed counters:
+// ref:<host>, browser:<family>, os:<family>, country:<code> and day:<yyyy-mm-dd> (utc), plus
+// the daily:<yyyy-mm-dd>:... rollups of compacted raw events, see clicklog.go
+func bumpCounter(bucket *bolt.Bucket, key string) error {
+	var n uint64
+	if v := bucket.Get([]byte(key)); len(v) == 8 {
+		n = binary.BigEndian.Uint64(v)
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n+1)
+	return bucket.Put([]byte(key), buf)
+}
+
+// recordStats bumps the aggregate counters for a click inside the caller's transaction
+func recordStats(tx *bolt.Tx, shortCode string, click Click) error {
+	stats, err := tx.CreateBucketIfNotExists([]byte("stats"))
+	if err != nil {
+		return err
+	}
+	bucket, err := stats.CreateBucketIfNotExists([]byte(shortCode))
+	if err != nil {
+		return err
+	}
+	keys := []string{"day:" + click.Time.UTC().Format(time.DateOnly)}
+	if !click.Private {
+		keys = append(keys, "ref:"+click.Referrer, "browser:"+click.Browser, "os:"+click.OS)
+		if click.Country != "" {
+			keys = append(keys, "country:"+click.Country)
+		}
+	}
+	sealer := storeCipherOf(tx)
+	for _, key := range keys {
+		if err := bumpCounter(bucket, sealer.counterKey(key)); err != nil {
+			return err
+		}
+	}
+	if click.Private {
+		return nil
+	}
+	return recordEvent(tx, shortCode, click)
+}
+
+// DayCount is one day of the click history
+type DayCount struct {
+	Day   time.Time `json:"day"`
+	Count uint64    `json:"count"`
+}
+
+// dailyClicks returns the last n days of clicks for a link, oldest first,
+// with zero days filled in
+func (app *App) dailyClicks(shortCode string, n int) ([]DayCount, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	days := make([]DayCount, n)
+	for i := range days {
+		days[i].Day = today.AddDate(0, 0, i-n+1)
+	}
+
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte("stats"))
+		if stats == nil {
+			return nil
+		}
+		bucket := stats.Bucket([]byte(shortCode))
+		if bucket == nil {
+			return nil
+		}
+		for i := range days {
+			if v := bucket.Get([]byte("day:" + days[i].Day.Format(time.DateOnly))); len(v) == 8 {
+				days[i].Count = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	return days, err
+}
+
+// StatCount is one row of a breakdown
+type StatCount struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// statBreakdown reads every counter with the given prefix for a link, biggest first
+func (app *App) statBreakdown(shortCode, prefix string) ([]StatCount, uint64, error) {
+	rows := []StatCount{}
+	var total uint64
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte("stats"))
+		if stats == nil {
+			return nil
+		}
+		bucket := stats.Bucket([]byte(shortCode))
+		if bucket == nil {
+			return nil
+		}
+		sealer := storeCipherOf(tx)
+		c := bucket.Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			if len(v) != 8 {
+				continue
+			}
+			n := binary.BigEndian.Uint64(v)
+			rows = append(rows, StatCount{Key: sealer.openWord(strings.TrimPrefix(string(k), prefix)), Count: n})
+			total += n
+		}
+		return nil
+	})
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows, total, err
+}
+
+// counter prefix for each breakdown the stats api can serve
+var statDimensions = map[string]string{
+	"referrers": "ref:",
+	"browsers":  "browser:",
+	"os":        "os:",
+	"countries": "country:",
+}
+
+// LinkStatsResponse carries whichever breakdowns were asked for. total is the
+// number of (human) clicks the breakdowns cover
+type LinkStatsResponse struct {
+	ShortCode string      `json:"short_code"`
+	Total     uint64      `json:"total"`
+	Referrers []StatCount `json:"referrers,omitzero"`
+	Browsers  []StatCount `json:"browsers,omitzero"`
+	OS        []StatCount `json:"os,omitzero"`
+	Countries []StatCount `json:"countries,omitzero"`
+	Daily     []DayCount  `json:"daily,omitzero"` // clicks per day, only with ?days=
+}
+
+// longest ?days= window the stats can be narrowed to
+const maxStatsDays = 3650
+
+// linkStats reads the given breakdowns for a link, all time or over the last
+// days (plus the day by day counts then)
+func (app *App) linkStats(shortCode string, dimensions []string, days int) (LinkStatsResponse, error) {
+	resp := LinkStatsResponse{ShortCode: shortCode}
+	var since time.Time
+	if days > 0 {
+		since = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+		daily, err := app.dailyClicks(shortCode, days)
+		if err != nil {
+			return resp, err
+		}
+		resp.Daily = daily
+	}
+	for _, d := range dimensions {
+		rows, total, err := app.statBreakdown(shortCode, statDimensions[d])
+		if !since.IsZero() {
+			rows, total, err = app.rangeBreakdown(shortCode, statDimensions[d], since)
+		}
+		if err != nil {
+			return resp, err
+		}
+		// every click bumps one counter per dimension, but links clicked before
+		// a dimension existed have fewer counts there - take the biggest
+		if total > resp.Total {
+			resp.Total = total
+		}
+		switch d {
+		case "referrers":
+			resp.Referrers = rows
+		case "browsers":
+			resp.Browsers = rows
+		case "os":
+			resp.OS = rows
+		case "countries":
+			resp.Countries = rows
+		}
+	}
+	return resp, nil
+}
+
+// handles GET /api/links/{shortCode}/stats and /api/links/{shortCode}/stats/{dimension}
+func (app *App) linkStatsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
+	urlData, ok := app.authorizeLink(w, r, shortCode)
+	if !ok {
+		return
+	}
+
+	dimensions := []string{"referrers", "browsers", "os"}
+	if app.Config.CountryHeader != "" {
+		dimensions = append(dimensions, "countries")
+	}
+	if d := vars["dimension"]; d != "" {
+		dimensions = []string{d}
+	}
+
+	// ?days=N narrows the breakdowns to the last N days (utc, today included)
+	days := 0
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days <= 0 || days > maxStatsDays {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "days", fmt.Sprintf("days must be between 1 and %d", maxStatsDays))
+			return
+		}
+	}
+
+	resp, err := app.linkStats(shortCode, dimensions, days)
+	if err != nil {
+		log.Printf("stats error for %s: %v", shortCode, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	// every click writes the link too, so its last write dates the stats. a
+	// ?days= window also moves at midnight without any click
+	modified := urlData.lastModified()
+	if days > 0 {
+		if today := time.Now().UTC().Truncate(24 * time.Hour); today.After(modified) {
+			modified = today
+		}
+	}
+	serveConditional(w, r, modified, resp)
+}
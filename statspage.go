@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// who gets to see the /{code}/stats page
+const (
+	statsPagePublic = "public" // anyone with the code, like the old goo.gl+ pages
+	statsPageOwner  = "owner"  // whoever created the link, admins, or a signed preview url
+	statsPageOff    = "off"
+)
+
+const statsPageDays = 7
+
+// a day in the chart, with its bar height as a percentage of the busiest day
+type statsPageDay struct {
+	DayCount
+	Height int
+}
+
+// handles GET /{shortCode}/stats
+func (app *App) statsPageHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	if app.Config.StatsPage == statsPageOff {
+		http.NotFound(w, r)
+		return
+	}
+
+	urlData, err := app.getURL(shortCode)
+	if err != nil || urlData == nil || urlData.Disabled || urlData.Quarantined || urlData.TrashedAt != nil || !app.servesLink(r, urlData.Domain) {
+		http.NotFound(w, r)
+		return
+	}
+
+	owner := app.Config.StatsPage == statsPageOwner || urlData.Private
+	if owner && !identityFromContext(r.Context()).owns(urlData) && !app.validPreviewToken(shortCode, r.URL.Query()) {
+		http.NotFound(w, r)
+		return
+	}
+
+	days, err := app.dailyClicks(shortCode, statsPageDays)
+	if err != nil {
+		log.Printf("stats page error for %s: %v", shortCode, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	referrers, _, err := app.statBreakdown(shortCode, "ref:")
+	if err != nil {
+		log.Printf("stats page error for %s: %v", shortCode, err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if len(referrers) > 10 {
+		referrers = referrers[:10]
+	}
+
+	var busiest uint64
+	for _, d := range days {
+		busiest = max(busiest, d.Count)
+	}
+	chart := make([]statsPageDay, len(days))
+	for i, d := range days {
+		chart[i] = statsPageDay{DayCount: d}
+		if busiest > 0 {
+			chart[i].Height = int(d.Count * 100 / busiest)
+		}
+	}
+
+	if owner {
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+	}
+	app.noindex(w)
+	w.Header().Set("Content-Type", "text/html")
+	err = app.page(r).ExecuteTemplate(w, "stats.html", map[string]any{
+		"ShortCode": shortCode,
+		"ShortURL":  app.linkShortURL(urlData),
+		"Link":      urlData,
+		"Days":      chart,
+		"Referrers": referrers,
+	})
+	if err != nil {
+		log.Printf("stats page render error: %v", err)
+	}
+}
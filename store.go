@@ -0,0 +1,222 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// getURL loads a single url record by short code, returns nil if it doesn't exist
+func (app *App) getURL(shortCode string) (*URL, error) {
+	var urlData *URL
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("urls"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(shortCode))
+		if v == nil {
+			return nil
+		}
+		urlData = &URL{}
+		return openJSON(tx, v, urlData)
+	})
+	return urlData, err
+}
+
+// linkOwner is who a link is deduped for - its user, else the api key that
+// made it, "" for anonymous and admin token links. handing someone else's
+// link back would give them a code its owner can still edit under them
+func linkOwner(userID, apiKeyID string) string {
+	switch {
+	case userID != "":
+		return "user:" + userID
+	case apiKeyID != "":
+		return "key:" + apiKeyID
+	}
+	return ""
+}
+
+// reverseKey is the dedupe key for a destination. each owner, short domain
+// and namespace dedupes on its own, so the same url can have a link in each.
+// with a STORE_KEY it's an hmac of that instead
+func reverseKey(tx *bolt.Tx, owner, domain, namespace, originalURL string) []byte {
+	key := originalURL
+	if namespace != "" {
+		key = strings.ToLower(domain) + "/" + namespace + " " + originalURL
+	} else if domain != "" {
+		key = strings.ToLower(domain) + " " + originalURL
+	}
+	if owner != "" {
+		key = owner + " " + key
+	}
+	return storeCipherOf(tx).indexKey([]byte(key))
+}
+
+// liveLinkTx finds the link owner already made for a destination on a domain
+// and namespace, if any and not expired by now
+func liveLinkTx(tx *bolt.Tx, owner, domain, namespace, originalURL string, now time.Time) *URL {
+	reverseBucket, bucket := tx.Bucket([]byte("reverse")), tx.Bucket([]byte("urls"))
+	if reverseBucket == nil || bucket == nil {
+		return nil
+	}
+	code := reverseBucket.Get(reverseKey(tx, owner, domain, namespace, originalURL))
+	if code == nil {
+		return nil
+	}
+	data := bucket.Get(code)
+	if data == nil {
+		return nil
+	}
+	var existing URL
+	if openJSON(tx, data, &existing) != nil {
+		return nil
+	}
+	// an expired or trashed link doesn't count, the url gets a fresh code. nor
+	// does one made private since, it's not for everyone who asks, or one that
+	// changed hands - a claimed anonymous link is its new owner's now
+	if existing.TrashedAt != nil || existing.Private || (existing.ExpiresAt != nil && !existing.ExpiresAt.After(now)) ||
+		linkOwner(existing.UserID, existing.APIKeyID) != owner {
+		return nil
+	}
+	return &existing
+}
+
+// updateURL does a read-modify-write of one record inside a single transaction
+// so background jobs don't clobber click counts (or each other)
+func (app *App) updateURL(shortCode string, fn func(u *URL) error) error {
+	return app.DB.Update(func(tx *bolt.Tx) error {
+		return updateURLTx(tx, shortCode, fn)
+	})
+}
+
+// updateURLTx is updateURL for callers that already have a write transaction.
+// fn isn't called at all when the code doesn't exist
+func updateURLTx(tx *bolt.Tx, shortCode string, fn func(u *URL) error) error {
+	bucket := tx.Bucket([]byte("urls"))
+	if bucket == nil {
+		return nil
+	}
+	v := bucket.Get([]byte(shortCode))
+	if v == nil {
+		return nil
+	}
+
+	var urlData URL
+	if err := openJSON(tx, v, &urlData); err != nil {
+		return err
+	}
+	before := syncEntry(&urlData)
+	if err := fn(&urlData); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	urlData.UpdatedAt = &now
+
+	updatedJSON, err := sealJSON(tx, urlData)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(shortCode), updatedJSON); err != nil {
+		return err
+	}
+
+	// click counts don't matter to sync clients, only what the code serves
+	if after := syncEntry(&urlData); after != before {
+		return appendChange(tx, after)
+	}
+	return nil
+}
+
+// listURLs walks the urls bucket in short code order starting after the given
+// cursor, returning at most limit matches plus the cursor for the next page
+func (app *App) listURLs(after string, limit int, match func(u *URL) bool) ([]URL, string, error) {
+	links := []URL{}
+	next := ""
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("urls"))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		k, v := c.First()
+		if after != "" {
+			k, v = c.Seek([]byte(after))
+			if k != nil && string(k) == after {
+				k, v = c.Next()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			var urlData URL
+			if err := openJSON(tx, v, &urlData); err != nil {
+				continue // skip corrupt records rather than failing the whole listing
+			}
+			if match != nil && !match(&urlData) {
+				continue
+			}
+			if len(links) == limit {
+				next = links[len(links)-1].ShortCode
+				break
+			}
+			links = append(links, urlData)
+		}
+		return nil
+	})
+	return links, next, err
+}
+
+// deleteURL removes a link and its reverse mapping (if the mapping still points at it),
+// logging the last version of it under the given actor and action
+func (app *App) deleteURL(shortCode string, who AuditEntry, action string) error {
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("urls"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(shortCode))
+		if v == nil {
+			return nil
+		}
+
+		if err := appendChange(tx, SyncChange{Code: shortCode, Deleted: true}); err != nil {
+			return err
+		}
+		if claims := tx.Bucket([]byte("claims")); claims != nil {
+			if err := claims.Delete([]byte(shortCode)); err != nil {
+				return err
+			}
+		}
+		for _, name := range []string{"stats", "clicks"} {
+			if parent := tx.Bucket([]byte(name)); parent != nil && parent.Bucket([]byte(shortCode)) != nil {
+				if err := parent.DeleteBucket([]byte(shortCode)); err != nil {
+					return err
+				}
+			}
+		}
+
+		var urlData URL
+		if openJSON(tx, v, &urlData) == nil {
+			entry := who
+			entry.Action, entry.ShortCode, entry.Old = action, shortCode, linkFields(&urlData)
+			if err := auditTx(tx, entry); err != nil {
+				return err
+			}
+			if reverseBucket := tx.Bucket([]byte("reverse")); reverseBucket != nil {
+				key := reverseKey(tx, linkOwner(urlData.UserID, urlData.APIKeyID), urlData.Domain, urlData.Namespace, urlData.OriginalURL)
+				if string(reverseBucket.Get(key)) == shortCode {
+					if err := reverseBucket.Delete(key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return bucket.Delete([]byte(shortCode))
+	})
+	if err == nil {
+		app.Cache.Delete(shortCode)
+	}
+	return err
+}
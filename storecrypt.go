@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// STORE_KEY (or STORE_KEY_FILE) encrypts what the db file says about where
+// links go and who clicked them, for deployments where it lands on shared
+// disks or in backups. link records, raw click events, the sync change feed,
+// the audit log and saved idempotent responses are sealed whole with aes-gcm.
+// the dedupe index and the favicons are keyed by an hmac of the destination
+// (or its host), and stats counters keep their prefixes (the breakdowns seek
+// on them) but seal the value, deterministically so a click still lands on
+// the same counter. codes, day counts and the other buckets stay as they are.
+//
+// the cipher hangs off the db handle rather than the app because the store
+// helpers (updateURLTx, auditTx, recordStats...) only ever get a transaction
+var storeCiphers sync.Map // *bolt.DB -> *storeCipher
+
+// sealed values start with a zero byte, which json never does, so records
+// written before the key was set keep reading until they're sealed
+const sealedMark = 0
+
+// sealed counter values look like ref:~<base64>
+const sealedWord = "~"
+
+// the "store" bucket remembers that the db is sealed, and with which key
+var storeCheckKey = []byte("key_check")
+
+var errStoreSealed = errors.New("the database is encrypted, set STORE_KEY or STORE_KEY_FILE to open it")
+
+type storeCipher struct {
+	aead  cipher.AEAD
+	index []byte // hmac key for the dedupe index and the counter nonces
+}
+
+func newStoreCipher(key []byte) (*storeCipher, error) {
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("store records"))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &storeCipher{aead: gcm, index: derive("store index")}, nil
+}
+
+// storeCipherOf is the cipher for a transaction's db, nil when it isn't
+// encrypted. every method below passes things through untouched on nil
+func storeCipherOf(tx *bolt.Tx) *storeCipher {
+	if c, ok := storeCiphers.Load(tx.DB()); ok {
+		return c.(*storeCipher)
+	}
+	return nil
+}
+
+func (c *storeCipher) seal(data []byte) []byte {
+	if c == nil {
+		return data
+	}
+	out := make([]byte, 1+c.aead.NonceSize(), 1+c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	out[0] = sealedMark
+	rand.Read(out[1:])
+	return c.aead.Seal(out, out[1:], data, nil)
+}
+
+func (c *storeCipher) open(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != sealedMark {
+		return data, nil
+	}
+	if c == nil {
+		return nil, errStoreSealed
+	}
+	n := 1 + c.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("malformed sealed record")
+	}
+	return c.aead.Open(nil, data[1:n], data[n:], nil)
+}
+
+// indexKey hides a lookup key that has a destination in it
+func (c *storeCipher) indexKey(key []byte) []byte {
+	if c == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, c.index)
+	mac.Write(key)
+	return mac.Sum([]byte{sealedMark})
+}
+
+// sealWord encrypts with a nonce taken from the value itself, so the same
+// value always seals the same way
+func (c *storeCipher) sealWord(s string) string {
+	if c == nil || s == "" {
+		return s
+	}
+	mac := hmac.New(sha256.New, c.index)
+	mac.Write([]byte(s))
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, mac.Sum(nil))
+	return sealedWord + base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(s), nil))
+}
+
+// openWord undoes sealWord, anything it can't open comes back as it was
+func (c *storeCipher) openWord(s string) string {
+	if c == nil || !strings.HasPrefix(s, sealedWord) {
+		return s
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s[len(sealedWord):])
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return s
+	}
+	plain, err := c.aead.Open(nil, data[:c.aead.NonceSize()], data[c.aead.NonceSize():], nil)
+	if err != nil {
+		return s
+	}
+	return string(plain)
+}
+
+// splitCounterKey pulls the value out of a breakdown counter key, ref:<host>
+// or daily:<yyyy-mm-dd>:ref:<host>. day counters have none
+func splitCounterKey(key string) (head, value string, ok bool) {
+	rest := key
+	if strings.HasPrefix(key, "daily:") && len(key) >= len("daily:yyyy-mm-dd:") {
+		rest = key[len("daily:yyyy-mm-dd:"):]
+	}
+	for _, prefix := range statDimensions {
+		if strings.HasPrefix(rest, prefix) {
+			n := len(key) - len(rest) + len(prefix)
+			return key[:n], key[n:], true
+		}
+	}
+	return key, "", false
+}
+
+// counterKey seals the value part of a stats counter key
+func (c *storeCipher) counterKey(key string) string {
+	head, value, ok := splitCounterKey(key)
+	if !ok {
+		return key
+	}
+	return head + c.sealWord(value)
+}
+
+// sealJSON marshals a record for one of the sealed buckets
+func sealJSON(tx *bolt.Tx, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return storeCipherOf(tx).seal(data), nil
+}
+
+// openJSON is json.Unmarshal for a record out of one of the sealed buckets
+func openJSON(tx *bolt.Tx, data []byte, v any) error {
+	data, err := storeCipherOf(tx).open(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// setupStoreCipher checks the key against the db and hooks the cipher up to
+// it. the first start with a key seals everything already there, which is one
+// write transaction - fine for the sizes bolt is used at
+func setupStoreCipher(db *bolt.DB, key []byte) error {
+	if key == nil {
+		return db.View(func(tx *bolt.Tx) error {
+			if bucket := tx.Bucket([]byte("store")); bucket != nil && bucket.Get(storeCheckKey) != nil {
+				return errStoreSealed
+			}
+			return nil
+		})
+	}
+	c, err := newStoreCipher(key)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("store"))
+		if err != nil {
+			return err
+		}
+		if check := bucket.Get(storeCheckKey); check != nil {
+			if _, err := c.open(check); err != nil {
+				return errors.New("STORE_KEY doesn't match the key the database was encrypted with")
+			}
+			return nil
+		}
+		if err := c.sealExisting(tx); err != nil {
+			return err
+		}
+		return bucket.Put(storeCheckKey, c.seal([]byte("ok")))
+	})
+	if err != nil {
+		return err
+	}
+	storeCiphers.Store(db, c)
+	return nil
+}
+
+// sealExisting encrypts what was written before there was a key
+func (c *storeCipher) sealExisting(tx *bolt.Tx) error {
+	for _, name := range []string{"urls", "changes", "audit", "idempotency"} {
+		if err := c.sealValues(tx.Bucket([]byte(name))); err != nil {
+			return err
+		}
+	}
+	if clicks := tx.Bucket([]byte("clicks")); clicks != nil {
+		err := clicks.ForEachBucket(func(k []byte) error {
+			return c.sealValues(clicks.Bucket(k))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{"reverse", "favicons"} {
+		if err := c.hideKeys(tx.Bucket([]byte(name))); err != nil {
+			return err
+		}
+	}
+
+	stats := tx.Bucket([]byte("stats"))
+	if stats == nil {
+		return nil
+	}
+	return stats.ForEachBucket(func(code []byte) error {
+		bucket := stats.Bucket(code)
+		counts := map[string]uint64{}
+		bucket.ForEach(func(k, v []byte) error {
+			if _, value, ok := splitCounterKey(string(k)); ok && len(v) == 8 && c.openWord(value) == value {
+				counts[string(k)] = binary.BigEndian.Uint64(v)
+			}
+			return nil
+		})
+		for key, n := range counts {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+			sealed := []byte(c.counterKey(key))
+			if v := bucket.Get(sealed); len(v) == 8 {
+				n += binary.BigEndian.Uint64(v)
+			}
+			buf := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf, n)
+			if err := bucket.Put(sealed, buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// hideKeys moves plain keys over to their indexKey
+func (c *storeCipher) hideKeys(bucket *bolt.Bucket) error {
+	if bucket == nil {
+		return nil
+	}
+	// collect first - writing under a live cursor skips keys
+	var keys, values [][]byte
+	bucket.ForEach(func(k, v []byte) error {
+		if k[0] != sealedMark {
+			keys, values = append(keys, append([]byte(nil), k...)), append(values, append([]byte(nil), v...))
+		}
+		return nil
+	})
+	for i, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+		if err := bucket.Put(c.indexKey(k), values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *storeCipher) sealValues(bucket *bolt.Bucket) error {
+	if bucket == nil {
+		return nil
+	}
+	plain := map[string][]byte{}
+	bucket.ForEach(func(k, v []byte) error {
+		if len(v) > 0 && v[0] != sealedMark {
+			plain[string(k)] = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	for k, v := range plain {
+		if err := bucket.Put([]byte(k), c.seal(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// leaks reports whether any key or value in the db contains s
+func leaks(t *testing.T, db *bolt.DB, s string) bool {
+	t.Helper()
+	found := false
+	var walk func(b *bolt.Bucket)
+	walk = func(b *bolt.Bucket) {
+		b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				walk(b.Bucket(k))
+			}
+			found = found || bytes.Contains(k, []byte(s)) || bytes.Contains(v, []byte(s))
+			return nil
+		})
+	}
+	db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, b *bolt.Bucket) error {
+			walk(b)
+			return nil
+		})
+	})
+	return found
+}
+
+func clickFrom(ta *testApp, code, referrer string) {
+	ta.do(http.MethodGet, "/"+code, nil, "Referer", referrer, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	ta.Clicks.Wait()
+}
+
+func TestStoreEncryption(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"STORE_KEY": "test-store-key"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	code := ta.shorten(ShortenRequest{URL: "https://hidden-destination.example/page"}, http.StatusOK).ShortCode
+	clickFrom(ta, code, "https://hidden-referrer.example/")
+	// a replayable response and an icon say where links go too
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://hidden-idempotent.example/"}, "Idempotency-Key", "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("idempotent shorten: status %d", rec.Code)
+	}
+	if err := ta.saveFavicon("hidden-icon.example", storedFavicon{Type: "image/png", Data: []byte("png"), FetchedAt: ta.now()}); err != nil {
+		t.Fatal(err)
+	}
+	if icon, err := ta.loadFavicon("hidden-icon.example"); err != nil || icon == nil || string(icon.Data) != "png" {
+		t.Errorf("loadFavicon = %+v, %v", icon, err)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://hidden-idempotent.example/"}, "Idempotency-Key", "k1"); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay: status %d, headers %v", rec.Code, rec.Header())
+	}
+
+	referrers := func(query string) []StatCount {
+		var resp LinkStatsResponse
+		json.NewDecoder(ta.do(http.MethodGet, "/api/v1/links/"+code+"/stats/referrers"+query, nil, admin...).Body).Decode(&resp)
+		return resp.Referrers
+	}
+	check := func(when string) {
+		t.Helper()
+		for _, query := range []string{"", "?days=7"} {
+			if rows := referrers(query); len(rows) != 1 || rows[0].Key != "hidden-referrer.example" {
+				t.Errorf("%s: referrers%s = %+v", when, query, rows)
+			}
+		}
+		for _, s := range []string{"hidden-destination", "hidden-referrer", "hidden-idempotent", "hidden-icon"} {
+			if leaks(t, ta.DB, s) {
+				t.Errorf("%s: %q is in the db file", when, s)
+			}
+		}
+	}
+	check("raw events")
+	if _, err := ta.compactClicks(ta.clock.Now().Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	check("rolled up")
+
+	// dedupe still finds the link through the hashed index
+	if resp := ta.shorten(ShortenRequest{URL: "https://hidden-destination.example/page"}, http.StatusOK); resp.Created || resp.ShortCode != code {
+		t.Errorf("shortening it again = %+v, want %s back", resp, code)
+	}
+}
+
+func TestStoreKeyOnExistingDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.db")
+	ta := newTestApp(t, map[string]string{"DB_PATH": path})
+	code := ta.shorten(ShortenRequest{URL: "https://plain-destination.example/"}, http.StatusOK).ShortCode
+	clickFrom(ta, code, "https://plain-referrer.example/")
+	ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://plain-idempotent.example/"}, "Idempotency-Key", "k1")
+	ta.saveFavicon("plain-icon.example", storedFavicon{Type: "image/png", Data: []byte("png"), FetchedAt: ta.now()})
+	ta.DB.Close()
+
+	open := func(key string) (*bolt.DB, error) {
+		cfg := &Config{Store: storeBolt, DBPath: path}
+		if key != "" {
+			cfg.StoreKey = []byte(key)
+		}
+		return openDatabase(cfg)
+	}
+	db, err := open("right key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"plain-destination", "plain-referrer", "plain-idempotent", "plain-icon"} {
+		if leaks(t, db, s) {
+			t.Errorf("existing records with %q weren't sealed", s)
+		}
+	}
+	app := &App{DB: db}
+	if icon, err := app.loadFavicon("plain-icon.example"); err != nil || icon == nil {
+		t.Errorf("loadFavicon after sealing = %+v, %v", icon, err)
+	}
+	if u, err := app.getURL(code); err != nil || u == nil || u.OriginalURL != "https://plain-destination.example/" {
+		t.Errorf("getURL = %+v, %v", u, err)
+	}
+	if rows, _, _ := app.statBreakdown(code, "ref:"); len(rows) != 1 || rows[0].Key != "plain-referrer.example" || rows[0].Count != 1 {
+		t.Errorf("referrers = %+v", rows)
+	}
+	db.Close()
+
+	if _, err := open("wrong key"); err == nil {
+		t.Error("opened with the wrong key")
+	}
+	if _, err := open(""); !errors.Is(err, errStoreSealed) {
+		t.Errorf("opening without a key: %v, want %v", err, errStoreSealed)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SyncChange is one entry of the compact change feed. a tombstone (D set) means
+// "stop serving this code" - deleted, disabled, or held for review
+type SyncChange struct {
+	Code    string `json:"c"`
+	URL     string `json:"u,omitempty"`
+	Expires int64  `json:"e,omitempty"` // unix seconds, 0 = never
+	Domain  string `json:"h,omitempty"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+type SyncResponse struct {
+	Cursor   string       `json:"cursor"`             // pass back as ?since= next time
+	Snapshot bool         `json:"snapshot,omitempty"` // full mapping - replace local state instead of merging
+	Changes  []SyncChange `json:"changes"`
+	More     bool         `json:"more,omitempty"` // call again straight away with the new cursor
+}
+
+// syncEntry is the bit of a link an edge cache needs to serve it. edges
+// can't check logins, so private links stay with us
+func syncEntry(u *URL) SyncChange {
+	if u.Disabled || u.Quarantined || u.TrashedAt != nil || u.Private {
+		return SyncChange{Code: u.ShortCode, Deleted: true}
+	}
+	change := SyncChange{Code: u.ShortCode, URL: u.OriginalURL, Domain: u.Domain}
+	if u.ExpiresAt != nil {
+		change.Expires = u.ExpiresAt.Unix()
+	}
+	return change
+}
+
+// appendChange adds an entry to the change log inside the caller's transaction,
+// so the log can never disagree with the urls bucket
+func appendChange(tx *bolt.Tx, change SyncChange) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("changes"))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := sealJSON(tx, change)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(seqKey(seq), data)
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// handles GET /api/sync?since=cursor - without a cursor it returns a full snapshot
+// of live links, after that only what changed (latest state per code)
+func (app *App) syncHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 10000 {
+		limit = 5000
+	}
+
+	var since uint64
+	if s := q.Get("since"); s != "" {
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "since", "invalid cursor")
+			return
+		}
+	}
+
+	resp := SyncResponse{Changes: []SyncChange{}}
+	err = app.DB.View(func(tx *bolt.Tx) error {
+		var current uint64
+		changes := tx.Bucket([]byte("changes"))
+		if changes != nil {
+			current = changes.Sequence()
+		}
+
+		// first sync - everything live, plus the cursor it's consistent with
+		if since == 0 {
+			resp.Snapshot = true
+			resp.Cursor = strconv.FormatUint(current, 10)
+			bucket := tx.Bucket([]byte("urls"))
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(k, v []byte) error {
+				var urlData URL
+				if openJSON(tx, v, &urlData) != nil {
+					return nil
+				}
+				if entry := syncEntry(&urlData); !entry.Deleted {
+					resp.Changes = append(resp.Changes, entry)
+				}
+				return nil
+			})
+		}
+
+		resp.Cursor = strconv.FormatUint(since, 10)
+		if changes == nil {
+			return nil
+		}
+
+		// collapse repeated changes to the same code, keeping the latest
+		index := map[string]int{}
+		c := changes.Cursor()
+		for k, v := c.Seek(seqKey(since + 1)); k != nil; k, v = c.Next() {
+			if len(index) == limit {
+				resp.More = true
+				break
+			}
+			var change SyncChange
+			if openJSON(tx, v, &change) != nil {
+				continue
+			}
+			if i, ok := index[change.Code]; ok {
+				resp.Changes[i] = change
+			} else {
+				index[change.Code] = len(resp.Changes)
+				resp.Changes = append(resp.Changes, change)
+			}
+			resp.Cursor = strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("sync error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
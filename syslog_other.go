@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func dialSyslog(addr, tag string) (io.Writer, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func dialSyslog(addr, tag string) (io.Writer, error) {
+	network, raddr := syslogTarget(addr)
+	return syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
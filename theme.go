@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// THEME values
+const (
+	themeAuto  = "auto" // follow the visitor's os setting
+	themeLight = "light"
+	themeDark  = "dark"
+)
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Brand is what pages show instead of the stock name and colors. templates get
+// it through the brand func, and the theme partial turns it into css variables
+type Brand struct {
+	Name      string
+	Logo      string // image url, optional
+	Color     string // accent for buttons, links and charts
+	ColorDark string // hover shade of Color
+	Theme     string
+}
+
+func newBrand(c *Config) (*Brand, error) {
+	switch c.Theme {
+	case themeAuto, themeLight, themeDark:
+	default:
+		return nil, fmt.Errorf("THEME must be auto, light or dark, got %q", c.Theme)
+	}
+	if !hexColorPattern.MatchString(c.BrandColor) {
+		return nil, fmt.Errorf("BRAND_COLOR must be a hex color like #007bff, got %q", c.BrandColor)
+	}
+	return &Brand{
+		Name:      c.BrandName,
+		Logo:      c.BrandLogo,
+		Color:     c.BrandColor,
+		ColorDark: darken(c.BrandColor, 0.8),
+		Theme:     c.Theme,
+	}, nil
+}
+
+// darken scales each channel of a #rgb or #rrggbb color
+func darken(hex string, factor float64) string {
+	c := parseHexColor(hex)
+	return fmt.Sprintf("#%02x%02x%02x", int(float64(c.R)*factor), int(float64(c.G)*factor), int(float64(c.B)*factor))
+}
+
+func parseHexColor(hex string) color.RGBA {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, _ := strconv.ParseUint(hex, 16, 32)
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// people log in for a short-lived access jwt plus an opaque refresh token.
+// refresh tokens are single use - every refresh hands out a new one in the
+// same "family", and presenting a used one again means it leaked, so the
+// whole family gets revoked (access tokens carry the family so they die too)
+
+// accessClaims is what goes in the access jwt
+type accessClaims struct {
+	Family string `json:"fam"`
+	Admin  bool   `json:"adm,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// RefreshToken is the stored side of a refresh token, keyed by its sha256
+type RefreshToken struct {
+	UserID    string     `json:"user_id"`
+	Family    string     `json:"family"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"` // set once it's been swapped for a new one
+}
+
+// TokenResponse is returned by login and refresh
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+	RefreshToken string `json:"refresh_token"`
+}
+
+func randomToken(prefix string, n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return prefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens mints an access/refresh pair for a user inside the caller's transaction
+func (app *App) issueTokens(tx *bolt.Tx, user *User, family string) (*TokenResponse, error) {
+	now := time.Now()
+	claims := accessClaims{
+		Family: family,
+		Admin:  app.userAdmin(user),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.Config.BaseURL,
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(app.Config.AccessTokenTTL)),
+			ID:        randomToken("", 12),
+		},
+	}
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(app.Config.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := randomToken("rt_", 32)
+	record, err := json.Marshal(RefreshToken{
+		UserID:    user.ID,
+		Family:    family,
+		ExpiresAt: now.Add(app.Config.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte("refresh_tokens"))
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.Put([]byte(hashToken(refresh)), record); err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(app.Config.AccessTokenTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// revokeFamily puts a family on the revocation list until its last refresh
+// token could have expired - after that nothing from it can still be valid
+func (app *App) revokeFamily(tx *bolt.Tx, family string) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte("revoked_families"))
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(app.Config.RefreshTokenTTL).Format(time.RFC3339)
+	return bucket.Put([]byte(family), []byte(until))
+}
+
+func familyRevoked(tx *bolt.Tx, family string) bool {
+	bucket := tx.Bucket([]byte("revoked_families"))
+	return bucket != nil && bucket.Get([]byte(family)) != nil
+}
+
+// jwtAuth accepts bearer access tokens issued by /api/auth/login
+type jwtAuth struct {
+	app *App
+}
+
+func (a *jwtAuth) Name() string { return "jwt" }
+
+func (a *jwtAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil // not a jwt, someone else's credential
+	}
+
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		return a.app.Config.JWTSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithIssuer(a.app.Config.BaseURL), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, errBadCredentials("invalid or expired token")
+	}
+
+	// tokens die with their family (logout, reuse) and with the account
+	revoked := false
+	a.app.DB.View(func(tx *bolt.Tx) error {
+		revoked = familyRevoked(tx, claims.Family)
+		return nil
+	})
+	if revoked {
+		return nil, errBadCredentials("token revoked")
+	}
+	user, err := a.app.getUser(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errBadCredentials("token revoked")
+	}
+
+	return &Identity{Method: "jwt", Subject: user.ID, Admin: a.app.userAdmin(user), User: user}, nil
+}
+
+// handles POST /api/auth/login
+func (app *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		OTP      string `json:"otp"` // two-factor or recovery code, when it's on
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+
+	user, err := app.checkPassword(strings.ToLower(strings.TrimSpace(req.Email)), req.Password)
+	if err != nil {
+		log.Printf("login error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, errorUnauthorized, "wrong email or password")
+		return
+	}
+	if user.TOTPSecret != "" {
+		if strings.TrimSpace(req.OTP) == "" {
+			writeFieldError(w, http.StatusUnauthorized, errorTwoFactor, "otp", "two-factor code required")
+			return
+		}
+		ok, err := app.useSecondFactor(user.ID, req.OTP)
+		if err != nil {
+			log.Printf("2fa check error for %s: %v", user.ID, err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+		if !ok {
+			writeFieldError(w, http.StatusUnauthorized, errorUnauthorized, "otp", "wrong or used two-factor code")
+			return
+		}
+	}
+
+	var tokens *TokenResponse
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		tokens, err = app.issueTokens(tx, user, randomToken("", 12))
+		return err
+	})
+	if err != nil {
+		log.Printf("token issue error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handles POST /api/auth/refresh - swaps a refresh token for a new pair
+func (app *App) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "refresh_token", "refresh_token is required")
+		return
+	}
+
+	var tokens *TokenResponse
+	reused := false
+	// one transaction so two concurrent refreshes can't both win
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("refresh_tokens"))
+		if bucket == nil {
+			return nil
+		}
+		key := []byte(hashToken(req.RefreshToken))
+		v := bucket.Get(key)
+		if v == nil {
+			return nil
+		}
+		var stored RefreshToken
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		if !stored.ExpiresAt.After(time.Now()) || familyRevoked(tx, stored.Family) {
+			return nil
+		}
+		if stored.UsedAt != nil {
+			reused = true
+			return app.revokeFamily(tx, stored.Family)
+		}
+
+		user, err := getUserTx(tx, stored.UserID)
+		if err != nil || user == nil {
+			return err
+		}
+
+		now := time.Now()
+		stored.UsedAt = &now
+		updated, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, updated); err != nil {
+			return err
+		}
+		tokens, err = app.issueTokens(tx, user, stored.Family)
+		return err
+	})
+	if err != nil {
+		log.Printf("token refresh error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if reused {
+		log.Printf("refresh token reused from %s, revoked its family", clientIP(r))
+	}
+	if tokens == nil {
+		writeError(w, http.StatusUnauthorized, errorUnauthorized, "invalid refresh token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handles POST /api/auth/logout - revokes the refresh token's family, which
+// also kills the access tokens issued alongside it
+func (app *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "refresh_token", "refresh_token is required")
+		return
+	}
+
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("refresh_tokens"))
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(hashToken(req.RefreshToken)))
+		if v == nil {
+			return nil
+		}
+		var stored RefreshToken
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		return app.revokeFamily(tx, stored.Family)
+	})
+	if err != nil {
+		log.Printf("logout error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	// unknown tokens get the same answer - there's nothing left to log out of
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runTokenCleanup drops expired refresh tokens, browser sessions and revocation
+// entries that have outlived anything they could apply to
+func (app *App) runTokenCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		removed := 0
+		err := app.DB.Update(func(tx *bolt.Tx) error {
+			if bucket := tx.Bucket([]byte("refresh_tokens")); bucket != nil {
+				c := bucket.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					var stored RefreshToken
+					if json.Unmarshal(v, &stored) == nil && stored.ExpiresAt.After(now) {
+						continue
+					}
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					removed++
+				}
+			}
+			if bucket := tx.Bucket([]byte("revoked_families")); bucket != nil {
+				c := bucket.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					until, err := time.Parse(time.RFC3339, string(v))
+					if err == nil && until.After(now) {
+						continue
+					}
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					removed++
+				}
+			}
+			if bucket := tx.Bucket([]byte("sessions")); bucket != nil {
+				c := bucket.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					var session Session
+					if json.Unmarshal(v, &session) == nil && session.ExpiresAt.After(now) {
+						continue
+					}
+					if err := c.Delete(); err != nil {
+						return err
+					}
+					removed++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("token cleanup error: %v", err)
+		} else if removed > 0 {
+			log.Printf("token cleanup: removed %d expired entries", removed)
+		}
+	}
+}
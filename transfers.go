@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+// handing links over. the owner of a link (or of a whole campaign's worth)
+// offers it to another user or to an org, and nothing moves until the other
+// side accepts - a user for themselves, an org admin for their org. pending
+// offers live in the transfers bucket keyed by id and are gone once accepted,
+// declined or a week old; the audit log keeps the history. stats are keyed
+// by short code, so the new owner gets them as they are
+
+const transferTTL = 7 * 24 * time.Hour
+
+// Transfer is a pending offer of links to a new owner
+type Transfer struct {
+	ID       string         `json:"id"`
+	Links    []TransferLink `json:"links"`
+	Campaign string         `json:"campaign,omitempty"`
+	ToUserID string         `json:"to_user_id,omitempty"`
+	ToOrgID  string         `json:"to_org_id,omitempty"`
+
+	// who offered it, to tell outgoing offers apart
+	FromUserID   string `json:"from_user_id,omitempty"`
+	FromAPIKeyID string `json:"from_api_key_id,omitempty"`
+	FromActor    string `json:"from"`
+
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TransferLink is one offered link and who owned it at the time. a link
+// that has changed hands since is left alone on accept
+type TransferLink struct {
+	ShortCode string `json:"short_code"`
+	UserID    string `json:"user_id,omitempty"`
+	APIKeyID  string `json:"api_key_id,omitempty"`
+}
+
+// response for POST /api/transfers/{id}/accept
+type TransferResult struct {
+	Transferred []string `json:"transferred"`
+	Skipped     []string `json:"skipped,omitempty"` // deleted or owned by someone else by now
+}
+
+// response for GET /api/transfers
+type TransferListResponse struct {
+	Incoming []Transfer `json:"incoming"`
+	Outgoing []Transfer `json:"outgoing"`
+}
+
+// recipient reports whether an identity may accept a transfer
+func (id *Identity) recipient(t *Transfer) bool {
+	if t.ToOrgID != "" {
+		return id.User != nil && id.managesOrg(t.ToOrgID)
+	}
+	return id.User != nil && id.User.ID == t.ToUserID
+}
+
+// sender reports whether an identity made the offer
+func (id *Identity) sender(t *Transfer) bool {
+	return id.Admin ||
+		(t.FromAPIKeyID != "" && id.Key != nil && id.Key.ID == t.FromAPIKeyID) ||
+		(t.FromUserID != "" && id.User != nil && id.User.ID == t.FromUserID)
+}
+
+// handles POST /api/links/{shortCode}/transfer
+func (app *App) transferLinkHandler(w http.ResponseWriter, r *http.Request) {
+	urlData, ok := app.authorizeLink(w, r, mux.Vars(r)["shortCode"])
+	if !ok {
+		return
+	}
+	app.offerTransfer(w, r, []URL{*urlData}, "")
+}
+
+// handles POST /api/campaigns/{name}/transfer - offers every link of the
+// campaign the caller owns
+func (app *App) transferCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	groups, err := app.campaignLinks(identityFromContext(r.Context()), name)
+	if err != nil {
+		log.Printf("campaign lookup error for %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if len(groups[name]) == 0 {
+		writeError(w, http.StatusNotFound, errorNotFound, "campaign not found")
+		return
+	}
+	app.offerTransfer(w, r, groups[name], name)
+}
+
+// offerTransfer reads who the links are for and saves the offer
+func (app *App) offerTransfer(w http.ResponseWriter, r *http.Request, links []URL, campaign string) {
+	var req struct {
+		ToUser string `json:"to_user"` // email or id
+		ToOrg  string `json:"to_org"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+	req.ToUser, req.ToOrg = strings.TrimSpace(req.ToUser), strings.TrimSpace(req.ToOrg)
+	if (req.ToUser == "") == (req.ToOrg == "") {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "to_user", "one of to_user or to_org is required")
+		return
+	}
+
+	id := identityFromContext(r.Context())
+	now := app.now()
+	transfer := Transfer{
+		Campaign:  campaign,
+		FromActor: id.actor(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(transferTTL),
+	}
+	if id.Key != nil {
+		transfer.FromAPIKeyID = id.Key.ID
+	}
+	if id.User != nil {
+		transfer.FromUserID = id.User.ID
+	}
+
+	if req.ToUser != "" {
+		user, err := app.getUserByEmail(strings.ToLower(req.ToUser))
+		if err == nil && user == nil {
+			user, err = app.getUser(req.ToUser)
+		}
+		if err != nil {
+			log.Printf("transfer recipient lookup error: %v", err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+		if user == nil {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "to_user", "user not found")
+			return
+		}
+		transfer.ToUserID = user.ID
+	} else {
+		if org, _ := app.getOrg(req.ToOrg); org == nil {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "to_org", "org not found")
+			return
+		}
+		transfer.ToOrgID = req.ToOrg
+	}
+
+	for _, u := range links {
+		// nothing to offer someone who has it already
+		if transfer.ToUserID != "" && u.UserID == transfer.ToUserID {
+			continue
+		}
+		transfer.Links = append(transfer.Links, TransferLink{ShortCode: u.ShortCode, UserID: u.UserID, APIKeyID: u.APIKeyID})
+	}
+	if len(transfer.Links) == 0 {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "to_user", "already owned by that user")
+		return
+	}
+
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	transfer.ID = hex.EncodeToString(raw)
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("transfers"))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(transfer)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(transfer.ID), data)
+	})
+	if err != nil {
+		log.Printf("transfer save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save transfer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// getTransferTx loads a pending transfer, nil when it's unknown or expired
+func getTransferTx(tx *bolt.Tx, id string, now time.Time) (*Transfer, error) {
+	bucket := tx.Bucket([]byte("transfers"))
+	if bucket == nil {
+		return nil, nil
+	}
+	v := bucket.Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+	var t Transfer
+	if err := json.Unmarshal(v, &t); err != nil {
+		return nil, err
+	}
+	if now.After(t.ExpiresAt) {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// handles GET /api/transfers - offers to and from the caller. expired ones
+// are dropped on the way
+func (app *App) listTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	id := identityFromContext(r.Context())
+	now := app.now()
+	resp := TransferListResponse{Incoming: []Transfer{}, Outgoing: []Transfer{}}
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("transfers"))
+		if bucket == nil {
+			return nil
+		}
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var t Transfer
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			switch {
+			case now.After(t.ExpiresAt):
+				expired = append(expired, k)
+			case id.recipient(&t):
+				resp.Incoming = append(resp.Incoming, t)
+			case id.sender(&t):
+				resp.Outgoing = append(resp.Outgoing, t)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("transfer list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	for _, list := range [][]Transfer{resp.Incoming, resp.Outgoing} {
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handles POST /api/transfers/{id}/accept
+func (app *App) acceptTransferHandler(w http.ResponseWriter, r *http.Request) {
+	transferID := mux.Vars(r)["id"]
+	id := identityFromContext(r.Context())
+	if id.User == nil {
+		writeError(w, http.StatusForbidden, errorForbidden, "accepting a transfer needs a user login")
+		return
+	}
+
+	var result *TransferResult
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		transfer, err := getTransferTx(tx, transferID, app.now())
+		if err != nil || transfer == nil || !id.recipient(transfer) {
+			return err
+		}
+		orgID := transfer.ToOrgID
+		if orgID == "" {
+			orgID = id.User.OrgID
+		}
+
+		result = &TransferResult{Transferred: []string{}}
+		for _, offered := range transfer.Links {
+			moved := false
+			err := updateURLTx(tx, offered.ShortCode, func(u *URL) error {
+				if u.UserID != offered.UserID || u.APIKeyID != offered.APIKeyID || u.TrashedAt != nil {
+					return nil
+				}
+				u.UserID, u.APIKeyID, u.OrgID = id.User.ID, "", orgID
+				moved = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !moved {
+				result.Skipped = append(result.Skipped, offered.ShortCode)
+				continue
+			}
+			result.Transferred = append(result.Transferred, offered.ShortCode)
+
+			entry := requestActor(r)
+			entry.Action, entry.ShortCode = "transfer", offered.ShortCode
+			entry.Old = map[string]any{"user_id": offered.UserID, "api_key_id": offered.APIKeyID}
+			entry.New = map[string]any{"user_id": id.User.ID, "org_id": orgID, "transfer_id": transfer.ID}
+			if err := auditTx(tx, entry); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte("transfers")).Delete([]byte(transfer.ID))
+	})
+	if err != nil {
+		log.Printf("transfer accept error for %s: %v", transferID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if result == nil {
+		// other people's offers look the same as missing ones
+		writeError(w, http.StatusNotFound, errorNotFound, "transfer not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handles POST /api/transfers/{id}/decline - the recipient turning it down
+// or the sender taking it back
+func (app *App) declineTransferHandler(w http.ResponseWriter, r *http.Request) {
+	transferID := mux.Vars(r)["id"]
+	id := identityFromContext(r.Context())
+	found := false
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		transfer, err := getTransferTx(tx, transferID, app.now())
+		if err != nil || transfer == nil || !(id.recipient(transfer) || id.sender(transfer)) {
+			return err
+		}
+		found = true
+		return tx.Bucket([]byte("transfers")).Delete([]byte(transfer.ID))
+	})
+	if err != nil {
+		log.Printf("transfer decline error for %s: %v", transferID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errorNotFound, "transfer not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
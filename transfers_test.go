@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// loginAs makes a user and returns the header for their access token
+func loginAs(ta *testApp, email string, extra map[string]any) []string {
+	ta.t.Helper()
+	body := map[string]any{"email": email, "password": "correct horse battery"}
+	for k, v := range extra {
+		body[k] = v
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/admin/users", body, "Authorization", "Bearer "+testAdminToken); rec.Code != http.StatusCreated {
+		ta.t.Fatalf("create user %s: %d %s", email, rec.Code, rec.Body)
+	}
+	rec := ta.do(http.MethodPost, "/api/v1/auth/login", map[string]string{"email": email, "password": "correct horse battery"})
+	var tokens TokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || tokens.AccessToken == "" {
+		ta.t.Fatalf("login %s: %d %v", email, rec.Code, err)
+	}
+	return []string{"Authorization", "Bearer " + tokens.AccessToken}
+}
+
+func TestTransferLink(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	alice := loginAs(ta, "alice@example.com", nil)
+	bob := loginAs(ta, "bob@example.com", nil)
+	mallory := loginAs(ta, "mallory@example.com", nil)
+
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/a"}, alice...)
+	var link ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&link)
+	ta.do(http.MethodGet, "/"+link.ShortCode, nil, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+	ta.Clicks.Wait()
+
+	// only the owner can offer it
+	if rec := ta.do(http.MethodPost, "/api/v1/links/"+link.ShortCode+"/transfer", map[string]string{"to_user": "bob@example.com"}, mallory...); rec.Code != http.StatusNotFound {
+		t.Fatalf("stranger offering: status %d, want 404", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/links/"+link.ShortCode+"/transfer", map[string]string{"to_user": "nobody@example.com"}, alice...); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown recipient: status %d, want 400", rec.Code)
+	}
+	rec = ta.do(http.MethodPost, "/api/v1/links/"+link.ShortCode+"/transfer", map[string]string{"to_user": "Bob@example.com"}, alice...)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("offer: status %d: %s", rec.Code, rec.Body)
+	}
+	var transfer Transfer
+	json.NewDecoder(rec.Body).Decode(&transfer)
+
+	// nothing moves until it's accepted
+	if u, _ := ta.getURL(link.ShortCode); u.UserID == transfer.ToUserID {
+		t.Fatal("link moved before the transfer was accepted")
+	}
+	var list TransferListResponse
+	json.NewDecoder(ta.do(http.MethodGet, "/api/v1/transfers", nil, bob...).Body).Decode(&list)
+	if len(list.Incoming) != 1 || list.Incoming[0].ID != transfer.ID || len(list.Outgoing) != 0 {
+		t.Fatalf("bob's transfers = %+v", list)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, mallory...); rec.Code != http.StatusNotFound {
+		t.Fatalf("stranger accepting: status %d, want 404", rec.Code)
+	}
+
+	rec = ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, bob...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("accept: status %d: %s", rec.Code, rec.Body)
+	}
+	var result TransferResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if len(result.Transferred) != 1 || result.Transferred[0] != link.ShortCode {
+		t.Fatalf("result = %+v", result)
+	}
+	u, _ := ta.getURL(link.ShortCode)
+	if u.UserID != transfer.ToUserID || u.ClickCount != 1 {
+		t.Fatalf("after accept: user %q clicks %d, want %q with its click", u.UserID, u.ClickCount, transfer.ToUserID)
+	}
+	if rec := ta.do(http.MethodGet, "/api/v1/links/"+link.ShortCode+"/favicon", nil, alice...); rec.Code != http.StatusNotFound {
+		t.Fatalf("old owner still has the link: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, bob...); rec.Code != http.StatusNotFound {
+		t.Fatalf("second accept: status %d, want 404", rec.Code)
+	}
+}
+
+func TestTransferCampaignToOrg(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+	var org Org
+	json.NewDecoder(ta.do(http.MethodPost, "/api/v1/admin/orgs", map[string]string{"name": "Team"}, admin...).Body).Decode(&org)
+	alice := loginAs(ta, "alice@example.com", nil)
+	member := loginAs(ta, "member@example.com", map[string]any{"org_id": org.ID})
+	lead := loginAs(ta, "lead@example.com", map[string]any{"org_id": org.ID, "org_admin": true})
+
+	var codes []string
+	for _, dest := range []string{"https://example.com/1", "https://example.com/2"} {
+		rec := ta.do(http.MethodPost, "/api/v1/shorten", map[string]string{"url": dest, "campaign": "launch"}, alice...)
+		var link ShortenResponse
+		json.NewDecoder(rec.Body).Decode(&link)
+		codes = append(codes, link.ShortCode)
+	}
+
+	rec := ta.do(http.MethodPost, "/api/v1/campaigns/launch/transfer", map[string]string{"to_org": org.ID}, alice...)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("offer: status %d: %s", rec.Code, rec.Body)
+	}
+	var transfer Transfer
+	json.NewDecoder(rec.Body).Decode(&transfer)
+	if len(transfer.Links) != 2 || transfer.Campaign != "launch" {
+		t.Fatalf("transfer = %+v", transfer)
+	}
+
+	// an org transfer is for the org's admins to take
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, member...); rec.Code != http.StatusNotFound {
+		t.Fatalf("plain member accepting: status %d, want 404", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, lead...); rec.Code != http.StatusOK {
+		t.Fatalf("accept: status %d: %s", rec.Code, rec.Body)
+	}
+	for _, code := range codes {
+		if u, _ := ta.getURL(code); u.OrgID != org.ID {
+			t.Errorf("%s org = %q, want %q", code, u.OrgID, org.ID)
+		}
+	}
+}
+
+func TestDeclineTransfer(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+	alice := loginAs(ta, "alice@example.com", nil)
+	bob := loginAs(ta, "bob@example.com", nil)
+
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/a"}, alice...)
+	var link ShortenResponse
+	json.NewDecoder(rec.Body).Decode(&link)
+	var transfer Transfer
+	json.NewDecoder(ta.do(http.MethodPost, "/api/v1/links/"+link.ShortCode+"/transfer", map[string]string{"to_user": "bob@example.com"}, alice...).Body).Decode(&transfer)
+
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/decline", nil, bob...); rec.Code != http.StatusNoContent {
+		t.Fatalf("decline: status %d", rec.Code)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, bob...); rec.Code != http.StatusNotFound {
+		t.Fatalf("accept after decline: status %d, want 404", rec.Code)
+	}
+
+	// offers run out after a week
+	json.NewDecoder(ta.do(http.MethodPost, "/api/v1/links/"+link.ShortCode+"/transfer", map[string]string{"to_user": "bob@example.com"}, alice...).Body).Decode(&transfer)
+	ta.clock.Advance(transferTTL + 1)
+	if rec := ta.do(http.MethodPost, "/api/v1/transfers/"+transfer.ID+"/accept", nil, bob...); rec.Code != http.StatusNotFound {
+		t.Fatalf("accept after expiry: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// link states for GET /api/links?state=
+const (
+	stateActive  = "active"
+	stateTrashed = "trashed"
+	stateAll     = "all"
+)
+
+// handles DELETE /api/links/{shortCode} - moves a link to the trash, where it
+// answers 410 until restored or purged. ?purge=true on a trashed link deletes it for good
+func (app *App) trashLinkHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	urlData, ok := app.authorizeLink(w, r, shortCode)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("purge") == "true" {
+		if urlData.TrashedAt == nil {
+			writeError(w, http.StatusConflict, errorConflict, "only trashed links can be purged, delete it first")
+			return
+		}
+		if err := app.deleteURL(shortCode, requestActor(r), "purge"); err != nil {
+			log.Printf("purge error for %s: %v", shortCode, err)
+			writeError(w, http.StatusInternalServerError, errorServer, "server error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	urlData, err := app.auditedUpdate(requestActor(r), shortCode, func(u *URL) (string, error) {
+		if u.TrashedAt != nil {
+			return "", nil
+		}
+		now := time.Now()
+		u.TrashedAt = &now
+		return "trash", nil
+	})
+	if err != nil {
+		log.Printf("trash error for %s: %v", shortCode, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	app.Cache.Delete(shortCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(urlData)
+}
+
+// handles POST /api/links/{shortCode}/restore
+func (app *App) restoreLinkHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+	if _, ok := app.authorizeLink(w, r, shortCode); !ok {
+		return
+	}
+
+	urlData, err := app.auditedUpdate(requestActor(r), shortCode, func(u *URL) (string, error) {
+		if u.TrashedAt == nil {
+			return "", nil
+		}
+		u.TrashedAt = nil
+		return "restore", nil
+	})
+	if err != nil {
+		log.Printf("restore error for %s: %v", shortCode, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(urlData)
+}
+
+// purgeTrash deletes links that have sat in the trash longer than TRASH_RETENTION
+func (app *App) purgeTrash() {
+	cutoff := time.Now().Add(-app.Config.TrashRetention)
+	links, _, err := app.listURLs("", -1, func(u *URL) bool {
+		return u.TrashedAt != nil && u.TrashedAt.Before(cutoff)
+	})
+	if err != nil {
+		log.Printf("trash purge error: %v", err)
+		return
+	}
+	purged := 0
+	for _, link := range links {
+		if err := app.deleteURL(link.ShortCode, AuditEntry{Actor: "system:trash"}, "purge"); err != nil {
+			log.Printf("trash purge error for %s: %v", link.ShortCode, err)
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("trash purge: removed %d links", purged)
+	}
+}
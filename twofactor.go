@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	bolt "go.etcd.io/bbolt"
+)
+
+// two-factor logins with authenticator apps (totp, rfc 6238: sha1, 6 digits,
+// 30 second steps). setup hands out a secret and its otpauth:// uri (and a qr
+// code of it), enable confirms the app got it right with a first code and
+// returns one-time recovery codes. from then on both login endpoints want a
+// code as well as the password. secrets are kept sealed with TOTP_KEY
+// (aes-gcm) so the database alone can't mint codes; recovery codes are only
+// kept hashed. without TOTP_KEY nobody can turn it on, since a key made up at
+// startup would lock everyone out on the next one. with ADMIN_2FA=true admin users don't get admin rights until
+// they've turned it on - the ADMIN_TOKEN isn't a login and isn't affected
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode is the code for one time step
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// sealSecret encrypts a totp secret for the users bucket
+func (app *App) sealSecret(secret []byte) (string, error) {
+	gcm, err := app.secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return base64.RawStdEncoding.EncodeToString(gcm.Seal(nonce, nonce, secret, nil)), nil
+}
+
+// openSecret undoes sealSecret. a different TOTP_KEY fails here
+func (app *App) openSecret(sealed string) ([]byte, error) {
+	gcm, err := app.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, errors.New("malformed sealed secret")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func (app *App) secretCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256(app.Config.TOTPKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// matchTOTP finds the step a code belongs to, allowing a step of clock drift
+// either way. steps up to lastStep are spent. 0 means no match
+func (app *App) matchTOTP(sealed, code string, lastStep int64) (int64, error) {
+	secret, err := app.openSecret(sealed)
+	if err != nil {
+		return 0, err
+	}
+	now := app.now().Unix() / totpPeriod
+	for step := now - 1; step <= now+1; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, nil
+}
+
+// normalizeOTP strips what people type around a code
+func normalizeOTP(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// newRecoveryCodes makes a fresh set, returning them for the user and hashed
+// for storing
+func newRecoveryCodes() (codes, hashes []string) {
+	for range recoveryCodeCount {
+		b := make([]byte, 5)
+		rand.Read(b)
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes
+}
+
+// useSecondFactor checks a totp or recovery code for a user with two-factor
+// on and spends it: a totp step can't be replayed and a recovery code is gone
+func (app *App) useSecondFactor(userID, code string) (bool, error) {
+	code = normalizeOTP(code)
+	ok := false
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		user, err := getUserTx(tx, userID)
+		if err != nil || user == nil || user.TOTPSecret == "" || code == "" {
+			return err
+		}
+		if len(code) == totpDigits {
+			step, err := app.matchTOTP(user.TOTPSecret, code, user.TOTPLastStep)
+			if err != nil || step == 0 {
+				return err
+			}
+			user.TOTPLastStep = step
+		} else {
+			hash := hashToken(code)
+			i := 0
+			for i < len(user.RecoveryCodes) && subtle.ConstantTimeCompare([]byte(user.RecoveryCodes[i]), []byte(hash)) != 1 {
+				i++
+			}
+			if i == len(user.RecoveryCodes) {
+				return nil
+			}
+			user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+		}
+		ok = true
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("users")).Put([]byte(user.ID), data)
+	})
+	return ok, err
+}
+
+// userAdmin is whether a logged-in user gets admin rights
+func (app *App) userAdmin(u *User) bool {
+	return u.Admin && (!app.Config.Admin2FA || u.TOTPSecret != "")
+}
+
+// response for POST /api/me/2fa/setup
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"` // base32, for typing in by hand
+	URI    string `json:"uri"`    // otpauth://
+	QRCode string `json:"qr_code"`
+}
+
+// response for POST /api/me/2fa/enable and /api/me/2fa/recovery-codes
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"` // shown this once
+}
+
+// twoFactorUser is the logged-in user for the 2fa endpoints
+func twoFactorUser(w http.ResponseWriter, r *http.Request) *User {
+	user := identityFromContext(r.Context()).User
+	if user == nil {
+		writeError(w, http.StatusForbidden, errorForbidden, "two-factor settings need a user login")
+	}
+	return user
+}
+
+// decodeOTP reads {"code": ...} for the 2fa endpoints
+func decodeOTP(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return "", false
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		writeFieldError(w, http.StatusBadRequest, errorMissingField, "code", "code is required")
+		return "", false
+	}
+	return req.Code, true
+}
+
+// handles POST /api/me/2fa/setup - a new secret, not in force until enabled
+func (app *App) twoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+	if user.TOTPSecret != "" {
+		writeError(w, http.StatusConflict, errorConflict, "two-factor is already on, turn it off first")
+		return
+	}
+	if app.Config.TOTPKey == nil {
+		writeError(w, http.StatusServiceUnavailable, errorDisabled, "two-factor logins need TOTP_KEY set on this server")
+		return
+	}
+
+	secret := make([]byte, 20)
+	rand.Read(secret)
+	sealed, err := app.sealSecret(secret)
+	if err == nil {
+		err = app.updateUser(user.ID, func(u *User) error {
+			u.TOTPPending = sealed
+			return nil
+		})
+	}
+	if err != nil {
+		log.Printf("2fa setup error for %s: %v", user.ID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	encoded := totpEncoding.EncodeToString(secret)
+	issuer := app.Config.BrandName
+	params := url.Values{
+		"secret":    {encoded},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	uri := "otpauth://totp/" + url.PathEscape(issuer+":"+user.Email) + "?" + params.Encode()
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Printf("2fa qr error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TwoFactorSetupResponse{
+		Secret: encoded,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// handles POST /api/me/2fa/enable - a code from the app proves it has the
+// secret from setup
+func (app *App) twoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+	code, ok := decodeOTP(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPPending == "" {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, "call /me/2fa/setup first")
+		return
+	}
+	step, err := app.matchTOTP(user.TOTPPending, normalizeOTP(code), 0)
+	if err != nil {
+		log.Printf("2fa enable error for %s: %v", user.ID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if step == 0 {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "code", "wrong code, check the app's clock")
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	err = app.updateUser(user.ID, func(u *User) error {
+		u.TOTPSecret, u.TOTPPending, u.TOTPLastStep = u.TOTPPending, "", step
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		log.Printf("2fa enable error for %s: %v", user.ID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// secondFactorOK spends a code for one of the endpoints that need one,
+// writing the error when it doesn't check out
+func (app *App) secondFactorOK(w http.ResponseWriter, user *User, code string) bool {
+	if user.TOTPSecret == "" {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, "two-factor is not on")
+		return false
+	}
+	ok, err := app.useSecondFactor(user.ID, code)
+	if err != nil {
+		log.Printf("2fa check error for %s: %v", user.ID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return false
+	}
+	if !ok {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "code", "wrong or used code")
+	}
+	return ok
+}
+
+// handles POST /api/me/2fa/disable - needs a current code or a recovery code
+func (app *App) twoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+	code, ok := decodeOTP(w, r)
+	if !ok || !app.secondFactorOK(w, user, code) {
+		return
+	}
+	err := app.updateUser(user.ID, func(u *User) error {
+		u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, u.RecoveryCodes = "", "", 0, nil
+		return nil
+	})
+	if err != nil {
+		log.Printf("2fa disable error for %s: %v", user.ID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handles POST /api/me/2fa/recovery-codes - a new set replacing the old one
+func (app *App) recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+	code, ok := decodeOTP(w, r)
+	if !ok || !app.secondFactorOK(w, user, code) {
+		return
+	}
+	codes, hashes := newRecoveryCodes()
+	err := app.updateUser(user.ID, func(u *User) error {
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		log.Printf("recovery codes save error for %s: %v", user.ID, err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// checkTOTPKey stops startup without TOTP_KEY once anyone has two-factor on,
+// their secrets can't be opened and every login of theirs would fail
+func (app *App) checkTOTPKey() error {
+	if app.Config.TOTPKey != nil {
+		return nil
+	}
+	return app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("users"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var u User
+			if json.Unmarshal(v, &u) == nil && u.TOTPSecret != "" {
+				return errors.New("users have two-factor on, set TOTP_KEY (or TOTP_KEY_FILE) to the key their secrets were sealed with")
+			}
+			return nil
+		})
+	})
+}
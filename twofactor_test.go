@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// rfc 6238 appendix b, last six digits
+	secret := []byte("12345678901234567890")
+	for step, want := range map[int64]string{1: "287082", 37037036: "081804", 37037037: "050471"} {
+		if got := totpCode(secret, step); got != want {
+			t.Errorf("step %d: %s, want %s", step, got, want)
+		}
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	ta := newTestApp(t, nil)
+	alice := loginAs(ta, "alice@example.com", nil)
+	login := func(otp string) *ErrorResponse {
+		rec := ta.do(http.MethodPost, "/api/v1/auth/login", map[string]string{"email": "alice@example.com", "password": "correct horse battery", "otp": otp})
+		if rec.Code == http.StatusOK {
+			return nil
+		}
+		var resp ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return &resp
+	}
+
+	rec := ta.do(http.MethodPost, "/api/v1/me/2fa/setup", nil, alice...)
+	var setup TwoFactorSetupResponse
+	json.NewDecoder(rec.Body).Decode(&setup)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(setup.URI, "otpauth://totp/") || !strings.HasPrefix(setup.QRCode, "data:image/png;base64,") {
+		t.Fatalf("setup: status %d, %+v", rec.Code, setup)
+	}
+	secret, err := totpEncoding.DecodeString(setup.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := func() string { return totpCode(secret, ta.clock.Now().Unix()/totpPeriod) }
+
+	// not in force until it's confirmed, and the secret isn't stored in the clear
+	if resp := login(""); resp != nil {
+		t.Fatalf("login before enabling: %+v", resp)
+	}
+	if user, _ := ta.getUserByEmail("alice@example.com"); strings.Contains(user.TOTPPending, setup.Secret) || user.TOTPPending == "" {
+		t.Fatalf("pending secret stored as %q", user.TOTPPending)
+	}
+	if rec := ta.do(http.MethodPost, "/api/v1/me/2fa/enable", map[string]string{"code": "000000"}, alice...); rec.Code != http.StatusBadRequest {
+		t.Fatalf("enable with a wrong code: status %d", rec.Code)
+	}
+	rec = ta.do(http.MethodPost, "/api/v1/me/2fa/enable", map[string]string{"code": code()}, alice...)
+	var recovery RecoveryCodesResponse
+	json.NewDecoder(rec.Body).Decode(&recovery)
+	if rec.Code != http.StatusOK || len(recovery.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("enable: status %d, %+v", rec.Code, recovery)
+	}
+
+	if resp := login(""); resp == nil || resp.Code != errorTwoFactor {
+		t.Fatalf("login without a code: %+v, want %s", resp, errorTwoFactor)
+	}
+	// the code that enabled it is spent
+	if resp := login(code()); resp == nil {
+		t.Fatal("login with the enabling code worked")
+	}
+	ta.clock.Advance(totpPeriod * time.Second)
+	if resp := login(code()); resp != nil {
+		t.Fatalf("login with a code: %+v", resp)
+	}
+	if resp := login(strings.ToUpper(recovery.RecoveryCodes[0])); resp != nil {
+		t.Fatalf("login with a recovery code: %+v", resp)
+	}
+	if resp := login(recovery.RecoveryCodes[0]); resp == nil {
+		t.Fatal("recovery code worked twice")
+	}
+
+	if rec := ta.do(http.MethodPost, "/api/v1/me/2fa/disable", map[string]string{"code": recovery.RecoveryCodes[1]}, alice...); rec.Code != http.StatusNoContent {
+		t.Fatalf("disable: status %d: %s", rec.Code, rec.Body)
+	}
+	if resp := login(""); resp != nil {
+		t.Fatalf("login after disabling: %+v", resp)
+	}
+}
+
+func TestAdmin2FA(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ADMIN_2FA": "true"})
+	boss := loginAs(ta, "boss@example.com", map[string]any{"admin": true})
+
+	if rec := ta.do(http.MethodGet, "/api/v1/admin/users", nil, boss...); rec.Code != http.StatusForbidden {
+		t.Fatalf("admin without two-factor: status %d, want 403", rec.Code)
+	}
+	var setup TwoFactorSetupResponse
+	json.NewDecoder(ta.do(http.MethodPost, "/api/v1/me/2fa/setup", nil, boss...).Body).Decode(&setup)
+	secret, _ := totpEncoding.DecodeString(setup.Secret)
+	rec := ta.do(http.MethodPost, "/api/v1/me/2fa/enable", map[string]string{"code": totpCode(secret, ta.clock.Now().Unix()/totpPeriod)}, boss...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enable: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(http.MethodGet, "/api/v1/admin/users", nil, boss...); rec.Code != http.StatusOK {
+		t.Fatalf("admin with two-factor: status %d", rec.Code)
+	}
+}
+
+func TestTwoFactorNeedsKey(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"TOTP_KEY": ""})
+	alice := loginAs(ta, "alice@example.com", nil)
+	rec := ta.do(http.MethodPost, "/api/v1/me/2fa/setup", nil, alice...)
+	var resp ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusServiceUnavailable || resp.Code != errorDisabled {
+		t.Fatalf("setup without TOTP_KEY: status %d, %+v", rec.Code, resp)
+	}
+
+	// someone who turned it on while there was a key keeps the server from starting without it
+	user, _ := ta.getUserByEmail("alice@example.com")
+	ta.updateUser(user.ID, func(u *User) error {
+		u.TOTPSecret = "sealed"
+		return nil
+	})
+	if _, err := newApp(ta.DB, ta.Config); err == nil || !strings.Contains(err.Error(), "TOTP_KEY") {
+		t.Errorf("newApp = %v, want a TOTP_KEY error", err)
+	}
+}
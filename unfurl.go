@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// link unfurling. chat apps and social sites fetch every link that's pasted
+// into them to build a preview - that's a bot asking about the link, not a
+// visit. UNFURL_MODE picks what they get, and they never count as clicks
+// (or fire click webhooks) unless COUNT_UNFURLS=true
+
+// what unfurlers get, UNFURL_MODE
+const (
+	unfurlCard     = "card"     // the card page for links that have a card, the redirect otherwise
+	unfurlPreview  = "preview"  // a page for every link, the destination's host standing in for a missing card
+	unfurlRedirect = "redirect" // the redirect like everyone else, cards are never shown
+)
+
+func validUnfurlMode(mode string) error {
+	switch mode {
+	case unfurlCard, unfurlPreview, unfurlRedirect:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q, want card, preview or redirect", mode)
+}
+
+// substrings (lowercase) of the user agents that fetch link previews
+var unfurlers = []string{
+	"facebookexternalhit", "facebookcatalog", "twitterbot", "linkedinbot", "slackbot",
+	"discordbot", "telegrambot", "whatsapp", "pinterest", "redditbot", "skypeuripreview",
+	"embedly", "iframely", "vkshare", "mastodon", "cardyb", "applebot",
+}
+
+// unfurling says whether the request is a preview fetcher rather than a visitor
+func unfurling(r *http.Request) bool {
+	ua := strings.ToLower(r.UserAgent())
+	for _, pattern := range unfurlers {
+		if strings.Contains(ua, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// unfurlCard is the card unfurlers get for a link, nil when they get the
+// redirect. it doesn't look at the request, so the redirect can say it Varies
+func (app *App) unfurlCard(link cachedLink) *LinkCard {
+	switch app.Config.UnfurlMode {
+	case unfurlRedirect:
+		return nil
+	case unfurlPreview:
+		if link.Card != nil {
+			return link.Card
+		}
+		// only web pages, a mailto: or app link has nothing to show
+		if u, err := url.Parse(link.URL); err == nil && webURL(link.URL) && u.Host != "" {
+			return &LinkCard{Title: u.Hostname()}
+		}
+		return nil
+	}
+	return link.Card
+}
+
+// countsUnfurl says whether a request counts as a click as far as unfurling goes
+func (app *App) countsUnfurl(r *http.Request) bool {
+	return app.Config.CountUnfurls || !unfurling(r)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const slackbot = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+
+func TestUnfurlModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode            string
+		plain, withCard int
+		plainTitle      string
+	}{
+		{unfurlCard, http.StatusMovedPermanently, http.StatusOK, ""},
+		{unfurlPreview, http.StatusOK, http.StatusOK, "example.com"},
+		{unfurlRedirect, http.StatusMovedPermanently, http.StatusMovedPermanently, ""},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true", "UNFURL_MODE": tc.mode})
+			plain := ta.shorten(ShortenRequest{URL: "https://example.com/plain"}, http.StatusOK).ShortCode
+			req := ShortenRequest{URL: "https://example.com/carded"}
+			req.Card = &LinkCard{Title: "Carded"}
+			carded := ta.shorten(req, http.StatusOK).ShortCode
+
+			rec := ta.do(http.MethodGet, "/"+plain, nil, "User-Agent", slackbot)
+			if rec.Code != tc.plain {
+				t.Errorf("plain: status %d", rec.Code)
+			}
+			if tc.plainTitle != "" && !strings.Contains(rec.Body.String(), `<meta property="og:title" content="`+tc.plainTitle+`">`) {
+				t.Errorf("plain preview: %s", rec.Body)
+			}
+			if rec := ta.do(http.MethodGet, "/"+carded, nil, "User-Agent", slackbot); rec.Code != tc.withCard {
+				t.Errorf("with card: status %d", rec.Code)
+			}
+		})
+	}
+
+	if validUnfurlMode("nope") == nil {
+		t.Error("bad UNFURL_MODE accepted")
+	}
+}
+
+func TestUnfurlsAreNotClicks(t *testing.T) {
+	for _, count := range []bool{false, true} {
+		env := map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"}
+		if count {
+			env["COUNT_UNFURLS"] = "true"
+		}
+		ta := newTestApp(t, env)
+		code := ta.shorten(ShortenRequest{URL: "https://example.com/pasted"}, http.StatusOK).ShortCode
+		ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "WhatsApp/2.23.20.0 A")
+		ta.do(http.MethodGet, "/"+code, nil, "User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0")
+		ta.Clicks.Wait()
+
+		link, _ := ta.getURL(code)
+		want := 0
+		if count {
+			want = 1
+		}
+		if link.ClickCount != 1 || link.BotClickCount != want {
+			t.Errorf("COUNT_UNFURLS=%v: clicks %d, bot clicks %d", count, link.ClickCount, link.BotClickCount)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// follow_redirects on a shorten request follows the destination's own
+// redirects first (HEAD, up to FOLLOW_REDIRECTS_MAX_HOPS) and links straight
+// to where they end, so a bit.ly or a newsletter's click tracker doesn't sit
+// between every visitor and the page. the hops are kept on the link, and
+// shorteners and trackers among them come back as warnings. anything that
+// goes wrong on the way leaves the url as it was given
+
+// hosts the chain shouldn't need to pass through, matched with their subdomains
+var (
+	knownShorteners = []string{
+		"bit.ly", "bitly.com", "t.co", "tinyurl.com", "goo.gl", "ow.ly", "is.gd", "v.gd", "buff.ly",
+		"rebrand.ly", "cutt.ly", "shorturl.at", "tiny.cc", "rb.gy", "t.ly", "lnkd.in", "dlvr.it",
+		"s.id", "bl.ink", "adf.ly", "shorte.st",
+	}
+	knownTrackers = []string{
+		"doubleclick.net", "list-manage.com", "sendgrid.net", "mandrillapp.com", "hubspotlinks.com",
+		"mailchi.mp", "rs6.net", "klclick.com", "awstrack.me", "linksynergy.com", "redirectingat.com",
+		"anrdoezrs.net", "dpbolvw.net", "jdoqocy.com", "tkqlhce.com", "awin1.com", "shareasale.com",
+	}
+)
+
+// unwrapResult is where a destination's redirects led
+type unwrapResult struct {
+	URL      string   // the final url, or the given one when it couldn't be followed
+	Chain    []string // every url visited, the given one first - empty when it didn't redirect
+	Flagged  []string // shortener and tracker hosts on the way
+	Warnings []string
+}
+
+// hostIn says whether host is one of domains or under one
+func hostIn(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// hopKind names what a host on the chain is, "" for an ordinary site
+func hopKind(host string) string {
+	switch {
+	case hostIn(host, knownShorteners):
+		return "url shortener"
+	case hostIn(host, knownTrackers):
+		return "click tracker"
+	}
+	return ""
+}
+
+// unwrap follows rawURL's redirects
+func (app *App) unwrap(ctx context.Context, rawURL string) unwrapResult {
+	res := unwrapResult{URL: rawURL}
+	if !webURL(rawURL) {
+		return res
+	}
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+
+	// redirectChain counts requests, one more than the hops allowed checks
+	// that the last one lands
+	maxHops := app.Config.FollowRedirectsMaxHops
+	chain, err := app.redirectChain(ctx, rawURL, maxHops+1)
+	switch {
+	case err != nil:
+		res.Warnings = append(res.Warnings, "couldn't follow the destination's redirects, it was kept as given")
+		return res
+	case len(chain) == 1:
+		return res
+	case len(chain)-1 > maxHops:
+		res.Warnings = append(res.Warnings, fmt.Sprintf("the destination still redirected after %d hops, it was kept as given", maxHops))
+		return res
+	}
+	final := chain[len(chain)-1]
+	if err := app.validateDestination(final); err != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("the destination redirects to something that can't be linked to (%v), it was kept as given", err))
+		return res
+	}
+
+	res.URL, res.Chain = final, chain
+	seen := map[string]bool{}
+	for _, hop := range chain[:len(chain)-1] {
+		u, err := url.Parse(hop)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if kind := hopKind(host); kind != "" && !seen[host] {
+			seen[host] = true
+			res.Flagged = append(res.Flagged, host)
+			res.Warnings = append(res.Warnings, fmt.Sprintf("the destination went through %s, a %s", host, kind))
+		}
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	var hits atomic.Int32
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/middle", http.StatusFound)
+		case "/middle":
+			http.Redirect(w, r, "/final?id=7", http.StatusMovedPermanently)
+		case "/one":
+			http.Redirect(w, r, "/two", http.StatusFound)
+		case "/two":
+			http.Redirect(w, r, "/three", http.StatusFound)
+		case "/three":
+			http.Redirect(w, r, "/landed", http.StatusFound)
+		case "/zero":
+			http.Redirect(w, r, "/one", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer dest.Close()
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true", "FOLLOW_REDIRECTS_MAX_HOPS": "3"})
+
+	resp := ta.shorten(ShortenRequest{URL: dest.URL + "/start", FollowRedirects: true}, http.StatusOK)
+	u, _ := ta.getURL(resp.ShortCode)
+	if resp.OriginalURL != dest.URL+"/final?id=7" || len(u.RedirectChain) != 3 || len(resp.Warnings) != 0 {
+		t.Errorf("followed: %+v, chain %q", resp, u.RedirectChain)
+	}
+
+	// exactly the limit still lands, one more doesn't
+	resp = ta.shorten(ShortenRequest{URL: dest.URL + "/one", FollowRedirects: true}, http.StatusOK)
+	if resp.OriginalURL != dest.URL+"/landed" || len(resp.Warnings) != 0 {
+		t.Errorf("max hops: %+v", resp)
+	}
+	resp = ta.shorten(ShortenRequest{URL: dest.URL + "/zero", FollowRedirects: true}, http.StatusOK)
+	if resp.OriginalURL != dest.URL+"/zero" || len(resp.Warnings) != 1 {
+		t.Errorf("over max hops: %+v", resp)
+	}
+
+	resp = ta.shorten(ShortenRequest{URL: dest.URL + "/loop", FollowRedirects: true}, http.StatusOK)
+	if resp.OriginalURL != dest.URL+"/loop" || len(resp.Warnings) != 1 {
+		t.Errorf("redirect loop: %+v", resp)
+	}
+
+	// the GET form takes any bool spelling, like reuse and private
+	for v, want := range map[string]bool{"true": true, "1": true, "yes": true, "0": false, "nope": false} {
+		if got := queryRequest("https://example.com", url.Values{"follow_redirects": {v}}); got.FollowRedirects != want {
+			t.Errorf("follow_redirects=%s: %v, want %v", v, got.FollowRedirects, want)
+		}
+	}
+
+	// a request that's refused anyway doesn't send anything to the destination
+	ta.Namespaces.set(map[string]*Namespace{"team": {Name: "team", OrgID: "org-1"}})
+	hits.Store(0)
+	req := ShortenRequest{URL: dest.URL + "/start", FollowRedirects: true, ShortenOptions: ShortenOptions{Namespace: "team"}}
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", req); rec.Code != http.StatusForbidden || hits.Load() != 0 {
+		t.Errorf("namespace of another team: status %d, %d requests to the destination", rec.Code, hits.Load())
+	}
+
+	// without asking, the url is kept as it is
+	if resp := ta.shorten(ShortenRequest{URL: dest.URL + "/start"}, http.StatusOK); resp.OriginalURL != dest.URL+"/start" {
+		t.Errorf("not followed: %+v", resp)
+	}
+}
+
+func TestHopKind(t *testing.T) {
+	for host, want := range map[string]string{
+		"bit.ly":                     "url shortener",
+		"eepurl.us3.list-manage.com": "click tracker",
+		"notbit.ly":                  "",
+		"example.com":                "",
+	} {
+		if got := hopKind(host); got != want {
+			t.Errorf("hopKind(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
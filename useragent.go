@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+// browser families, checked in order - most browsers claim to be several of
+// the others (chrome says safari, edge says chrome) so the specific ones go first
+var browserPatterns = []struct{ token, family string }{
+	{"edg/", "Edge"},
+	{"edga/", "Edge"},
+	{"edgios/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"yabrowser/", "Yandex"},
+	{"vivaldi/", "Vivaldi"},
+	{"fxios/", "Firefox"},
+	{"firefox/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chromium/", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"msie ", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+	{"safari/", "Safari"},
+}
+
+// same idea for operating systems - android before linux, ios before macos
+// since ipads report as macintosh too
+var osPatterns = []struct{ token, family string }{
+	{"windows", "Windows"},
+	{"android", "Android"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"cros", "ChromeOS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+// parseUserAgent boils a user agent down to browser family and os for the
+// stats breakdowns - deliberately coarse, versions aren't useful in a chart
+func parseUserAgent(ua string) (browser, os string) {
+	ua = strings.ToLower(ua)
+	browser, os = "Other", "Other"
+	for _, p := range browserPatterns {
+		if strings.Contains(ua, p.token) {
+			browser = p.family
+			break
+		}
+	}
+	for _, p := range osPatterns {
+		if strings.Contains(ua, p.token) {
+			os = p.family
+			break
+		}
+	}
+	return browser, os
+}
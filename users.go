@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLen = 10
+
+// User is a person logging into the dashboard or a first-party client. people
+// get short-lived tokens from /api/auth/login, api keys stay for machines
+type User struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	Admin        bool      `json:"admin,omitempty"`
+	OrgID        string    `json:"org_id,omitempty"`
+	OrgAdmin     bool      `json:"org_admin,omitempty"` // manages the org's settings
+	CreatedAt    time.Time `json:"created_at"`
+	PasswordHash []byte    `json:"password_hash"`
+
+	EmailVerified bool `json:"email_verified,omitempty"` // followed the link we mailed, see accounts.go
+
+	// two-factor, see twofactor.go
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // sealed with TOTP_KEY
+	TOTPPending   string   `json:"totp_pending,omitempty"`   // from setup, until it's confirmed
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"` // so a code can't be used twice
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // hashed, each works once
+
+	WeeklyReport bool       `json:"weekly_report,omitempty"` // opted in to emailed summaries
+	LastReportAt *time.Time `json:"last_report_at,omitempty"`
+}
+
+// the users bucket is keyed by id, with a second bucket mapping email -> id
+// so logins don't have to scan
+
+func (app *App) getUser(id string) (*User, error) {
+	var user *User
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getUserTx(tx, id)
+		return err
+	})
+	return user, err
+}
+
+func getUserTx(tx *bolt.Tx, id string) (*User, error) {
+	bucket := tx.Bucket([]byte("users"))
+	if bucket == nil {
+		return nil, nil
+	}
+	v := bucket.Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+	user := &User{}
+	return user, json.Unmarshal(v, user)
+}
+
+// updateUser does a read-modify-write of one user, fn isn't called if they're gone
+func (app *App) updateUser(id string, fn func(u *User) error) error {
+	return app.DB.Update(func(tx *bolt.Tx) error {
+		user, err := getUserTx(tx, id)
+		if err != nil || user == nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		data, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("users")).Put([]byte(id), data)
+	})
+}
+
+func (app *App) getUserByEmail(email string) (*User, error) {
+	var id string
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte("user_emails")); bucket != nil {
+			id = string(bucket.Get([]byte(strings.ToLower(email))))
+		}
+		return nil
+	})
+	if err != nil || id == "" {
+		return nil, err
+	}
+	return app.getUser(id)
+}
+
+// checkPassword always does a bcrypt compare, even for unknown emails, so
+// response timing doesn't reveal which accounts exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+func (app *App) checkPassword(email, password string) (*User, error) {
+	user, err := app.getUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	hash := dummyHash
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || user == nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// user responses never include the password hash
+type UserResponse struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Admin     bool      `json:"admin,omitempty"`
+	OrgID     string    `json:"org_id,omitempty"`
+	OrgAdmin  bool      `json:"org_admin,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	TwoFactor bool      `json:"two_factor,omitempty"`
+	Verified  bool      `json:"email_verified,omitempty"`
+}
+
+func (u *User) response() UserResponse {
+	return UserResponse{ID: u.ID, Email: u.Email, Admin: u.Admin, OrgID: u.OrgID, OrgAdmin: u.OrgAdmin, CreatedAt: u.CreatedAt, TwoFactor: u.TOTPSecret != "", Verified: u.EmailVerified}
+}
+
+// handles POST /api/admin/users
+func (app *App) createUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Admin    bool   `json:"admin"`
+		OrgID    string `json:"org_id"`
+		OrgAdmin bool   `json:"org_admin"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		jsonBodyError(w, err)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "email", "invalid email")
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "password", "password must be at least 10 characters")
+		return
+	}
+
+	if req.OrgID != "" {
+		if org, _ := app.getOrg(req.OrgID); org == nil {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "org_id", "org not found")
+			return
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		// bcrypt only fails on passwords over 72 bytes
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "password", "password too long")
+		return
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	user := User{
+		ID:           hex.EncodeToString(id),
+		Email:        email,
+		Admin:        req.Admin,
+		OrgID:        req.OrgID,
+		OrgAdmin:     req.OrgAdmin && req.OrgID != "",
+		CreatedAt:    time.Now(),
+		PasswordHash: hash,
+	}
+
+	taken := false
+	err = app.DB.Update(func(tx *bolt.Tx) error {
+		emails, err := tx.CreateBucketIfNotExists([]byte("user_emails"))
+		if err != nil {
+			return err
+		}
+		if emails.Get([]byte(email)) != nil {
+			taken = true
+			return nil
+		}
+		users, err := tx.CreateBucketIfNotExists([]byte("users"))
+		if err != nil {
+			return err
+		}
+		userJSON, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if err := users.Put([]byte(user.ID), userJSON); err != nil {
+			return err
+		}
+		return emails.Put([]byte(email), []byte(user.ID))
+	})
+	if err != nil {
+		log.Printf("user save error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "failed to save user")
+		return
+	}
+	if taken {
+		writeFieldError(w, http.StatusConflict, errorConflict, "email", "email already registered")
+		return
+	}
+
+	app.sendVerification(&user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user.response())
+}
+
+// handles GET /api/admin/users
+func (app *App) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users := []UserResponse{}
+	err := app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("users"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var user User
+			if json.Unmarshal(v, &user) == nil {
+				users = append(users, user.response())
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("user list error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// handles DELETE /api/admin/users/{id} - outstanding tokens die with the account
+func (app *App) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	found := false
+	err := app.DB.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("users"))
+		if users == nil {
+			return nil
+		}
+		v := users.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		found = true
+
+		var user User
+		if json.Unmarshal(v, &user) == nil {
+			if emails := tx.Bucket([]byte("user_emails")); emails != nil {
+				if err := emails.Delete([]byte(user.Email)); err != nil {
+					return err
+				}
+			}
+		}
+		return users.Delete([]byte(id))
+	})
+	if err != nil {
+		log.Printf("user delete error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, errorNotFound, "user not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
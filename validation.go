@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// request bodies are checked field by field and every problem goes back in
+// one 400, rather than the first one and another round trip per fix. the
+// body is the usual error (see errors.go) plus the full list:
+//
+//	{"code": "invalid_field", "message": "url: invalid url format; tags: at most 20 tags per link",
+//	 "errors": [{"field": "url", ...}, {"field": "tags", ...}]}
+//
+// code and field at the top are the first problem's, field only when it's
+// the only one
+
+// FieldError is one problem with one field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"` // invalid_field or missing_field
+	Message string `json:"message"`
+}
+
+// fieldErrors collects the problems with a request. it's an error, so checks
+// can hand it up as one
+type fieldErrors []FieldError
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add records err against field and says whether there was one. an err that's
+// already fieldErrors keeps its own fields
+func (e *fieldErrors) add(field string, err error) bool {
+	if err == nil {
+		return false
+	}
+	var nested fieldErrors
+	if errors.As(err, &nested) {
+		*e = append(*e, nested...)
+		return true
+	}
+	*e = append(*e, FieldError{Field: field, Code: errorInvalidField, Message: err.Error()})
+	return true
+}
+
+// missing records a required field that was left empty
+func (e *fieldErrors) missing(field string) {
+	*e = append(*e, FieldError{Field: field, Code: errorMissingField, Message: field + " is required"})
+}
+
+// err is nil when nothing was added, so a nil list doesn't turn into a non-nil error
+func (e fieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// response is the 400 body for the list
+func (e fieldErrors) response() ErrorResponse {
+	resp := ErrorResponse{Code: e[0].Code, Message: e.Error(), Errors: e}
+	if len(e) == 1 {
+		resp.Field, resp.Message = e[0].Field, e[0].Message
+	}
+	resp.Error = resp.Message
+	return resp
+}
+
+// writeValidationError answers a request that failed its checks - every
+// problem when err is fieldErrors, just the one otherwise
+func writeValidationError(w http.ResponseWriter, err error) {
+	var problems fieldErrors
+	if !errors.As(err, &problems) || len(problems) == 0 {
+		writeError(w, http.StatusBadRequest, errorInvalidRequest, err.Error())
+		return
+	}
+	writeErrorResponse(w, http.StatusBadRequest, problems.response())
+}
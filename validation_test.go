@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidationAllFields(t *testing.T) {
+	ta := newTestApp(t, nil)
+
+	body := map[string]any{
+		"url":        "ftp://example.com/file",
+		"tags":       []string{strings.Repeat("x", maxTagLen+1)},
+		"expires_in": "soon",
+		"code":       "!!",
+	}
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", body)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	var fields []string
+	for _, fe := range resp.Errors {
+		fields = append(fields, fe.Field)
+		if fe.Code != errorInvalidField || fe.Message == "" {
+			t.Errorf("%s: %+v", fe.Field, fe)
+		}
+	}
+	if strings.Join(fields, ",") != "url,tags,expires_in,code" {
+		t.Errorf("fields = %v", fields)
+	}
+	// more than one, so no single field at the top
+	if resp.Code != errorInvalidField || resp.Field != "" || !strings.Contains(resp.Message, "code: ") {
+		t.Errorf("resp = %+v", resp)
+	}
+}
+
+func TestValidationOneField(t *testing.T) {
+	ta := newTestApp(t, nil)
+
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", map[string]any{})
+	var resp ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusBadRequest || resp.Code != errorMissingField || resp.Field != "url" || resp.Message != "url is required" || len(resp.Errors) != 1 {
+		t.Errorf("status %d: %+v", rec.Code, resp)
+	}
+
+	// each entry is translated, and the message at the top rebuilt from them
+	rec = ta.do(http.MethodPost, "/api/v1/shorten", map[string]any{"code": "!!"}, "Accept-Language", "de")
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Errors) != 2 || resp.Errors[0].Message != "url fehlt" || !strings.HasPrefix(resp.Message, "url: url fehlt; code: ") {
+		t.Errorf("de: %+v", resp)
+	}
+}
+
+func TestFieldErrorsAdd(t *testing.T) {
+	var problems fieldErrors
+	if problems.add("x", nil) || problems.err() != nil {
+		t.Fatal("nil error recorded")
+	}
+	var nested fieldErrors
+	nested.missing("title")
+	problems.add("ignored", nested)
+	problems.add("url", errInvalidURL)
+	if len(problems) != 2 || problems[0].Field != "title" || problems[1].Field != "url" {
+		t.Errorf("problems = %+v", problems)
+	}
+	if problems.Error() != "title: title is required; url: invalid url format" {
+		t.Errorf("Error() = %q", problems.Error())
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/net/publicsuffix"
+)
+
+// vanity codes. a shorten request can ask for its own code ("code":
+// "acme-pricing") instead of a generated one, and GET /api/suggest proposes
+// free ones made from the destination's domain, path and title
+
+// codePattern is what the link routes accept - generated codes and custom ones
+const codePattern = "[a-zA-Z0-9][a-zA-Z0-9-]{2,63}"
+
+var customCode = regexp.MustCompile("^" + codePattern + "$")
+
+var errCodeTaken = errors.New("that code is already taken")
+
+const (
+	maxSuggestions   = 10
+	maxSuggestionLen = 32
+)
+
+// words that don't make a code any more memorable
+var suggestStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "of": true, "to": true, "for": true, "in": true,
+	"on": true, "at": true, "by": true, "with": true, "from": true, "is": true, "your": true, "our": true,
+	"www": true, "index": true, "html": true, "htm": true, "php": true, "aspx": true, "home": true,
+}
+
+// validateCustomCode checks a requested code. availability is checked on insert
+func (app *App) validateCustomCode(code string) error {
+	if !customCode.MatchString(code) && !app.Codes.allowsCustom(code) {
+		return errors.New("code must be 3-64 letters, digits and dashes, starting with a letter or digit")
+	}
+	lower := strings.ToLower(code)
+	if slices.Contains(reservedNamespaces, lower) || app.Namespaces.get(lower) != nil {
+		return fmt.Errorf("%q is reserved", code)
+	}
+	return nil
+}
+
+// slugWords splits text into lowercase ascii words, dropping stop words and numbers
+func slugWords(s string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9')
+	}) {
+		if suggestStopWords[w] {
+			continue
+		}
+		if _, err := strconv.Atoi(w); err == nil {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// suggestionCandidates turns a destination and its page title into codes
+// worth offering, best first. availability isn't checked here
+func suggestionCandidates(dest *url.URL, title string) []string {
+	host := strings.TrimPrefix(strings.ToLower(dest.Hostname()), "www.")
+	brand := host
+	if site, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		brand = site
+	}
+	brand, _, _ = strings.Cut(brand, ".")
+
+	// the last path segment that says something, /en/products/pricing -> pricing
+	var pathWords []string
+	segments := strings.Split(strings.Trim(dest.Path, "/"), "/")
+	for i := len(segments) - 1; i >= 0 && len(pathWords) == 0; i-- {
+		seg, _ := url.PathUnescape(segments[i])
+		pathWords = slugWords(strings.TrimSuffix(seg, ".html"))
+	}
+	titleWords := slugWords(title)
+	// titles often repeat the site name - "Pricing | Acme"
+	titleWords = slices.DeleteFunc(titleWords, func(w string) bool { return w == brand })
+
+	first := func(words []string, n int) []string { return words[:min(n, len(words))] }
+	join := func(parts ...[]string) string {
+		var all []string
+		for _, p := range parts {
+			all = append(all, p...)
+		}
+		return strings.Join(all, "-")
+	}
+	brandWords := slugWords(brand)
+	candidates := []string{
+		join(brandWords, first(pathWords, 2)),
+		join(first(pathWords, 3)),
+		join(brandWords, first(titleWords, 2)),
+		join(first(titleWords, 3)),
+		join(brandWords),
+	}
+
+	var out []string
+	for _, c := range candidates {
+		if len(c) > maxSuggestionLen {
+			c = strings.TrimRight(c[:maxSuggestionLen], "-")
+		}
+		if customCode.MatchString(c) && !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// SuggestResponse is what GET /api/suggest answers with
+type SuggestResponse struct {
+	URL         string   `json:"url"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// handles GET /api/suggest?url=...&title=...&limit=5 - free vanity codes for
+// a destination. without a title the page's own is fetched, best effort and
+// only for a logged in caller, like /api/preview
+func (app *App) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := normalizeURL(q.Get("url"))
+	dest, err := url.Parse(target)
+	if err != nil || !isValidURL(target) {
+		writeFieldError(w, http.StatusBadRequest, errorInvalidField, "url", "invalid url format")
+		return
+	}
+	limit := 5
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSuggestions {
+			writeFieldError(w, http.StatusBadRequest, errorInvalidField, "limit", fmt.Sprintf("limit must be between 1 and %d", maxSuggestions))
+			return
+		}
+	}
+
+	title := q.Get("title")
+	if title == "" && webURL(target) && !identityFromContext(r.Context()).Anonymous() {
+		if meta, err := app.fetchMetadata(r.Context(), target); err == nil {
+			title = meta.Title
+		}
+	}
+
+	suggestions := []string{}
+	err = app.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("urls"))
+		taken := func(code string) bool {
+			return app.validateCustomCode(code) != nil || (bucket != nil && bucket.Get([]byte(code)) != nil)
+		}
+		for _, c := range suggestionCandidates(dest, title) {
+			// acme-pricing, then acme-pricing-2 and so on
+			for n := 1; n <= 9 && len(suggestions) < limit; n++ {
+				code := c
+				if n > 1 {
+					code = c + "-" + strconv.Itoa(n)
+				}
+				if !taken(code) {
+					if !slices.Contains(suggestions, code) {
+						suggestions = append(suggestions, code)
+					}
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("suggest lookup error: %v", err)
+		writeError(w, http.StatusInternalServerError, errorServer, "server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SuggestResponse{URL: target, Suggestions: suggestions})
+}
+
+// notReservedPath is a mux matcher for paths that don't start with one of
+// the app's own names, the only ones a code can be found under
+func notReservedPath(r *http.Request, _ *mux.RouteMatch) bool {
+	first, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return !slices.Contains(reservedNamespaces, strings.ToLower(first))
+}
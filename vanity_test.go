@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestSuggestionCandidates(t *testing.T) {
+	dest, _ := url.Parse("https://www.acme.co.uk/en/pricing")
+	got := suggestionCandidates(dest, "Plans and Pricing | Acme")
+	want := []string{"acme-pricing", "pricing", "acme-plans-pricing", "plans-pricing", "acme"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVanityCodes(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true"})
+
+	req := ShortenRequest{URL: "https://acme.com/pricing", Code: "acme-pricing"}
+	if resp := ta.shorten(req, http.StatusOK); resp.ShortCode != "acme-pricing" {
+		t.Fatalf("short_code = %s", resp.ShortCode)
+	}
+	if rec := ta.do(http.MethodGet, "/acme-pricing", nil); rec.Header().Get("Location") != "https://acme.com/pricing" {
+		t.Errorf("redirect: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	// custom codes don't take over the url's dedupe
+	if plain := ta.shorten(ShortenRequest{URL: "https://acme.com/pricing"}, http.StatusOK); plain.ShortCode == "acme-pricing" {
+		t.Error("a plain shorten got the custom code")
+	}
+
+	req.URL = "https://acme.com/other"
+	if rec := ta.do(http.MethodPost, "/api/v1/shorten", req); rec.Code != http.StatusConflict {
+		t.Errorf("taken code: status %d, want 409", rec.Code)
+	}
+	for _, code := range []string{"api", "-acme", "ab", "acme pricing"} {
+		req.Code = code
+		if rec := ta.do(http.MethodPost, "/api/v1/shorten", req); rec.Code != http.StatusBadRequest {
+			t.Errorf("code %q: status %d, want 400", code, rec.Code)
+		}
+	}
+
+	rec := ta.do(http.MethodGet, "/api/v1/suggest?url=acme.com/pricing&title=Pricing&limit=2", nil)
+	var resp SuggestResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || !slices.Equal(resp.Suggestions, []string{"acme-pricing-2", "pricing"}) {
+		t.Errorf("suggest: %d %+v", rec.Code, resp)
+	}
+	if rec := ta.do(http.MethodGet, "/api/v1/suggest?url=acme.com&limit=50", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("limit 50: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// the api is versioned by path: everything lives under /api/v1, and a
+// breaking change gets a new /api/v2 next to it rather than changing v1 in
+// place. every api response says which version answered in API-Version.
+// the unversioned /api/... paths from before still work as aliases of v1,
+// but say they're going away - Deprecation, Sunset (LEGACY_API_SUNSET) and a
+// Link to the same endpoint under /api/v1. LEGACY_API=false turns them off
+// once clients have moved
+
+const apiVersion = "v1"
+
+// when the unversioned paths were deprecated, for the Deprecation header
+var legacyAPIDeprecated = time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+
+var versionedAPIPath = regexp.MustCompile(`^/api/v[0-9]+(/|$)`)
+
+// apiVersionMiddleware runs before the router, so a legacy path is matched
+// (and checked, rate limited, captcha'd) once, as its v1 route
+func (app *App) apiVersionMiddleware(next http.Handler) http.Handler {
+	current := "/api/" + apiVersion
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case path != "/api" && !strings.HasPrefix(path, "/api/"):
+			next.ServeHTTP(w, r)
+			return
+		case path == current || strings.HasPrefix(path, current+"/"):
+			w.Header().Set("API-Version", apiVersion)
+			next.ServeHTTP(w, r)
+			return
+		case versionedAPIPath.MatchString(path):
+			writeError(w, http.StatusNotFound, errorNotFound, fmt.Sprintf("unknown api version, this server has %s", current))
+			return
+		}
+
+		successor := current + strings.TrimPrefix(path, "/api")
+		if !app.Config.LegacyAPI {
+			writeError(w, http.StatusGone, errorGone, "unversioned api paths are gone, use "+app.path(successor))
+			return
+		}
+
+		h := w.Header()
+		h.Set("API-Version", apiVersion)
+		h.Set("Deprecation", fmt.Sprintf("@%d", legacyAPIDeprecated.Unix()))
+		if !app.Config.LegacyAPISunset.IsZero() {
+			h.Set("Sunset", app.Config.LegacyAPISunset.UTC().Format(http.TimeFormat))
+		}
+		h.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", app.path(successor)))
+		app.Metrics.incr("api.legacy")
+
+		u := *r.URL
+		u.Path = successor
+		if u.RawPath != "" {
+			u.RawPath = current + strings.TrimPrefix(u.RawPath, "/api")
+		}
+		r = r.Clone(r.Context())
+		r.URL = &u
+		next.ServeHTTP(w, r)
+	})
+}
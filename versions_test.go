@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLegacyAPIPaths(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"ALLOW_PRIVATE_DESTINATIONS": "true", "LEGACY_API_SUNSET": "2027-04-01"})
+
+	rec := ta.do(http.MethodPost, "/api/v1/shorten", ShortenRequest{URL: "https://example.com/current"})
+	if rec.Code != http.StatusOK || rec.Header().Get("API-Version") != "v1" || rec.Header().Get("Deprecation") != "" {
+		t.Errorf("v1: status %d, headers %v", rec.Code, rec.Header())
+	}
+
+	// the old path still works, and says where to go instead
+	rec = ta.do(http.MethodPost, "/api/shorten", ShortenRequest{URL: "https://example.com/legacy"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("legacy: status %d: %s", rec.Code, rec.Body)
+	}
+	h := rec.Header()
+	if h.Get("API-Version") != "v1" || !strings.HasPrefix(h.Get("Deprecation"), "@") ||
+		h.Get("Sunset") != "Thu, 01 Apr 2027 00:00:00 GMT" || h.Get("Link") != `</api/v1/shorten>; rel="successor-version"` {
+		t.Errorf("legacy headers = %v", h)
+	}
+
+	// admin routes keep their auth under either path
+	if rec := ta.do(http.MethodGet, "/api/admin/keys", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("legacy admin: status %d", rec.Code)
+	}
+
+	if rec := ta.do(http.MethodGet, "/api/v2/links", nil); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "/api/v1") {
+		t.Errorf("v2: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLegacyAPIOff(t *testing.T) {
+	ta := newTestApp(t, map[string]string{"LEGACY_API": "false"})
+	rec := ta.do(http.MethodPost, "/api/shorten", ShortenRequest{URL: "https://example.com/legacy"})
+	if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), "/api/v1/shorten") {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}